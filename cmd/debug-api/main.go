@@ -48,6 +48,7 @@ var (
 	cleanup       = flag.Bool("cleanup", false, "Clean up orphaned targets without datasets")
 	dryRun        = flag.Bool("dry-run", true, "Dry run mode (default true, set to false to actually delete)")
 	debugSessions = flag.Bool("debug-sessions", false, "Debug: dump raw session data")
+	recordFile    = flag.String("record", "", "Record API requests and responses (API key redacted) to this JSONL file")
 )
 
 func main() {
@@ -73,6 +74,7 @@ func main() {
 		Timeout:           60 * time.Second,
 		MaxConcurrentReqs: 1,
 		MaxConnections:    1,
+		RecordFile:        *recordFile,
 	})
 	if err != nil {
 		fmt.Printf("Failed to create client: %v\n", err)
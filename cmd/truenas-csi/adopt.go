@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/driver"
+)
+
+// runAdopt implements the adopt subcommand: it marks the volumes and snapshots provisioned
+// by democratic-csi under the configured parent dataset as managed by this driver. With
+// --dataset, it instead adopts a single pre-existing dataset used as a static volume. It
+// returns the process exit code.
+func runAdopt(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("adopt", flag.ContinueOnError)
+	fs.SetOutput(out)
+	configFile := fs.String("config", "", "Path to driver configuration file (required)")
+	dryRun := fs.Bool("dry-run", false, "List what would be adopted without changing anything")
+	timeout := fs.Duration("timeout", 10*time.Minute, "Timeout for the whole adoption")
+	dataset := fs.String("dataset", "", "Adopt this static volume dataset so deleting its PV destroys it")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(out, "Usage: %s adopt --config <file> [--dry-run] [--dataset <path>]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *configFile == "" {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := driver.LoadConfig(*configFile)
+	if err != nil {
+		printProblems(out, err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	if *dataset != "" {
+		if *dryRun {
+			_, _ = fmt.Fprintf(out, "Would adopt dataset %s\n", *dataset)
+			return 0
+		}
+		if err := driver.AdoptStaticVolume(ctx, cfg, *dataset); err != nil {
+			printProblems(out, err)
+			return 1
+		}
+		_, _ = fmt.Fprintf(out, "Adopted dataset %s\n", *dataset)
+		return 0
+	}
+
+	result, err := driver.AdoptLegacyVolumes(ctx, cfg, *dryRun)
+
+	verb := "Adopted"
+	if *dryRun {
+		verb = "Would adopt"
+	}
+	if result != nil {
+		for _, name := range result.Volumes {
+			_, _ = fmt.Fprintf(out, "%s volume %s\n", verb, name)
+		}
+		for _, name := range result.Snapshots {
+			_, _ = fmt.Fprintf(out, "%s snapshot %s\n", verb, name)
+		}
+		_, _ = fmt.Fprintf(out, "%s %d volumes and %d snapshots\n", verb, len(result.Volumes), len(result.Snapshots))
+	}
+	if err != nil {
+		printProblems(out, err)
+		return 1
+	}
+	return 0
+}
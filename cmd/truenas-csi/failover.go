@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/driver"
+)
+
+// runFailover implements the failover subcommand: it makes the volumes replicated to a
+// secondary TrueNAS writable, shares them there and prints PersistentVolumes pointing at
+// the secondary. It returns the process exit code.
+func runFailover(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("failover", flag.ContinueOnError)
+	fs.SetOutput(out)
+	configFile := fs.String("config", "", "Path to a driver configuration file for the secondary TrueNAS (required)")
+	volumes := fs.String("volumes", "", "Comma-separated volume IDs to fail over (defaults to every replicated volume)")
+	driverName := fs.String("driver-name", "org.truenas.csi", "CSI driver name for the printed PersistentVolumes")
+	storageClass := fs.String("storage-class", "", "StorageClass name for the printed PersistentVolumes")
+	timeout := fs.Duration("timeout", 10*time.Minute, "Timeout for the failover")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(out, "Usage: %s failover --config <secondary config file> [--volumes <id,...>]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *configFile == "" {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := driver.LoadConfig(*configFile)
+	if err != nil {
+		printProblems(out, err)
+		return 1
+	}
+
+	var volumeIDs []string
+	for _, id := range strings.Split(*volumes, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			volumeIDs = append(volumeIDs, id)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	recovered, failoverErr := driver.FailoverVolumes(ctx, cfg, volumeIDs)
+
+	// Print what failed over even if some volumes didn't
+	for _, volume := range recovered {
+		name := volume.VolumeName
+		if name == "" {
+			name = volume.VolumeID
+		}
+		data, err := persistentVolumeYAML(volume, name, *driverName, *storageClass)
+		if err != nil {
+			printProblems(out, err)
+			return 1
+		}
+		_, _ = fmt.Fprintf(out, "---\n# Failed over volume %s on %s\n%s", volume.VolumeID, cfg.TrueNAS.Host, data)
+	}
+	if failoverErr != nil {
+		printProblems(out, failoverErr)
+		return 1
+	}
+	return 0
+}
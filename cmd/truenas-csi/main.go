@@ -2,11 +2,13 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/GizmoTickler/truenas-scale-csi/pkg/driver"
 	"k8s.io/klog/v2"
@@ -20,6 +22,19 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:], os.Stdout))
+		case "adopt":
+			os.Exit(runAdopt(os.Args[2:], os.Stdout))
+		case "restore":
+			os.Exit(runRestore(os.Args[2:], os.Stdout))
+		case "failover":
+			os.Exit(runFailover(os.Args[2:], os.Stdout))
+		}
+	}
+
 	// Define flags
 	var (
 		configFile  string
@@ -27,14 +42,17 @@ func main() {
 		nodeID      string
 		driverName  string
 		mode        string
+		httpAddress string
 		showVersion bool
 	)
 
 	flag.StringVar(&configFile, "config", "", "Path to driver configuration file (required)")
+	flag.StringVar(&configFile, "driver-config-file", "", "Deprecated: use --config")
 	flag.StringVar(&endpoint, "endpoint", "unix:///csi/csi.sock", "CSI endpoint")
 	flag.StringVar(&nodeID, "node-id", "", "Node ID (required for node mode)")
 	flag.StringVar(&driverName, "driver-name", "org.truenas.csi", "CSI driver name")
 	flag.StringVar(&mode, "mode", "all", "Driver mode: controller, node, or all")
+	flag.StringVar(&httpAddress, "http-address", "", "Listen address for /healthz, /readyz and /debug/locks (overrides http.address in config)")
 	flag.BoolVar(&showVersion, "version", false, "Show version and exit")
 
 	klog.InitFlags(nil)
@@ -45,6 +63,12 @@ func main() {
 		os.Exit(0)
 	}
 
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "driver-config-file" {
+			klog.Warning("--driver-config-file is deprecated, use --config")
+		}
+	})
+
 	if configFile == "" {
 		klog.Fatal("--config is required")
 	}
@@ -55,12 +79,24 @@ func main() {
 		klog.Fatalf("Failed to load config: %v", err)
 	}
 
-	// Override config with CLI flags if provided
-	if driverName != "org.truenas.csi" {
-		cfg.DriverName = driverName
+	// Override config with CLI flags if provided (re-applied on config reload)
+	applyOverrides := func(cfg *driver.Config) {
+		if driverName != "org.truenas.csi" {
+			cfg.DriverName = driverName
+		}
+		if cfg.DriverName == "" {
+			cfg.DriverName = driverName
+		}
+		if httpAddress != "" {
+			cfg.HTTP.Address = httpAddress
+		}
 	}
-	if cfg.DriverName == "" {
-		cfg.DriverName = driverName
+	applyOverrides(cfg)
+
+	// Set up tracing before the driver so the TrueNAS client picks up the provider
+	shutdownTracing, err := driver.SetupTracing(context.Background(), &cfg.Tracing, cfg.DriverName, Version)
+	if err != nil {
+		klog.Fatalf("Failed to set up tracing: %v", err)
 	}
 
 	// Validate mode
@@ -95,6 +131,9 @@ func main() {
 		RunController: runController,
 		RunNode:       runNode,
 		Config:        cfg,
+
+		ConfigFile:      configFile,
+		ConfigOverrides: applyOverrides,
 	})
 	if err != nil {
 		klog.Fatalf("Failed to create driver: %v", err)
@@ -104,6 +143,19 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// SIGHUP reloads the config file immediately instead of waiting for the next check
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+
+	go func() {
+		for range hupChan {
+			klog.Info("Received SIGHUP, reloading config")
+			if err := drv.ReloadConfig(); err != nil {
+				klog.Errorf("Config reload rejected: %v", err)
+			}
+		}
+	}()
+
 	go func() {
 		sig := <-sigChan
 		klog.Infof("Received signal %v, shutting down", sig)
@@ -111,7 +163,16 @@ func main() {
 	}()
 
 	// Run driver
-	if err := drv.Run(); err != nil {
-		klog.Fatalf("Driver failed: %v", err)
+	runErr := drv.Run()
+
+	// Flush pending spans before exiting
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		klog.Warningf("Failed to flush traces: %v", err)
+	}
+
+	if runErr != nil {
+		klog.Fatalf("Driver failed: %v", runErr)
 	}
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/driver"
+)
+
+// runRestore implements the restore subcommand: it moves a volume out of the trash, shares
+// it again and prints a PersistentVolume that binds to it. It returns the process exit code.
+func runRestore(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.SetOutput(out)
+	configFile := fs.String("config", "", "Path to driver configuration file (required)")
+	dataset := fs.String("dataset", "", "Trashed dataset, as a full name or a name in zfs.trash.datasetParentName (required)")
+	volumeID := fs.String("volume-id", "", "Volume ID to restore as (defaults to the ID it was deleted with)")
+	pvName := fs.String("pv-name", "", "Name of the printed PersistentVolume (defaults to the volume ID)")
+	driverName := fs.String("driver-name", "org.truenas.csi", "CSI driver name for the printed PersistentVolume")
+	storageClass := fs.String("storage-class", "", "StorageClass name for the printed PersistentVolume")
+	timeout := fs.Duration("timeout", 5*time.Minute, "Timeout for the restore")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(out, "Usage: %s restore --config <file> --dataset <name> [--volume-id <id>]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *configFile == "" || *dataset == "" {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := driver.LoadConfig(*configFile)
+	if err != nil {
+		printProblems(out, err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	restored, err := driver.RestoreVolume(ctx, cfg, *dataset, *volumeID)
+	if err != nil {
+		printProblems(out, err)
+		return 1
+	}
+
+	name := *pvName
+	if name == "" {
+		name = restored.VolumeID
+	}
+	data, err := persistentVolumeYAML(restored, name, *driverName, *storageClass)
+	if err != nil {
+		printProblems(out, err)
+		return 1
+	}
+
+	_, _ = fmt.Fprintf(out, "# Restored %s as volume %s\n%s", *dataset, restored.DatasetName, data)
+	return 0
+}
+
+// persistentVolumeYAML renders a PersistentVolume that binds to a recovered volume.
+func persistentVolumeYAML(volume *driver.RecoveredVolume, name, driverName, storageClass string) ([]byte, error) {
+	accessMode := "ReadWriteOnce"
+	if volume.ShareType == "nfs" {
+		accessMode = "ReadWriteMany"
+	}
+	pv := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "PersistentVolume",
+		"metadata":   map[string]interface{}{"name": name},
+		"spec": map[string]interface{}{
+			"capacity":                      map[string]interface{}{"storage": fmt.Sprintf("%d", volume.CapacityBytes)},
+			"accessModes":                   []string{accessMode},
+			"persistentVolumeReclaimPolicy": "Delete",
+			"storageClassName":              storageClass,
+			"csi": map[string]interface{}{
+				"driver":           driverName,
+				"volumeHandle":     volume.VolumeID,
+				"volumeAttributes": volume.VolumeContext,
+			},
+		},
+	}
+	return yaml.Marshal(pv)
+}
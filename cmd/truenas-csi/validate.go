@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/driver"
+)
+
+// runValidate implements the validate subcommand: it loads the config file, reports any
+// problems and, with --connect, checks the referenced TrueNAS resources exist. It returns
+// the process exit code.
+func runValidate(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(out)
+	configFile := fs.String("config", "", "Path to driver configuration file (required)")
+	connect := fs.Bool("connect", false, "Connect to TrueNAS and verify the datasets, portal and initiator groups, and NVMe-oF ports exist")
+	timeout := fs.Duration("timeout", 30*time.Second, "Timeout for the TrueNAS checks")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(out, "Usage: %s validate --config <file> [--connect]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *configFile == "" {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := driver.LoadConfig(*configFile)
+	if err != nil {
+		printProblems(out, err)
+		return 1
+	}
+
+	for _, msg := range cfg.Deprecations() {
+		_, _ = fmt.Fprintf(out, "  warning: %s\n", msg)
+	}
+
+	if *connect {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		if err := driver.VerifyTrueNAS(ctx, cfg); err != nil {
+			printProblems(out, err)
+			return 1
+		}
+	}
+
+	_, _ = fmt.Fprintf(out, "%s: OK\n", *configFile)
+	return 0
+}
+
+// printProblems writes each error joined into err on its own line.
+func printProblems(out io.Writer, err error) {
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	for _, e := range errs {
+		_, _ = fmt.Fprintf(out, "  - %v\n", e)
+	}
+}
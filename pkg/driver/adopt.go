@@ -0,0 +1,180 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+)
+
+// legacyPropManagedResource marks datasets and snapshots provisioned by democratic-csi.
+const legacyPropManagedResource = "democratic-csi:managed_resource"
+
+// legacyVolumeProps maps democratic-csi dataset user properties to this driver's.
+var legacyVolumeProps = map[string]string{
+	"democratic-csi:provision_success":               PropProvisionSuccess,
+	"democratic-csi:csi_volume_name":                 PropCSIVolumeName,
+	"democratic-csi:csi_share_volume_context":        PropShareVolumeContext,
+	"democratic-csi:csi_volume_content_source_type":  PropVolumeContentSourceType,
+	"democratic-csi:csi_volume_content_source_id":    PropVolumeContentSourceID,
+	"democratic-csi:freenas_nfs_share_id":            PropNFSShareID,
+	"democratic-csi:freenas_iscsi_target_id":         PropISCSITargetID,
+	"democratic-csi:freenas_iscsi_extent_id":         PropISCSIExtentID,
+	"democratic-csi:freenas_iscsi_targettoextent_id": PropISCSITargetExtentID,
+}
+
+// legacySnapshotProps maps democratic-csi snapshot user properties to this driver's.
+var legacySnapshotProps = map[string]string{
+	"democratic-csi:csi_snapshot_name":             PropCSISnapshotName,
+	"democratic-csi:csi_snapshot_source_volume_id": PropCSISnapshotSourceVolumeID,
+}
+
+// adoptPageSize is the number of snapshots fetched per query during adoption.
+const adoptPageSize = 100
+
+// AdoptionResult lists the datasets and snapshots adopted from democratic-csi.
+type AdoptionResult struct {
+	Volumes   []string
+	Snapshots []string
+}
+
+// AdoptLegacyVolumes connects to TrueNAS with cfg and adopts the democratic-csi volumes and
+// snapshots under zfs.datasetParentName. With dryRun, nothing is changed and the result
+// lists what would be adopted.
+func AdoptLegacyVolumes(ctx context.Context, cfg *Config, dryRun bool) (*AdoptionResult, error) {
+	client, err := truenas.NewClient(newClientConfig(&cfg.TrueNAS))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to TrueNAS: %w", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	return adoptLegacyVolumes(ctx, client, cfg.ZFS.DatasetParentName, dryRun)
+}
+
+// adoptLegacyVolumes copies democratic-csi user properties onto this driver's properties for
+// every dataset and snapshot under parent that democratic-csi manages and this driver doesn't
+// yet. The share, target and extent IDs are kept, so no data or share is touched. The legacy
+// properties are left in place. A dataset's properties, PropManagedResource included, are
+// written in one update, so a failure never leaves a half-adopted volume; snapshot
+// properties are written one at a time with PropManagedResource last, so an interrupted
+// adoption is retried. A resource that fails is reported and the rest are still adopted.
+func adoptLegacyVolumes(ctx context.Context, client truenas.ClientInterface, parent string, dryRun bool) (*AdoptionResult, error) {
+	result := &AdoptionResult{}
+	var errs []error
+
+	datasets, err := listVolumes(ctx, client, parent)
+	if err != nil {
+		return result, fmt.Errorf("failed to list datasets: %w", err)
+	}
+	for _, ds := range datasets {
+		props := needsAdoption(ds.UserProperties, legacyVolumeProps)
+		if props == nil {
+			continue
+		}
+		if !dryRun {
+			props[PropManagedResource] = "true"
+			if err := client.DatasetSetUserProperties(ctx, ds.Name, props); err != nil {
+				errs = append(errs, fmt.Errorf("failed to adopt dataset %s: %w", ds.Name, err))
+				continue
+			}
+		}
+		result.Volumes = append(result.Volumes, ds.Name)
+	}
+
+	for offset := 0; ; offset += adoptPageSize {
+		snapshots, err := client.SnapshotListAll(ctx, parent, adoptPageSize, offset)
+		if err != nil {
+			return result, fmt.Errorf("failed to list snapshots: %w", err)
+		}
+		for _, snap := range snapshots {
+			props := needsAdoption(snap.UserProperties, legacySnapshotProps)
+			if props == nil {
+				continue
+			}
+			if !dryRun {
+				if err := setAdoptedProperties(props, func(key, value string) error {
+					return client.SnapshotSetUserProperty(ctx, snap.ID, key, value)
+				}); err != nil {
+					errs = append(errs, fmt.Errorf("failed to adopt snapshot %s: %w", snap.ID, err))
+					continue
+				}
+			}
+			result.Snapshots = append(result.Snapshots, snap.ID)
+		}
+		if len(snapshots) < adoptPageSize {
+			break
+		}
+	}
+
+	sort.Strings(result.Volumes)
+	sort.Strings(result.Snapshots)
+	return result, errors.Join(errs...)
+}
+
+// needsAdoption returns the properties to set on a democratic-csi managed resource that
+// this driver doesn't manage yet, or nil if there is nothing to adopt.
+func needsAdoption(userProps map[string]truenas.UserProperty, mapping map[string]string) map[string]string {
+	if prop, ok := userProps[legacyPropManagedResource]; !ok || prop.Value != "true" {
+		return nil
+	}
+	if prop, ok := userProps[PropManagedResource]; ok && prop.Value == "true" {
+		return nil
+	}
+
+	props := make(map[string]string)
+	for legacy, native := range mapping {
+		prop, ok := userProps[legacy]
+		if !ok || prop.Value == "" || prop.Value == "-" {
+			continue
+		}
+		// Values already written by this driver win
+		if existing, ok := userProps[native]; ok && existing.Value != "" && existing.Value != "-" {
+			continue
+		}
+		props[native] = prop.Value
+	}
+	return props
+}
+
+// setAdoptedProperties writes props in a stable order, then PropManagedResource.
+func setAdoptedProperties(props map[string]string, set func(key, value string) error) error {
+	keys := make([]string, 0, len(props))
+	for key := range props {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := set(key, props[key]); err != nil {
+			return fmt.Errorf("failed to set %s: %w", key, err)
+		}
+	}
+	if err := set(PropManagedResource, "true"); err != nil {
+		return fmt.Errorf("failed to set %s: %w", PropManagedResource, err)
+	}
+	return nil
+}
+
+// adoptLegacyVolumesIfEnabled runs adoption when zfs.adoptLegacyVolumes is enabled.
+func (d *Driver) adoptLegacyVolumesIfEnabled() {
+	cfg := d.GetConfig()
+	if !cfg.ZFS.AdoptLegacyVolumes {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	result, err := adoptLegacyVolumes(ctx, d.truenasClient, cfg.ZFS.DatasetParentName, false)
+	if err != nil {
+		klog.Warningf("Failed to adopt some democratic-csi volumes: %v", err)
+	}
+	if result != nil && (len(result.Volumes) > 0 || len(result.Snapshots) > 0) {
+		klog.Infof("Adopted %d volumes and %d snapshots from democratic-csi", len(result.Volumes), len(result.Snapshots))
+	}
+}
@@ -0,0 +1,133 @@
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+)
+
+// legacyProps builds democratic-csi style user properties.
+func legacyProps(kv ...string) map[string]truenas.UserProperty {
+	props := make(map[string]truenas.UserProperty)
+	for i := 0; i < len(kv); i += 2 {
+		props["democratic-csi:"+kv[i]] = truenas.UserProperty{Value: kv[i+1]}
+	}
+	return props
+}
+
+func TestAdoptLegacyVolumes(t *testing.T) {
+	ctx := context.Background()
+	mockClient := truenas.NewMockClient()
+	d := &Driver{
+		config: &Config{
+			DriverName: "truenas-iscsi",
+			ZFS:        ZFSConfig{DatasetParentName: "pool/parent"},
+		},
+		truenasClient: mockClient,
+	}
+
+	// An iSCSI volume and a snapshot of it provisioned by democratic-csi
+	mockClient.Datasets["pool/parent/pvc-1"] = &truenas.Dataset{
+		ID: "pool/parent/pvc-1", Name: "pool/parent/pvc-1", Type: "VOLUME",
+		UserProperties: legacyProps(
+			"managed_resource", "true",
+			"provision_success", "true",
+			"csi_volume_name", "pvc-1",
+			"freenas_iscsi_target_id", "7",
+			"freenas_iscsi_extent_id", "8",
+			"freenas_iscsi_targettoextent_id", "9",
+		),
+	}
+	mockClient.ISCSITargets[7] = &truenas.ISCSITarget{ID: 7, Name: "pvc-1"}
+	mockClient.ISCSIExtents[8] = &truenas.ISCSIExtent{ID: 8, Name: "pvc-1"}
+	mockClient.TargetExtents[9] = &truenas.ISCSITargetExtent{ID: 9, Target: 7, Extent: 8}
+	mockClient.Snapshots["pool/parent/pvc-1@snap-1"] = &truenas.Snapshot{
+		ID: "pool/parent/pvc-1@snap-1", Name: "snap-1", Dataset: "pool/parent/pvc-1",
+		UserProperties: legacyProps(
+			"managed_resource", "true",
+			"csi_snapshot_name", "snap-1",
+			"csi_snapshot_source_volume_id", "pvc-1",
+		),
+	}
+	// Unmanaged datasets are left alone
+	mockClient.Datasets["pool/parent/other"] = &truenas.Dataset{
+		ID: "pool/parent/other", Name: "pool/parent/other", UserProperties: map[string]truenas.UserProperty{},
+	}
+
+	// Test Case 1: Dry run reports without changing anything
+	result, err := adoptLegacyVolumes(ctx, mockClient, "pool/parent", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pool/parent/pvc-1"}, result.Volumes)
+	assert.Equal(t, []string{"pool/parent/pvc-1@snap-1"}, result.Snapshots)
+	_, managed := mockClient.Datasets["pool/parent/pvc-1"].UserProperties[PropManagedResource]
+	assert.False(t, managed)
+
+	// Test Case 2: A failed write leaves the volume untouched, to be adopted again
+	mockClient.FailNext("DatasetSetUserProperties", assert.AnError)
+	result, err = adoptLegacyVolumes(ctx, mockClient, "pool/parent", false)
+	assert.Error(t, err)
+	assert.Empty(t, result.Volumes)
+	for key := range mockClient.Datasets["pool/parent/pvc-1"].UserProperties {
+		assert.True(t, strings.HasPrefix(key, "democratic-csi:"), key)
+	}
+
+	// Test Case 3: Adoption maps the IDs and marks the resources managed, in one write per volume
+	mockClient.ResetCalls()
+	result, err = adoptLegacyVolumes(ctx, mockClient, "pool/parent", false)
+	assert.NoError(t, err)
+	assert.Len(t, result.Volumes, 1)
+	assert.Equal(t, 1, mockClient.CallCount("DatasetSetUserProperties"))
+	props := mockClient.Datasets["pool/parent/pvc-1"].UserProperties
+	assert.Equal(t, "true", props[PropManagedResource].Value)
+	assert.Equal(t, "pvc-1", props[PropCSIVolumeName].Value)
+	assert.Equal(t, "7", props[PropISCSITargetID].Value)
+	assert.Equal(t, "8", props[PropISCSIExtentID].Value)
+	assert.Equal(t, "9", props[PropISCSITargetExtentID].Value)
+	assert.Equal(t, "true", props["democratic-csi:managed_resource"].Value)
+	assert.Equal(t, "pvc-1", mockClient.Snapshots["pool/parent/pvc-1@snap-1"].UserProperties[PropCSISnapshotSourceVolumeID].Value)
+
+	// Test Case 4: Already adopted resources are skipped
+	result, err = adoptLegacyVolumes(ctx, mockClient, "pool/parent", false)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Volumes)
+	assert.Empty(t, result.Snapshots)
+
+	// Test Case 5: Adopted volumes and snapshots work with the controller
+	volumes, err := d.ListVolumes(ctx, &csi.ListVolumesRequest{})
+	assert.NoError(t, err)
+	if assert.Len(t, volumes.Entries, 1) {
+		assert.Equal(t, "pvc-1", volumes.Entries[0].Volume.VolumeId)
+	}
+
+	snapshots, err := d.ListSnapshots(ctx, &csi.ListSnapshotsRequest{SourceVolumeId: "pvc-1"})
+	assert.NoError(t, err)
+	assert.Len(t, snapshots.Entries, 1)
+
+	// democratic-csi snapshot handles name the volume as well as the snapshot
+	_, err = d.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: "pvc-1@snap-1"})
+	assert.NoError(t, err)
+	assert.NotContains(t, mockClient.Snapshots, "pool/parent/pvc-1@snap-1")
+
+	_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "pvc-1"})
+	assert.NoError(t, err)
+	assert.NotContains(t, mockClient.ISCSITargets, 7)
+	assert.NotContains(t, mockClient.ISCSIExtents, 8)
+	assert.NotContains(t, mockClient.TargetExtents, 9)
+}
+
+func TestNeedsAdoption(t *testing.T) {
+	// Values already written by this driver win over legacy ones
+	props := legacyProps("managed_resource", "true", "freenas_nfs_share_id", "3", "csi_volume_name", "-")
+	props[PropNFSShareID] = truenas.UserProperty{Value: "4"}
+	assert.Empty(t, needsAdoption(props, legacyVolumeProps))
+
+	props = legacyProps("managed_resource", "true", "freenas_nfs_share_id", "3")
+	assert.Equal(t, map[string]string{PropNFSShareID: "3"}, needsAdoption(props, legacyVolumeProps))
+
+	// Not managed by democratic-csi
+	assert.Nil(t, needsAdoption(legacyProps("freenas_nfs_share_id", "3"), legacyVolumeProps))
+}
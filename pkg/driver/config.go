@@ -2,10 +2,16 @@
 package driver
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"regexp"
+	"strings"
 
 	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
 )
 
 // Config holds the driver configuration loaded from YAML.
@@ -30,6 +36,24 @@ type Config struct {
 
 	// NVMe-oF configuration
 	NVMeoF NVMeoFConfig `yaml:"nvmeof"`
+
+	// Tracing configuration
+	Tracing TracingConfig `yaml:"tracing"`
+
+	// Controller leader election configuration
+	LeaderElection LeaderElectionConfig `yaml:"leaderElection"`
+
+	// Operation lock configuration
+	Locks LocksConfig `yaml:"locks"`
+
+	// HTTP endpoint for health and debug handlers
+	HTTP HTTPConfig `yaml:"http"`
+
+	// Health check configuration
+	Health HealthConfig `yaml:"health"`
+
+	// deprecations lists the legacy keys translated by LoadConfig
+	deprecations []string
 }
 
 // TrueNASConfig holds TrueNAS connection settings.
@@ -46,9 +70,43 @@ type TrueNASConfig struct {
 	// APIKey is the TrueNAS API key for authentication
 	APIKey string `yaml:"apiKey"`
 
-	// AllowInsecure skips TLS verification
+	// APIKeyFile reads the API key from a file, such as a mounted Secret. Unlike an
+	// environment variable, changes to the file are picked up by config reload.
+	APIKeyFile string `yaml:"apiKeyFile"`
+
+	// Username and Password authenticate with auth.login when apiKey is empty
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	// PasswordFile reads the password from a file, like apiKeyFile
+	PasswordFile string `yaml:"passwordFile"`
+
+	// TokenTTL is the lifetime in seconds of the temporary tokens used to reconnect after a
+	// password login, so the password isn't resent on every reconnect (default: 600, -1 disables)
+	TokenTTL int `yaml:"tokenTTL"`
+
+	// AllowInsecure skips TLS verification (pinnedPublicKeys are still enforced)
 	AllowInsecure bool `yaml:"allowInsecure"`
 
+	// CAFile is a PEM CA bundle used to verify the TrueNAS certificate instead of the system roots
+	CAFile string `yaml:"caFile"`
+
+	// CABundle is an inline PEM CA bundle, added to caFile if both are set
+	CABundle string `yaml:"caBundle"`
+
+	// ServerName overrides the hostname used for SNI and certificate verification
+	ServerName string `yaml:"serverName"`
+
+	// PinnedPublicKeys are SHA-256 SPKI fingerprints (sha256//<base64> or hex) of accepted certificates.
+	// Without a CA bundle, a matching pin is sufficient and the chain is not verified.
+	PinnedPublicKeys []string `yaml:"pinnedPublicKeys"`
+
+	// ClientCertFile is a PEM client certificate for mutual TLS
+	ClientCertFile string `yaml:"clientCertFile"`
+
+	// ClientKeyFile is the PEM key for ClientCertFile
+	ClientKeyFile string `yaml:"clientKeyFile"`
+
 	// RequestTimeout is the timeout for API requests in seconds (default: 60)
 	RequestTimeout int `yaml:"requestTimeout"`
 
@@ -57,6 +115,26 @@ type TrueNASConfig struct {
 
 	// MaxConcurrentRequests limits concurrent API requests to prevent overwhelming TrueNAS (default: 10)
 	MaxConcurrentRequests int `yaml:"maxConcurrentRequests"`
+
+	// TargetLatency in seconds: API calls slower than this, like timeouts, halve the number
+	// of concurrent requests, which then grows back to MaxConcurrentRequests (default: 5)
+	TargetLatency int `yaml:"targetLatency"`
+
+	// BreakerThreshold is how many consecutive calls may time out or fail to reach TrueNAS
+	// before controller RPCs fail fast with Unavailable (default: 5, -1 disables)
+	BreakerThreshold int `yaml:"breakerThreshold"`
+
+	// BreakerCooldown is how long in seconds calls fail fast before one is tried (default: 10)
+	BreakerCooldown int `yaml:"breakerCooldown"`
+
+	// RecordFile appends every API request and response, with credentials redacted, to this
+	// JSONL file for bug reports. truenas.NewReplayClient serves it back in tests.
+	RecordFile string `yaml:"recordFile"`
+
+	// Cache keeps the controller's view of the datasets, snapshots, shares, iSCSI and
+	// NVMe-oF objects under zfs.datasetParentName in memory, kept current by TrueNAS
+	// events, so lookups don't each cost an API call
+	Cache bool `yaml:"cache"`
 }
 
 // ZFSConfig holds ZFS dataset configuration.
@@ -78,6 +156,66 @@ type ZFSConfig struct {
 
 	// ZvolBlocksize is the block size for zvols (default: 16K)
 	ZvolBlocksize string `yaml:"zvolBlocksize"`
+
+	// AdoptLegacyVolumes adopts volumes and snapshots provisioned by democratic-csi under
+	// datasetParentName when the controller becomes active (default: false)
+	AdoptLegacyVolumes bool `yaml:"adoptLegacyVolumes"`
+
+	// Trash configures soft-delete of volumes
+	Trash TrashConfig `yaml:"trash"`
+
+	// SnapshotPolicies take scheduled snapshots of volumes under datasetParentName
+	SnapshotPolicies []SnapshotPolicy `yaml:"snapshotPolicies"`
+
+	// Replication configures replication of volumes to a secondary TrueNAS
+	Replication ReplicationConfig `yaml:"replication"`
+}
+
+// ReplicationConfig holds settings for replicating volumes to a secondary TrueNAS. Volumes
+// whose StorageClass sets replication: "true" get a TrueNAS replication task that pushes
+// their snapshot policy snapshots to the secondary over SSH.
+type ReplicationConfig struct {
+	// SSHCredentials is the ID of the SSH connection to the secondary in the primary's keychain
+	SSHCredentials int `yaml:"sshCredentials"`
+
+	// TargetDatasetParentName is the dataset on the secondary volumes are replicated into
+	// (empty disables replication)
+	TargetDatasetParentName string `yaml:"targetDatasetParentName"`
+
+	// Schedule is when replication tasks run, as a five-field cron expression (default: "*/15 * * * *")
+	Schedule string `yaml:"schedule"`
+
+	// StatusInterval is how often replication status is copied onto volumes, in seconds (default: 300)
+	StatusInterval int `yaml:"statusInterval"`
+}
+
+// SnapshotPolicy schedules snapshots of volumes and prunes the oldest ones.
+type SnapshotPolicy struct {
+	// Name identifies the policy in snapshot names and the snapshotPolicies StorageClass parameter
+	Name string `yaml:"name"`
+
+	// Schedule is how often a snapshot is taken: hourly, daily or weekly (UTC)
+	Schedule string `yaml:"schedule"`
+
+	// Keep is how many snapshots taken by this policy are kept per volume
+	Keep int `yaml:"keep"`
+
+	// AllVolumes applies the policy to every volume, not just those whose StorageClass names it
+	AllVolumes bool `yaml:"allVolumes"`
+}
+
+// TrashConfig holds soft-delete settings. DeleteVolume moves volumes into the trash dataset
+// instead of destroying them, and they are purged once the retention period has passed.
+type TrashConfig struct {
+	// DatasetParentName is the dataset deleted volumes are moved into, in the same pool as
+	// zfs.datasetParentName (empty destroys volumes immediately)
+	DatasetParentName string `yaml:"datasetParentName"`
+
+	// Retention is how long trashed volumes are kept, in seconds; -1 keeps them until restored or removed by hand (default: 604800)
+	Retention int `yaml:"retention"`
+
+	// PurgeInterval is how often the trash is checked for expired volumes, in seconds (default: 3600)
+	PurgeInterval int `yaml:"purgeInterval"`
 }
 
 // NFSConfig holds NFS share configuration.
@@ -192,6 +330,63 @@ type NVMeoFConfig struct {
 	DeviceWaitTimeout int `yaml:"deviceWaitTimeout"`
 }
 
+// TracingConfig holds OpenTelemetry tracing settings.
+type TracingConfig struct {
+	// Enabled turns on span export (default: false)
+	Enabled bool `yaml:"enabled"`
+
+	// Endpoint is the OTLP/gRPC collector address (host:port). Empty uses OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4317
+	Endpoint string `yaml:"endpoint"`
+
+	// Insecure disables TLS to the collector
+	Insecure bool `yaml:"insecure"`
+
+	// SampleRatio is the fraction of new traces to sample, 0.0-1.0 (default: 1.0). 0 samples none
+	SampleRatio *float64 `yaml:"sampleRatio"`
+}
+
+// LeaderElectionConfig holds active/standby settings for running multiple controller replicas.
+type LeaderElectionConfig struct {
+	// Enabled turns on leader election; only the leader serves controller RPCs (default: false)
+	Enabled bool `yaml:"enabled"`
+
+	// Identity is this replica's unique name in the lease (default: hostname)
+	Identity string `yaml:"identity"`
+
+	// LeaseDuration is how long a standby waits after the last renewal before taking over, in seconds (default: 15)
+	LeaseDuration int `yaml:"leaseDuration"`
+
+	// RenewDeadline is how long the leader keeps leading while renewals fail, in seconds (default: 10)
+	RenewDeadline int `yaml:"renewDeadline"`
+
+	// RetryPeriod is the interval between acquire/renew attempts in seconds (default: 2)
+	RetryPeriod int `yaml:"retryPeriod"`
+}
+
+// LocksConfig holds per-volume operation lock settings.
+type LocksConfig struct {
+	// TTL is how long a lock is honored before another operation may take it over, in seconds (default: 600)
+	TTL int `yaml:"ttl"`
+
+	// WaitTimeout is how long an operation waits for a held lock before returning Aborted, in seconds (default: 30)
+	WaitTimeout int `yaml:"waitTimeout"`
+
+	// DisablePersistence keeps controller locks in memory instead of recording them in TrueNAS
+	DisablePersistence bool `yaml:"disablePersistence"`
+}
+
+// HTTPConfig holds settings for the driver's HTTP endpoint.
+type HTTPConfig struct {
+	// Address is the listen address for /healthz, /readyz and /debug/locks, e.g. ":9809" (empty disables the endpoint)
+	Address string `yaml:"address"`
+}
+
+// HealthConfig holds health and readiness settings.
+type HealthConfig struct {
+	// UnreachableTimeout is how long TrueNAS may go without answering before the controller reports not-ready, in seconds (default: 90)
+	UnreachableTimeout int `yaml:"unreachableTimeout"`
+}
+
 // LoadConfig loads configuration from a YAML file.
 func LoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
@@ -202,11 +397,41 @@ func LoadConfig(path string) (*Config, error) {
 	// Expand environment variables in the config
 	data = []byte(os.ExpandEnv(string(data)))
 
-	cfg := &Config{}
-	if err := yaml.Unmarshal(data, cfg); err != nil {
+	// democratic-csi style configs are translated to the native layout first
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	deprecations := translateLegacyConfig(raw)
+	if len(deprecations) > 0 {
+		if data, err = yaml.Marshal(raw); err != nil {
+			return nil, fmt.Errorf("failed to translate legacy config: %w", err)
+		}
+		for _, msg := range deprecations {
+			klog.Warningf("Config: %s", msg)
+		}
+	}
+
+	// Unknown keys are errors so typos and unsupported options aren't silently ignored
+	cfg := &Config{deprecations: deprecations}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
+	// Read credentials from files
+	if cfg.TrueNAS.APIKeyFile != "" {
+		if cfg.TrueNAS.APIKey, err = readCredentialFile(cfg.TrueNAS.APIKeyFile); err != nil {
+			return nil, fmt.Errorf("truenas.apiKeyFile: %w", err)
+		}
+	}
+	if cfg.TrueNAS.PasswordFile != "" {
+		if cfg.TrueNAS.Password, err = readCredentialFile(cfg.TrueNAS.PasswordFile); err != nil {
+			return nil, fmt.Errorf("truenas.passwordFile: %w", err)
+		}
+	}
+
 	// Set defaults
 	if cfg.TrueNAS.Protocol == "" {
 		cfg.TrueNAS.Protocol = "https"
@@ -224,6 +449,9 @@ func LoadConfig(path string) (*Config, error) {
 	if cfg.TrueNAS.ConnectTimeout == 0 {
 		cfg.TrueNAS.ConnectTimeout = 10
 	}
+	if cfg.TrueNAS.TokenTTL == 0 {
+		cfg.TrueNAS.TokenTTL = 600
+	}
 	if cfg.ZFS.ZvolBlocksize == "" {
 		cfg.ZFS.ZvolBlocksize = "16K"
 	}
@@ -236,6 +464,8 @@ func LoadConfig(path string) (*Config, error) {
 	if cfg.ISCSI.ExtentRpm == "" {
 		cfg.ISCSI.ExtentRpm = "SSD"
 	}
+	// TrueNAS only accepts the upper-case names (democratic-csi documents "Unknown")
+	cfg.ISCSI.ExtentRpm = strings.ToUpper(cfg.ISCSI.ExtentRpm)
 	if cfg.ISCSI.DeviceWaitTimeout == 0 {
 		cfg.ISCSI.DeviceWaitTimeout = 60 // Default 60 seconds
 	}
@@ -248,36 +478,231 @@ func LoadConfig(path string) (*Config, error) {
 	if cfg.NVMeoF.DeviceWaitTimeout == 0 {
 		cfg.NVMeoF.DeviceWaitTimeout = 60 // Default 60 seconds (OTHER-001 fix)
 	}
+	if cfg.LeaderElection.LeaseDuration == 0 {
+		cfg.LeaderElection.LeaseDuration = 15
+	}
+	if cfg.LeaderElection.RenewDeadline == 0 {
+		cfg.LeaderElection.RenewDeadline = 10
+	}
+	if cfg.LeaderElection.RetryPeriod == 0 {
+		cfg.LeaderElection.RetryPeriod = 2
+	}
+	if cfg.Health.UnreachableTimeout == 0 {
+		cfg.Health.UnreachableTimeout = 90 // Three missed 30s heartbeats
+	}
+	if cfg.Locks.TTL == 0 {
+		cfg.Locks.TTL = 600
+	}
+	if cfg.Locks.WaitTimeout == 0 {
+		cfg.Locks.WaitTimeout = 30
+	}
+	if cfg.ZFS.Trash.Retention == 0 {
+		cfg.ZFS.Trash.Retention = 7 * 24 * 60 * 60
+	}
+	if cfg.ZFS.Trash.PurgeInterval == 0 {
+		cfg.ZFS.Trash.PurgeInterval = 3600
+	}
+	if cfg.ZFS.Replication.Schedule == "" {
+		cfg.ZFS.Replication.Schedule = "*/15 * * * *"
+	}
+	if cfg.ZFS.Replication.StatusInterval == 0 {
+		cfg.ZFS.Replication.StatusInterval = 300
+	}
+	if cfg.Tracing.SampleRatio == nil {
+		ratio := 1.0
+		cfg.Tracing.SampleRatio = &ratio
+	}
 
-	// Validate required fields
-	if cfg.TrueNAS.Host == "" {
-		return nil, fmt.Errorf("truenas.host is required")
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Allowed values for enumerated settings.
+var (
+	validZvolBlocksizes   = []string{"512", "1K", "2K", "4K", "8K", "16K", "32K", "64K", "128K"}
+	validExtentBlocksizes = []int{512, 1024, 2048, 4096}
+	validExtentRpms       = []string{"UNKNOWN", "SSD", "5400", "7200", "10000", "15000"}
+	validAuthMethods      = []string{"NONE", "CHAP", "CHAP_MUTUAL"}
+	validNVMeTransports   = []string{"tcp", "rdma"}
+	validPolicyName       = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+)
+
+// Validate checks required settings and value ranges. It reports every problem found,
+// not just the first. Defaults must already be applied.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	// Required fields
+	check(c.TrueNAS.Host != "", "truenas.host is required")
+	check(c.TrueNAS.APIKey != "" || (c.TrueNAS.Username != "" && c.TrueNAS.Password != ""),
+		"truenas.apiKey or truenas.username and truenas.password are required")
+	check(c.ZFS.DatasetParentName != "", "zfs.datasetParentName is required")
+
+	// TrueNAS connection
+	check(c.TrueNAS.Protocol == "http" || c.TrueNAS.Protocol == "https",
+		"truenas.protocol must be http or https, got %q", c.TrueNAS.Protocol)
+	check(validPort(c.TrueNAS.Port), "truenas.port must be between 1 and 65535, got %d", c.TrueNAS.Port)
+	check(c.TrueNAS.RequestTimeout > 0, "truenas.requestTimeout must be positive")
+	check(c.TrueNAS.ConnectTimeout > 0, "truenas.connectTimeout must be positive")
+	check(c.TrueNAS.MaxConcurrentRequests >= 0, "truenas.maxConcurrentRequests must not be negative")
+	check(c.TrueNAS.TargetLatency >= 0, "truenas.targetLatency must not be negative")
+	check(c.TrueNAS.BreakerThreshold >= -1, "truenas.breakerThreshold must be positive or -1")
+	check(c.TrueNAS.BreakerCooldown >= 0, "truenas.breakerCooldown must not be negative")
+	check(c.TrueNAS.TokenTTL > 0 || c.TrueNAS.TokenTTL == -1, "truenas.tokenTTL must be positive or -1")
+	check((c.TrueNAS.ClientCertFile == "") == (c.TrueNAS.ClientKeyFile == ""),
+		"truenas.clientCertFile and truenas.clientKeyFile must be set together")
+
+	// ZFS
+	check(c.ZFS.DetachedSnapshotsDatasetParentName == "" ||
+		!isDatasetWithin(c.ZFS.DetachedSnapshotsDatasetParentName, c.ZFS.DatasetParentName) &&
+			!isDatasetWithin(c.ZFS.DatasetParentName, c.ZFS.DetachedSnapshotsDatasetParentName),
+		"zfs.datasetParentName and zfs.detachedSnapshotsDatasetParentName must not be nested")
+	if trash := c.ZFS.Trash.DatasetParentName; trash != "" {
+		check(datasetPool(trash) == datasetPool(c.ZFS.DatasetParentName),
+			"zfs.trash.datasetParentName must be in the same pool as zfs.datasetParentName")
+		for _, other := range []string{c.ZFS.DatasetParentName, c.ZFS.DetachedSnapshotsDatasetParentName} {
+			check(other == "" || !isDatasetWithin(trash, other) && !isDatasetWithin(other, trash),
+				"zfs.trash.datasetParentName and %s must not be nested", other)
+		}
 	}
-	if cfg.TrueNAS.APIKey == "" {
-		return nil, fmt.Errorf("truenas.apiKey is required")
+	check(c.ZFS.Trash.Retention > 0 || c.ZFS.Trash.Retention == -1, "zfs.trash.retention must be positive or -1")
+	check(c.ZFS.Trash.PurgeInterval > 0, "zfs.trash.purgeInterval must be positive")
+	policyNames := make(map[string]bool)
+	for i, p := range c.ZFS.SnapshotPolicies {
+		check(validPolicyName.MatchString(p.Name),
+			"zfs.snapshotPolicies[%d].name must be lower-case letters, digits and dashes, got %q", i, p.Name)
+		check(!policyNames[p.Name], "zfs.snapshotPolicies[%d].name %q is not unique", i, p.Name)
+		policyNames[p.Name] = true
+		_, ok := snapshotSchedules[p.Schedule]
+		check(ok, "zfs.snapshotPolicies[%d].schedule must be hourly, daily or weekly, got %q", i, p.Schedule)
+		check(p.Keep > 0, "zfs.snapshotPolicies[%d].keep must be positive", i)
 	}
-	if cfg.ZFS.DatasetParentName == "" {
-		return nil, fmt.Errorf("zfs.datasetParentName is required")
+	if c.ZFS.Replication.TargetDatasetParentName != "" {
+		check(c.ZFS.Replication.SSHCredentials > 0, "zfs.replication.sshCredentials is required")
+		check(len(c.ZFS.SnapshotPolicies) > 0, "zfs.replication requires zfs.snapshotPolicies, their snapshots are replicated")
+	}
+	check(len(strings.Fields(c.ZFS.Replication.Schedule)) == 5,
+		"zfs.replication.schedule must be a five-field cron expression, got %q", c.ZFS.Replication.Schedule)
+	check(c.ZFS.Replication.StatusInterval > 0, "zfs.replication.statusInterval must be positive")
+	check(containsFold(validZvolBlocksizes, c.ZFS.ZvolBlocksize),
+		"zfs.zvolBlocksize must be one of %v, got %q", validZvolBlocksizes, c.ZFS.ZvolBlocksize)
+
+	// iSCSI
+	check(containsInt(validExtentBlocksizes, c.ISCSI.ExtentBlocksize),
+		"iscsi.extentBlocksize must be one of %v, got %d", validExtentBlocksizes, c.ISCSI.ExtentBlocksize)
+	check(containsFold(validExtentRpms, c.ISCSI.ExtentRpm),
+		"iscsi.extentRpm must be one of %v, got %q", validExtentRpms, c.ISCSI.ExtentRpm)
+	check(c.ISCSI.ExtentAvailThreshold >= 0 && c.ISCSI.ExtentAvailThreshold <= 99,
+		"iscsi.extentAvailThreshold must be between 0 and 99, got %d", c.ISCSI.ExtentAvailThreshold)
+	check(c.ISCSI.DeviceWaitTimeout > 0, "iscsi.deviceWaitTimeout must be positive")
+	for i, tg := range c.ISCSI.TargetGroups {
+		check(tg.Portal > 0, "iscsi.targetGroups[%d].portal must be a portal group ID", i)
+		check(tg.Initiator >= 0, "iscsi.targetGroups[%d].initiator must not be negative", i)
+		check(tg.AuthMethod == "" || containsFold(validAuthMethods, tg.AuthMethod),
+			"iscsi.targetGroups[%d].authMethod must be one of %v, got %q", i, validAuthMethods, tg.AuthMethod)
+		check(!strings.HasPrefix(strings.ToUpper(tg.AuthMethod), "CHAP") || (tg.Auth != nil && *tg.Auth > 0),
+			"iscsi.targetGroups[%d].auth is required for %s", i, tg.AuthMethod)
 	}
 
-	// Validate protocol-specific settings based on driver type
-	shareType := cfg.GetDriverShareType()
-	switch shareType {
+	// NVMe-oF
+	check(containsFold(validNVMeTransports, c.NVMeoF.Transport),
+		"nvmeof.transport must be one of %v, got %q", validNVMeTransports, c.NVMeoF.Transport)
+	check(validPort(c.NVMeoF.TransportServiceID),
+		"nvmeof.transportServiceId must be between 1 and 65535, got %d", c.NVMeoF.TransportServiceID)
+	check(c.NVMeoF.DeviceWaitTimeout > 0, "nvmeof.deviceWaitTimeout must be positive")
+
+	// Controller features
+	if c.LeaderElection.Enabled {
+		le := c.LeaderElection
+		check(le.RetryPeriod > 0 && le.RetryPeriod < le.RenewDeadline && le.RenewDeadline < le.LeaseDuration,
+			"leaderElection requires 0 < retryPeriod < renewDeadline < leaseDuration")
+	}
+	check(c.Tracing.SampleRatio == nil || (*c.Tracing.SampleRatio >= 0 && *c.Tracing.SampleRatio <= 1), "tracing.sampleRatio must be between 0 and 1")
+	check(c.Locks.TTL > 0, "locks.ttl must be positive")
+	check(c.Locks.WaitTimeout >= 0, "locks.waitTimeout must not be negative")
+	check(c.Health.UnreachableTimeout >= 0, "health.unreachableTimeout must not be negative")
+
+	// Protocol-specific settings based on driver type
+	switch c.GetDriverShareType() {
 	case "nfs":
-		if cfg.NFS.ShareHost == "" {
-			return nil, fmt.Errorf("nfs.shareHost is required for NFS driver")
-		}
+		check(c.NFS.ShareHost != "", "nfs.shareHost is required for NFS driver")
 	case "iscsi":
-		if cfg.ISCSI.TargetPortal == "" {
-			return nil, fmt.Errorf("iscsi.targetPortal is required for iSCSI driver")
-		}
+		check(c.ISCSI.TargetPortal != "", "iscsi.targetPortal is required for iSCSI driver")
 	case "nvmeof":
-		if cfg.NVMeoF.TransportAddress == "" {
-			return nil, fmt.Errorf("nvmeof.transportAddress is required for NVMe-oF driver")
+		check(c.NVMeoF.TransportAddress != "", "nvmeof.transportAddress is required for NVMe-oF driver")
+	}
+
+	return errors.Join(errs...)
+}
+
+// validPort returns true if port is a valid TCP port number.
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+// isDatasetWithin returns true if name is parent or a descendant of it.
+func isDatasetWithin(name, parent string) bool {
+	return name == parent || strings.HasPrefix(name, parent+"/")
+}
+
+// datasetPool returns the pool a dataset belongs to.
+func datasetPool(name string) string {
+	pool, _, _ := strings.Cut(name, "/")
+	return pool
+}
+
+// containsFold returns true if values contains s, ignoring case.
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
 		}
 	}
+	return false
+}
 
-	return cfg, nil
+// containsInt returns true if values contains n.
+func containsInt(values []int, n int) bool {
+	for _, v := range values {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// readCredentialFile returns the trimmed content of a credential file.
+func readCredentialFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Deprecations returns a message for each democratic-csi style key that LoadConfig
+// translated or dropped.
+func (c *Config) Deprecations() []string {
+	return c.deprecations
+}
+
+// credentialFiles returns the credential files referenced by the config.
+func (c *Config) credentialFiles() []string {
+	var files []string
+	for _, f := range []string{c.TrueNAS.APIKeyFile, c.TrueNAS.PasswordFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
 }
 
 // GetDriverShareType returns the share type based on driver name.
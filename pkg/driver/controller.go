@@ -9,7 +9,6 @@ import (
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
-	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -120,6 +119,9 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	if name == "" {
 		return nil, status.Error(codes.InvalidArgument, "volume name is required")
 	}
+	if len(req.GetVolumeCapabilities()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "volume capabilities are required")
+	}
 
 	// Enhanced logging for debugging volsync and backup scenarios
 	contentSourceInfo := "none"
@@ -134,10 +136,11 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 
 	// Lock on volume name to prevent concurrent creates
 	lockKey := "volume:" + name
-	if !d.acquireOperationLock(lockKey) {
-		return nil, status.Error(codes.Aborted, "operation already in progress for this volume")
+	lock, err := d.acquireOperationLock(ctx, lockKey, "CreateVolume")
+	if err != nil {
+		return nil, err
 	}
-	defer d.releaseOperationLock(lockKey)
+	defer d.releaseOperationLock(lock)
 
 	// Calculate capacity
 	capacityBytes := int64(0)
@@ -150,13 +153,22 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 
 	// Get volume ID from name
 	volumeID := d.sanitizeVolumeID(name)
-	datasetName := path.Join(d.config.ZFS.DatasetParentName, volumeID)
+	datasetName := path.Join(d.GetConfig().ZFS.DatasetParentName, volumeID)
 
 	// Get share type from StorageClass parameters (with fallback to driver name)
 	params := req.GetParameters()
-	shareType := d.config.GetShareType(params)
+	shareType := d.GetConfig().GetShareType(params)
 	klog.Infof("CreateVolume: using share type %s for volume %s", shareType, volumeID)
 
+	snapshotPolicies, err := parseSnapshotPolicies(params[snapshotPoliciesParam], d.GetConfig().ZFS.SnapshotPolicies)
+	if err != nil {
+		return nil, err
+	}
+	replicate, err := d.GetConfig().parseReplication(params[replicationParam], snapshotPolicies)
+	if err != nil {
+		return nil, err
+	}
+
 	// Check if volume already exists
 	// Check if volume already exists
 	existingDS, err := d.truenasClient.DatasetGet(ctx, datasetName)
@@ -164,39 +176,31 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		// Volume exists - check and ensure properties are set
 		klog.Infof("Volume %s already exists", volumeID)
 
-		// Ensure properties are set (idempotent)
-		g, gCtx := errgroup.WithContext(ctx)
-		g.Go(func() error {
-			if prop, ok := existingDS.UserProperties[PropManagedResource]; ok && prop.Value == "true" {
-				return nil
-			}
-			if err := d.truenasClient.DatasetSetUserProperty(gCtx, datasetName, PropManagedResource, "true"); err != nil {
-				return fmt.Errorf("failed to set managed resource property: %w", err)
-			}
-			return nil
-		})
-		g.Go(func() error {
-			if prop, ok := existingDS.UserProperties[PropProvisionSuccess]; ok && prop.Value == "true" {
-				return nil
+		// The same name with an incompatible size is a different volume
+		if hasFixedCapacity(existingDS) {
+			existing := d.getDatasetCapacity(existingDS)
+			limit := req.GetCapacityRange().GetLimitBytes()
+			if existing < capacityBytes || limit > 0 && existing > limit {
+				return nil, status.Errorf(codes.AlreadyExists, "volume %s already exists with capacity %d", volumeID, existing)
 			}
-			if err := d.truenasClient.DatasetSetUserProperty(gCtx, datasetName, PropProvisionSuccess, "true"); err != nil {
-				return fmt.Errorf("failed to set provision success property: %w", err)
-			}
-			return nil
-		})
-		g.Go(func() error {
-			if prop, ok := existingDS.UserProperties[PropCSIVolumeName]; ok && prop.Value == name {
-				return nil
-			}
-			if err := d.truenasClient.DatasetSetUserProperty(gCtx, datasetName, PropCSIVolumeName, name); err != nil {
-				return fmt.Errorf("failed to set CSI volume name property: %w", err)
+		}
+
+		// Ensure properties are set (idempotent), updating only those that differ
+		missing := map[string]string{}
+		for key, value := range volumeProperties(name, snapshotPolicies) {
+			if prop, ok := existingDS.UserProperties[key]; !ok || prop.Value != value {
+				missing[key] = value
 			}
-			return nil
-		})
-		// Wait for all property sets to complete
-		if err := g.Wait(); err != nil {
+		}
+		if err := d.setUserProperties(ctx, existingDS, missing); err != nil {
 			klog.Errorf("Failed to ensure properties for existing volume %s: %v", volumeID, err)
-			return nil, status.Errorf(codes.Internal, "failed to ensure volume properties: %v", err)
+			return nil, status.Errorf(truenasCode(err), "failed to ensure volume properties: %v", err)
+		}
+
+		if replicate {
+			if err := d.ensureReplicationTask(ctx, datasetName, existingDS, snapshotPolicies); err != nil {
+				return nil, status.Errorf(truenasCode(err), "failed to set up replication: %v", err)
+			}
 		}
 
 		// CRITICAL: Ensure share exists for existing volumes (fixes missing iSCSI targets after retries)
@@ -206,9 +210,9 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 			return nil, err
 		}
 
-		volumeContext, err := d.getVolumeContext(ctx, datasetName, shareType)
+		volumeContext, err := d.getVolumeContext(ctx, existingDS, shareType)
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to get volume context: %v", err)
+			return nil, status.Errorf(truenasCode(err), "failed to get volume context: %v", err)
 		}
 		return &csi.CreateVolumeResponse{
 			Volume: &csi.Volume{
@@ -221,20 +225,24 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 
 	// Handle volume content source (clone from snapshot or volume)
 	var contentSource *csi.VolumeContentSource
+	var ds *truenas.Dataset
 	if req.GetVolumeContentSource() != nil {
 		contentSource = req.GetVolumeContentSource()
 		if err := d.handleVolumeContentSource(ctx, datasetName, contentSource, capacityBytes); err != nil {
 			return nil, err
 		}
+		if ds, err = d.truenasClient.DatasetGet(ctx, datasetName); err != nil {
+			return nil, status.Errorf(truenasCode(err), "failed to get cloned dataset: %v", err)
+		}
 	} else {
 		// Create new dataset
-		if err := d.createDataset(ctx, datasetName, capacityBytes, shareType); err != nil {
+		if ds, err = d.createDataset(ctx, datasetName, capacityBytes, shareType); err != nil {
 			return nil, err
 		}
 	}
 
 	// Create share (NFS, iSCSI, or NVMe-oF)
-	if err := d.createShare(ctx, datasetName, name, shareType); err != nil {
+	if err := d.createShare(ctx, ds, name, shareType); err != nil {
 		// Cleanup on failure
 		if delErr := d.truenasClient.DatasetDelete(ctx, datasetName, false, false); delErr != nil {
 			klog.Warningf("Failed to cleanup dataset after share creation failure: %v", delErr)
@@ -242,37 +250,24 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		return nil, err
 	}
 
-	// Mark as managed and successful - run property sets in parallel
-	g, gCtx := errgroup.WithContext(ctx)
-	g.Go(func() error {
-		if err := d.truenasClient.DatasetSetUserProperty(gCtx, datasetName, PropManagedResource, "true"); err != nil {
-			return fmt.Errorf("failed to set managed resource property: %w", err)
-		}
-		return nil
-	})
-	g.Go(func() error {
-		if err := d.truenasClient.DatasetSetUserProperty(gCtx, datasetName, PropProvisionSuccess, "true"); err != nil {
-			return fmt.Errorf("failed to set provision success property: %w", err)
-		}
-		return nil
-	})
-	g.Go(func() error {
-		if err := d.truenasClient.DatasetSetUserProperty(gCtx, datasetName, PropCSIVolumeName, name); err != nil {
-			return fmt.Errorf("failed to set CSI volume name property: %w", err)
-		}
-		return nil
-	})
-	// Wait for all property sets to complete
-	if err := g.Wait(); err != nil {
+	// Mark as managed and successful in one update
+	if err := d.setUserProperties(ctx, ds, volumeProperties(name, snapshotPolicies)); err != nil {
 		// If property setting fails, return error so it retries
 		klog.Errorf("Failed to set properties for volume %s: %v", volumeID, err)
-		return nil, status.Errorf(codes.Internal, "failed to set volume properties: %v", err)
+		return nil, status.Errorf(truenasCode(err), "failed to set volume properties: %v", err)
+	}
+
+	// A failure here is retried through the existing volume path above
+	if replicate {
+		if err := d.ensureReplicationTask(ctx, datasetName, nil, snapshotPolicies); err != nil {
+			return nil, status.Errorf(truenasCode(err), "failed to set up replication: %v", err)
+		}
 	}
 
 	// Get volume context for response
-	volumeContext, err := d.getVolumeContext(ctx, datasetName, shareType)
+	volumeContext, err := d.getVolumeContext(ctx, ds, shareType)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to get volume context: %v", err)
+		return nil, status.Errorf(truenasCode(err), "failed to get volume context: %v", err)
 	}
 
 	klog.Infof("CreateVolume completed: volume=%s, shareType=%s, contentSource=%s, elapsed=%v",
@@ -299,12 +294,17 @@ func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 
 	// Lock on volume ID
 	lockKey := "volume:" + volumeID
-	if !d.acquireOperationLock(lockKey) {
-		return nil, status.Error(codes.Aborted, "operation already in progress for this volume")
+	lock, err := d.acquireOperationLock(ctx, lockKey, "DeleteVolume")
+	if err != nil {
+		return nil, err
 	}
-	defer d.releaseOperationLock(lockKey)
+	defer d.releaseOperationLock(lock)
 
-	datasetName := path.Join(d.config.ZFS.DatasetParentName, volumeID)
+	ref, err := d.resolveVolume(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	datasetName := ref.datasetName
 
 	// Check if volume exists (idempotency - return success if already deleted)
 	ds, err := d.truenasClient.DatasetGet(ctx, datasetName)
@@ -313,38 +313,57 @@ func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 			klog.Infof("Volume %s already deleted or does not exist", volumeID)
 			return &csi.DeleteVolumeResponse{}, nil
 		}
+		// Static volumes are only deleted once confirmed adopted
+		if ref.static {
+			return nil, status.Errorf(truenasCode(err), "failed to get dataset %s: %v", datasetName, err)
+		}
 		// Log but don't fail - try to proceed with deletion anyway
 		klog.V(4).Infof("Could not verify volume existence: %v", err)
 	}
 
+	// Pre-existing datasets are never deleted unless explicitly adopted
+	if ref.static && !isAdopted(ds) {
+		klog.Infof("Volume %s is a static volume that has not been adopted, leaving dataset %s and its share in place", volumeID, datasetName)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
 	// Determine share type from dataset type
 	// Filesystem = NFS, Volume (zvol) = iSCSI or NVMe-oF
-	shareType := d.config.GetDriverShareType() // fallback to driver name
-	if ds != nil {
-		switch ds.Type {
-		case "FILESYSTEM":
-			shareType = "nfs"
-		case "VOLUME":
-			// For zvol, prefer iSCSI unless driver specifically configured for NVMe-oF
-			if d.config.GetDriverShareType() == "nvmeof" {
-				shareType = "nvmeof"
-			} else {
-				shareType = "iscsi"
-			}
-		}
+	shareType := d.GetConfig().GetDriverShareType() // fallback to driver name
+	if ref.static {
+		shareType = ref.shareType
+	} else if ds != nil {
+		shareType = d.GetConfig().shareTypeForDataset(ds)
 	}
 
 	// Delete share first (errors are fatal to prevent orphaned targets)
-	if err := d.deleteShare(ctx, datasetName, shareType); err != nil {
+	if err := d.deleteShare(ctx, ds, datasetName, shareType); err != nil {
 		klog.Errorf("Failed to delete share for volume %s: %v", volumeID, err)
-		return nil, status.Errorf(codes.Internal, "failed to delete share: %v", err)
+		return nil, status.Errorf(truenasCode(err), "failed to delete share: %v", err)
+	}
+
+	// Stop replicating before the dataset goes away; the replica on the secondary is kept
+	if err := d.deleteReplicationTask(ctx, ds); err != nil {
+		klog.Errorf("Failed to delete replication task for volume %s: %v", volumeID, err)
+		return nil, status.Errorf(truenasCode(err), "failed to delete replication task: %v", err)
+	}
+
+	// With soft-delete, move the dataset to the trash for the purger to destroy later
+	if d.GetConfig().trashEnabled(datasetName) {
+		trashedName, err := d.trashVolume(ctx, datasetName)
+		if err != nil {
+			klog.Errorf("Failed to move volume %s to the trash: %v", volumeID, err)
+			return nil, status.Errorf(truenasCode(err), "failed to move volume to the trash: %v", err)
+		}
+		klog.Infof("Volume %s moved to the trash as %s", volumeID, trashedName)
+		return &csi.DeleteVolumeResponse{}, nil
 	}
 
 	// Delete dataset (recursive to handle snapshots, force to ignore busy state)
 	if err := d.truenasClient.DatasetDelete(ctx, datasetName, true, true); err != nil {
 		// DatasetDelete already handles "not found" errors, so this is a real error
 		klog.Errorf("Failed to delete dataset for volume %s: %v", volumeID, err)
-		return nil, status.Errorf(codes.Internal, "failed to delete volume: %v", err)
+		return nil, status.Errorf(truenasCode(err), "failed to delete volume: %v", err)
 	}
 
 	klog.Infof("Volume %s deleted successfully", volumeID)
@@ -377,9 +396,11 @@ func (d *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.Valida
 	}
 
 	// Check volume exists
-	datasetName := path.Join(d.config.ZFS.DatasetParentName, volumeID)
-	_, err := d.truenasClient.DatasetGet(ctx, datasetName)
+	ref, err := d.resolveVolume(volumeID)
 	if err != nil {
+		return nil, err
+	}
+	if _, err := d.truenasClient.DatasetGet(ctx, ref.datasetName); err != nil {
 		return nil, status.Errorf(codes.NotFound, "volume not found: %s", volumeID)
 	}
 
@@ -413,9 +434,9 @@ func (d *Driver) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (
 		limit = 100
 	}
 
-	datasets, err := d.truenasClient.DatasetList(ctx, d.config.ZFS.DatasetParentName, limit, offset)
+	datasets, err := d.truenasClient.DatasetList(ctx, d.GetConfig().ZFS.DatasetParentName, limit, offset)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to list volumes: %v", err)
+		return nil, status.Errorf(truenasCode(err), "failed to list volumes: %v", err)
 	}
 
 	entries := make([]*csi.ListVolumesResponse_Entry, 0)
@@ -452,9 +473,9 @@ func (d *Driver) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (
 func (d *Driver) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
 	klog.V(4).Info("GetCapacity called")
 
-	available, err := d.truenasClient.GetPoolAvailable(ctx, d.config.ZFS.DatasetParentName)
+	available, err := d.truenasClient.GetPoolAvailable(ctx, d.GetConfig().ZFS.DatasetParentName)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to get capacity: %v", err)
+		return nil, status.Errorf(truenasCode(err), "failed to get capacity: %v", err)
 	}
 
 	return &csi.GetCapacityResponse{
@@ -479,23 +500,42 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 
 	// Lock on snapshot name
 	lockKey := "snapshot:" + name
-	if !d.acquireOperationLock(lockKey) {
-		return nil, status.Error(codes.Aborted, "operation already in progress for this snapshot")
+	lock, err := d.acquireOperationLock(ctx, lockKey, "CreateSnapshot")
+	if err != nil {
+		return nil, err
 	}
-	defer d.releaseOperationLock(lockKey)
+	defer d.releaseOperationLock(lock)
 
-	datasetName := path.Join(d.config.ZFS.DatasetParentName, sourceVolumeID)
+	ref, err := d.resolveVolume(sourceVolumeID)
+	if err != nil {
+		return nil, err
+	}
+	// Snapshot IDs are looked up under the parent dataset
+	if ref.static {
+		return nil, status.Errorf(codes.InvalidArgument, "snapshots of static volume %s are not supported", sourceVolumeID)
+	}
+	datasetName := ref.datasetName
 	snapshotID := d.sanitizeVolumeID(name)
 
-	// Create snapshot
-	snap, err := d.truenasClient.SnapshotCreate(ctx, datasetName, snapshotID)
+	// Snapshot names are unique across volumes; a retry returns the existing snapshot
+	snap, err := d.truenasClient.SnapshotFindByName(ctx, d.GetConfig().ZFS.DatasetParentName, snapshotID)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to create snapshot: %v", err)
+		return nil, status.Errorf(truenasCode(err), "failed to find snapshot: %v", err)
+	}
+	if snap != nil && snap.Dataset != datasetName {
+		return nil, status.Errorf(codes.AlreadyExists, "snapshot %s already exists for volume %s", name, path.Base(snap.Dataset))
+	}
+
+	// Create snapshot
+	if snap == nil {
+		snap, err = d.truenasClient.SnapshotCreate(ctx, datasetName, snapshotID)
+		if err != nil {
+			return nil, status.Errorf(truenasCode(err), "failed to create snapshot: %v", err)
+		}
 	}
 
 	// Set snapshot properties in parallel
-	// Set snapshot properties in parallel
-	g, gCtx := errgroup.WithContext(ctx)
+	g, gCtx := tracedGroup(ctx, "CreateSnapshot.setProperties")
 	g.Go(func() error {
 		if err := d.truenasClient.SnapshotSetUserProperty(gCtx, snap.ID, PropManagedResource, "true"); err != nil {
 			return fmt.Errorf("failed to set managed resource property on snapshot: %w", err)
@@ -516,7 +556,7 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 	})
 	if err := g.Wait(); err != nil {
 		klog.Errorf("Failed to set properties for snapshot %s: %v", snapshotID, err)
-		return nil, status.Errorf(codes.Internal, "failed to set snapshot properties: %v", err)
+		return nil, status.Errorf(truenasCode(err), "failed to set snapshot properties: %v", err)
 	}
 
 	snapshotSize := snap.GetSnapshotSize()
@@ -545,20 +585,21 @@ func (d *Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequ
 
 	// Lock on snapshot ID
 	lockKey := "snapshot:" + snapshotID
-	if !d.acquireOperationLock(lockKey) {
-		return nil, status.Error(codes.Aborted, "operation already in progress for this snapshot")
+	lock, err := d.acquireOperationLock(ctx, lockKey, "DeleteSnapshot")
+	if err != nil {
+		return nil, err
 	}
-	defer d.releaseOperationLock(lockKey)
+	defer d.releaseOperationLock(lock)
 
 	// Find and delete the snapshot using efficient query (PERF-001 fix)
-	snap, err := d.truenasClient.SnapshotFindByName(ctx, d.config.ZFS.DatasetParentName, snapshotID)
+	snap, err := d.findSnapshot(ctx, snapshotID)
 	if err != nil {
 		// If parent dataset doesn't exist, the snapshot is effectively deleted
 		if truenas.IsNotFoundError(err) {
 			klog.Infof("Snapshot %s parent not found, treating as deleted", snapshotID)
 			return &csi.DeleteSnapshotResponse{}, nil
 		}
-		return nil, status.Errorf(codes.Internal, "failed to find snapshot: %v", err)
+		return nil, status.Errorf(truenasCode(err), "failed to find snapshot: %v", err)
 	}
 
 	if snap == nil {
@@ -566,6 +607,12 @@ func (d *Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequ
 		return &csi.DeleteSnapshotResponse{}, nil
 	}
 
+	// Policy snapshots are read-only to CSI; their policy prunes them
+	if isPolicySnapshot(snap) {
+		klog.Infof("Snapshot %s was taken by a snapshot policy, leaving it for the policy to prune", snapshotID)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
 	if err := d.truenasClient.SnapshotDelete(ctx, snap.ID, false, false); err != nil {
 		// Handle "not found" as success (idempotency)
 		if truenas.IsNotFoundError(err) {
@@ -573,7 +620,7 @@ func (d *Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequ
 			return &csi.DeleteSnapshotResponse{}, nil
 		}
 		klog.Errorf("Failed to delete snapshot %s: %v", snapshotID, err)
-		return nil, status.Errorf(codes.Internal, "failed to delete snapshot: %v", err)
+		return nil, status.Errorf(truenasCode(err), "failed to delete snapshot: %v", err)
 	}
 	klog.Infof("Snapshot %s deleted successfully", snapshotID)
 
@@ -600,13 +647,34 @@ func (d *Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsReques
 		limit = 100
 	}
 
-	snapshots, err := d.truenasClient.SnapshotListAll(ctx, d.config.ZFS.DatasetParentName, limit, offset)
+	parent := d.GetConfig().ZFS.DatasetParentName
+	snapshots, err := d.truenasClient.SnapshotListAll(ctx, parent, limit, offset)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to list snapshots: %v", err)
+		return nil, status.Errorf(truenasCode(err), "failed to list snapshots: %v", err)
 	}
 
 	entries := make([]*csi.ListSnapshotsResponse_Entry, 0)
 	for _, snap := range snapshots {
+		// Policy snapshots share names across volumes, so their IDs include the volume
+		if isPolicySnapshot(snap) {
+			snapshotID := strings.TrimPrefix(snap.ID, parent+"/")
+			sourceVolumeID := path.Base(snap.Dataset)
+			if req.GetSnapshotId() != "" && snapshotID != req.GetSnapshotId() ||
+				req.GetSourceVolumeId() != "" && sourceVolumeID != req.GetSourceVolumeId() {
+				continue
+			}
+			entries = append(entries, &csi.ListSnapshotsResponse_Entry{
+				Snapshot: &csi.Snapshot{
+					SnapshotId:     snapshotID,
+					SourceVolumeId: sourceVolumeID,
+					SizeBytes:      snap.GetSnapshotSize(),
+					CreationTime:   timestampProto(snap.GetCreationTime()),
+					ReadyToUse:     true,
+				},
+			})
+			continue
+		}
+
 		// Skip if not managed by CSI
 		if prop, ok := snap.UserProperties[PropManagedResource]; !ok || prop.Value != "true" {
 			continue
@@ -674,32 +742,46 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 
 	// Lock on volume ID (OTHER-004 fix: prevent concurrent expansions of same volume)
 	lockKey := "volume:" + volumeID
-	if !d.acquireOperationLock(lockKey) {
-		return nil, status.Error(codes.Aborted, "operation already in progress for this volume")
+	lock, err := d.acquireOperationLock(ctx, lockKey, "ControllerExpandVolume")
+	if err != nil {
+		return nil, err
 	}
-	defer d.releaseOperationLock(lockKey)
+	defer d.releaseOperationLock(lock)
 
-	datasetName := path.Join(d.config.ZFS.DatasetParentName, volumeID)
+	ref, err := d.resolveVolume(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	datasetName := ref.datasetName
+
+	// Static volumes carry their protocol, so take the resource type from it
+	resourceType := d.GetConfig().GetZFSResourceType()
+	if ref.static {
+		resourceType = "volume"
+		if ref.shareType == "nfs" {
+			resourceType = "filesystem"
+		}
+	}
 
 	// For zvols (iSCSI/NVMe-oF), expand the volsize
-	if d.config.GetZFSResourceType() == "volume" {
+	if resourceType == "volume" {
 		if err := d.truenasClient.DatasetExpand(ctx, datasetName, capacityBytes); err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to expand volume: %v", err)
+			return nil, status.Errorf(truenasCode(err), "failed to expand volume: %v", err)
 		}
 	}
 
 	// For filesystems (NFS), update quota if enabled
-	if d.config.GetZFSResourceType() == "filesystem" && d.config.ZFS.DatasetEnableQuotas {
+	if resourceType == "filesystem" && d.GetConfig().ZFS.DatasetEnableQuotas {
 		params := &truenas.DatasetUpdateParams{
 			Refquota: capacityBytes,
 		}
 		if _, err := d.truenasClient.DatasetUpdate(ctx, datasetName, params); err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to update quota: %v", err)
+			return nil, status.Errorf(truenasCode(err), "failed to update quota: %v", err)
 		}
 	}
 
 	// Node expansion may be required for filesystems
-	nodeExpansionRequired := d.config.GetZFSResourceType() == "volume"
+	nodeExpansionRequired := resourceType == "volume"
 
 	klog.Infof("Volume %s expanded successfully", volumeID)
 
@@ -716,8 +798,11 @@ func (d *Driver) ControllerGetVolume(ctx context.Context, req *csi.ControllerGet
 		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
 	}
 
-	datasetName := path.Join(d.config.ZFS.DatasetParentName, volumeID)
-	ds, err := d.truenasClient.DatasetGet(ctx, datasetName)
+	ref, err := d.resolveVolume(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	ds, err := d.truenasClient.DatasetGet(ctx, ref.datasetName)
 	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "volume not found: %s", volumeID)
 	}
@@ -762,6 +847,15 @@ func isAlphanumeric(c byte) bool {
 	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
 }
 
+// hasFixedCapacity reports whether getDatasetCapacity returns a provisioned size rather than free space.
+func hasFixedCapacity(ds *truenas.Dataset) bool {
+	if ds.Type == "VOLUME" {
+		return true
+	}
+	parsed, ok := ds.Refquota.Parsed.(float64)
+	return ok && parsed > 0
+}
+
 func (d *Driver) getDatasetCapacity(ds *truenas.Dataset) int64 {
 	// For zvols, use volsize
 	if ds.Type == "VOLUME" {
@@ -779,7 +873,20 @@ func (d *Driver) getDatasetCapacity(ds *truenas.Dataset) int64 {
 	return 0
 }
 
-func (d *Driver) createDataset(ctx context.Context, datasetName string, capacityBytes int64, shareType string) error {
+// volumeProperties returns the user properties CreateVolume sets on a volume it provisioned.
+func volumeProperties(name string, snapshotPolicies string) map[string]string {
+	props := map[string]string{
+		PropManagedResource:  "true",
+		PropProvisionSuccess: "true",
+		PropCSIVolumeName:    name,
+	}
+	if snapshotPolicies != "" {
+		props[PropSnapshotPolicies] = snapshotPolicies
+	}
+	return props
+}
+
+func (d *Driver) createDataset(ctx context.Context, datasetName string, capacityBytes int64, shareType string) (*truenas.Dataset, error) {
 	params := &truenas.DatasetCreateParams{
 		Name: datasetName,
 	}
@@ -787,22 +894,21 @@ func (d *Driver) createDataset(ctx context.Context, datasetName string, capacity
 	if shareType == "nfs" {
 		// Create filesystem for NFS
 		params.Type = "FILESYSTEM"
-		if d.config.ZFS.DatasetEnableQuotas {
+		if d.GetConfig().ZFS.DatasetEnableQuotas {
 			params.Refquota = capacityBytes
 		}
-		if d.config.ZFS.DatasetEnableReservation {
+		if d.GetConfig().ZFS.DatasetEnableReservation {
 			params.Refreservation = capacityBytes
 		}
 	} else {
 		// Create zvol for iSCSI/NVMe-oF
 		params.Type = "VOLUME"
 		params.Volsize = capacityBytes
-		params.Volblocksize = d.config.ZFS.ZvolBlocksize
+		params.Volblocksize = d.GetConfig().ZFS.ZvolBlocksize
 		params.Sparse = true
 	}
 
-	_, err := d.truenasClient.DatasetCreate(ctx, params)
-	return err
+	return d.truenasClient.DatasetCreate(ctx, params)
 }
 
 func (d *Driver) handleVolumeContentSource(ctx context.Context, datasetName string, source *csi.VolumeContentSource, capacityBytes int64) error {
@@ -815,9 +921,9 @@ func (d *Driver) handleVolumeContentSource(ctx context.Context, datasetName stri
 		klog.Infof("Creating volume from snapshot: %s -> %s", snapshotID, datasetName)
 
 		// Find the snapshot using efficient query (PERF-001 fix)
-		snap, err := d.truenasClient.SnapshotFindByName(ctx, d.config.ZFS.DatasetParentName, snapshotID)
+		snap, err := d.findSnapshot(ctx, snapshotID)
 		if err != nil {
-			return status.Errorf(codes.Internal, "failed to find snapshot: %v", err)
+			return status.Errorf(truenasCode(err), "failed to find snapshot: %v", err)
 		}
 
 		if snap == nil {
@@ -828,7 +934,7 @@ func (d *Driver) handleVolumeContentSource(ctx context.Context, datasetName stri
 		klog.V(4).Infof("Found snapshot %s for cloning", sourceSnapshot)
 
 		if err := d.truenasClient.SnapshotClone(ctx, sourceSnapshot, datasetName); err != nil {
-			return status.Errorf(codes.Internal, "failed to clone snapshot: %v", err)
+			return status.Errorf(truenasCode(err), "failed to clone snapshot: %v", err)
 		}
 		klog.Infof("Snapshot clone created: %s -> %s", sourceSnapshot, datasetName)
 
@@ -851,35 +957,35 @@ func (d *Driver) handleVolumeContentSource(ctx context.Context, datasetName stri
 			}
 		}
 
-		// Set content source properties in parallel (BUG-004 fix: log errors from Wait)
-		g, gCtx := errgroup.WithContext(ctx)
-		g.Go(func() error {
-			if err := d.truenasClient.DatasetSetUserProperty(gCtx, datasetName, PropVolumeContentSourceType, "snapshot"); err != nil {
-				klog.Warningf("Failed to set volume content source type property: %v", err)
-			}
-			return nil
-		})
-		g.Go(func() error {
-			if err := d.truenasClient.DatasetSetUserProperty(gCtx, datasetName, PropVolumeContentSourceID, snapshotID); err != nil {
-				klog.Warningf("Failed to set volume content source ID property: %v", err)
-			}
-			return nil
-		})
-		if err := g.Wait(); err != nil {
-			klog.Warningf("Error setting content source properties for snapshot clone: %v", err)
+		if err := d.truenasClient.DatasetSetUserProperties(ctx, datasetName, map[string]string{
+			PropVolumeContentSourceType: "snapshot",
+			PropVolumeContentSourceID:   snapshotID,
+		}); err != nil {
+			klog.Warningf("Failed to set content source properties for snapshot clone: %v", err)
 		}
 
 	} else if volume := source.GetVolume(); volume != nil {
 		// Clone from volume
 		sourceVolumeID := volume.GetVolumeId()
-		sourceDataset := path.Join(d.config.ZFS.DatasetParentName, sourceVolumeID)
+		sourceRef, err := d.resolveVolume(sourceVolumeID)
+		if err != nil {
+			return err
+		}
+		sourceDataset := sourceRef.datasetName
+		exists, err := d.truenasClient.DatasetExists(ctx, sourceDataset)
+		if err != nil {
+			return status.Errorf(truenasCode(err), "failed to find source volume: %v", err)
+		}
+		if !exists {
+			return status.Errorf(codes.NotFound, "source volume not found: %s", sourceVolumeID)
+		}
 		klog.Infof("Creating volume from volume: %s -> %s", sourceVolumeID, datasetName)
 
 		// Create a snapshot of source volume, then clone it
 		tempSnapshotName := fmt.Sprintf("clone-source-%s", d.sanitizeVolumeID(path.Base(datasetName)))
 		snap, err := d.truenasClient.SnapshotCreate(ctx, sourceDataset, tempSnapshotName)
 		if err != nil {
-			return status.Errorf(codes.Internal, "failed to create source snapshot: %v", err)
+			return status.Errorf(truenasCode(err), "failed to create source snapshot: %v", err)
 		}
 		klog.V(4).Infof("Created temporary snapshot %s for volume clone", snap.ID)
 
@@ -887,7 +993,7 @@ func (d *Driver) handleVolumeContentSource(ctx context.Context, datasetName stri
 			if delErr := d.truenasClient.SnapshotDelete(ctx, snap.ID, false, false); delErr != nil {
 				klog.Warningf("Failed to cleanup snapshot after clone failure: %v", delErr)
 			}
-			return status.Errorf(codes.Internal, "failed to clone volume: %v", err)
+			return status.Errorf(truenasCode(err), "failed to clone volume: %v", err)
 		}
 		klog.Infof("Volume clone created: %s -> %s", sourceVolumeID, datasetName)
 
@@ -909,41 +1015,29 @@ func (d *Driver) handleVolumeContentSource(ctx context.Context, datasetName stri
 			}
 		}
 
-		// Set content source properties in parallel (BUG-004 fix: log errors from Wait)
-		g, gCtx := errgroup.WithContext(ctx)
-		g.Go(func() error {
-			if err := d.truenasClient.DatasetSetUserProperty(gCtx, datasetName, PropVolumeContentSourceType, "volume"); err != nil {
-				klog.Warningf("Failed to set volume content source type property: %v", err)
-			}
-			return nil
-		})
-		g.Go(func() error {
-			if err := d.truenasClient.DatasetSetUserProperty(gCtx, datasetName, PropVolumeContentSourceID, sourceVolumeID); err != nil {
-				klog.Warningf("Failed to set volume content source ID property: %v", err)
-			}
-			return nil
-		})
-		if err := g.Wait(); err != nil {
-			klog.Warningf("Error setting content source properties for volume clone: %v", err)
+		if err := d.truenasClient.DatasetSetUserProperties(ctx, datasetName, map[string]string{
+			PropVolumeContentSourceType: "volume",
+			PropVolumeContentSourceID:   sourceVolumeID,
+		}); err != nil {
+			klog.Warningf("Failed to set content source properties for volume clone: %v", err)
 		}
 	}
 
 	return nil
 }
 
-func (d *Driver) getVolumeContext(ctx context.Context, datasetName string, shareType string) (map[string]string, error) {
+// getVolumeContext returns the volume context for a shared dataset, finding the share
+// through the IDs stored on ds.
+func (d *Driver) getVolumeContext(ctx context.Context, ds *truenas.Dataset, shareType string) (map[string]string, error) {
 	context := map[string]string{
 		"node_attach_driver": shareType,
 	}
-
-	ds, err := d.truenasClient.DatasetGet(ctx, datasetName)
-	if err != nil {
-		return nil, err
-	}
+	datasetName := ds.Name
+	var err error
 
 	switch shareType {
 	case "nfs":
-		context["server"] = d.config.NFS.ShareHost
+		context["server"] = d.GetConfig().NFS.ShareHost
 		context["share"] = ds.Mountpoint
 
 	case "iscsi":
@@ -964,12 +1058,12 @@ func (d *Driver) getVolumeContext(ctx context.Context, datasetName string, share
 		// Fallback: look up target by name (same name generation as createISCSIShare)
 		if target == nil {
 			iscsiName := path.Base(datasetName)
-			if d.config.ISCSI.NameSuffix != "" {
-				iscsiName = iscsiName + d.config.ISCSI.NameSuffix
+			if d.GetConfig().ISCSI.NameSuffix != "" {
+				iscsiName = iscsiName + d.GetConfig().ISCSI.NameSuffix
 			}
 			target, err = d.truenasClient.ISCSITargetFindByName(ctx, iscsiName)
 			if err != nil {
-				return nil, status.Errorf(codes.Internal, "failed to find iSCSI target by name %s: %v", iscsiName, err)
+				return nil, status.Errorf(truenasCode(err), "failed to find iSCSI target by name %s: %v", iscsiName, err)
 			}
 			if target == nil {
 				return nil, status.Errorf(codes.FailedPrecondition, "iSCSI target not found for volume %s (looked up by name: %s)", datasetName, iscsiName)
@@ -979,12 +1073,12 @@ func (d *Driver) getVolumeContext(ctx context.Context, datasetName string, share
 
 		globalCfg, err := d.truenasClient.ISCSIGlobalConfigGet(ctx)
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to get iSCSI global config: %v", err)
+			return nil, status.Errorf(truenasCode(err), "failed to get iSCSI global config: %v", err)
 		}
 		context["iqn"] = fmt.Sprintf("%s:%s", globalCfg.Basename, target.Name)
-		context["portal"] = d.config.ISCSI.TargetPortal
+		context["portal"] = d.GetConfig().ISCSI.TargetPortal
 		context["lun"] = "0"
-		context["interface"] = d.config.ISCSI.Interface
+		context["interface"] = d.GetConfig().ISCSI.Interface
 
 	case "nvmeof":
 		// Get subsystem info from dataset properties, with fallback to name lookup
@@ -1004,15 +1098,15 @@ func (d *Driver) getVolumeContext(ctx context.Context, datasetName string, share
 		// Fallback: look up subsystem by NQN (same name generation as createNVMeoFShare)
 		if subsys == nil {
 			nqn := path.Base(datasetName)
-			if d.config.NVMeoF.NamePrefix != "" {
-				nqn = d.config.NVMeoF.NamePrefix + nqn
+			if d.GetConfig().NVMeoF.NamePrefix != "" {
+				nqn = d.GetConfig().NVMeoF.NamePrefix + nqn
 			}
-			if d.config.NVMeoF.NameSuffix != "" {
-				nqn = nqn + d.config.NVMeoF.NameSuffix
+			if d.GetConfig().NVMeoF.NameSuffix != "" {
+				nqn = nqn + d.GetConfig().NVMeoF.NameSuffix
 			}
 			subsys, err = d.truenasClient.NVMeoFSubsystemFindByNQN(ctx, nqn)
 			if err != nil {
-				return nil, status.Errorf(codes.Internal, "failed to find NVMe-oF subsystem by NQN %s: %v", nqn, err)
+				return nil, status.Errorf(truenasCode(err), "failed to find NVMe-oF subsystem by NQN %s: %v", nqn, err)
 			}
 			if subsys == nil {
 				return nil, status.Errorf(codes.FailedPrecondition, "NVMe-oF subsystem not found for volume %s (looked up by NQN: %s)", datasetName, nqn)
@@ -1021,9 +1115,9 @@ func (d *Driver) getVolumeContext(ctx context.Context, datasetName string, share
 		}
 
 		context["nqn"] = subsys.NQN
-		context["transport"] = d.config.NVMeoF.Transport
-		context["address"] = d.config.NVMeoF.TransportAddress
-		context["port"] = strconv.Itoa(d.config.NVMeoF.TransportServiceID)
+		context["transport"] = d.GetConfig().NVMeoF.Transport
+		context["address"] = d.GetConfig().NVMeoF.TransportAddress
+		context["port"] = strconv.Itoa(d.GetConfig().NVMeoF.TransportServiceID)
 	}
 
 	return context, nil
@@ -1035,6 +1129,25 @@ func timestampProto(unixSeconds int64) *timestamppb.Timestamp {
 	}
 }
 
+// findSnapshot looks up a snapshot by CSI snapshot ID. IDs are snapshot names found anywhere
+// under the parent dataset; a "<volume>@<name>" ID, as issued by democratic-csi, names the
+// snapshot directly. It returns nil if the snapshot doesn't exist.
+func (d *Driver) findSnapshot(ctx context.Context, snapshotID string) (*truenas.Snapshot, error) {
+	parent := d.GetConfig().ZFS.DatasetParentName
+	if !strings.Contains(snapshotID, "@") {
+		return d.truenasClient.SnapshotFindByName(ctx, parent, snapshotID)
+	}
+
+	snap, err := d.truenasClient.SnapshotGet(ctx, path.Join(parent, snapshotID))
+	if err != nil {
+		if truenas.IsNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return snap, nil
+}
+
 // extractSnapshotName safely extracts the snapshot name from a ZFS snapshot ID.
 // ZFS snapshot IDs are in format "dataset@snapshotname".
 // Returns the snapshot name and true if valid, empty string and false if invalid.
@@ -1046,3 +1159,26 @@ func extractSnapshotName(snapshotID string) (string, bool) {
 	}
 	return path.Base(parts[1]), true
 }
+
+// volumeListPageSize is the number of datasets fetched per query by listVolumes.
+const volumeListPageSize = 100
+
+// listVolumes returns every dataset directly under parent. All pages are read before it
+// returns, so callers can change or destroy the datasets without shifting the pages.
+func listVolumes(ctx context.Context, client truenas.ClientInterface, parent string) ([]*truenas.Dataset, error) {
+	var volumes []*truenas.Dataset
+	for offset := 0; ; offset += volumeListPageSize {
+		datasets, err := client.DatasetList(ctx, parent, volumeListPageSize, offset)
+		if err != nil {
+			return nil, err
+		}
+		for _, ds := range datasets {
+			if path.Dir(ds.Name) == parent {
+				volumes = append(volumes, ds)
+			}
+		}
+		if len(datasets) < volumeListPageSize {
+			return volumes, nil
+		}
+	}
+}
@@ -9,6 +9,12 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// volumeCapabilities is a single-node filesystem capability for CreateVolume requests.
+var volumeCapabilities = []*csi.VolumeCapability{{
+	AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+	AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+}}
+
 func TestCreateVolume(t *testing.T) {
 	// Setup
 	mockClient := truenas.NewMockClient()
@@ -31,6 +37,7 @@ func TestCreateVolume(t *testing.T) {
 		CapacityRange: &csi.CapacityRange{
 			RequiredBytes: 1024 * 1024 * 1024,
 		},
+		VolumeCapabilities: volumeCapabilities,
 	}
 	resp, err := d.CreateVolume(context.Background(), req)
 	assert.NoError(t, err)
@@ -2,19 +2,27 @@ package driver
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"k8s.io/klog/v2"
 
 	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/util"
 )
 
 // DriverConfig holds the driver initialization configuration.
@@ -26,6 +34,11 @@ type DriverConfig struct {
 	RunController bool
 	RunNode       bool
 	Config        *Config
+
+	// ConfigFile is watched and reloaded when it changes (optional)
+	ConfigFile string
+	// ConfigOverrides re-applies command-line overrides to reloaded configs (optional)
+	ConfigOverrides func(*Config)
 }
 
 // Driver is the TrueNAS Scale CSI driver.
@@ -42,21 +55,48 @@ type Driver struct {
 	runController bool
 	runNode       bool
 	config        *Config
+	configMu      sync.RWMutex
+
+	// Config file watched for reloads (empty disables reloading)
+	configFile      string
+	configOverrides func(*Config)
+	configHash      [sha256.Size]byte
+	reloadMu        sync.Mutex // Serializes ReloadConfig
+	reloadCancel    context.CancelFunc
+
+	// Stops the trash purger and snapshot scheduler (nil when not running)
+	backgroundCancel context.CancelFunc
 
 	// TrueNAS API client
 	truenasClient truenas.ClientInterface
 
+	// Runs node commands and mounts (fakes in tests)
+	exec    util.Exec
+	mounter util.Mounter
+
 	// gRPC server
 	server *grpc.Server
 
-	// Operation lock to prevent concurrent operations on same volume
-	operationLock sync.Map
+	// HTTP server for health and debug handlers (nil when disabled)
+	httpServer *http.Server
+	health     healthState
+
+	// Operation locks to prevent concurrent operations on same volume
+	operationLock lockManager
+
+	// identity names this replica as lock owner and leader election candidate
+	identity string
 
 	// Ready flag
 	ready bool
 
 	// Request counter for generating unique request IDs
 	requestCounter uint64
+
+	// Leader elector for active/standby controllers (nil when leader election is disabled)
+	leader       *leaderElector
+	leaderCancel context.CancelFunc
+	leaderDone   chan struct{}
 }
 
 // NewDriver creates a new TrueNAS CSI driver instance.
@@ -75,30 +115,128 @@ func NewDriver(cfg *DriverConfig) (*Driver, error) {
 	}
 
 	// Create TrueNAS API client
-	truenasClient, err := truenas.NewClient(&truenas.ClientConfig{
-		Host:              cfg.Config.TrueNAS.Host,
-		Port:              cfg.Config.TrueNAS.Port,
-		Protocol:          cfg.Config.TrueNAS.Protocol,
-		APIKey:            cfg.Config.TrueNAS.APIKey,
-		AllowInsecure:     cfg.Config.TrueNAS.AllowInsecure,
-		Timeout:           time.Duration(cfg.Config.TrueNAS.RequestTimeout) * time.Second,
-		ConnectTimeout:    time.Duration(cfg.Config.TrueNAS.ConnectTimeout) * time.Second,
-		MaxConcurrentReqs: cfg.Config.TrueNAS.MaxConcurrentRequests,
-	})
+	truenasClient, err := truenas.NewClient(driverClientConfig(cfg.Config, cfg.RunController))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create TrueNAS client: %w", err)
 	}
 
-	return &Driver{
-		name:          cfg.Name,
-		version:       cfg.Version,
-		nodeID:        cfg.NodeID,
-		endpoint:      cfg.Endpoint,
-		runController: cfg.RunController,
-		runNode:       cfg.RunNode,
-		config:        cfg.Config,
-		truenasClient: truenasClient,
-	}, nil
+	// Refuse to serve a release the controller can't work with, rather than failing per volume
+	if cfg.RunController {
+		timeout := time.Duration(cfg.Config.TrueNAS.RequestTimeout) * time.Second
+		if timeout <= 0 {
+			timeout = time.Minute
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := checkTrueNASRelease(ctx, truenasClient, cfg.Config)
+		cancel()
+		if err != nil {
+			_ = truenasClient.Close()
+			return nil, err
+		}
+	}
+
+	identity := cfg.Config.LeaderElection.Identity
+	if identity == "" {
+		identity, _ = os.Hostname()
+	}
+	if identity == "" {
+		identity = cfg.NodeID
+	}
+
+	locks := cfg.Config.Locks
+	exec := util.NewExec("")
+	d := &Driver{
+		name:            cfg.Name,
+		version:         cfg.Version,
+		nodeID:          cfg.NodeID,
+		endpoint:        cfg.Endpoint,
+		runController:   cfg.RunController,
+		runNode:         cfg.RunNode,
+		config:          cfg.Config,
+		truenasClient:   truenasClient,
+		exec:            exec,
+		mounter:         util.NewMounter(exec),
+		configFile:      cfg.ConfigFile,
+		configOverrides: cfg.ConfigOverrides,
+		identity:        identity,
+		operationLock: lockManager{
+			owner:   identity,
+			ttl:     time.Duration(locks.TTL) * time.Second,
+			maxWait: time.Duration(locks.WaitTimeout) * time.Second,
+		},
+	}
+
+	// Controller locks are persisted in TrueNAS; node locks stay local to the node
+	if cfg.RunController && !locks.DisablePersistence {
+		d.operationLock.client = truenasClient
+		d.operationLock.dataset = cfg.Config.ZFS.DatasetParentName
+		d.operationLock.persist = func(key string) bool {
+			return !strings.HasPrefix(key, "node-")
+		}
+		d.operationLock.exclusive = !cfg.Config.LeaderElection.Enabled
+	}
+
+	if cfg.RunController && cfg.Config.LeaderElection.Enabled {
+		le := cfg.Config.LeaderElection
+		if identity == "" {
+			return nil, fmt.Errorf("leaderElection.identity is required when hostname is unavailable")
+		}
+		if cfg.RunNode {
+			klog.Warning("Leader election with mode=all: the node service keeps serving on standby replicas")
+		}
+		d.leader = newLeaderElector(truenasClient, cfg.Config.ZFS.DatasetParentName, identity,
+			time.Duration(le.LeaseDuration)*time.Second,
+			time.Duration(le.RenewDeadline)*time.Second,
+			time.Duration(le.RetryPeriod)*time.Second)
+		d.leader.onStartedLeading = d.onControllerActive
+	}
+
+	if d.configFile != "" {
+		if d.configHash, err = configSourceHash(d.configFile, cfg.Config); err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// newClientConfig converts the TrueNAS section of the driver config to a client config.
+func newClientConfig(cfg *TrueNASConfig) *truenas.ClientConfig {
+	return &truenas.ClientConfig{
+		Host:              cfg.Host,
+		Port:              cfg.Port,
+		Protocol:          cfg.Protocol,
+		APIKey:            cfg.APIKey,
+		Username:          cfg.Username,
+		Password:          cfg.Password,
+		TokenTTL:          time.Duration(cfg.TokenTTL) * time.Second,
+		AllowInsecure:     cfg.AllowInsecure,
+		Timeout:           time.Duration(cfg.RequestTimeout) * time.Second,
+		ConnectTimeout:    time.Duration(cfg.ConnectTimeout) * time.Second,
+		MaxConcurrentReqs: cfg.MaxConcurrentRequests,
+		TargetLatency:     time.Duration(cfg.TargetLatency) * time.Second,
+		BreakerThreshold:  cfg.BreakerThreshold,
+		BreakerCooldown:   time.Duration(cfg.BreakerCooldown) * time.Second,
+		RecordFile:        cfg.RecordFile,
+		TLS: truenas.TLSConfig{
+			CAFile:         cfg.CAFile,
+			CAData:         []byte(cfg.CABundle),
+			ServerName:     cfg.ServerName,
+			PinnedSPKI:     cfg.PinnedPublicKeys,
+			ClientCertFile: cfg.ClientCertFile,
+			ClientKeyFile:  cfg.ClientKeyFile,
+		},
+	}
+}
+
+// driverClientConfig returns the client configuration of a long-running driver, whose
+// controller may cache TrueNAS objects. One-shot commands use newClientConfig.
+func driverClientConfig(cfg *Config, controller bool) *truenas.ClientConfig {
+	clientCfg := newClientConfig(&cfg.TrueNAS)
+	if controller && cfg.TrueNAS.Cache {
+		clientCfg.CacheParent = cfg.ZFS.DatasetParentName
+	}
+	return clientCfg
 }
 
 // Run starts the CSI driver.
@@ -151,6 +289,39 @@ func (d *Driver) Run() error {
 		klog.Info("Node service registered")
 	}
 
+	if d.runController && d.leader == nil {
+		go d.onControllerActive()
+	}
+
+	d.httpServer, err = d.startHTTPServer()
+	if err != nil {
+		return fmt.Errorf("failed to start HTTP server: %w", err)
+	}
+
+	if d.leader != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		d.leaderCancel = cancel
+		d.leaderDone = make(chan struct{})
+		go func() {
+			defer close(d.leaderDone)
+			d.leader.Run(ctx)
+		}()
+	}
+
+	if d.configFile != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		d.reloadCancel = cancel
+		go d.watchConfig(ctx)
+	}
+
+	if d.runController {
+		ctx, cancel := context.WithCancel(context.Background())
+		d.backgroundCancel = cancel
+		go d.runTrashPurger(ctx)
+		go d.runSnapshotScheduler(ctx)
+		go d.runReplicationMonitor(ctx)
+	}
+
 	d.ready = true
 	klog.Infof("CSI driver listening on %s", d.endpoint)
 
@@ -164,6 +335,18 @@ func (d *Driver) Stop() {
 	if d.server != nil {
 		d.server.GracefulStop()
 	}
+	d.stopHTTPServer()
+	if d.reloadCancel != nil {
+		d.reloadCancel()
+	}
+	if d.backgroundCancel != nil {
+		d.backgroundCancel()
+	}
+	// Release the leader lease before closing the client so a standby takes over immediately
+	if d.leaderCancel != nil {
+		d.leaderCancel()
+		<-d.leaderDone
+	}
 	if d.truenasClient != nil {
 		if err := d.truenasClient.Close(); err != nil {
 			klog.Warningf("Failed to close TrueNAS client: %v", err)
@@ -171,6 +354,29 @@ func (d *Driver) Stop() {
 	}
 }
 
+// cleanupMethods release TrueNAS resources. Their API calls go ahead of other calls, so
+// cleanup keeps up during a burst of creates.
+var cleanupMethods = map[string]bool{
+	"/csi.v1.Controller/DeleteVolume":              true,
+	"/csi.v1.Controller/DeleteSnapshot":            true,
+	"/csi.v1.Controller/ControllerUnpublishVolume": true,
+	"/csi.v1.Node/NodeUnstageVolume":               true,
+	"/csi.v1.Node/NodeUnpublishVolume":             true,
+}
+
+// checkTrueNAS fails controller RPCs fast with Unavailable while the TrueNAS client's
+// circuit breaker is open, so the sidecars back off instead of queueing more work.
+func (d *Driver) checkTrueNAS(fullMethod string) error {
+	if d.truenasClient == nil || !strings.HasPrefix(fullMethod, "/csi.v1.Controller/") ||
+		fullMethod == "/csi.v1.Controller/ControllerGetCapabilities" {
+		return nil
+	}
+	if err := d.truenasClient.Available(); err != nil {
+		return status.Errorf(codes.Unavailable, "TrueNAS is not responding: %v", err)
+	}
+	return nil
+}
+
 // logInterceptor is a gRPC interceptor for logging requests with request IDs and timing.
 func (d *Driver) logInterceptor(
 	ctx context.Context,
@@ -211,8 +417,32 @@ func (d *Driver) logInterceptor(
 	// Log full request at higher verbosity
 	klog.V(5).Infof("[req-%d] request: %+v", requestID, req)
 
-	// Handle the request
-	resp, err := handler(ctx, req)
+	// Start a server span so TrueNAS API calls made by the handler nest under the CSI RPC
+	ctx, span := tracer.Start(ctx, strings.TrimPrefix(info.FullMethod, "/"),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(append(requestAttributes(req),
+			attribute.Int64("csi.request_id", int64(requestID)),
+			attribute.String("rpc.system", "grpc"),
+			attribute.String("rpc.method", info.FullMethod),
+		)...),
+	)
+
+	// Handle the request (standby controllers reject controller RPCs, and every
+	// controller RPC is rejected while TrueNAS isn't answering)
+	var resp interface{}
+	err := d.checkLeader(ctx, info.FullMethod)
+	if err == nil {
+		err = d.checkTrueNAS(info.FullMethod)
+	}
+	if err == nil {
+		if cleanupMethods[info.FullMethod] {
+			ctx = truenas.WithPriority(ctx, truenas.PriorityHigh)
+		}
+		resp, err = handler(ctx, req)
+	}
+
+	span.SetAttributes(attribute.String("rpc.grpc.status_code", status.Code(err).String()))
+	endSpan(span, err)
 
 	// Calculate duration
 	duration := time.Since(startTime)
@@ -228,16 +458,20 @@ func (d *Driver) logInterceptor(
 	return resp, err
 }
 
-// acquireOperationLock acquires a lock for the given operation key.
-// Returns false if the lock is already held.
-func (d *Driver) acquireOperationLock(key string) bool {
-	_, loaded := d.operationLock.LoadOrStore(key, struct{}{})
-	return !loaded
+// onControllerActive runs the one-off tasks of the active controller: at startup without
+// leader election, or each time leadership is acquired with it.
+func (d *Driver) onControllerActive() {
+	d.recoverOperationLocks()
+	d.adoptLegacyVolumesIfEnabled()
 }
 
-// releaseOperationLock releases the lock for the given operation key.
-func (d *Driver) releaseOperationLock(key string) {
-	d.operationLock.Delete(key)
+// recoverOperationLocks loads persisted controller locks left by a crashed process or previous leader.
+func (d *Driver) recoverOperationLocks() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := d.operationLock.Recover(ctx); err != nil {
+		klog.Warningf("Failed to recover operation locks: %v", err)
+	}
 }
 
 // GetTrueNASClient returns the TrueNAS API client.
@@ -245,7 +479,10 @@ func (d *Driver) GetTrueNASClient() truenas.ClientInterface {
 	return d.truenasClient
 }
 
-// GetConfig returns the driver configuration.
+// GetConfig returns the driver configuration. The returned config must not be modified;
+// ReloadConfig replaces it rather than updating it in place.
 func (d *Driver) GetConfig() *Config {
+	d.configMu.RLock()
+	defer d.configMu.RUnlock()
 	return d.config
 }
@@ -0,0 +1,38 @@
+package driver
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+)
+
+// truenasCode returns the gRPC code for a failed TrueNAS call, so the CO can tell a
+// request that can't succeed from one worth retrying. Errors TrueNAS didn't classify
+// are Internal.
+func truenasCode(err error) codes.Code {
+	switch truenas.ErrorKindOf(err) {
+	case truenas.ErrorNotFound:
+		return codes.NotFound
+	case truenas.ErrorAlreadyExists:
+		return codes.AlreadyExists
+	case truenas.ErrorBusy:
+		return codes.FailedPrecondition
+	case truenas.ErrorInvalid:
+		return codes.InvalidArgument
+	case truenas.ErrorPermission:
+		return codes.PermissionDenied
+	case truenas.ErrorNoSpace:
+		return codes.ResourceExhausted
+	case truenas.ErrorUnsupported:
+		return codes.Unimplemented
+	case truenas.ErrorUnavailable:
+		if errors.Is(err, context.DeadlineExceeded) {
+			return codes.DeadlineExceeded
+		}
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
@@ -0,0 +1,38 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+)
+
+func TestTrueNASCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code codes.Code
+	}{
+		{"not found", truenas.MockNotFoundError("dataset not found"), codes.NotFound},
+		{"already exists", truenas.MockAlreadyExistsError("dataset"), codes.AlreadyExists},
+		{"invalid", truenas.MockInvalidParamsError("pool_dataset_create.volsize", "Should be a multiple of 16384"), codes.InvalidArgument},
+		{"busy", &truenas.APIError{Code: -32001, Data: map[string]interface{}{"error": float64(16)}}, codes.FailedPrecondition},
+		{"no space", fmt.Errorf("failed to create dataset: %w", &truenas.APIError{Code: -32001, Data: map[string]interface{}{"error": float64(28)}}), codes.ResourceExhausted},
+		{"permission", &truenas.APIError{Code: -32001, Data: map[string]interface{}{"error": float64(13)}}, codes.PermissionDenied},
+		{"unsupported", &truenas.APIError{Code: -32601, Message: "Method not found"}, codes.Unimplemented},
+		{"connection lost", &truenas.ConnectionError{Method: "pool.dataset.query", Err: errors.New("connection lost")}, codes.Unavailable},
+		{"timeout", &truenas.ConnectionError{Method: "pool.dataset.query", Err: context.DeadlineExceeded}, codes.DeadlineExceeded},
+		{"circuit open", truenas.ErrCircuitOpen, codes.Unavailable},
+		{"unclassified", errors.New("unexpected response format"), codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.code, truenasCode(tt.err))
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"k8s.io/klog/v2"
+)
+
+// healthCheckTimeout bounds each individual health check.
+const healthCheckTimeout = 3 * time.Second
+
+// parentDatasetCheckInterval caches the parent dataset lookup so frequent probes
+// don't each cost a TrueNAS API call.
+const parentDatasetCheckInterval = 10 * time.Second
+
+// healthCheck is a named check reported by /healthz or /readyz.
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// healthState caches expensive check results and the self-dial gRPC connection.
+type healthState struct {
+	mu              sync.Mutex
+	parentCheckedAt time.Time
+	parentErr       error
+	grpcConn        *grpc.ClientConn
+}
+
+// truenasUnreachableFor returns how long it has been since TrueNAS last answered,
+// or zero if it answered within the configured window (or no window is configured).
+func (d *Driver) truenasUnreachableFor() time.Duration {
+	window := time.Duration(d.GetConfig().Health.UnreachableTimeout) * time.Second
+	last := d.truenasClient.LastResponse()
+	if window <= 0 || last.IsZero() {
+		return 0
+	}
+	if since := time.Since(last); since > window {
+		return since
+	}
+	return 0
+}
+
+// livenessChecks detect a wedged process without depending on TrueNAS, so an outage
+// doesn't cause restart loops.
+func (d *Driver) livenessChecks() []healthCheck {
+	return []healthCheck{
+		{name: "grpc", check: d.checkGRPCServer},
+	}
+}
+
+// readinessChecks verify the controller can reach TrueNAS. Node-only drivers don't
+// talk to TrueNAS during normal operation, so they are ready once serving.
+func (d *Driver) readinessChecks() []healthCheck {
+	checks := []healthCheck{
+		{name: "driver", check: func(ctx context.Context) error {
+			if !d.ready {
+				return fmt.Errorf("driver not ready")
+			}
+			return nil
+		}},
+	}
+	if !d.runController {
+		return checks
+	}
+	return append(checks,
+		healthCheck{name: "truenas-connection", check: func(ctx context.Context) error {
+			if !d.truenasClient.IsConnected() {
+				return fmt.Errorf("no authenticated TrueNAS connection")
+			}
+			return nil
+		}},
+		healthCheck{name: "truenas-roundtrip", check: func(ctx context.Context) error {
+			if since := d.truenasUnreachableFor(); since > 0 {
+				return fmt.Errorf("no TrueNAS response for %v", since.Round(time.Second))
+			}
+			return nil
+		}},
+		healthCheck{name: "parent-dataset", check: d.checkParentDataset},
+	)
+}
+
+// checkParentDataset verifies the parent dataset exists, caching the result briefly.
+func (d *Driver) checkParentDataset(ctx context.Context) error {
+	d.health.mu.Lock()
+	defer d.health.mu.Unlock()
+
+	if time.Since(d.health.parentCheckedAt) < parentDatasetCheckInterval {
+		return d.health.parentErr
+	}
+
+	parent := d.GetConfig().ZFS.DatasetParentName
+	exists, err := d.truenasClient.DatasetExists(ctx, parent)
+	switch {
+	case err != nil:
+		d.health.parentErr = fmt.Errorf("failed to look up %s: %v", parent, err)
+	case !exists:
+		d.health.parentErr = fmt.Errorf("parent dataset %s does not exist", parent)
+	default:
+		d.health.parentErr = nil
+	}
+	d.health.parentCheckedAt = time.Now()
+	return d.health.parentErr
+}
+
+// checkGRPCServer calls GetPluginInfo over the driver's own endpoint to detect a stuck gRPC server.
+func (d *Driver) checkGRPCServer(ctx context.Context) error {
+	if d.server == nil {
+		return fmt.Errorf("gRPC server not started")
+	}
+
+	d.health.mu.Lock()
+	if d.health.grpcConn == nil {
+		target, err := grpcDialTarget(d.endpoint)
+		if err != nil {
+			d.health.mu.Unlock()
+			return err
+		}
+		conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			d.health.mu.Unlock()
+			return fmt.Errorf("failed to create gRPC client: %v", err)
+		}
+		d.health.grpcConn = conn
+	}
+	conn := d.health.grpcConn
+	d.health.mu.Unlock()
+
+	if _, err := csi.NewIdentityClient(conn).GetPluginInfo(ctx, &csi.GetPluginInfoRequest{}); err != nil {
+		return fmt.Errorf("GetPluginInfo failed: %v", err)
+	}
+	return nil
+}
+
+// grpcDialTarget converts a CSI endpoint (unix:///path or tcp://host:port) to a gRPC target.
+func grpcDialTarget(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse endpoint: %w", err)
+	}
+	if u.Scheme == "unix" {
+		return "unix://" + u.Path, nil
+	}
+	return u.Host, nil
+}
+
+// healthHandler runs checks and writes a kube-apiserver style report.
+// It responds 200 when all checks pass and 503 otherwise.
+func healthHandler(checks func() []healthCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var report strings.Builder
+		failed := false
+		for _, c := range checks() {
+			ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+			err := c.check(ctx)
+			cancel()
+			if err != nil {
+				failed = true
+				fmt.Fprintf(&report, "[-]%s failed: %v\n", c.name, err)
+			} else {
+				fmt.Fprintf(&report, "[+]%s ok\n", c.name)
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if failed {
+			klog.V(2).Infof("Health check %s failed:\n%s", r.URL.Path, report.String())
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		_, _ = w.Write([]byte(report.String()))
+	}
+}
@@ -0,0 +1,138 @@
+package driver
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+func TestReadyz(t *testing.T) {
+	mockClient := truenas.NewMockClient()
+	d := &Driver{
+		config: &Config{
+			ZFS:    ZFSConfig{DatasetParentName: "pool/parent"},
+			Health: HealthConfig{UnreachableTimeout: 60},
+		},
+		truenasClient: mockClient,
+		runController: true,
+		ready:         true,
+	}
+	get := func() (int, string) {
+		rec := httptest.NewRecorder()
+		d.httpHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	// Test Case 1: Parent dataset missing
+	code, body := get()
+	assert.Equal(t, 503, code)
+	assert.Contains(t, body, "[-]parent-dataset failed")
+
+	// Test Case 2: All checks pass (clear the cached parent result)
+	_, err := mockClient.DatasetCreate(context.Background(), &truenas.DatasetCreateParams{Name: "pool/parent"})
+	assert.NoError(t, err)
+	d.health.parentCheckedAt = time.Time{}
+	code, body = get()
+	assert.Equal(t, 200, code)
+	assert.Contains(t, body, "[+]truenas-roundtrip ok")
+
+	// Test Case 3: No authenticated connection
+	mockClient.Disconnected = true
+	code, body = get()
+	assert.Equal(t, 503, code)
+	assert.Contains(t, body, "[-]truenas-connection failed")
+
+	// Test Case 4: No recent round trip
+	mockClient.Disconnected = false
+	mockClient.LastResponseTime = time.Now().Add(-2 * time.Minute)
+	code, body = get()
+	assert.Equal(t, 503, code)
+	assert.Contains(t, body, "[-]truenas-roundtrip failed")
+}
+
+func TestProbeUnreachableWindow(t *testing.T) {
+	mockClient := truenas.NewMockClient()
+	d := &Driver{
+		config:        &Config{Health: HealthConfig{UnreachableTimeout: 60}},
+		truenasClient: mockClient,
+		runController: true,
+		ready:         true,
+	}
+
+	// Short outages stay within the window
+	mockClient.Disconnected = true
+	mockClient.LastResponseTime = time.Now().Add(-30 * time.Second)
+	resp, err := d.Probe(context.Background(), &csi.ProbeRequest{})
+	assert.NoError(t, err)
+	assert.True(t, resp.Ready.Value)
+
+	// Unreachable for longer than the window
+	mockClient.LastResponseTime = time.Now().Add(-90 * time.Second)
+	resp, err = d.Probe(context.Background(), &csi.ProbeRequest{})
+	assert.NoError(t, err)
+	assert.False(t, resp.Ready.Value)
+
+	// Node-only drivers don't depend on TrueNAS
+	d.runController = false
+	d.runNode = true
+	resp, err = d.Probe(context.Background(), &csi.ProbeRequest{})
+	assert.NoError(t, err)
+	assert.True(t, resp.Ready.Value)
+}
+
+func TestGetPluginInfoReportsCapabilities(t *testing.T) {
+	mockClient := truenas.NewMockClient()
+	d := &Driver{name: "org.truenas.csi.nfs", version: "v1", truenasClient: mockClient, runController: true}
+
+	// Test Case 1: Nothing is detected yet, so no manifest is reported
+	resp, err := d.GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "org.truenas.csi.nfs", resp.Name)
+	assert.Empty(t, resp.Manifest)
+
+	// Test Case 2: Detected capabilities are reported without calling TrueNAS
+	_, err = mockClient.Capabilities(context.Background())
+	assert.NoError(t, err)
+	mockClient.ResetCalls()
+	mockClient.SetLatency("Capabilities", time.Hour)
+	resp, err = d.GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"truenas-version":      "25.10.0",
+		"truenas-snapshot-api": "pool.snapshot",
+		"truenas-nvmeof":       "true",
+	}, resp.Manifest)
+	assert.Equal(t, 0, mockClient.CallCount("Capabilities"))
+}
+
+func TestHealthzGRPC(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "csi.sock")
+	listener, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+
+	d := &Driver{name: "org.truenas.csi", endpoint: "unix://" + socket, config: &Config{}}
+	get := func() int {
+		rec := httptest.NewRecorder()
+		d.httpHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+		return rec.Code
+	}
+
+	// Not serving yet
+	assert.Equal(t, 503, get())
+
+	d.server = grpc.NewServer()
+	csi.RegisterIdentityServer(d.server, d)
+	go func() { _ = d.server.Serve(listener) }()
+	defer d.server.Stop()
+	defer d.stopHTTPServer()
+
+	assert.Equal(t, 200, get())
+}
@@ -0,0 +1,85 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// startHTTPServer serves the driver's HTTP handlers (/healthz, /readyz, /debug/locks) on the configured address.
+// It returns nil if no address is configured.
+func (d *Driver) startHTTPServer() (*http.Server, error) {
+	cfg := d.GetConfig()
+	if cfg == nil || cfg.HTTP.Address == "" {
+		return nil, nil
+	}
+
+	listener, err := net.Listen("tcp", cfg.HTTP.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{
+		Handler:           d.httpHandler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			klog.Errorf("HTTP server failed: %v", err)
+		}
+	}()
+
+	klog.Infof("HTTP endpoint listening on %s", listener.Addr())
+	return server, nil
+}
+
+// httpHandler returns the mux for the driver's HTTP endpoint.
+func (d *Driver) httpHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", healthHandler(d.livenessChecks))
+	mux.Handle("/readyz", healthHandler(d.readinessChecks))
+	mux.HandleFunc("/debug/locks", d.handleDebugLocks)
+	return mux
+}
+
+// handleDebugLocks reports the currently held operation locks as JSON.
+func (d *Driver) handleDebugLocks(w http.ResponseWriter, r *http.Request) {
+	resp := struct {
+		Identity string          `json:"identity"`
+		Leader   bool            `json:"leader"`
+		Locks    []OperationLock `json:"locks"`
+	}{
+		Identity: d.identity,
+		Leader:   d.isLeader(),
+		Locks:    d.operationLock.List(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		klog.V(4).Infof("Failed to write /debug/locks response: %v", err)
+	}
+}
+
+// stopHTTPServer shuts down the HTTP endpoint if it is running.
+func (d *Driver) stopHTTPServer() {
+	d.health.mu.Lock()
+	if d.health.grpcConn != nil {
+		_ = d.health.grpcConn.Close()
+		d.health.grpcConn = nil
+	}
+	d.health.mu.Unlock()
+
+	if d.httpServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.httpServer.Shutdown(ctx); err != nil {
+		klog.Warningf("Failed to shut down HTTP server: %v", err)
+	}
+}
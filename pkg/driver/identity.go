@@ -2,6 +2,8 @@ package driver
 
 import (
 	"context"
+	"strconv"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
@@ -14,10 +16,24 @@ import (
 func (d *Driver) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
 	klog.V(4).Info("GetPluginInfo called")
 
-	return &csi.GetPluginInfoResponse{
+	resp := &csi.GetPluginInfoResponse{
 		Name:          d.name,
 		VendorVersion: d.version,
-	}, nil
+	}
+
+	// The controller also reports the TrueNAS release and API it is using, once detected.
+	// Only the cached capabilities are used, so liveness checks and sidecars calling this
+	// don't wait on TrueNAS.
+	if d.runController && d.truenasClient != nil {
+		if caps := d.truenasClient.CachedCapabilities(); caps != nil {
+			resp.Manifest = map[string]string{
+				"truenas-version":      caps.Version,
+				"truenas-snapshot-api": caps.SnapshotPrefix,
+				"truenas-nvmeof":       strconv.FormatBool(caps.NVMeoF),
+			}
+		}
+	}
+	return resp, nil
 }
 
 // GetPluginCapabilities returns the capabilities of the driver.
@@ -61,9 +77,26 @@ func (d *Driver) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeRe
 		// The client will auto-reconnect on next call
 	}
 
+	// The controller is useless without TrueNAS; report not-ready once it has been
+	// unreachable for longer than the configured window
+	if d.runController {
+		if since := d.truenasUnreachableFor(); since > 0 {
+			klog.Warningf("Probe: TrueNAS unreachable for %v, reporting not ready", since.Round(time.Second))
+			return &csi.ProbeResponse{Ready: &wrapperspb.BoolValue{Value: false}}, nil
+		}
+	}
+
+	// A standby controller reports not-ready so sidecars (which probe before their own
+	// leader election) only start campaigning against the elected replica
+	ready := d.ready
+	if !d.runNode && !d.isLeader() {
+		klog.V(4).Info("Probe: controller is in standby")
+		ready = false
+	}
+
 	return &csi.ProbeResponse{
 		Ready: &wrapperspb.BoolValue{
-			Value: d.ready,
+			Value: ready,
 		},
 	}, nil
 }
@@ -0,0 +1,182 @@
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas/faketruenas"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+)
+
+// newFakeTrueNASDriver returns a driver using the real client against a fake TrueNAS.
+func newFakeTrueNASDriver(t *testing.T, driverName string) (*Driver, *faketruenas.Server, *truenas.Client) {
+	t.Helper()
+	server := faketruenas.New(faketruenas.Config{})
+	t.Cleanup(server.Close)
+
+	client, err := truenas.NewClient(&truenas.ClientConfig{
+		Host:           server.Host(),
+		Port:           server.Port(),
+		Protocol:       "http",
+		APIKey:         "1-test",
+		MaxConnections: 2,
+		RetryInterval:  10 * time.Millisecond,
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	_, err = client.DatasetCreate(context.Background(), &truenas.DatasetCreateParams{Name: "tank/k8s"})
+	assert.NoError(t, err)
+
+	d := &Driver{
+		config: &Config{
+			DriverName: driverName,
+			ZFS:        ZFSConfig{DatasetParentName: "tank/k8s", ZvolBlocksize: "16K"},
+			NFS:        NFSConfig{ShareHost: "10.0.0.1"},
+			ISCSI: ISCSIConfig{
+				TargetPortal:    "10.0.0.1:3260",
+				TargetGroups:    []ISCSITargetGroup{{Portal: 1, Initiator: 1, AuthMethod: "NONE"}},
+				ExtentBlocksize: 4096,
+				ExtentRpm:       "SSD",
+			},
+			NVMeoF: NVMeoFConfig{NamePrefix: "nqn.2011-06.com.truenas:", SubsystemAllowAnyHost: true},
+		},
+		truenasClient: client,
+	}
+	return d, server, client
+}
+
+func TestVolumeLifecycleAgainstFakeTrueNAS(t *testing.T) {
+	tests := []struct {
+		driverName string
+		context    map[string]string
+	}{
+		{"org.truenas.csi.nfs", map[string]string{"node_attach_driver": "nfs", "server": "10.0.0.1", "share": "/mnt/tank/k8s/vol-1"}},
+		{"org.truenas.csi.iscsi", map[string]string{"node_attach_driver": "iscsi", "iqn": "iqn.2005-10.org.freenas.ctl:vol-1", "portal": "10.0.0.1:3260", "lun": "0"}},
+		{"org.truenas.csi.nvmeof", map[string]string{"node_attach_driver": "nvmeof", "nqn": "nqn.2011-06.com.truenas:vol-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.driverName, func(t *testing.T) {
+			ctx := context.Background()
+			d, server, client := newFakeTrueNASDriver(t, tt.driverName)
+
+			req := &csi.CreateVolumeRequest{
+				Name:               "vol-1",
+				CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+				VolumeCapabilities: volumeCapabilities,
+			}
+			resp, err := d.CreateVolume(ctx, req)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, "vol-1", resp.Volume.VolumeId)
+			assert.Equal(t, int64(1<<30), resp.Volume.CapacityBytes)
+			for key, value := range tt.context {
+				assert.Equal(t, value, resp.Volume.VolumeContext[key], key)
+			}
+
+			// Repeating the request returns the same volume without creating anything
+			again, err := d.CreateVolume(ctx, req)
+			assert.NoError(t, err)
+			assert.Equal(t, resp.Volume.VolumeContext, again.Volume.VolumeContext)
+
+			// Snapshot and restore it to a new volume
+			snap, err := d.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: "vol-1"})
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Greater(t, snap.Snapshot.CreationTime.GetSeconds(), int64(0))
+			restored, err := d.CreateVolume(ctx, &csi.CreateVolumeRequest{
+				Name:               "vol-2",
+				CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+				VolumeCapabilities: volumeCapabilities,
+				VolumeContentSource: &csi.VolumeContentSource{
+					Type: &csi.VolumeContentSource_Snapshot{
+						Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: snap.Snapshot.SnapshotId},
+					},
+				},
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, snap.Snapshot.SnapshotId, restored.Volume.ContentSource.GetSnapshot().GetSnapshotId())
+
+			// Everything is cleaned up on delete, clone first
+			_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "vol-2"})
+			assert.NoError(t, err)
+			_, err = d.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: snap.Snapshot.SnapshotId})
+			assert.NoError(t, err)
+			_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "vol-1"})
+			assert.NoError(t, err)
+
+			// Deleting again is a no-op
+			_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "vol-1"})
+			assert.NoError(t, err)
+
+			assert.Equal(t, []string{"tank", "tank/k8s"}, server.Datasets())
+			assert.Empty(t, server.Snapshots())
+			shares, err := client.NFSShareList(ctx)
+			assert.NoError(t, err)
+			assert.Empty(t, shares)
+			target, err := client.ISCSITargetFindByName(ctx, "vol-1")
+			assert.NoError(t, err)
+			assert.Nil(t, target)
+			subsys, err := client.NVMeoFSubsystemFindByNQN(ctx, "nqn.2011-06.com.truenas:vol-1")
+			assert.NoError(t, err)
+			assert.Nil(t, subsys)
+		})
+	}
+}
+
+// TestVolumeAPICallsAgainstFakeTrueNAS keeps the number of TrueNAS calls per volume in
+// check. Properties are read once per request and written in one update, so creating
+// and deleting a volume reads its dataset twice and updates it twice.
+func TestVolumeAPICallsAgainstFakeTrueNAS(t *testing.T) {
+	tests := []struct {
+		driverName string
+		calls      int // Before batching: NFS 14, iSCSI 34, NVMe-oF 18
+	}{
+		{"org.truenas.csi.nfs", 8},
+		{"org.truenas.csi.iscsi", 20},
+		{"org.truenas.csi.nvmeof", 11},
+	}
+	for _, tt := range tests {
+		t.Run(tt.driverName, func(t *testing.T) {
+			ctx := context.Background()
+			d, server, _ := newFakeTrueNASDriver(t, tt.driverName)
+			lifecycle := func(name string) {
+				_, err := d.CreateVolume(ctx, &csi.CreateVolumeRequest{
+					Name:               name,
+					CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+					VolumeCapabilities: volumeCapabilities,
+				})
+				assert.NoError(t, err)
+				_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: name})
+				assert.NoError(t, err)
+			}
+			// The first volume also probes the TrueNAS release
+			lifecycle("vol-1")
+
+			before := len(server.Calls())
+			lifecycle("vol-2")
+			calls := server.Calls()[before:]
+			assert.Len(t, calls, tt.calls)
+			assert.Len(t, filterMethods(calls, "pool.dataset.query"), 2)
+			assert.Len(t, filterMethods(calls, "pool.dataset.update"), 2)
+		})
+	}
+}
+
+// filterMethods returns the calls to method.
+func filterMethods(calls []string, method string) []string {
+	var filtered []string
+	for _, call := range calls {
+		if call == method {
+			filtered = append(filtered, call)
+		}
+	}
+	return filtered
+}
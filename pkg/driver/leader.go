@@ -0,0 +1,329 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+)
+
+// PropLeaderLease is the user property on the parent dataset that holds the controller leader lease.
+const PropLeaderLease = "truenas-csi:controller_leader_lease"
+
+// leaseRecord is the JSON value stored in PropLeaderLease.
+type leaseRecord struct {
+	HolderIdentity       string    `json:"holderIdentity"`
+	LeaseDurationSeconds int       `json:"leaseDurationSeconds"`
+	AcquireTime          time.Time `json:"acquireTime"`
+	RenewTime            time.Time `json:"renewTime"`
+}
+
+// leaderElector implements active/standby election for controller replicas using a
+// lease stored in a TrueNAS dataset user property, so it works without a Kubernetes API.
+//
+// TrueNAS has no compare-and-swap for user properties, so acquisition writes the lease and
+// then re-reads it after a short settle delay; of two replicas racing, usually only the last
+// writer sees its own record and becomes leader. Writes slower than the settle delay can
+// still let both read their own record, so leadership is only approximately exclusive:
+// leader-only actions call confirm first, which steps down once the lease names another
+// replica, and operation locks are checked in TrueNAS before they are taken, which keeps
+// two replicas off the same volume unless they also race on its lock. Expiry is judged from when this replica last
+// saw the record change (like client-go), so clock skew between replicas does not matter.
+type leaderElector struct {
+	client        truenas.ClientInterface
+	dataset       string
+	identity      string
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+	settleDelay   time.Duration
+
+	isLeader atomic.Bool
+	// confirmedAt is when the lease last named this replica, in Unix nanoseconds
+	confirmedAt atomic.Int64
+
+	// Last observed raw lease value and when it was observed (local clock)
+	observedRaw  string
+	observedTime time.Time
+
+	// Last successful renewal of our own lease
+	lastRenew   time.Time
+	acquireTime time.Time
+
+	// onStartedLeading, if set, runs in a new goroutine each time leadership is acquired
+	onStartedLeading func()
+}
+
+// newLeaderElector creates a leader elector for the lease on dataset.
+func newLeaderElector(client truenas.ClientInterface, dataset, identity string, leaseDuration, renewDeadline, retryPeriod time.Duration) *leaderElector {
+	settle := retryPeriod / 2
+	if settle > time.Second {
+		settle = time.Second
+	}
+	return &leaderElector{
+		client:        client,
+		dataset:       dataset,
+		identity:      identity,
+		leaseDuration: leaseDuration,
+		renewDeadline: renewDeadline,
+		retryPeriod:   retryPeriod,
+		settleDelay:   settle,
+	}
+}
+
+// IsLeader reports whether this replica currently holds the lease.
+func (le *leaderElector) IsLeader() bool {
+	return le.isLeader.Load()
+}
+
+// Run campaigns for and renews the lease every retryPeriod until ctx is cancelled.
+// On cancellation the lease is released so a standby can take over immediately.
+func (le *leaderElector) Run(ctx context.Context) {
+	klog.Infof("Leader election started (identity=%s, dataset=%s, leaseDuration=%v)", le.identity, le.dataset, le.leaseDuration)
+
+	ticker := time.NewTicker(le.retryPeriod)
+	defer ticker.Stop()
+
+	for {
+		le.tick(ctx)
+
+		select {
+		case <-ctx.Done():
+			le.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tick runs one acquire/renew attempt and updates leadership state.
+func (le *leaderElector) tick(ctx context.Context) {
+	acquired, err := le.tryAcquireOrRenew(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+
+	if acquired {
+		le.lastRenew = time.Now()
+		le.confirmedAt.Store(le.lastRenew.UnixNano())
+		if !le.isLeader.Swap(true) {
+			klog.Infof("Became controller leader (identity=%s)", le.identity)
+			if le.onStartedLeading != nil {
+				go le.onStartedLeading()
+			}
+		}
+		return
+	}
+
+	if !le.IsLeader() {
+		if err != nil {
+			klog.V(4).Infof("Leader election attempt failed: %v", err)
+		}
+		return
+	}
+
+	// Transient API errors are tolerated until the renew deadline; losing the record to another holder is not
+	var held *leaseHeldError
+	if !errors.As(err, &held) && time.Since(le.lastRenew) < le.renewDeadline {
+		klog.Warningf("Failed to renew leader lease, retrying: %v", err)
+		return
+	}
+	le.isLeader.Store(false)
+	klog.Warningf("Lost controller leadership (identity=%s): %v", le.identity, err)
+}
+
+// tryAcquireOrRenew returns true if this replica holds the lease after the call.
+func (le *leaderElector) tryAcquireOrRenew(ctx context.Context) (bool, error) {
+	raw, err := le.client.DatasetGetUserProperty(ctx, le.dataset, PropLeaderLease)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	if raw != le.observedRaw {
+		le.observedRaw = raw
+		le.observedTime = now
+	}
+
+	current := parseLeaseRecord(raw)
+	if current != nil && current.HolderIdentity != "" && current.HolderIdentity != le.identity {
+		leaseDuration := time.Duration(current.LeaseDurationSeconds) * time.Second
+		if leaseDuration <= 0 {
+			leaseDuration = le.leaseDuration
+		}
+		if now.Before(le.observedTime.Add(leaseDuration)) {
+			return false, &leaseHeldError{holder: current.HolderIdentity}
+		}
+		klog.Infof("Leader lease held by %s expired, attempting takeover", current.HolderIdentity)
+	}
+
+	record := leaseRecord{
+		HolderIdentity:       le.identity,
+		LeaseDurationSeconds: int((le.leaseDuration + time.Second - 1) / time.Second),
+		AcquireTime:          now.UTC(),
+		RenewTime:            now.UTC(),
+	}
+	renewing := current != nil && current.HolderIdentity == le.identity
+	if renewing && !le.acquireTime.IsZero() {
+		record.AcquireTime = le.acquireTime
+	}
+
+	data, _ := json.Marshal(record)
+	if err := le.client.DatasetSetUserProperty(ctx, le.dataset, PropLeaderLease, string(data)); err != nil {
+		return false, err
+	}
+	le.observedRaw = string(data)
+	le.observedTime = now
+
+	if renewing {
+		return true, nil
+	}
+
+	// Fresh acquisition: wait for any concurrent writer to land, then check who won
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-time.After(le.settleDelay):
+	}
+	raw, err = le.client.DatasetGetUserProperty(ctx, le.dataset, PropLeaderLease)
+	if err != nil {
+		return false, err
+	}
+	if raw != string(data) {
+		le.observedRaw = raw
+		le.observedTime = time.Now()
+		if winner := parseLeaseRecord(raw); winner != nil {
+			return false, &leaseHeldError{holder: winner.HolderIdentity}
+		}
+		return false, nil
+	}
+
+	le.acquireTime = record.AcquireTime
+	return true, nil
+}
+
+// confirm re-reads the lease before a leader-only action and steps down if another replica
+// holds it. It returns an error unless this replica is the leader and the lease says so.
+// The lease is read at most once per retry period; a failed read is returned as is.
+func (le *leaderElector) confirm(ctx context.Context) error {
+	if !le.IsLeader() {
+		return errNotLeader
+	}
+	if time.Since(time.Unix(0, le.confirmedAt.Load())) < le.retryPeriod {
+		return nil
+	}
+	raw, err := le.client.DatasetGetUserProperty(ctx, le.dataset, PropLeaderLease)
+	if err != nil {
+		return fmt.Errorf("failed to confirm leader lease: %w", err)
+	}
+	if current := parseLeaseRecord(raw); current == nil || current.HolderIdentity != le.identity {
+		holder := ""
+		if current != nil {
+			holder = current.HolderIdentity
+		}
+		if le.isLeader.Swap(false) {
+			klog.Warningf("Lost controller leadership (identity=%s): lease now held by %q", le.identity, holder)
+		}
+		return &leaseHeldError{holder: holder}
+	}
+	le.confirmedAt.Store(time.Now().UnixNano())
+	return nil
+}
+
+// errNotLeader is returned by confirm on a standby replica.
+var errNotLeader = errors.New("not the elected leader")
+
+// release clears the lease if this replica holds it.
+func (le *leaderElector) release() {
+	if !le.isLeader.Swap(false) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	raw, err := le.client.DatasetGetUserProperty(ctx, le.dataset, PropLeaderLease)
+	if err != nil {
+		klog.Warningf("Failed to read leader lease during release: %v", err)
+		return
+	}
+	if current := parseLeaseRecord(raw); current == nil || current.HolderIdentity != le.identity {
+		return
+	}
+
+	data, _ := json.Marshal(leaseRecord{LeaseDurationSeconds: 1, RenewTime: time.Now().UTC()})
+	if err := le.client.DatasetSetUserProperty(ctx, le.dataset, PropLeaderLease, string(data)); err != nil {
+		klog.Warningf("Failed to release leader lease: %v", err)
+		return
+	}
+	le.acquireTime = time.Time{}
+	klog.Infof("Released controller leadership (identity=%s)", le.identity)
+}
+
+// parseLeaseRecord decodes a lease value, returning nil for empty or malformed values.
+func parseLeaseRecord(raw string) *leaseRecord {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw == "-" {
+		return nil
+	}
+	record := &leaseRecord{}
+	if err := json.Unmarshal([]byte(raw), record); err != nil {
+		klog.Warningf("Ignoring malformed leader lease %q: %v", raw, err)
+		return nil
+	}
+	return record
+}
+
+// leaseHeldError reports that another replica holds a valid lease.
+type leaseHeldError struct {
+	holder string
+}
+
+func (e *leaseHeldError) Error() string {
+	return "leader lease held by " + e.holder
+}
+
+// isLeader reports whether this driver may serve controller RPCs and run background
+// reconcilers. It is always true when leader election is disabled.
+func (d *Driver) isLeader() bool {
+	return d.leader == nil || d.leader.IsLeader()
+}
+
+// confirmLeader reports whether this driver may run a leader-only action, re-reading the
+// lease so a replica that lost it stops at once. It always succeeds when leader election
+// is disabled.
+func (d *Driver) confirmLeader(ctx context.Context) error {
+	if d.leader == nil {
+		return nil
+	}
+	return d.leader.confirm(ctx)
+}
+
+// checkLeader rejects controller RPCs on a standby replica.
+// ControllerGetCapabilities is still answered so sidecars can start up against a standby.
+func (d *Driver) checkLeader(ctx context.Context, fullMethod string) error {
+	if !strings.HasPrefix(fullMethod, "/csi.v1.Controller/") ||
+		fullMethod == "/csi.v1.Controller/ControllerGetCapabilities" {
+		return nil
+	}
+	if !d.isLeader() {
+		return status.Error(codes.Unavailable, "controller is in standby, not the elected leader")
+	}
+	if err := d.confirmLeader(ctx); err != nil {
+		var held *leaseHeldError
+		if errors.As(err, &held) || errors.Is(err, errNotLeader) {
+			return status.Errorf(codes.Unavailable, "controller is not the elected leader: %v", err)
+		}
+		return status.Errorf(truenasCode(err), "%v", err)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestElector(client truenas.ClientInterface, identity string) *leaderElector {
+	return newLeaderElector(client, "pool/parent", identity, time.Second, 500*time.Millisecond, 20*time.Millisecond)
+}
+
+func TestLeaderElection(t *testing.T) {
+	ctx := context.Background()
+	mockClient := truenas.NewMockClient()
+	_, err := mockClient.DatasetCreate(ctx, &truenas.DatasetCreateParams{Name: "pool/parent"})
+	assert.NoError(t, err)
+
+	a := newTestElector(mockClient, "replica-a")
+	b := newTestElector(mockClient, "replica-b")
+
+	// Test Case 1: First replica acquires, second stays standby
+	a.tick(ctx)
+	b.tick(ctx)
+	assert.True(t, a.IsLeader())
+	assert.False(t, b.IsLeader())
+
+	// Test Case 2: Renewal keeps leadership
+	a.tick(ctx)
+	b.tick(ctx)
+	assert.True(t, a.IsLeader())
+	assert.False(t, b.IsLeader())
+
+	// Test Case 3: Graceful release allows immediate takeover
+	a.release()
+	assert.False(t, a.IsLeader())
+	b.tick(ctx)
+	assert.True(t, b.IsLeader())
+
+	// Test Case 4: Old leader does not reclaim a held lease
+	a.tick(ctx)
+	assert.False(t, a.IsLeader())
+}
+
+func TestLeaderElectionExpiry(t *testing.T) {
+	ctx := context.Background()
+	mockClient := truenas.NewMockClient()
+	_, err := mockClient.DatasetCreate(ctx, &truenas.DatasetCreateParams{Name: "pool/parent"})
+	assert.NoError(t, err)
+
+	a := newTestElector(mockClient, "replica-a")
+	b := newTestElector(mockClient, "replica-b")
+
+	a.tick(ctx)
+	b.tick(ctx)
+	assert.True(t, a.IsLeader())
+
+	// Leader stops renewing (crashed); standby takes over once the lease expires
+	time.Sleep(1100 * time.Millisecond)
+	b.tick(ctx)
+	assert.True(t, b.IsLeader())
+
+	// Old leader resumes and sees the new holder, stepping down immediately
+	a.tick(ctx)
+	assert.False(t, a.IsLeader())
+}
+
+func TestLeaderElectionRenewDeadline(t *testing.T) {
+	ctx := context.Background()
+	mockClient := truenas.NewMockClient()
+	_, err := mockClient.DatasetCreate(ctx, &truenas.DatasetCreateParams{Name: "pool/parent"})
+	assert.NoError(t, err)
+
+	a := newTestElector(mockClient, "replica-a")
+	a.tick(ctx)
+	assert.True(t, a.IsLeader())
+
+	// Transient errors are tolerated until the renew deadline passes
+	mockClient.SetInjectError(&truenas.APIError{Code: -1, Message: "connection closed"})
+	a.tick(ctx)
+	assert.True(t, a.IsLeader())
+
+	time.Sleep(600 * time.Millisecond)
+	a.tick(ctx)
+	assert.False(t, a.IsLeader())
+}
+
+func TestStandbyRejectsControllerRPCs(t *testing.T) {
+	mockClient := truenas.NewMockClient()
+	d := &Driver{
+		config:        &Config{},
+		truenasClient: mockClient,
+		runController: true,
+		ready:         true,
+		leader:        newTestElector(mockClient, "replica-a"),
+	}
+
+	err := d.checkLeader(context.Background(), "/csi.v1.Controller/CreateVolume")
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.NoError(t, d.checkLeader(context.Background(), "/csi.v1.Controller/ControllerGetCapabilities"))
+	assert.NoError(t, d.checkLeader(context.Background(), "/csi.v1.Identity/Probe"))
+
+	resp, err := d.Probe(context.Background(), &csi.ProbeRequest{})
+	assert.NoError(t, err)
+	assert.False(t, resp.Ready.Value)
+
+	_, err = mockClient.DatasetCreate(context.Background(), &truenas.DatasetCreateParams{Name: "pool/parent"})
+	assert.NoError(t, err)
+	d.leader.tick(context.Background())
+	assert.NoError(t, d.checkLeader(context.Background(), "/csi.v1.Controller/CreateVolume"))
+	resp, err = d.Probe(context.Background(), &csi.ProbeRequest{})
+	assert.NoError(t, err)
+	assert.True(t, resp.Ready.Value)
+}
+
+func TestLeaderConfirm(t *testing.T) {
+	ctx := context.Background()
+	mockClient := truenas.NewMockClient()
+	_, err := mockClient.DatasetCreate(ctx, &truenas.DatasetCreateParams{Name: "pool/parent"})
+	assert.NoError(t, err)
+	d := &Driver{config: &Config{}, truenasClient: mockClient, leader: newTestElector(mockClient, "replica-a")}
+
+	d.leader.tick(ctx)
+	assert.NoError(t, d.confirmLeader(ctx))
+
+	// Test Case 1: Within a retry period of the last renewal the lease isn't read again
+	mockClient.ResetCalls()
+	d.leader.confirmedAt.Store(time.Now().UnixNano())
+	assert.NoError(t, d.checkLeader(ctx, "/csi.v1.Controller/CreateVolume"))
+	assert.Equal(t, 0, mockClient.CallCount("DatasetGetUserProperty"))
+
+	// Test Case 2: A failed read is reported as a TrueNAS error, not as a lost lease
+	time.Sleep(d.leader.retryPeriod)
+	mockClient.FailNext("DatasetGetUserProperty", errors.New("connection lost"))
+	err = d.checkLeader(ctx, "/csi.v1.Controller/CreateVolume")
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.NotContains(t, err.Error(), "not the elected leader")
+	assert.True(t, d.leader.IsLeader())
+
+	// Test Case 3: Another replica that also believes it acquired the lease wins on the
+	// last write; the loser steps down before its next leader-only action
+	b := newTestElector(mockClient, "replica-b")
+	data, _ := json.Marshal(leaseRecord{HolderIdentity: "replica-b", LeaseDurationSeconds: 1})
+	assert.NoError(t, mockClient.DatasetSetUserProperty(ctx, "pool/parent", PropLeaderLease, string(data)))
+	b.isLeader.Store(true)
+	err = d.checkLeader(ctx, "/csi.v1.Controller/CreateVolume")
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.False(t, d.leader.IsLeader())
+	assert.NoError(t, b.confirm(ctx))
+
+	// Leader election disabled
+	assert.NoError(t, (&Driver{}).confirmLeader(ctx))
+}
@@ -0,0 +1,260 @@
+package driver
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// legacySections maps democratic-csi top-level sections to their native names. An empty
+// name means the section has no equivalent and is dropped.
+var legacySections = map[string]string{
+	"httpConnection": "truenas",
+	"sshConnection":  "",
+	"node":           "",
+	"csi":            "",
+	"_private":       "",
+}
+
+// legacyKeys maps democratic-csi keys within a native section to their native names. An
+// empty name means the key has no equivalent and is dropped.
+var legacyKeys = map[string]map[string]string{
+	"truenas": {
+		"apiVersion": "",
+		"serialize":  "",
+	},
+	"zfs": {
+		"cli":                     "",
+		"zvolEnableReservation":   "datasetEnableReservation",
+		"zvolCompression":         "",
+		"zvolDedup":               "",
+		"datasetPermissionsMode":  "",
+		"datasetPermissionsUser":  "",
+		"datasetPermissionsGroup": "",
+		"datasetPermissionsAcls":  "",
+	},
+	"nfs": {
+		"shareAlldirs": "",
+	},
+	"iscsi": {
+		"extentCommentTemplate": "",
+		"extentInsecureTpc":     "",
+		"extentXenCompat":       "",
+	},
+	"nvmeof": {
+		"targetAddress":   "transportAddress",
+		"port":            "transportServiceId",
+		"hosts":           "subsystemHosts",
+		"nqnPrefix":       "",
+		"namespace":       "",
+		"commentTemplate": "",
+	},
+}
+
+// legacyTargetGroupKeys maps democratic-csi iSCSI target group keys to their native names.
+var legacyTargetGroupKeys = map[string]string{
+	"targetGroupPortalGroup":    "portal",
+	"targetGroupInitiatorGroup": "initiator",
+	"targetGroupAuthType":       "authMethod",
+	"targetGroupAuthGroup":      "auth",
+}
+
+// legacyTemplateKeys are settings that held Handlebars templates in democratic-csi.
+// Templates aren't rendered by this driver, so templated values are dropped.
+var legacyTemplateKeys = map[string][]string{
+	"nfs":    {"shareCommentTemplate"},
+	"iscsi":  {"nameTemplate"},
+	"nvmeof": {"nameTemplate"},
+}
+
+// legacyDriverPrefixes are democratic-csi driver name prefixes, replaced with "truenas-".
+var legacyDriverPrefixes = []string{"freenas-api-", "truenas-api-", "freenas-", "zfs-generic-"}
+
+// translateLegacyConfig rewrites a democratic-csi style config, decoded into raw, to the
+// native layout in place. Unknown keys are left alone so strict decoding still reports
+// them. It returns one message per deprecated key, sorted.
+func translateLegacyConfig(raw map[string]interface{}) []string {
+	var warnings []string
+	warn := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	for old, native := range legacySections {
+		value, ok := raw[old]
+		if !ok {
+			continue
+		}
+		delete(raw, old)
+		if native == "" {
+			warn("%s is not supported by this driver and was ignored", old)
+			continue
+		}
+		if _, exists := raw[native]; exists {
+			warn("%s is deprecated and was ignored because %s is also set", old, native)
+			continue
+		}
+		warn("%s is deprecated, use %s", old, native)
+		raw[native] = value
+	}
+
+	if name, ok := raw["driver"].(string); ok {
+		for _, prefix := range legacyDriverPrefixes {
+			if shareType, found := strings.CutPrefix(name, prefix); found {
+				raw["driver"] = "truenas-" + shareType
+				warn("driver %q is deprecated, use %q", name, raw["driver"])
+				break
+			}
+		}
+	}
+
+	for section, keys := range legacyKeys {
+		m, ok := raw[section].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		renameLegacyKeys(section, m, keys, warn)
+	}
+
+	for section, keys := range legacyTemplateKeys {
+		m, ok := raw[section].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, key := range keys {
+			if s, ok := m[key].(string); ok && isTemplate(s) {
+				delete(m, key)
+				warn("%s.%s is a Handlebars template, which is not supported, and was ignored", section, key)
+			}
+		}
+	}
+
+	if zfs, ok := raw["zfs"].(map[string]interface{}); ok {
+		if props, ok := zfs["datasetProperties"].(map[string]interface{}); ok {
+			for name, value := range props {
+				if s, ok := value.(string); ok && isTemplate(s) {
+					delete(props, name)
+					warn("zfs.datasetProperties[%s] is a Handlebars template, which is not supported, and was ignored", name)
+				}
+			}
+		}
+	}
+
+	if iscsi, ok := raw["iscsi"].(map[string]interface{}); ok {
+		if groups, ok := iscsi["targetGroups"].([]interface{}); ok {
+			for i, g := range groups {
+				if group, ok := g.(map[string]interface{}); ok {
+					translateLegacyTargetGroup(fmt.Sprintf("iscsi.targetGroups[%d]", i), group, warn)
+				}
+			}
+		}
+	}
+
+	if nvmeof, ok := raw["nvmeof"].(map[string]interface{}); ok {
+		translateLegacyNVMeoF(nvmeof, warn)
+	}
+
+	sort.Strings(warnings)
+	return warnings
+}
+
+// renameLegacyKeys moves keys in m to their native names, dropping those without one.
+// A native key that is already set wins over its legacy equivalent.
+func renameLegacyKeys(section string, m map[string]interface{}, keys map[string]string, warn func(string, ...interface{})) {
+	for old, native := range keys {
+		value, ok := m[old]
+		if !ok {
+			continue
+		}
+		delete(m, old)
+		switch _, exists := m[native]; {
+		case native == "":
+			warn("%s.%s is not supported by this driver and was ignored", section, old)
+		case exists:
+			warn("%s.%s is deprecated and was ignored because %s.%s is also set", section, old, section, native)
+		default:
+			warn("%s.%s is deprecated, use %s.%s", section, old, section, native)
+			m[native] = value
+		}
+	}
+}
+
+// translateLegacyTargetGroup converts a democratic-csi iSCSI target group in place.
+func translateLegacyTargetGroup(path string, group map[string]interface{}, warn func(string, ...interface{})) {
+	renameLegacyKeys(path, group, legacyTargetGroupKeys, warn)
+
+	// democratic-csi uses the UI labels: None, CHAP, CHAP Mutual
+	if method, ok := group["authMethod"].(string); ok {
+		group["authMethod"] = strings.ReplaceAll(strings.ToUpper(method), " ", "_")
+	}
+	// An empty auth group means none
+	if auth, ok := group["auth"]; ok && (auth == nil || auth == "") {
+		delete(group, "auth")
+	}
+}
+
+// translateLegacyNVMeoF converts the democratic-csi nvmeof subsystem and transports
+// settings in place.
+func translateLegacyNVMeoF(m map[string]interface{}, warn func(string, ...interface{})) {
+	if subsystem, ok := m["subsystem"].(map[string]interface{}); ok {
+		delete(m, "subsystem")
+		if allowAnyHost, ok := subsystem["allowAnyHost"]; ok {
+			m["subsystemAllowAnyHost"] = allowAnyHost
+			warn("nvmeof.subsystem.allowAnyHost is deprecated, use nvmeof.subsystemAllowAnyHost")
+		}
+		for key := range subsystem {
+			if key != "allowAnyHost" {
+				warn("nvmeof.subsystem.%s is not supported by this driver and was ignored", key)
+			}
+		}
+	}
+
+	// transports: ["tcp://10.0.0.1:4420"]; only the first is used
+	if transports, ok := m["transports"].([]interface{}); ok {
+		delete(m, "transports")
+		if len(transports) == 0 {
+			return
+		}
+		s, _ := transports[0].(string)
+		transport, address, port, err := parseLegacyNVMeoFTransport(s)
+		if err != nil {
+			warn("nvmeof.transports[0] %q could not be parsed and was ignored: %v", s, err)
+			return
+		}
+		m["transport"] = transport
+		m["transportAddress"] = address
+		if port != 0 {
+			m["transportServiceId"] = port
+		}
+		warn("nvmeof.transports is deprecated, use nvmeof.transport, nvmeof.transportAddress and nvmeof.transportServiceId")
+		if len(transports) > 1 {
+			warn("nvmeof.transports has more than one entry; only the first is used")
+		}
+	}
+}
+
+// parseLegacyNVMeoFTransport splits a transport URI such as tcp://10.0.0.1:4420.
+func parseLegacyNVMeoFTransport(s string) (transport, address string, port int, err error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", "", 0, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", "", 0, fmt.Errorf("expected <transport>://<address>[:<port>]")
+	}
+	address = u.Host
+	if host, p, splitErr := net.SplitHostPort(u.Host); splitErr == nil {
+		address = host
+		if port, err = strconv.Atoi(p); err != nil {
+			return "", "", 0, fmt.Errorf("invalid port %q", p)
+		}
+	}
+	return u.Scheme, address, port, nil
+}
+
+// isTemplate returns true if s contains a Handlebars expression.
+func isTemplate(s string) bool {
+	return strings.Contains(s, "{{")
+}
@@ -0,0 +1,125 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const legacyTestConfig = `driver: freenas-api-iscsi
+httpConnection:
+  protocol: https
+  host: truenas.local
+  port: 443
+  apiKey: 1-key
+  apiVersion: 2
+sshConnection:
+  host: truenas.local
+zfs:
+  datasetParentName: tank/k8s/volumes
+  zvolEnableReservation: true
+  zvolCompression: lz4
+  datasetProperties:
+    "org.example:owner": "{{ parameters.[csi.storage.k8s.io/pvc/name] }}"
+    "org.example:team": storage
+iscsi:
+  targetPortal: truenas.local:3260
+  nameTemplate: "{{ parameters.[csi.storage.k8s.io/pvc/name] }}"
+  extentRpm: Unknown
+  targetGroups:
+    - targetGroupPortalGroup: 1
+      targetGroupInitiatorGroup: 2
+      targetGroupAuthType: CHAP Mutual
+      targetGroupAuthGroup: 3
+    - targetGroupPortalGroup: 4
+      targetGroupInitiatorGroup: 5
+      targetGroupAuthType: None
+      targetGroupAuthGroup:
+nvmeof:
+  transports:
+    - tcp://10.0.0.5:4421
+  subsystem:
+    allowAnyHost: false
+    serialNumber: abc
+  hosts:
+    - nqn.2014-08.org.nvmexpress:uuid:1234
+`
+
+func TestLoadLegacyConfig(t *testing.T) {
+	cfg, err := loadTestConfig(t, legacyTestConfig)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "truenas-iscsi", cfg.DriverName)
+	assert.Equal(t, "truenas.local", cfg.TrueNAS.Host)
+	assert.Equal(t, "1-key", cfg.TrueNAS.APIKey)
+	assert.True(t, cfg.ZFS.DatasetEnableReservation)
+	assert.Equal(t, map[string]string{"org.example:team": "storage"}, cfg.ZFS.DatasetProperties)
+	assert.Empty(t, cfg.ISCSI.NameTemplate)
+	assert.Equal(t, "UNKNOWN", cfg.ISCSI.ExtentRpm)
+
+	auth := 3
+	assert.Equal(t, []ISCSITargetGroup{
+		{Portal: 1, Initiator: 2, AuthMethod: "CHAP_MUTUAL", Auth: &auth},
+		{Portal: 4, Initiator: 5, AuthMethod: "NONE"},
+	}, cfg.ISCSI.TargetGroups)
+
+	assert.Equal(t, "tcp", cfg.NVMeoF.Transport)
+	assert.Equal(t, "10.0.0.5", cfg.NVMeoF.TransportAddress)
+	assert.Equal(t, 4421, cfg.NVMeoF.TransportServiceID)
+	assert.False(t, cfg.NVMeoF.SubsystemAllowAnyHost)
+	assert.Equal(t, []string{"nqn.2014-08.org.nvmexpress:uuid:1234"}, cfg.NVMeoF.SubsystemHosts)
+
+	warnings := strings.Join(cfg.Deprecations(), "\n")
+	for _, want := range []string{
+		"httpConnection is deprecated, use truenas",
+		"sshConnection is not supported",
+		"truenas.apiVersion is not supported",
+		`driver "freenas-api-iscsi" is deprecated, use "truenas-iscsi"`,
+		"zfs.zvolCompression is not supported",
+		"zfs.datasetProperties[org.example:owner] is a Handlebars template",
+		"iscsi.nameTemplate is a Handlebars template",
+		"iscsi.targetGroups[1].targetGroupPortalGroup is deprecated, use iscsi.targetGroups[1].portal",
+		"nvmeof.subsystem.serialNumber is not supported",
+		"nvmeof.transports is deprecated",
+	} {
+		assert.Contains(t, warnings, want)
+	}
+}
+
+func TestLoadLegacyConfigPrecedence(t *testing.T) {
+	// Native keys win over their legacy equivalents
+	data := validateTestConfig + "httpConnection:\n  host: legacy.local\n"
+	data = strings.Replace(data, "zfs:\n", "zfs:\n  zvolEnableReservation: true\n  datasetEnableReservation: false\n", 1)
+	cfg, err := loadTestConfig(t, data)
+	assert.NoError(t, err)
+	assert.Equal(t, "truenas.local", cfg.TrueNAS.Host)
+	assert.False(t, cfg.ZFS.DatasetEnableReservation)
+	assert.Len(t, cfg.Deprecations(), 2)
+
+	// Native configs have no deprecations, and unknown keys are still errors after translation
+	cfg, err = loadTestConfig(t, validateTestConfig)
+	assert.NoError(t, err)
+	assert.Empty(t, cfg.Deprecations())
+
+	_, err = loadTestConfig(t, legacyTestConfig+"  bogusKey: 1\n")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "bogusKey")
+	}
+}
+
+func TestParseLegacyNVMeoFTransport(t *testing.T) {
+	transport, address, port, err := parseLegacyNVMeoFTransport("rdma://[fd00::1]:4420")
+	assert.NoError(t, err)
+	assert.Equal(t, "rdma", transport)
+	assert.Equal(t, "fd00::1", address)
+	assert.Equal(t, 4420, port)
+
+	_, address, port, err = parseLegacyNVMeoFTransport("tcp://10.0.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", address)
+	assert.Equal(t, 0, port)
+
+	_, _, _, err = parseLegacyNVMeoFTransport("10.0.0.1:4420")
+	assert.Error(t, err)
+}
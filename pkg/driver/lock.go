@@ -0,0 +1,395 @@
+package driver
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+)
+
+// PropOperationLockPrefix prefixes the per-key user properties that persist controller
+// operation locks on the parent dataset.
+const PropOperationLockPrefix = "truenas-csi:oplock_"
+
+// foreignLockPollInterval is how often a waiter re-checks TrueNAS for a lock held by another replica.
+const foreignLockPollInterval = time.Second
+
+// OperationLock describes a held operation lock.
+type OperationLock struct {
+	Key        string    `json:"key"`
+	Operation  string    `json:"operation"`
+	Owner      string    `json:"owner"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+
+	// Foreign is true for locks loaded from TrueNAS that another replica (or a crashed
+	// previous leader) holds; it is not persisted.
+	Foreign bool `json:"foreign,omitempty"`
+
+	released chan struct{}
+	// persistMu orders the renewals of the persisted lock before its removal
+	persistMu sync.Mutex
+}
+
+// defaultLockTTL bounds how long a lock is honored when none is configured.
+const defaultLockTTL = 10 * time.Minute
+
+// lockManager serializes operations per key with owner and expiry tracking.
+// Locks for keys accepted by persist are also written to TrueNAS as user properties on the
+// parent dataset, so a replica taking over after a crash or failover honors in-flight operations.
+// Held locks are renewed every third of the TTL, so only the locks of a crashed or hung
+// process expire. The zero value is an in-memory manager with the default TTL.
+type lockManager struct {
+	mu    sync.Mutex
+	locks map[string]*OperationLock
+
+	owner   string
+	ttl     time.Duration
+	maxWait time.Duration
+
+	// Optional persistence (client nil or persist nil keeps locks in memory only)
+	client  truenas.ClientInterface
+	dataset string
+	persist func(key string) bool
+	// exclusive is set when no other replica can run the controller, so Recover clears
+	// every persisted lock instead of honoring those of other owners
+	exclusive bool
+}
+
+// Acquire takes the lock for key, waiting while it is held until the lock is released or
+// expires, ctx is done, or maxWait elapses. A zero maxWait waits for the ctx deadline only;
+// a context that is already done fails immediately if the key is held. Unless the manager
+// is exclusive, a persisted lock is first checked in TrueNAS, so a lock another replica
+// holds is waited for too. The returned lock is passed to Release.
+func (m *lockManager) Acquire(ctx context.Context, key, operation string) (*OperationLock, error) {
+	if m.maxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.maxWait)
+		defer cancel()
+	}
+
+	ttl := m.lockTTL()
+	for {
+		if m.persistent(key) && !m.exclusive {
+			m.loadForeign(ctx, key)
+		}
+
+		m.mu.Lock()
+		if m.locks == nil {
+			m.locks = make(map[string]*OperationLock)
+		}
+		held := m.locks[key]
+		now := time.Now()
+		if held == nil || now.After(held.ExpiresAt) {
+			if held != nil {
+				klog.Warningf("Taking over expired lock %s (operation=%s, owner=%s, acquired=%v)",
+					key, held.Operation, held.Owner, held.AcquiredAt.Format(time.RFC3339))
+				close(held.released)
+			}
+			lock := &OperationLock{
+				Key:        key,
+				Operation:  operation,
+				Owner:      m.owner,
+				AcquiredAt: now,
+				ExpiresAt:  now.Add(ttl),
+				released:   make(chan struct{}),
+			}
+			m.locks[key] = lock
+			m.mu.Unlock()
+
+			if m.persistent(key) {
+				m.store(ctx, lock)
+			}
+			go m.renew(lock, ttl)
+			return lock, nil
+		}
+		expiresAt := held.ExpiresAt
+		m.mu.Unlock()
+
+		klog.V(4).Infof("Waiting for lock %s held by %s (operation=%s)", key, held.Owner, held.Operation)
+
+		// Wait for release, expiry, or (for foreign locks) removal from TrueNAS
+		wait := time.Until(expiresAt)
+		if held.Foreign && wait > foreignLockPollInterval {
+			wait = foreignLockPollInterval
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-held.released:
+			timer.Stop()
+		case <-timer.C:
+			if held.Foreign {
+				m.refreshForeign(ctx, held)
+			}
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%s already in progress for %s (owner %s since %s): %w",
+				held.Operation, key, held.Owner, held.AcquiredAt.Format(time.RFC3339), ctx.Err())
+		}
+	}
+}
+
+// Release releases lock if it is still held. It does nothing once the lock has expired and
+// been taken over, so a slow holder can't release the lock of the operation that replaced it.
+// The persisted lock is removed first, so a waiter that takes the key over next isn't
+// affected by the removal.
+func (m *lockManager) Release(lock *OperationLock) {
+	lock.persistMu.Lock()
+	defer lock.persistMu.Unlock()
+
+	if !m.holds(lock) {
+		return
+	}
+	if m.persistent(lock.Key) {
+		m.remove(lock.Key)
+	}
+
+	m.mu.Lock()
+	if m.locks[lock.Key] == lock {
+		delete(m.locks, lock.Key)
+		close(lock.released)
+	}
+	m.mu.Unlock()
+}
+
+// renew extends the expiry of lock, in memory and in TrueNAS, until it is released or
+// taken over.
+func (m *lockManager) renew(lock *OperationLock, ttl time.Duration) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lock.released:
+			return
+		case <-ticker.C:
+		}
+
+		lock.persistMu.Lock()
+		m.mu.Lock()
+		held := m.locks[lock.Key] == lock
+		if held {
+			lock.ExpiresAt = time.Now().Add(ttl)
+		}
+		m.mu.Unlock()
+		if held && m.persistent(lock.Key) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			m.store(ctx, lock)
+			cancel()
+		}
+		lock.persistMu.Unlock()
+		if !held {
+			return
+		}
+	}
+}
+
+// holds reports whether lock is the current lock for its key.
+func (m *lockManager) holds(lock *OperationLock) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.locks[lock.Key] == lock
+}
+
+// lockTTL returns the configured TTL, or the default if none is set.
+func (m *lockManager) lockTTL() time.Duration {
+	if m.ttl <= 0 {
+		return defaultLockTTL
+	}
+	return m.ttl
+}
+
+// List returns a snapshot of the held locks sorted by key.
+func (m *lockManager) List() []OperationLock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	locks := make([]OperationLock, 0, len(m.locks))
+	for _, l := range m.locks {
+		locks = append(locks, OperationLock{
+			Key:        l.Key,
+			Operation:  l.Operation,
+			Owner:      l.Owner,
+			AcquiredAt: l.AcquiredAt,
+			ExpiresAt:  l.ExpiresAt,
+			Foreign:    l.Foreign,
+		})
+	}
+	sort.Slice(locks, func(i, j int) bool { return locks[i].Key < locks[j].Key })
+	return locks
+}
+
+// Recover loads persisted locks from TrueNAS. Locks recorded by this owner are left over from
+// a previous run that crashed mid-operation; they are logged and cleared. Locks from other
+// owners are honored until they are released or expire, unless the manager is exclusive:
+// a replacement pod has a new owner name, and without other replicas its predecessor's
+// locks can only be stale.
+func (m *lockManager) Recover(ctx context.Context) error {
+	if m.client == nil {
+		return nil
+	}
+
+	ds, err := m.client.DatasetGet(ctx, m.dataset)
+	if err != nil {
+		return fmt.Errorf("failed to read persisted locks: %w", err)
+	}
+
+	for prop, value := range ds.UserProperties {
+		if !strings.HasPrefix(prop, PropOperationLockPrefix) {
+			continue
+		}
+		lock := parseOperationLock(value.Value)
+		if lock == nil || m.exclusive || lock.Owner == m.owner || time.Now().After(lock.ExpiresAt) {
+			if lock != nil {
+				klog.Warningf("Clearing stale lock %s (operation=%s, owner=%s, acquired=%v): operation was interrupted",
+					lock.Key, lock.Operation, lock.Owner, lock.AcquiredAt.Format(time.RFC3339))
+			}
+			m.removeProperty(ctx, prop)
+			continue
+		}
+
+		klog.Infof("Honoring lock %s held by %s (operation=%s) until %v",
+			lock.Key, lock.Owner, lock.Operation, lock.ExpiresAt.Format(time.RFC3339))
+		m.addForeign(lock)
+	}
+	return nil
+}
+
+// loadForeign records the persisted lock for key as foreign if another owner holds it and
+// the key isn't held here. A failed read is logged and the key is treated as free.
+func (m *lockManager) loadForeign(ctx context.Context, key string) {
+	m.mu.Lock()
+	_, held := m.locks[key]
+	m.mu.Unlock()
+	if held {
+		return
+	}
+
+	value, err := m.client.DatasetGetUserProperty(ctx, m.dataset, lockPropertyName(key))
+	if err != nil {
+		klog.Warningf("Failed to check persisted lock %s: %v", key, err)
+		return
+	}
+	lock := parseOperationLock(value)
+	if lock == nil || lock.Owner == m.owner || time.Now().After(lock.ExpiresAt) {
+		return
+	}
+	klog.V(4).Infof("Lock %s is held by %s (operation=%s)", key, lock.Owner, lock.Operation)
+	m.addForeign(lock)
+}
+
+// addForeign adds a lock held by another owner unless the key is already held.
+func (m *lockManager) addForeign(lock *OperationLock) {
+	lock.Foreign = true
+	lock.released = make(chan struct{})
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.locks == nil {
+		m.locks = make(map[string]*OperationLock)
+	}
+	if _, exists := m.locks[lock.Key]; !exists {
+		m.locks[lock.Key] = lock
+	}
+}
+
+// persistent reports whether the lock for key is recorded in TrueNAS.
+func (m *lockManager) persistent(key string) bool {
+	return m.client != nil && m.persist != nil && m.persist(key)
+}
+
+// refreshForeign drops a foreign lock once its property is gone from TrueNAS, and picks up
+// the renewals of its owner otherwise.
+func (m *lockManager) refreshForeign(ctx context.Context, held *OperationLock) {
+	value, err := m.client.DatasetGetUserProperty(ctx, m.dataset, lockPropertyName(held.Key))
+	if err != nil {
+		klog.V(4).Infof("Failed to refresh lock %s: %v", held.Key, err)
+		return
+	}
+	if current := parseOperationLock(value); current != nil && current.Owner == held.Owner {
+		m.mu.Lock()
+		if current.ExpiresAt.After(held.ExpiresAt) {
+			held.ExpiresAt = current.ExpiresAt
+		}
+		m.mu.Unlock()
+		return
+	}
+
+	m.mu.Lock()
+	if m.locks[held.Key] == held {
+		delete(m.locks, held.Key)
+		close(held.released)
+	}
+	m.mu.Unlock()
+}
+
+// store persists lock to TrueNAS. Failures are logged; the in-memory lock stays authoritative.
+func (m *lockManager) store(ctx context.Context, lock *OperationLock) {
+	m.mu.Lock()
+	data, _ := json.Marshal(lock)
+	m.mu.Unlock()
+	if err := m.client.DatasetSetUserProperty(ctx, m.dataset, lockPropertyName(lock.Key), string(data)); err != nil {
+		klog.Warningf("Failed to persist lock %s: %v", lock.Key, err)
+	}
+}
+
+// remove deletes the persisted lock for key.
+func (m *lockManager) remove(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	m.removeProperty(ctx, lockPropertyName(key))
+}
+
+func (m *lockManager) removeProperty(ctx context.Context, prop string) {
+	_, err := m.client.DatasetUpdate(ctx, m.dataset, &truenas.DatasetUpdateParams{
+		UserPropertiesUpdate: []truenas.UserPropertyUpdate{{Key: prop, Remove: true}},
+	})
+	if err != nil {
+		klog.Warningf("Failed to remove persisted lock %s: %v", prop, err)
+	}
+}
+
+// lockPropertyEncoding encodes lock keys into the lowercase letters and digits allowed in
+// ZFS user property names, keeping keys that differ only in case apart.
+var lockPropertyEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// lockPropertyName maps a lock key to a valid ZFS user property name.
+func lockPropertyName(key string) string {
+	return PropOperationLockPrefix + lockPropertyEncoding.EncodeToString([]byte(key))
+}
+
+// parseOperationLock decodes a persisted lock, returning nil for malformed values.
+func parseOperationLock(raw string) *OperationLock {
+	if raw == "" || raw == "-" {
+		return nil
+	}
+	lock := &OperationLock{}
+	if err := json.Unmarshal([]byte(raw), lock); err != nil || lock.Key == "" {
+		return nil
+	}
+	return lock
+}
+
+// acquireOperationLock acquires the lock for the given operation key, waiting up to the
+// configured lock wait (bounded by the request deadline) if another operation holds it.
+func (d *Driver) acquireOperationLock(ctx context.Context, key, operation string) (*OperationLock, error) {
+	lock, err := d.operationLock.Acquire(ctx, key, operation)
+	if err != nil {
+		return nil, status.Errorf(codes.Aborted, "operation already in progress: %v", err)
+	}
+	return lock, nil
+}
+
+// releaseOperationLock releases a lock taken by acquireOperationLock.
+func (d *Driver) releaseOperationLock(lock *OperationLock) {
+	d.operationLock.Release(lock)
+}
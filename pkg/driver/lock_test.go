@@ -0,0 +1,202 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLockManagerWait(t *testing.T) {
+	m := &lockManager{owner: "replica-a", maxWait: time.Second}
+
+	// Test Case 1: Acquire and release
+	lock, err := m.Acquire(context.Background(), "volume:vol-01", "CreateVolume")
+	assert.NoError(t, err)
+	locks := m.List()
+	assert.Len(t, locks, 1)
+	assert.Equal(t, "CreateVolume", locks[0].Operation)
+	assert.Equal(t, "replica-a", locks[0].Owner)
+
+	// Test Case 2: Waiter proceeds once the holder releases
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		m.Release(lock)
+	}()
+	_, err = m.Acquire(context.Background(), "volume:vol-01", "DeleteVolume")
+	assert.NoError(t, err)
+	assert.Equal(t, "DeleteVolume", m.List()[0].Operation)
+
+	// Test Case 3: Waiter gives up at the context deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = m.Acquire(ctx, "volume:vol-01", "ControllerExpandVolume")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "DeleteVolume already in progress")
+
+	// Test Case 4: Other keys are independent
+	_, err = m.Acquire(context.Background(), "volume:vol-02", "CreateVolume")
+	assert.NoError(t, err)
+}
+
+func TestLockManagerExpiry(t *testing.T) {
+	m := &lockManager{owner: "replica-a", ttl: 50 * time.Millisecond}
+
+	// A lock whose holder stopped renewing it, as after a hang, is taken over after its TTL
+	expired := &OperationLock{
+		Key:       "volume:vol-01",
+		Operation: "CreateVolume",
+		Owner:     "replica-a",
+		ExpiresAt: time.Now().Add(50 * time.Millisecond),
+		released:  make(chan struct{}),
+	}
+	m.locks = map[string]*OperationLock{expired.Key: expired}
+	_, err := m.Acquire(context.Background(), "volume:vol-01", "DeleteVolume")
+	assert.NoError(t, err)
+	assert.Equal(t, "DeleteVolume", m.List()[0].Operation)
+
+	// The late release of the expired lock leaves the new holder's lock alone
+	m.Release(expired)
+	assert.Len(t, m.List(), 1)
+	assert.Equal(t, "DeleteVolume", m.List()[0].Operation)
+}
+
+func TestLockManagerRenewal(t *testing.T) {
+	ctx := context.Background()
+	mockClient := truenas.NewMockClient()
+	_, err := mockClient.DatasetCreate(ctx, &truenas.DatasetCreateParams{Name: "pool/parent"})
+	assert.NoError(t, err)
+	persist := func(string) bool { return true }
+	m := &lockManager{owner: "replica-a", ttl: 60 * time.Millisecond, client: mockClient, dataset: "pool/parent", persist: persist}
+
+	lock, err := m.Acquire(ctx, "volume:vol-01", "CreateVolume")
+	assert.NoError(t, err)
+	raw, _ := mockClient.DatasetGetUserProperty(ctx, "pool/parent", lockPropertyName("volume:vol-01"))
+	first := parseOperationLock(raw)
+
+	// Test Case 1: An operation running past the TTL keeps its lock
+	waitCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	_, err = m.Acquire(waitCtx, "volume:vol-01", "DeleteVolume")
+	assert.Error(t, err)
+	assert.Equal(t, "CreateVolume", m.List()[0].Operation)
+
+	// Test Case 2: The persisted lock is renewed too
+	raw, _ = mockClient.DatasetGetUserProperty(ctx, "pool/parent", lockPropertyName("volume:vol-01"))
+	renewed := parseOperationLock(raw)
+	if assert.NotNil(t, first) && assert.NotNil(t, renewed) {
+		assert.True(t, renewed.ExpiresAt.After(first.ExpiresAt))
+	}
+
+	// Test Case 3: Renewal stops at release, leaving nothing persisted
+	m.Release(lock)
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, m.List())
+	assert.Empty(t, mockClient.Datasets["pool/parent"].UserProperties)
+}
+
+func TestLockManagerPersistence(t *testing.T) {
+	ctx := context.Background()
+	mockClient := truenas.NewMockClient()
+	_, err := mockClient.DatasetCreate(ctx, &truenas.DatasetCreateParams{Name: "pool/parent"})
+	assert.NoError(t, err)
+
+	persist := func(key string) bool { return !strings.HasPrefix(key, "node-") }
+	a := &lockManager{owner: "replica-a", client: mockClient, dataset: "pool/parent", persist: persist}
+
+	// Controller locks are written to TrueNAS; node locks are not
+	lock, err := a.Acquire(ctx, "volume:PVC-01", "CreateVolume")
+	assert.NoError(t, err)
+	_, err = a.Acquire(ctx, "node-stage:pvc-01", "NodeStageVolume")
+	assert.NoError(t, err)
+	raw, err := mockClient.DatasetGetUserProperty(ctx, "pool/parent", lockPropertyName("volume:PVC-01"))
+	assert.NoError(t, err)
+	assert.Contains(t, raw, `"owner":"replica-a"`)
+	assert.Len(t, mockClient.Datasets["pool/parent"].UserProperties, 1)
+
+	// Another replica checks TrueNAS before taking a lock, even without recovering first
+	b := &lockManager{owner: "replica-b", client: mockClient, dataset: "pool/parent", persist: persist}
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = b.Acquire(waitCtx, "volume:PVC-01", "DeleteVolume")
+	assert.Error(t, err)
+
+	// It honors the persisted lock until it is removed from TrueNAS
+	assert.NoError(t, b.Recover(ctx))
+	assert.True(t, b.List()[0].Foreign)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		a.Release(lock)
+	}()
+	_, err = b.Acquire(ctx, "volume:PVC-01", "DeleteVolume")
+	assert.NoError(t, err)
+	raw, _ = mockClient.DatasetGetUserProperty(ctx, "pool/parent", lockPropertyName("volume:PVC-01"))
+	assert.Contains(t, raw, `"owner":"replica-b"`)
+
+	// A restarted replica clears locks it recorded before crashing
+	restarted := &lockManager{owner: "replica-b", client: mockClient, dataset: "pool/parent", persist: persist}
+	assert.NoError(t, restarted.Recover(ctx))
+	assert.Empty(t, restarted.List())
+	assert.Empty(t, mockClient.Datasets["pool/parent"].UserProperties)
+
+	// Without other replicas, a replacement pod clears the locks of its predecessor
+	_, err = a.Acquire(ctx, "volume:pvc-02", "CreateVolume")
+	assert.NoError(t, err)
+	replacement := &lockManager{owner: "replica-c", client: mockClient, dataset: "pool/parent", persist: persist, exclusive: true}
+	assert.NoError(t, replacement.Recover(ctx))
+	assert.Empty(t, replacement.List())
+	assert.Empty(t, mockClient.Datasets["pool/parent"].UserProperties)
+}
+
+func TestLockPropertyName(t *testing.T) {
+	// Keys differing only in case are persisted separately, in valid property names
+	upper, lower := lockPropertyName("volume:PVC-01"), lockPropertyName("volume:pvc-01")
+	assert.NotEqual(t, upper, lower)
+	for _, name := range []string{upper, lower} {
+		assert.True(t, strings.HasPrefix(name, PropOperationLockPrefix))
+		assert.Equal(t, strings.ToLower(name), name)
+		assert.NotContains(t, strings.TrimPrefix(name, PropOperationLockPrefix), "=")
+	}
+}
+
+func TestAcquireOperationLockAborted(t *testing.T) {
+	d := &Driver{operationLock: lockManager{maxWait: 20 * time.Millisecond}}
+
+	lock, err := d.acquireOperationLock(context.Background(), "volume:vol-01", "CreateVolume")
+	assert.NoError(t, err)
+	_, err = d.acquireOperationLock(context.Background(), "volume:vol-01", "CreateVolume")
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	d.releaseOperationLock(lock)
+	_, err = d.acquireOperationLock(context.Background(), "volume:vol-01", "CreateVolume")
+	assert.NoError(t, err)
+}
+
+func TestDebugLocksEndpoint(t *testing.T) {
+	d := &Driver{identity: "replica-a", operationLock: lockManager{owner: "replica-a"}}
+	_, err := d.acquireOperationLock(context.Background(), "snapshot:snap-01", "CreateSnapshot")
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	d.httpHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/locks", nil))
+	assert.Equal(t, 200, rec.Code)
+
+	var resp struct {
+		Identity string          `json:"identity"`
+		Leader   bool            `json:"leader"`
+		Locks    []OperationLock `json:"locks"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "replica-a", resp.Identity)
+	assert.True(t, resp.Leader)
+	assert.Len(t, resp.Locks, 1)
+	assert.Equal(t, "snapshot:snap-01", resp.Locks[0].Key)
+}
@@ -22,6 +22,10 @@ import (
 // This is typically /var/lib/kubelet/plugins/truenas-csi/connections/
 const connectionInfoDir = "/var/lib/kubelet/plugins/truenas-csi/connections"
 
+// stagedDeviceLink is the symlink to the device that NodeStageVolume creates in the staging
+// directory of a raw block volume.
+const stagedDeviceLink = "device"
+
 // ConnectionInfo stores session connection details for reliable cleanup during unstage.
 // This ensures we can properly disconnect iSCSI/NVMe-oF sessions even if the volume
 // is already unmounted and we can't determine the connection from the device.
@@ -34,7 +38,7 @@ type ConnectionInfo struct {
 
 // saveConnectionInfo saves connection details for a volume to allow reliable cleanup.
 func (d *Driver) saveConnectionInfo(volumeID string, info *ConnectionInfo) error {
-	if err := os.MkdirAll(connectionInfoDir, 0750); err != nil {
+	if err := os.MkdirAll(d.exec.HostPath(connectionInfoDir), 0750); err != nil {
 		return fmt.Errorf("failed to create connection info directory: %w", err)
 	}
 
@@ -43,7 +47,7 @@ func (d *Driver) saveConnectionInfo(volumeID string, info *ConnectionInfo) error
 		return fmt.Errorf("failed to marshal connection info: %w", err)
 	}
 
-	filePath := filepath.Join(connectionInfoDir, volumeID+".json")
+	filePath := filepath.Join(d.exec.HostPath(connectionInfoDir), volumeID+".json")
 	if err := os.WriteFile(filePath, data, 0600); err != nil {
 		return fmt.Errorf("failed to write connection info: %w", err)
 	}
@@ -54,7 +58,7 @@ func (d *Driver) saveConnectionInfo(volumeID string, info *ConnectionInfo) error
 
 // readConnectionInfo reads saved connection details for a volume.
 func (d *Driver) readConnectionInfo(volumeID string) *ConnectionInfo {
-	filePath := filepath.Join(connectionInfoDir, volumeID+".json")
+	filePath := filepath.Join(d.exec.HostPath(connectionInfoDir), volumeID+".json")
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		if !os.IsNotExist(err) {
@@ -75,7 +79,7 @@ func (d *Driver) readConnectionInfo(volumeID string) *ConnectionInfo {
 
 // deleteConnectionInfo removes saved connection details for a volume.
 func (d *Driver) deleteConnectionInfo(volumeID string) {
-	filePath := filepath.Join(connectionInfoDir, volumeID+".json")
+	filePath := filepath.Join(d.exec.HostPath(connectionInfoDir), volumeID+".json")
 	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
 		klog.V(4).Infof("Failed to delete connection info for %s: %v", volumeID, err)
 	}
@@ -142,7 +146,12 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 	if stagingPath == "" {
 		return nil, status.Error(codes.InvalidArgument, "staging target path is required")
 	}
-	if volumeContext == nil {
+	if req.GetVolumeCapability() == nil {
+		return nil, status.Error(codes.InvalidArgument, "volume capability is required")
+	}
+	// Static volumes may be pre-bound without a volume context
+	_, _, static := parseStaticVolumeID(volumeID)
+	if volumeContext == nil && !static {
 		return nil, status.Error(codes.InvalidArgument, "volume context is required")
 	}
 
@@ -150,15 +159,27 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 
 	// Lock on volume ID
 	lockKey := "node-stage:" + volumeID
-	if !d.acquireOperationLock(lockKey) {
-		return nil, status.Error(codes.Aborted, "operation already in progress")
+	lock, err := d.acquireOperationLock(ctx, lockKey, "NodeStageVolume")
+	if err != nil {
+		return nil, err
+	}
+	defer d.releaseOperationLock(lock)
+
+	// Share static volumes on first stage, unless the PV already carries the share details
+	if static && volumeContext["node_attach_driver"] == "" {
+		ref, err := d.resolveVolume(volumeID)
+		if err != nil {
+			return nil, err
+		}
+		if volumeContext, err = d.getStaticVolumeContext(ctx, ref, volumeContext); err != nil {
+			return nil, err
+		}
 	}
-	defer d.releaseOperationLock(lockKey)
 
 	// Get attach driver from volume context
 	attachDriver := volumeContext["node_attach_driver"]
 	if attachDriver == "" {
-		attachDriver = d.config.GetDriverShareType()
+		attachDriver = d.GetConfig().GetDriverShareType()
 	}
 
 	// Ensure staging directory exists
@@ -220,17 +241,18 @@ func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolu
 
 	// Lock on volume ID
 	lockKey := "node-unstage:" + volumeID
-	if !d.acquireOperationLock(lockKey) {
-		return nil, status.Error(codes.Aborted, "operation already in progress")
+	lock, err := d.acquireOperationLock(ctx, lockKey, "NodeUnstageVolume")
+	if err != nil {
+		return nil, err
 	}
-	defer d.releaseOperationLock(lockKey)
+	defer d.releaseOperationLock(lock)
 
 	// Read saved connection info (saved during stage for reliable cleanup)
 	// This ensures we can clean up even if the volume is already unmounted
 	connectionInfo := d.readConnectionInfo(volumeID)
 
 	// Get device path before unmounting (for session cleanup)
-	devicePath, err := util.GetDeviceFromMountPoint(stagingPath)
+	devicePath, err := d.mounter.GetDeviceFromMountPoint(stagingPath)
 	if err != nil {
 		// If not mounted, we can't get the device path from mount
 		// But we can still use saved connection info for cleanup
@@ -238,11 +260,11 @@ func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolu
 	}
 
 	// Unmount staging path
-	if err := util.Unmount(stagingPath); err != nil {
+	if err := d.mounter.Unmount(stagingPath); err != nil {
 		klog.Warningf("Failed to unmount staging path: %v", err)
 		// BUG-003 fix: Check if still mounted before attempting removal
 		// to prevent data corruption from removing mounted directories
-		mounted, checkErr := util.IsMounted(stagingPath)
+		mounted, checkErr := d.mounter.IsMounted(stagingPath)
 		if checkErr != nil {
 			klog.Warningf("Failed to check mount status after unmount failure: %v", checkErr)
 			// If we can't verify mount status, don't risk removing a mounted path
@@ -265,9 +287,9 @@ func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolu
 	if devicePath != "" {
 		if strings.Contains(devicePath, "nvme") {
 			// NVMe-oF cleanup
-			nqn, err := util.GetNVMeInfoFromDevice(devicePath)
+			nqn, err := util.GetNVMeInfoFromDevice(d.exec, devicePath)
 			if err == nil {
-				if err := util.NVMeoFDisconnect(nqn); err != nil {
+				if err := util.NVMeoFDisconnect(d.exec, nqn); err != nil {
 					klog.Warningf("Failed to disconnect NVMe-oF session %s: %v", nqn, err)
 				} else {
 					klog.Infof("Disconnected NVMe-oF session %s", nqn)
@@ -278,9 +300,9 @@ func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolu
 			}
 		} else {
 			// Try iSCSI cleanup
-			portal, iqn, err := util.GetISCSIInfoFromDevice(devicePath)
+			portal, iqn, err := util.GetISCSIInfoFromDevice(d.exec, devicePath)
 			if err == nil {
-				if err := util.ISCSIDisconnect(portal, iqn); err != nil {
+				if err := util.ISCSIDisconnect(d.exec, portal, iqn); err != nil {
 					klog.Warningf("Failed to disconnect iSCSI session %s: %v", iqn, err)
 				} else {
 					klog.Infof("Disconnected iSCSI session %s", iqn)
@@ -298,7 +320,7 @@ func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolu
 		switch connectionInfo.Driver {
 		case "iscsi":
 			if connectionInfo.Portal != "" && connectionInfo.IQN != "" {
-				if err := util.ISCSIDisconnect(connectionInfo.Portal, connectionInfo.IQN); err != nil {
+				if err := util.ISCSIDisconnect(d.exec, connectionInfo.Portal, connectionInfo.IQN); err != nil {
 					klog.Warningf("Failed to disconnect iSCSI session %s (from saved info): %v", connectionInfo.IQN, err)
 				} else {
 					klog.Infof("Disconnected iSCSI session %s (from saved info)", connectionInfo.IQN)
@@ -307,7 +329,7 @@ func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolu
 			}
 		case "nvmeof":
 			if connectionInfo.NQN != "" {
-				if err := util.NVMeoFDisconnect(connectionInfo.NQN); err != nil {
+				if err := util.NVMeoFDisconnect(d.exec, connectionInfo.NQN); err != nil {
 					klog.Warningf("Failed to disconnect NVMe-oF session %s (from saved info): %v", connectionInfo.NQN, err)
 				} else {
 					klog.Infof("Disconnected NVMe-oF session %s (from saved info)", connectionInfo.NQN)
@@ -340,15 +362,19 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 	if targetPath == "" {
 		return nil, status.Error(codes.InvalidArgument, "target path is required")
 	}
+	if req.GetVolumeCapability() == nil {
+		return nil, status.Error(codes.InvalidArgument, "volume capability is required")
+	}
 
 	klog.Infof("NodePublishVolume: volumeID=%s, targetPath=%s, stagingPath=%s", volumeID, targetPath, stagingPath)
 
 	// Lock on volume ID
 	lockKey := "node-publish:" + volumeID
-	if !d.acquireOperationLock(lockKey) {
-		return nil, status.Error(codes.Aborted, "operation already in progress")
+	lock, err := d.acquireOperationLock(ctx, lockKey, "NodePublishVolume")
+	if err != nil {
+		return nil, err
 	}
-	defer d.releaseOperationLock(lockKey)
+	defer d.releaseOperationLock(lock)
 
 	// Ensure target directory exists
 	if err := os.MkdirAll(filepath.Dir(targetPath), 0750); err != nil {
@@ -356,7 +382,7 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 	}
 
 	// Check if already mounted
-	mounted, err := util.IsMounted(targetPath)
+	mounted, err := d.mounter.IsMounted(targetPath)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to check mount status: %v", err)
 	}
@@ -380,11 +406,25 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 	}
 
 	// Bind mount from staging path to target path
-	if stagingPath != "" {
+	if stagingPath != "" && req.GetVolumeCapability().GetBlock() != nil {
+		// Raw block volumes are published by bind mounting the device onto a file
+		devicePath, err := os.Readlink(filepath.Join(stagingPath, stagedDeviceLink))
+		if err != nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "block volume %s is not staged at %s", volumeID, stagingPath)
+		}
+		file, err := os.OpenFile(targetPath, os.O_CREATE, 0640)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to create target file: %v", err)
+		}
+		file.Close()
+		if err := d.mounter.BindMount(devicePath, targetPath, mountOptions); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to bind mount device: %v", err)
+		}
+	} else if stagingPath != "" {
 		if err := os.MkdirAll(targetPath, 0750); err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to create target path: %v", err)
 		}
-		if err := util.BindMount(stagingPath, targetPath, mountOptions); err != nil {
+		if err := d.mounter.BindMount(stagingPath, targetPath, mountOptions); err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to bind mount: %v", err)
 		}
 	} else {
@@ -392,7 +432,7 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 		volumeContext := req.GetVolumeContext()
 		attachDriver := volumeContext["node_attach_driver"]
 		if attachDriver == "" {
-			attachDriver = d.config.GetDriverShareType()
+			attachDriver = d.GetConfig().GetDriverShareType()
 		}
 
 		switch attachDriver {
@@ -400,7 +440,7 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 			server := volumeContext["server"]
 			share := volumeContext["share"]
 			source := fmt.Sprintf("%s:%s", server, share)
-			if err := util.MountNFS(source, targetPath, mountOptions); err != nil {
+			if err := d.mounter.MountNFS(source, targetPath, mountOptions); err != nil {
 				return nil, status.Errorf(codes.Internal, "failed to mount NFS: %v", err)
 			}
 		default:
@@ -428,16 +468,17 @@ func (d *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublish
 
 	// Lock on volume ID
 	lockKey := "node-unpublish:" + volumeID
-	if !d.acquireOperationLock(lockKey) {
-		return nil, status.Error(codes.Aborted, "operation already in progress")
+	lock, err := d.acquireOperationLock(ctx, lockKey, "NodeUnpublishVolume")
+	if err != nil {
+		return nil, err
 	}
-	defer d.releaseOperationLock(lockKey)
+	defer d.releaseOperationLock(lock)
 
 	// Unmount target path
-	if err := util.Unmount(targetPath); err != nil {
+	if err := d.mounter.Unmount(targetPath); err != nil {
 		klog.Warningf("Failed to unmount target path: %v", err)
 		// BUG-003 fix: Check if still mounted before attempting removal
-		mounted, checkErr := util.IsMounted(targetPath)
+		mounted, checkErr := d.mounter.IsMounted(targetPath)
 		if checkErr != nil {
 			klog.Warningf("Failed to check mount status after unmount failure: %v", checkErr)
 			return nil, status.Errorf(codes.Internal, "failed to unmount target path and cannot verify mount status: %v", err)
@@ -508,17 +549,21 @@ func (d *Driver) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolume
 	if volumeID == "" {
 		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
 	}
+	if volumePath == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume path is required")
+	}
 
 	klog.Infof("NodeExpandVolume: volumeID=%s, volumePath=%s", volumeID, volumePath)
 
+	if _, err := os.Stat(volumePath); os.IsNotExist(err) {
+		return nil, status.Errorf(codes.NotFound, "volume path not found: %s", volumePath)
+	}
+
 	// For block volumes (iSCSI/NVMe-oF), resize the filesystem
-	shareType := d.config.GetDriverShareType()
+	shareType := d.GetConfig().GetDriverShareType()
 	if shareType == "iscsi" || shareType == "nvmeof" {
-		// Find the device and resize filesystem
-		if volumePath != "" {
-			if err := util.ResizeFilesystem(volumePath); err != nil {
-				return nil, status.Errorf(codes.Internal, "failed to resize filesystem: %v", err)
-			}
+		if err := d.mounter.ResizeFilesystem(volumePath); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to resize filesystem: %v", err)
 		}
 	}
 
@@ -548,7 +593,7 @@ func (d *Driver) stageNFSVolume(ctx context.Context, volumeContext map[string]st
 	source := fmt.Sprintf("%s:%s", server, share)
 
 	// Check if already mounted
-	mounted, err := util.IsMounted(stagingPath)
+	mounted, err := d.mounter.IsMounted(stagingPath)
 	if err != nil {
 		return status.Errorf(codes.Internal, "failed to check mount status: %v", err)
 	}
@@ -558,7 +603,7 @@ func (d *Driver) stageNFSVolume(ctx context.Context, volumeContext map[string]st
 	}
 
 	// Mount NFS
-	if err := util.MountNFS(source, stagingPath, nil); err != nil {
+	if err := d.mounter.MountNFS(source, stagingPath, nil); err != nil {
 		return status.Errorf(codes.Internal, "failed to mount NFS: %v", err)
 	}
 
@@ -590,19 +635,25 @@ func (d *Driver) stageISCSIVolume(ctx context.Context, volumeContext map[string]
 
 	// Connect to iSCSI target with configurable timeout
 	connectOpts := &util.ISCSIConnectOptions{
-		DeviceTimeout: time.Duration(d.config.ISCSI.DeviceWaitTimeout) * time.Second,
+		DeviceTimeout: time.Duration(d.GetConfig().ISCSI.DeviceWaitTimeout) * time.Second,
 	}
-	devicePath, err := util.ISCSIConnectWithOptions(ctx, portal, iqn, lun, connectOpts)
+	devicePath, err := util.ISCSIConnectWithOptions(ctx, d.exec, portal, iqn, lun, connectOpts)
 	if err != nil {
 		return status.Errorf(codes.Internal, "failed to connect iSCSI: %v", err)
 	}
 
 	// Check if block mode
 	if volCap != nil && volCap.GetBlock() != nil {
-		// For block mode, create a symlink to the device
-		if err := os.Symlink(devicePath, stagingPath); err != nil && !os.IsExist(err) {
-			return status.Errorf(codes.Internal, "failed to create device symlink: %v", err)
-		}
+		return stageBlockDevice(devicePath, stagingPath)
+	}
+
+	// Check if already mounted
+	mounted, err := d.mounter.IsMounted(stagingPath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to check mount status: %v", err)
+	}
+	if mounted {
+		klog.Infof("Device %s already mounted at %s", devicePath, stagingPath)
 		return nil
 	}
 
@@ -612,13 +663,27 @@ func (d *Driver) stageISCSIVolume(ctx context.Context, volumeContext map[string]
 		fsType = volCap.GetMount().GetFsType()
 	}
 
-	if err := util.FormatAndMount(devicePath, stagingPath, fsType, nil); err != nil {
+	if err := d.mounter.FormatAndMount(devicePath, stagingPath, fsType, nil); err != nil {
 		return status.Errorf(codes.Internal, "failed to format and mount: %v", err)
 	}
 
 	return nil
 }
 
+// stageBlockDevice links a raw block volume's device into the staging directory for
+// NodePublishVolume to bind mount.
+func stageBlockDevice(devicePath, stagingPath string) error {
+	link := filepath.Join(stagingPath, stagedDeviceLink)
+	if current, err := os.Readlink(link); err == nil && current == devicePath {
+		return nil
+	}
+	_ = os.Remove(link)
+	if err := os.Symlink(devicePath, link); err != nil {
+		return status.Errorf(codes.Internal, "failed to create device symlink: %v", err)
+	}
+	return nil
+}
+
 // stageNVMeoFVolume connects and mounts an NVMe-oF volume to the staging path.
 func (d *Driver) stageNVMeoFVolume(ctx context.Context, volumeContext map[string]string, stagingPath string, volCap *csi.VolumeCapability) error {
 	if volumeContext == nil {
@@ -643,19 +708,25 @@ func (d *Driver) stageNVMeoFVolume(ctx context.Context, volumeContext map[string
 	// Connect to NVMe-oF subsystem with configurable timeout (OTHER-001 fix)
 	transportURI := fmt.Sprintf("%s://%s:%s", transport, address, port)
 	connectOpts := &util.NVMeoFConnectOptions{
-		DeviceTimeout: time.Duration(d.config.NVMeoF.DeviceWaitTimeout) * time.Second,
+		DeviceTimeout: time.Duration(d.GetConfig().NVMeoF.DeviceWaitTimeout) * time.Second,
 	}
-	devicePath, err := util.NVMeoFConnectWithOptions(nqn, transportURI, connectOpts)
+	devicePath, err := util.NVMeoFConnectWithOptions(ctx, d.exec, nqn, transportURI, connectOpts)
 	if err != nil {
 		return status.Errorf(codes.Internal, "failed to connect NVMe-oF: %v", err)
 	}
 
 	// Check if block mode
 	if volCap != nil && volCap.GetBlock() != nil {
-		// For block mode, create a symlink to the device
-		if err := os.Symlink(devicePath, stagingPath); err != nil && !os.IsExist(err) {
-			return status.Errorf(codes.Internal, "failed to create device symlink: %v", err)
-		}
+		return stageBlockDevice(devicePath, stagingPath)
+	}
+
+	// Check if already mounted
+	mounted, err := d.mounter.IsMounted(stagingPath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to check mount status: %v", err)
+	}
+	if mounted {
+		klog.Infof("Device %s already mounted at %s", devicePath, stagingPath)
 		return nil
 	}
 
@@ -665,7 +736,7 @@ func (d *Driver) stageNVMeoFVolume(ctx context.Context, volumeContext map[string
 		fsType = strings.ToLower(volCap.GetMount().GetFsType())
 	}
 
-	if err := util.FormatAndMount(devicePath, stagingPath, fsType, nil); err != nil {
+	if err := d.mounter.FormatAndMount(devicePath, stagingPath, fsType, nil); err != nil {
 		return status.Errorf(codes.Internal, "failed to format and mount: %v", err)
 	}
 
@@ -0,0 +1,251 @@
+package driver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/util"
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/util/fakeexec"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testIQN = "iqn.2005-10.org.freenas.ctl:vol-1"
+	testNQN = "nqn.2011-06.com.truenas:vol-1"
+)
+
+// newFakeNodeDriver returns a node driver whose commands and sysfs are simulated, with one
+// iSCSI target and one NVMe-oF subsystem available to attach.
+func newFakeNodeDriver(t *testing.T, driverName string) (*Driver, *fakeexec.Exec) {
+	t.Helper()
+	fake := fakeexec.New(t.TempDir())
+	fake.AddISCSITarget("10.0.0.1:3260", testIQN, 0, "sdb")
+	fake.AddNVMeSubsystem(testNQN)
+	d := &Driver{
+		config: &Config{
+			DriverName: driverName,
+			ISCSI:      ISCSIConfig{DeviceWaitTimeout: 1},
+			NVMeoF:     NVMeoFConfig{DeviceWaitTimeout: 1},
+		},
+		exec:    fake,
+		mounter: util.NewMounter(fake),
+	}
+	return d, fake
+}
+
+func mountCapability(fsType string) *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: fsType}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+}
+
+func blockCapability() *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+}
+
+// countCommands returns how many commands start with prefix.
+func countCommands(commands []string, prefix string) int {
+	count := 0
+	for _, command := range commands {
+		if strings.HasPrefix(command, prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestNodeVolumeLifecycle(t *testing.T) {
+	iscsiContext := map[string]string{"node_attach_driver": "iscsi", "portal": "10.0.0.1:3260", "iqn": testIQN, "lun": "0"}
+	nvmeContext := map[string]string{"node_attach_driver": "nvmeof", "nqn": testNQN, "address": "10.0.0.1"}
+
+	tests := []struct {
+		name          string
+		driverName    string
+		volumeContext map[string]string
+		capability    *csi.VolumeCapability
+		device        string   // staged mount source, or the published device for block
+		commands      []string // prefixes of commands run exactly once by the whole lifecycle
+	}{
+		{
+			name:          "nfs",
+			driverName:    "org.truenas.csi.nfs",
+			volumeContext: map[string]string{"node_attach_driver": "nfs", "server": "10.0.0.1", "share": "/mnt/tank/k8s/vol-1"},
+			capability:    mountCapability(""),
+			device:        "10.0.0.1:/mnt/tank/k8s/vol-1",
+		},
+		{
+			name:          "iscsi filesystem",
+			driverName:    "org.truenas.csi.iscsi",
+			volumeContext: iscsiContext,
+			capability:    mountCapability("ext4"),
+			device:        "/dev/sdb",
+			commands: []string{
+				"iscsiadm -m node -T " + testIQN + " -p 10.0.0.1:3260 --login",
+				"mkfs.ext4 -F /dev/sdb",
+				"resize2fs /dev/sdb",
+				"iscsiadm -m node -T " + testIQN + " -p 10.0.0.1:3260 --logout",
+			},
+		},
+		{
+			name:          "iscsi block",
+			driverName:    "org.truenas.csi.iscsi",
+			volumeContext: iscsiContext,
+			capability:    blockCapability(),
+			device:        "/dev/sdb",
+			commands: []string{
+				"iscsiadm -m node -T " + testIQN + " -p 10.0.0.1:3260 --login",
+				"iscsiadm -m node -T " + testIQN + " -p 10.0.0.1:3260 --logout",
+			},
+		},
+		{
+			name:          "nvmeof filesystem",
+			driverName:    "org.truenas.csi.nvmeof",
+			volumeContext: nvmeContext,
+			capability:    mountCapability("xfs"),
+			device:        "/dev/nvme0n1",
+			commands: []string{
+				"nvme connect -t tcp -n " + testNQN + " -a 10.0.0.1 -s 4420",
+				"mkfs.xfs -f /dev/nvme0n1",
+				"xfs_growfs",
+				"nvme disconnect -n " + testNQN,
+			},
+		},
+		{
+			name:          "nvmeof block",
+			driverName:    "org.truenas.csi.nvmeof",
+			volumeContext: nvmeContext,
+			capability:    blockCapability(),
+			device:        "/dev/nvme0n1",
+			commands: []string{
+				"nvme connect -t tcp -n " + testNQN + " -a 10.0.0.1 -s 4420",
+				"nvme disconnect -n " + testNQN,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			d, fake := newFakeNodeDriver(t, tt.driverName)
+			dir := t.TempDir()
+			stagingPath := filepath.Join(dir, "staging")
+			targetPath := filepath.Join(dir, "pods", "vol-1", "mount")
+			block := tt.capability.GetBlock() != nil
+
+			// Staging twice attaches and mounts once
+			stage := &csi.NodeStageVolumeRequest{
+				VolumeId:          "vol-1",
+				StagingTargetPath: stagingPath,
+				VolumeCapability:  tt.capability,
+				VolumeContext:     tt.volumeContext,
+			}
+			for i := 0; i < 2; i++ {
+				_, err := d.NodeStageVolume(ctx, stage)
+				if !assert.NoError(t, err) {
+					t.Log(fake.Commands())
+					return
+				}
+			}
+			if block {
+				device, err := os.Readlink(filepath.Join(stagingPath, stagedDeviceLink))
+				assert.NoError(t, err)
+				assert.Equal(t, tt.device, device)
+				assert.Empty(t, fake.Mounts())
+			} else {
+				assert.Equal(t, map[string]string{stagingPath: tt.device}, fake.Mounts())
+			}
+
+			// Block volumes publish the device itself; filesystems bind mount the staging path
+			publish := &csi.NodePublishVolumeRequest{
+				VolumeId:          "vol-1",
+				StagingTargetPath: stagingPath,
+				TargetPath:        targetPath,
+				VolumeCapability:  tt.capability,
+			}
+			_, err := d.NodePublishVolume(ctx, publish)
+			assert.NoError(t, err)
+			_, err = d.NodePublishVolume(ctx, publish)
+			assert.NoError(t, err)
+			if block {
+				assert.Equal(t, tt.device, fake.Mounts()[targetPath])
+			} else {
+				assert.Equal(t, stagingPath, fake.Mounts()[targetPath])
+				_, err = d.NodeExpandVolume(ctx, &csi.NodeExpandVolumeRequest{VolumeId: "vol-1", VolumePath: stagingPath})
+				assert.NoError(t, err)
+			}
+
+			// Tearing down twice detaches once and leaves nothing behind
+			for i := 0; i < 2; i++ {
+				_, err = d.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "vol-1", TargetPath: targetPath})
+				assert.NoError(t, err)
+				_, err = d.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: "vol-1", StagingTargetPath: stagingPath})
+				assert.NoError(t, err)
+			}
+			assert.Empty(t, fake.Mounts())
+			assert.Empty(t, fake.ISCSISessions())
+			assert.Empty(t, fake.NVMeConnections())
+			assert.NoDirExists(t, stagingPath)
+			assert.NoFileExists(t, targetPath)
+			assert.NoFileExists(t, fake.HostPath(filepath.Join(connectionInfoDir, "vol-1.json")))
+
+			commands := fake.Commands()
+			for _, prefix := range tt.commands {
+				assert.Equal(t, 1, countCommands(commands, prefix), "%q: %v", prefix, commands)
+			}
+			assert.LessOrEqual(t, countCommands(commands, "mkfs."), 1)
+		})
+	}
+}
+
+func TestNodeStageVolumeAttachFailure(t *testing.T) {
+	tests := []struct {
+		name          string
+		driverName    string
+		volumeContext map[string]string
+		command       string
+	}{
+		{"nfs", "org.truenas.csi.nfs", map[string]string{"server": "10.0.0.1", "share": "/mnt/tank/k8s/vol-1"}, "mount"},
+		{"iscsi", "org.truenas.csi.iscsi", map[string]string{"portal": "10.0.0.1:3260", "iqn": testIQN}, "iscsiadm"},
+		{"nvmeof", "org.truenas.csi.nvmeof", map[string]string{"nqn": testNQN, "address": "10.0.0.1"}, "nvme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, fake := newFakeNodeDriver(t, tt.driverName)
+			fake.Handle(tt.command, func(ctx context.Context, args []string) ([]byte, error) {
+				return []byte("connection refused"), &fakeexec.ExitError{Code: 1}
+			})
+
+			_, err := d.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+				VolumeId:          "vol-1",
+				StagingTargetPath: filepath.Join(t.TempDir(), "staging"),
+				VolumeCapability:  mountCapability(""),
+				VolumeContext:     tt.volumeContext,
+			})
+			assert.Equal(t, codes.Internal, status.Code(err))
+			assert.Contains(t, err.Error(), "connection refused")
+			assert.Empty(t, fake.Mounts())
+		})
+	}
+}
+
+func TestNodePublishBlockVolumeNotStaged(t *testing.T) {
+	d, _ := newFakeNodeDriver(t, "org.truenas.csi.iscsi")
+	dir := t.TempDir()
+
+	_, err := d.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:          "vol-1",
+		StagingTargetPath: dir,
+		TargetPath:        filepath.Join(dir, "target"),
+		VolumeCapability:  blockCapability(),
+	})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
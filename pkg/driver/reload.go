@@ -0,0 +1,202 @@
+package driver
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// configReloadInterval is how often the config file (and any credential files it
+// references) is checked for changes. Mounted
+// Secrets and ConfigMaps are updated through a symlink swap, so the content is hashed
+// rather than relying on file events.
+const configReloadInterval = 10 * time.Second
+
+// restartOnlyConfig lists config paths that can't be changed without a restart: they name
+// resources that already exist, or configure components that are only set up at startup.
+var restartOnlyConfig = []string{
+	"driver",
+	"instance_id",
+	"zfs.datasetParentName",
+	"zfs.detachedSnapshotsDatasetParentName",
+	"iscsi.nameSuffix",
+	"iscsi.namePrefix",
+	"iscsi.nameTemplate",
+	"nvmeof.namePrefix",
+	"nvmeof.nameSuffix",
+	"nvmeof.nameTemplate",
+	"tracing",
+	"leaderElection",
+	"locks",
+	"http",
+}
+
+// secretConfig lists config paths whose values are never logged.
+var secretConfig = map[string]bool{
+	"truenas.apiKey":   true,
+	"truenas.password": true,
+	"truenas.caBundle": true,
+}
+
+// watchConfig reloads the config file whenever its content changes.
+func (d *Driver) watchConfig(ctx context.Context) {
+	ticker := time.NewTicker(configReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.ReloadConfig(); err != nil {
+				klog.Errorf("Config reload rejected: %v", err)
+			}
+		}
+	}
+}
+
+// ReloadConfig re-reads the config file and applies it if it changed. The new config is
+// validated, restart-only settings must be unchanged, and a TrueNAS connection change must
+// connect successfully before anything is swapped. On error the running config is kept.
+func (d *Driver) ReloadConfig() error {
+	if d.configFile == "" {
+		return nil
+	}
+
+	d.reloadMu.Lock()
+	defer d.reloadMu.Unlock()
+
+	oldCfg := d.GetConfig()
+	hash, err := configSourceHash(d.configFile, oldCfg)
+	if err != nil {
+		return err
+	}
+	if hash == d.configHash {
+		return nil
+	}
+
+	newCfg, err := LoadConfig(d.configFile)
+	if err != nil {
+		// Don't re-report the same invalid file every interval
+		d.configHash = hash
+		return err
+	}
+	if d.configOverrides != nil {
+		d.configOverrides(newCfg)
+	}
+
+	changes := configDiff(oldCfg, newCfg)
+	if len(changes) == 0 {
+		d.configHash = hash
+		return nil
+	}
+
+	var restartOnly []string
+	for _, change := range changes {
+		if isRestartOnlyConfig(change.path) {
+			restartOnly = append(restartOnly, change.path)
+		}
+	}
+	if len(restartOnly) > 0 {
+		d.configHash = hash
+		return fmt.Errorf("changes to %s require a restart", strings.Join(restartOnly, ", "))
+	}
+
+	// Connection changes are applied first; the hash is left unchanged on failure so the
+	// reload is retried (TrueNAS may just be unreachable)
+	if !reflect.DeepEqual(oldCfg.TrueNAS, newCfg.TrueNAS) {
+		if err := d.truenasClient.Reconfigure(driverClientConfig(newCfg, d.runController)); err != nil {
+			return fmt.Errorf("failed to apply TrueNAS connection settings: %w", err)
+		}
+	}
+
+	d.configMu.Lock()
+	d.config = newCfg
+	d.configMu.Unlock()
+	d.configHash = hash
+
+	for _, change := range changes {
+		klog.Infof("Config reloaded: %s", change)
+	}
+	return nil
+}
+
+// configSourceHash hashes the config file together with the credential files it
+// references, so rotating a mounted credential triggers a reload.
+func configSourceHash(path string, cfg *Config) ([sha256.Size]byte, error) {
+	h := sha256.New()
+	for _, f := range append([]string{path}, cfg.credentialFiles()...) {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return [sha256.Size]byte{}, fmt.Errorf("failed to read %s: %w", f, err)
+		}
+		h.Write(data)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// configChange is a single changed config value.
+type configChange struct {
+	path     string
+	old, new interface{}
+}
+
+func (c configChange) String() string {
+	if secretConfig[c.path] {
+		return c.path + " changed"
+	}
+	return fmt.Sprintf("%s: %+v -> %+v", c.path, c.old, c.new)
+}
+
+// configDiff returns the changed leaf values between two configs, keyed by YAML path.
+func configDiff(oldCfg, newCfg *Config) []configChange {
+	var changes []configChange
+	diffValues("", reflect.ValueOf(*oldCfg), reflect.ValueOf(*newCfg), &changes)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].path < changes[j].path })
+	return changes
+}
+
+// diffValues walks struct fields by their YAML names and records differing leaves.
+// Slices and maps are compared as a whole.
+func diffValues(prefix string, oldVal, newVal reflect.Value, changes *[]configChange) {
+	if oldVal.Kind() != reflect.Struct {
+		if !reflect.DeepEqual(oldVal.Interface(), newVal.Interface()) {
+			*changes = append(*changes, configChange{path: prefix, old: oldVal.Interface(), new: newVal.Interface()})
+		}
+		return
+	}
+
+	for i := 0; i < oldVal.NumField(); i++ {
+		field := oldVal.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		diffValues(name, oldVal.Field(i), newVal.Field(i), changes)
+	}
+}
+
+// isRestartOnlyConfig reports whether path is, or is nested under, a restart-only setting.
+func isRestartOnlyConfig(path string) bool {
+	for _, p := range restartOnlyConfig {
+		if path == p || strings.HasPrefix(path, p+".") {
+			return true
+		}
+	}
+	return false
+}
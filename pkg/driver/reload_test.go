@@ -0,0 +1,141 @@
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+	"github.com/stretchr/testify/assert"
+)
+
+const reloadTestConfig = `driver: truenas-nfs
+truenas:
+  host: truenas.local
+  apiKey: 1-original
+zfs:
+  datasetParentName: tank/k8s/volumes
+nfs:
+  shareHost: truenas.local
+  shareAllowedNetworks:
+    - 10.0.0.0/8
+`
+
+// newReloadTestDriver writes the config file and returns a driver watching it.
+func newReloadTestDriver(t *testing.T) (*Driver, *truenas.MockClient, string) {
+	path := filepath.Join(t.TempDir(), "driver.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(reloadTestConfig), 0600))
+	cfg, err := LoadConfig(path)
+	assert.NoError(t, err)
+
+	mockClient := truenas.NewMockClient()
+	d := &Driver{config: cfg, truenasClient: mockClient, configFile: path}
+	return d, mockClient, path
+}
+
+func TestReloadConfig(t *testing.T) {
+	d, mockClient, path := newReloadTestDriver(t)
+	update := func(old, new string) {
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(path, []byte(strings.Replace(string(data), old, new, 1)), 0600))
+	}
+
+	// Test Case 1: Unchanged content is a no-op
+	assert.NoError(t, d.ReloadConfig())
+	assert.NoError(t, d.ReloadConfig())
+
+	// Test Case 2: Share settings are swapped without touching the client
+	update("    - 10.0.0.0/8\n", "    - 10.0.0.0/8\n    - 192.168.0.0/16\n")
+	assert.NoError(t, d.ReloadConfig())
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.0.0/16"}, d.GetConfig().NFS.ShareAllowedNetworks)
+	assert.Nil(t, mockClient.ReconfiguredWith)
+
+	// Test Case 3: Connection changes rebuild the client
+	update("1-original", "1-rotated")
+	assert.NoError(t, d.ReloadConfig())
+	assert.Equal(t, "1-rotated", d.GetConfig().TrueNAS.APIKey)
+	if assert.NotNil(t, mockClient.ReconfiguredWith) {
+		assert.Equal(t, "1-rotated", mockClient.ReconfiguredWith.APIKey)
+	}
+
+	// Test Case 4: Restart-only changes are rejected and the running config is kept
+	update("tank/k8s/volumes", "tank/other")
+	err := d.ReloadConfig()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "zfs.datasetParentName")
+	assert.Equal(t, "tank/k8s/volumes", d.GetConfig().ZFS.DatasetParentName)
+
+	// Test Case 5: Invalid config is rejected
+	update("tank/other", "tank/k8s/volumes")
+	update("  host: truenas.local\n", "")
+	assert.Error(t, d.ReloadConfig())
+	assert.Equal(t, "truenas.local", d.GetConfig().TrueNAS.Host)
+}
+
+func TestReloadConfigClientFailure(t *testing.T) {
+	d, mockClient, path := newReloadTestDriver(t)
+	mockClient.SetInjectError(assert.AnError)
+
+	data := strings.Replace(reloadTestConfig, "1-original", "1-rotated", 1)
+	assert.NoError(t, os.WriteFile(path, []byte(data), 0600))
+	assert.Error(t, d.ReloadConfig())
+	assert.Equal(t, "1-original", d.GetConfig().TrueNAS.APIKey)
+
+	// The same file is retried once TrueNAS accepts the connection
+	mockClient.SetInjectError(nil)
+	assert.NoError(t, d.ReloadConfig())
+	assert.Equal(t, "1-rotated", d.GetConfig().TrueNAS.APIKey)
+}
+
+func TestConfigDiff(t *testing.T) {
+	oldCfg := &Config{
+		TrueNAS: TrueNASConfig{APIKey: "1-old", Port: 443},
+		ISCSI:   ISCSIConfig{TargetGroups: []ISCSITargetGroup{{Portal: 1}}},
+	}
+	newCfg := &Config{
+		TrueNAS: TrueNASConfig{APIKey: "1-new", Port: 8443},
+		ISCSI:   ISCSIConfig{TargetGroups: []ISCSITargetGroup{{Portal: 2}}},
+	}
+
+	var lines []string
+	for _, change := range configDiff(oldCfg, newCfg) {
+		lines = append(lines, change.String())
+	}
+	assert.Equal(t, []string{
+		"iscsi.targetGroups: [{Portal:1 Initiator:0 AuthMethod: Auth:<nil>}] -> [{Portal:2 Initiator:0 AuthMethod: Auth:<nil>}]",
+		"truenas.apiKey changed",
+		"truenas.port: 443 -> 8443",
+	}, lines)
+
+	assert.True(t, isRestartOnlyConfig("leaderElection.enabled"))
+	assert.True(t, isRestartOnlyConfig("zfs.datasetParentName"))
+	assert.False(t, isRestartOnlyConfig("zfs.datasetProperties"))
+}
+
+func TestReloadConfigCredentialFile(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "api-key")
+	assert.NoError(t, os.WriteFile(keyFile, []byte("1-original\n"), 0600))
+	path := filepath.Join(dir, "driver.yaml")
+	data := strings.Replace(reloadTestConfig, "apiKey: 1-original", "apiKeyFile: "+keyFile, 1)
+	assert.NoError(t, os.WriteFile(path, []byte(data), 0600))
+
+	cfg, err := LoadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "1-original", cfg.TrueNAS.APIKey)
+
+	mockClient := truenas.NewMockClient()
+	d := &Driver{config: cfg, truenasClient: mockClient, configFile: path}
+	d.configHash, err = configSourceHash(path, cfg)
+	assert.NoError(t, err)
+
+	// Rotating the mounted key reloads without any change to the config file
+	assert.NoError(t, os.WriteFile(keyFile, []byte("1-rotated\n"), 0600))
+	assert.NoError(t, d.ReloadConfig())
+	assert.Equal(t, "1-rotated", d.GetConfig().TrueNAS.APIKey)
+	if assert.NotNil(t, mockClient.ReconfiguredWith) {
+		assert.Equal(t, "1-rotated", mockClient.ReconfiguredWith.APIKey)
+	}
+}
@@ -0,0 +1,341 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+)
+
+const (
+	// PropReplicationTaskID links a volume to its TrueNAS replication task
+	PropReplicationTaskID = "truenas-csi:replication_task_id"
+	// PropReplicationState is the state of the last replication run
+	PropReplicationState = "truenas-csi:replication_state"
+	// PropReplicationLastRun records when replication last ran (RFC 3339)
+	PropReplicationLastRun = "truenas-csi:replication_last_run"
+	// PropReplicationLastSnapshot is the last snapshot replicated to the secondary
+	PropReplicationLastSnapshot = "truenas-csi:replication_last_snapshot"
+	// PropReplicationError is the error from the last replication run, if it failed
+	PropReplicationError = "truenas-csi:replication_error"
+)
+
+// replicationParam is the StorageClass parameter that enables replication of new volumes.
+const replicationParam = "replication"
+
+// replicationNamingSchema matches the snapshots taken by a policy, see policySnapshotTimeFormat.
+const replicationNamingSchema = "%Y%m%d-%H%M%S"
+
+// shareProperties are the properties holding share IDs, which are only valid on the
+// system that created the shares.
+var shareProperties = []string{
+	PropNFSShareID,
+	PropISCSITargetID,
+	PropISCSIExtentID,
+	PropISCSITargetExtentID,
+	PropNVMeoFSubsystemID,
+	PropNVMeoFNamespaceID,
+}
+
+// replicationProperties are the properties describing a volume's replication.
+var replicationProperties = []string{
+	PropReplicationTaskID,
+	PropReplicationState,
+	PropReplicationLastRun,
+	PropReplicationLastSnapshot,
+	PropReplicationError,
+}
+
+// parseReplication validates the replication StorageClass parameter. Replication sends the
+// snapshots taken by snapshot policies, so at least one must apply to the volume.
+func (c *Config) parseReplication(value string, snapshotPolicies string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, status.Errorf(codes.InvalidArgument, "invalid %s parameter %q", replicationParam, value)
+	}
+	if !enabled {
+		return false, nil
+	}
+	if c.ZFS.Replication.TargetDatasetParentName == "" {
+		return false, status.Errorf(codes.InvalidArgument, "%s requested but zfs.replication is not configured", replicationParam)
+	}
+	if len(selectPolicies(snapshotPolicies, c.ZFS.SnapshotPolicies)) == 0 {
+		return false, status.Errorf(codes.InvalidArgument, "%s requires a snapshot policy, set %s", replicationParam, snapshotPoliciesParam)
+	}
+	return true, nil
+}
+
+// replicationTaskID returns the replication task linked to a volume, or false if there is none.
+func replicationTaskID(ds *truenas.Dataset) (int, bool) {
+	if ds == nil {
+		return 0, false
+	}
+	prop, ok := ds.UserProperties[PropReplicationTaskID]
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.Atoi(prop.Value)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// ensureReplicationTask creates the replication task pushing a volume's policy snapshots to
+// the secondary, unless existing is already linked to one. The task replicates properties, so
+// the replica carries everything failover needs, and keeps the target read-only until then.
+func (d *Driver) ensureReplicationTask(ctx context.Context, datasetName string, existing *truenas.Dataset, snapshotPolicies string) error {
+	if id, ok := replicationTaskID(existing); ok {
+		if _, err := d.truenasClient.ReplicationGet(ctx, id); err == nil {
+			return nil
+		} else if !truenas.IsNotFoundError(err) {
+			return fmt.Errorf("failed to get replication task %d: %w", id, err)
+		}
+		klog.Warningf("Replication task %d of %s no longer exists, creating a new one", id, datasetName)
+	}
+
+	cfg := d.GetConfig()
+	var schemas []string
+	for _, p := range selectPolicies(snapshotPolicies, cfg.ZFS.SnapshotPolicies) {
+		schemas = append(schemas, p.Name+"-"+replicationNamingSchema)
+	}
+	cron := strings.Fields(cfg.ZFS.Replication.Schedule)
+
+	task, err := d.truenasClient.ReplicationCreate(ctx, &truenas.ReplicationCreateParams{
+		Name:                    "truenas-csi " + datasetName,
+		Direction:               "PUSH",
+		Transport:               "SSH",
+		SSHCredentials:          cfg.ZFS.Replication.SSHCredentials,
+		SourceDatasets:          []string{datasetName},
+		TargetDataset:           path.Join(cfg.ZFS.Replication.TargetDatasetParentName, path.Base(datasetName)),
+		Properties:              true,
+		AlsoIncludeNamingSchema: schemas,
+		Auto:                    true,
+		Schedule:                &truenas.ReplicationSchedule{Minute: cron[0], Hour: cron[1], Dom: cron[2], Month: cron[3], Dow: cron[4]},
+		RetentionPolicy:         "SOURCE",
+		Readonly:                "SET",
+		Enabled:                 true,
+	})
+	if err != nil {
+		return err
+	}
+	klog.Infof("Created replication task %d for %s", task.ID, datasetName)
+
+	if err := d.truenasClient.DatasetSetUserProperty(ctx, datasetName, PropReplicationTaskID, strconv.Itoa(task.ID)); err != nil {
+		// Don't leave a task behind that nothing refers to
+		if delErr := d.truenasClient.ReplicationDelete(ctx, task.ID); delErr != nil {
+			klog.Warningf("Failed to clean up replication task %d: %v", task.ID, delErr)
+		}
+		return fmt.Errorf("failed to set replication task property: %w", err)
+	}
+	return nil
+}
+
+// deleteReplicationTask deletes the replication task of a volume. The replica on the
+// secondary is kept.
+func (d *Driver) deleteReplicationTask(ctx context.Context, ds *truenas.Dataset) error {
+	id, ok := replicationTaskID(ds)
+	if !ok {
+		return nil
+	}
+	if err := d.truenasClient.ReplicationDelete(ctx, id); err != nil {
+		return err
+	}
+	klog.Infof("Deleted replication task %d of %s", id, ds.Name)
+	return nil
+}
+
+// runReplicationMonitor copies the state of replication tasks onto their volumes every
+// status interval while this replica is the leader.
+func (d *Driver) runReplicationMonitor(ctx context.Context) {
+	for {
+		// Re-read each time so reloaded settings apply
+		interval := time.Duration(d.GetConfig().ZFS.Replication.StatusInterval) * time.Second
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		cfg := d.GetConfig()
+		if cfg.ZFS.Replication.TargetDatasetParentName == "" || d.confirmLeader(ctx) != nil {
+			continue
+		}
+		updated, err := updateReplicationStatus(ctx, d.truenasClient, cfg.ZFS.DatasetParentName)
+		if err != nil {
+			klog.Warningf("Failed to update replication status: %v", err)
+		}
+		if updated > 0 {
+			klog.V(4).Infof("Updated replication status of %d volumes", updated)
+		}
+	}
+}
+
+// updateReplicationStatus records the last run of each replicated volume's task in its user
+// properties and returns how many volumes changed, along with the errors of the rest.
+func updateReplicationStatus(ctx context.Context, client truenas.ClientInterface, parent string) (int, error) {
+	volumes, err := listVolumes(ctx, client, parent)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list volumes: %w", err)
+	}
+
+	updated := 0
+	var errs []error
+	for _, ds := range volumes {
+		id, ok := replicationTaskID(ds)
+		if !ok {
+			continue
+		}
+		task, err := client.ReplicationGet(ctx, id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get replication task %d of %s: %w", id, ds.Name, err))
+			continue
+		}
+
+		want := map[string]string{
+			PropReplicationState:        task.State.State,
+			PropReplicationLastSnapshot: task.State.LastSnapshot,
+			PropReplicationError:        task.State.Error,
+		}
+		if !task.State.Datetime.IsZero() {
+			want[PropReplicationLastRun] = task.State.Datetime.UTC().Format(time.RFC3339)
+		}
+
+		var updates []truenas.UserPropertyUpdate
+		for key, value := range want {
+			prop, ok := ds.UserProperties[key]
+			switch {
+			case value == "" && ok:
+				updates = append(updates, truenas.UserPropertyUpdate{Key: key, Remove: true})
+			case value != "" && (!ok || prop.Value != value):
+				updates = append(updates, truenas.UserPropertyUpdate{Key: key, Value: value})
+			}
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		if _, err := client.DatasetUpdate(ctx, ds.Name, &truenas.DatasetUpdateParams{UserPropertiesUpdate: updates}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to record replication status of %s: %w", ds.Name, err))
+			continue
+		}
+		updated++
+	}
+	return updated, errors.Join(errs...)
+}
+
+// FailoverVolumes connects to the secondary TrueNAS with cfg, whose zfs.datasetParentName is
+// the primary's zfs.replication.targetDatasetParentName, and makes the replicated volumes
+// there usable. An empty volumeIDs fails over every volume. Volumes keep their IDs, so only
+// the volume context of their PVs changes. A volume that fails is reported and skipped.
+func FailoverVolumes(ctx context.Context, cfg *Config, volumeIDs []string) ([]*RecoveredVolume, error) {
+	client, err := truenas.NewClient(newClientConfig(&cfg.TrueNAS))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to TrueNAS: %w", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	d := &Driver{name: cfg.DriverName, config: cfg, truenasClient: client}
+	return d.failoverVolumes(ctx, volumeIDs)
+}
+
+// failoverVolumes fails over the replicated volumes under the parent dataset.
+func (d *Driver) failoverVolumes(ctx context.Context, volumeIDs []string) ([]*RecoveredVolume, error) {
+	parent := d.GetConfig().ZFS.DatasetParentName
+
+	var volumes []*truenas.Dataset
+	var errs []error
+	if len(volumeIDs) > 0 {
+		for _, volumeID := range volumeIDs {
+			ds, err := d.truenasClient.DatasetGet(ctx, path.Join(parent, volumeID))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to get volume %s: %w", volumeID, err))
+				continue
+			}
+			volumes = append(volumes, ds)
+		}
+	} else {
+		datasets, err := listVolumes(ctx, d.truenasClient, parent)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list volumes: %w", err)
+		}
+		for _, ds := range datasets {
+			if isAdopted(ds) {
+				volumes = append(volumes, ds)
+			}
+		}
+	}
+
+	var recovered []*RecoveredVolume
+	for _, ds := range volumes {
+		volume, err := d.failoverVolume(ctx, ds)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to fail over %s: %w", ds.Name, err))
+			continue
+		}
+		recovered = append(recovered, volume)
+	}
+	return recovered, errors.Join(errs...)
+}
+
+// failoverVolume makes a replica writable, drops the share IDs and replication details
+// copied from the primary, and shares it. It is safe to run again on the same volume.
+func (d *Driver) failoverVolume(ctx context.Context, ds *truenas.Dataset) (*RecoveredVolume, error) {
+	shareType := d.GetConfig().shareTypeForDataset(ds)
+
+	// The replication task ID marks properties still copied from the primary; it is removed
+	// below, so share IDs set by an earlier failover are kept
+	stale := replicationProperties
+	if _, ok := replicationTaskID(ds); ok {
+		stale = append(append([]string{}, shareProperties...), replicationProperties...)
+	}
+	var updates []truenas.UserPropertyUpdate
+	for _, key := range stale {
+		if _, ok := ds.UserProperties[key]; ok {
+			updates = append(updates, truenas.UserPropertyUpdate{Key: key, Remove: true})
+		}
+	}
+	if !isAdopted(ds) {
+		updates = append(updates, truenas.UserPropertyUpdate{Key: PropManagedResource, Value: "true"})
+	}
+	// Share the updated dataset, so IDs copied from the primary are not trusted
+	ds, err := d.truenasClient.DatasetUpdate(ctx, ds.Name, &truenas.DatasetUpdateParams{
+		Readonly:             "OFF",
+		UserPropertiesUpdate: updates,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update dataset: %w", err)
+	}
+
+	volumeName := path.Base(ds.Name)
+	if prop, ok := ds.UserProperties[PropCSIVolumeName]; ok && prop.Value != "" {
+		volumeName = prop.Value
+	}
+	if err := d.ensureShareExists(ctx, ds, ds.Name, volumeName, shareType); err != nil {
+		return nil, err
+	}
+	volumeContext, err := d.getVolumeContext(ctx, ds, shareType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get volume context: %w", err)
+	}
+
+	return &RecoveredVolume{
+		VolumeID:      path.Base(ds.Name),
+		VolumeName:    volumeName,
+		DatasetName:   ds.Name,
+		CapacityBytes: d.getDatasetCapacity(ds),
+		ShareType:     shareType,
+		VolumeContext: volumeContext,
+	}, nil
+}
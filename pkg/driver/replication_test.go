@@ -0,0 +1,146 @@
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReplication(t *testing.T) {
+	ctx := context.Background()
+	mockClient := truenas.NewMockClient()
+	d := &Driver{
+		config: &Config{
+			DriverName: "truenas-nfs",
+			ZFS: ZFSConfig{
+				DatasetParentName: "pool/parent",
+				SnapshotPolicies:  []SnapshotPolicy{{Name: "hourly", Schedule: "hourly", Keep: 24}},
+				Replication: ReplicationConfig{
+					SSHCredentials:          3,
+					TargetDatasetParentName: "backup/parent",
+					Schedule:                "*/15 * * * *",
+				},
+			},
+			NFS: NFSConfig{ShareHost: "1.2.3.4"},
+		},
+		truenasClient: mockClient,
+	}
+
+	// Test Case 1: Replication needs a snapshot policy to send
+	_, err := d.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "vol-0", Parameters: map[string]string{"replication": "true"}, VolumeCapabilities: volumeCapabilities})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = d.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "vol-0", Parameters: map[string]string{"replication": "maybe"}, VolumeCapabilities: volumeCapabilities})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Test Case 2: A replication task is created and linked to the volume
+	params := map[string]string{"replication": "true", "snapshotPolicies": "hourly"}
+	_, err = d.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "vol-1", Parameters: params, VolumeCapabilities: volumeCapabilities})
+	assert.NoError(t, err)
+	if assert.Len(t, mockClient.Replications, 1) {
+		task := mockClient.Replications[1]
+		assert.Equal(t, []string{"pool/parent/vol-1"}, task.SourceDatasets)
+		assert.Equal(t, "backup/parent/vol-1", task.TargetDataset)
+	}
+	assert.Equal(t, "1", mockClient.Datasets["pool/parent/vol-1"].UserProperties[PropReplicationTaskID].Value)
+
+	// Retries don't create another task
+	_, err = d.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "vol-1", Parameters: params, VolumeCapabilities: volumeCapabilities})
+	assert.NoError(t, err)
+	assert.Len(t, mockClient.Replications, 1)
+
+	// Test Case 3: Status of the last run is recorded on the volume
+	mockClient.Replications[1].State = truenas.ReplicationState{
+		State:        "ERROR",
+		Error:        "connection refused",
+		Datetime:     time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		LastSnapshot: "pool/parent/vol-1@hourly-20240501-110000",
+	}
+	updated, err := updateReplicationStatus(ctx, mockClient, "pool/parent")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, updated)
+	props := mockClient.Datasets["pool/parent/vol-1"].UserProperties
+	assert.Equal(t, "ERROR", props[PropReplicationState].Value)
+	assert.Equal(t, "connection refused", props[PropReplicationError].Value)
+	assert.Equal(t, "2024-05-01T12:00:00Z", props[PropReplicationLastRun].Value)
+	assert.Equal(t, "pool/parent/vol-1@hourly-20240501-110000", props[PropReplicationLastSnapshot].Value)
+
+	mockClient.Replications[1].State.State = "FINISHED"
+	mockClient.Replications[1].State.Error = ""
+	updated, err = updateReplicationStatus(ctx, mockClient, "pool/parent")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, updated)
+	assert.NotContains(t, mockClient.Datasets["pool/parent/vol-1"].UserProperties, PropReplicationError)
+
+	updated, err = updateReplicationStatus(ctx, mockClient, "pool/parent")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, updated)
+
+	// Test Case 4: Deleting the volume deletes its task
+	_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "vol-1"})
+	assert.NoError(t, err)
+	assert.Empty(t, mockClient.Replications)
+}
+
+func TestFailoverVolumes(t *testing.T) {
+	ctx := context.Background()
+	secondary := truenas.NewMockClient()
+	d := &Driver{
+		config: &Config{
+			DriverName: "truenas-nfs",
+			ZFS:        ZFSConfig{DatasetParentName: "backup/parent"},
+			NFS:        NFSConfig{ShareHost: "5.6.7.8"},
+		},
+		truenasClient: secondary,
+	}
+
+	// A replica carries the properties set on the primary, including its share IDs
+	secondary.NFSShares[1] = &truenas.NFSShare{ID: 1, Path: "/mnt/backup/unrelated"}
+	secondary.Datasets["backup/parent/vol-1"] = &truenas.Dataset{
+		ID:         "backup/parent/vol-1",
+		Name:       "backup/parent/vol-1",
+		Type:       "FILESYSTEM",
+		Mountpoint: "/mnt/backup/parent/vol-1",
+		UserProperties: map[string]truenas.UserProperty{
+			PropManagedResource:   {Value: "true"},
+			PropCSIVolumeName:     {Value: "pvc-1234"},
+			PropNFSShareID:        {Value: "1"},
+			PropReplicationTaskID: {Value: "1"},
+			PropReplicationState:  {Value: "FINISHED"},
+		},
+	}
+	secondary.Datasets["backup/parent/other"] = &truenas.Dataset{
+		ID:             "backup/parent/other",
+		Name:           "backup/parent/other",
+		Type:           "FILESYSTEM",
+		UserProperties: map[string]truenas.UserProperty{},
+	}
+
+	recovered, err := d.failoverVolumes(ctx, nil)
+	assert.NoError(t, err)
+	if assert.Len(t, recovered, 1) {
+		assert.Equal(t, "vol-1", recovered[0].VolumeID)
+		assert.Equal(t, "pvc-1234", recovered[0].VolumeName)
+		assert.Equal(t, "5.6.7.8", recovered[0].VolumeContext["server"])
+		assert.Equal(t, "/mnt/backup/parent/vol-1", recovered[0].VolumeContext["share"])
+	}
+	props := secondary.Datasets["backup/parent/vol-1"].UserProperties
+	assert.NotContains(t, props, PropReplicationTaskID)
+	assert.NotContains(t, props, PropReplicationState)
+	assert.NotEqual(t, "1", props[PropNFSShareID].Value)
+	assert.Len(t, secondary.NFSShares, 2)
+
+	// Failing over again is a no-op
+	recovered, err = d.failoverVolumes(ctx, []string{"vol-1"})
+	assert.NoError(t, err)
+	assert.Len(t, recovered, 1)
+	assert.Len(t, secondary.NFSShares, 2)
+
+	_, err = d.failoverVolumes(ctx, []string{"missing"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,89 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas/faketruenas"
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/util"
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/util/fakeexec"
+	"github.com/kubernetes-csi/csi-test/v5/pkg/sanity"
+	"github.com/stretchr/testify/assert"
+)
+
+const sanityTestConfig = `driver: org.truenas.csi.nfs
+truenas:
+  host: %s
+  port: %d
+  protocol: http
+  apiKey: 1-sanity
+zfs:
+  datasetParentName: tank/k8s
+  datasetEnableQuotas: true
+nfs:
+  shareHost: 10.0.0.1
+locks:
+  disablePersistence: true
+`
+
+// TestSanity runs the csi-test sanity suite against the NFS driver served on a unix socket,
+// backed by a fake TrueNAS and a fake node executor. Ginkgo suites can only run once
+// per process, so the block drivers share the controller code paths covered here and by
+// TestVolumeLifecycleAgainstFakeTrueNAS.
+func TestSanity(t *testing.T) {
+	server := faketruenas.New(faketruenas.Config{APIKey: "1-sanity"})
+	defer server.Close()
+
+	tmp := t.TempDir()
+
+	configFile := filepath.Join(tmp, "driver.yaml")
+	assert.NoError(t, os.WriteFile(configFile, []byte(fmt.Sprintf(sanityTestConfig, server.Host(), server.Port())), 0600))
+	cfg, err := LoadConfig(configFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	socket := filepath.Join(tmp, "csi.sock")
+	d, err := NewDriver(&DriverConfig{
+		Name:          cfg.DriverName,
+		Version:       "sanity",
+		NodeID:        "sanity-node",
+		Endpoint:      "unix://" + socket,
+		RunController: true,
+		RunNode:       true,
+		Config:        cfg,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	fake := fakeexec.New(filepath.Join(tmp, "host"))
+	d.exec = fake
+	d.mounter = util.NewMounter(fake)
+	_, err = d.truenasClient.DatasetCreate(context.Background(), &truenas.DatasetCreateParams{Name: "tank/k8s"})
+	assert.NoError(t, err)
+
+	go func() {
+		if err := d.Run(); err != nil {
+			t.Errorf("driver stopped: %v", err)
+		}
+	}()
+	defer d.Stop()
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(socket)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	config := sanity.NewTestConfig()
+	config.Address = socket
+	config.TargetPath = filepath.Join(tmp, "target")
+	config.StagingPath = filepath.Join(tmp, "staging")
+	config.TestVolumeSize = 1 << 30
+	config.TestVolumeExpandSize = 2 << 30
+	config.IdempotentCount = 2
+	sanity.Test(t, config)
+}
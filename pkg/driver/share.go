@@ -2,6 +2,7 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"path"
 	"strconv"
@@ -16,14 +17,17 @@ import (
 const (
 	// defaultShareRetryAttempts is the number of times to retry share creation
 	defaultShareRetryAttempts = 3
-	// defaultShareRetryDelay is the initial delay between retry attempts
-	defaultShareRetryDelay = 2 * time.Second
 	// zvolReadyTimeout is how long to wait for a zvol to be ready before creating extent
 	zvolReadyTimeout = 30 * time.Second
 )
 
+// defaultShareRetryDelay is the initial delay between retry attempts (a variable for tests).
+var defaultShareRetryDelay = 2 * time.Second
+
 // ensureShareExists checks if a share exists for the dataset and creates it if missing.
 // This is critical for idempotency when a volume was created but share creation failed.
+// ds is the dataset as read earlier in the request; its stored share IDs are reused
+// rather than read again.
 func (d *Driver) ensureShareExists(ctx context.Context, ds *truenas.Dataset, datasetName string, volumeName string, shareType string) error {
 	// Always call the create function, which is idempotent and will verify if the
 	// share actually exists (handling cases where property is set but share is missing).
@@ -31,70 +35,110 @@ func (d *Driver) ensureShareExists(ctx context.Context, ds *truenas.Dataset, dat
 
 	switch shareType {
 	case "nfs":
-		return d.createNFSShare(ctx, datasetName, volumeName)
+		return d.createNFSShare(ctx, ds, volumeName)
 	case "iscsi":
-		return d.createISCSIShare(ctx, datasetName, volumeName)
+		return d.createISCSIShare(ctx, ds, volumeName)
 	case "nvmeof":
-		return d.createNVMeoFShare(ctx, datasetName, volumeName)
+		return d.createNVMeoFShare(ctx, ds, volumeName)
 	default:
 		return nil
 	}
 }
 
+// setUserProperties stores properties on a dataset in one update and records them in
+// ds, the request's snapshot of it, so later steps see them without reading it again.
+func (d *Driver) setUserProperties(ctx context.Context, ds *truenas.Dataset, properties map[string]string) error {
+	if err := d.truenasClient.DatasetSetUserProperties(ctx, ds.Name, properties); err != nil {
+		return err
+	}
+	updated := make(map[string]truenas.UserProperty, len(ds.UserProperties)+len(properties))
+	for key, prop := range ds.UserProperties {
+		updated[key] = prop
+	}
+	for key, value := range properties {
+		updated[key] = truenas.UserProperty{Value: value, Source: "LOCAL"}
+	}
+	ds.UserProperties = updated
+	return nil
+}
+
+// storedID returns the TrueNAS object ID a dataset property holds, if any. ds is the
+// dataset as read once per request, and may be nil if that read failed.
+func storedID(ds *truenas.Dataset, key string) (int, bool) {
+	if ds == nil {
+		return 0, false
+	}
+	prop, ok := ds.UserProperties[key]
+	if !ok || prop.Value == "" || prop.Value == "-" {
+		return 0, false
+	}
+	id, err := strconv.Atoi(prop.Value)
+	return id, err == nil
+}
+
+// shareTypeForDataset returns the share type of an existing volume from its dataset type.
+// Filesystems are shared over NFS; zvols over iSCSI unless this is the NVMe-oF driver.
+func (c *Config) shareTypeForDataset(ds *truenas.Dataset) string {
+	switch ds.Type {
+	case "FILESYSTEM":
+		return "nfs"
+	case "VOLUME":
+		if c.GetDriverShareType() == "nvmeof" {
+			return "nvmeof"
+		}
+		return "iscsi"
+	default:
+		return c.GetDriverShareType()
+	}
+}
+
 // createShare creates the appropriate share type (NFS, iSCSI, or NVMe-oF) for a dataset.
 // shareType should be obtained from config.GetShareType(params) to support StorageClass parameters.
-func (d *Driver) createShare(ctx context.Context, datasetName string, volumeName string, shareType string) error {
-	klog.Infof("Creating %s share for dataset: %s", shareType, datasetName)
+func (d *Driver) createShare(ctx context.Context, ds *truenas.Dataset, volumeName string, shareType string) error {
+	klog.Infof("Creating %s share for dataset: %s", shareType, ds.Name)
 
 	switch shareType {
 	case "nfs":
-		return d.createNFSShare(ctx, datasetName, volumeName)
+		return d.createNFSShare(ctx, ds, volumeName)
 	case "iscsi":
-		return d.createISCSIShare(ctx, datasetName, volumeName)
+		return d.createISCSIShare(ctx, ds, volumeName)
 	case "nvmeof":
-		return d.createNVMeoFShare(ctx, datasetName, volumeName)
+		return d.createNVMeoFShare(ctx, ds, volumeName)
 	default:
 		return status.Errorf(codes.InvalidArgument, "unsupported share type: %s", shareType)
 	}
 }
 
-// deleteShare deletes the share for a dataset.
+// deleteShare deletes the share for a dataset, using the share IDs stored on ds. ds may
+// be nil if the dataset couldn't be read, leaving only the lookups by name.
 // shareType should be obtained from config.GetShareType(params) or stored metadata.
-func (d *Driver) deleteShare(ctx context.Context, datasetName string, shareType string) error {
+func (d *Driver) deleteShare(ctx context.Context, ds *truenas.Dataset, datasetName string, shareType string) error {
 	klog.Infof("Deleting %s share for dataset: %s", shareType, datasetName)
 
 	switch shareType {
 	case "nfs":
-		return d.deleteNFSShare(ctx, datasetName)
+		return d.deleteNFSShare(ctx, ds)
 	case "iscsi":
-		return d.deleteISCSIShare(ctx, datasetName)
+		return d.deleteISCSIShare(ctx, ds, datasetName)
 	case "nvmeof":
-		return d.deleteNVMeoFShare(ctx, datasetName)
+		return d.deleteNVMeoFShare(ctx, ds, datasetName)
 	default:
 		return nil
 	}
 }
 
 // createNFSShare creates an NFS share for a dataset.
-func (d *Driver) createNFSShare(ctx context.Context, datasetName string, volumeName string) error {
-	// Get dataset to find mountpoint
-	ds, err := d.truenasClient.DatasetGet(ctx, datasetName)
-	if err != nil {
-		return status.Errorf(codes.Internal, "failed to get dataset: %v", err)
-	}
+func (d *Driver) createNFSShare(ctx context.Context, ds *truenas.Dataset, volumeName string) error {
+	datasetName := ds.Name
 
 	// Check if share already exists (idempotency)
-	existingProp, _ := d.truenasClient.DatasetGetUserProperty(ctx, datasetName, PropNFSShareID)
-	if existingProp != "" && existingProp != "-" {
-		shareID, err := strconv.Atoi(existingProp)
-		if err == nil {
-			// Verify it actually exists
-			if _, err := d.truenasClient.NFSShareGet(ctx, shareID); err == nil {
-				klog.Infof("NFS share already exists for %s (ID %d)", datasetName, shareID)
-				return nil
-			}
-			klog.Warningf("Stored NFS share ID %d not found, recreating...", shareID)
+	if shareID, ok := storedID(ds, PropNFSShareID); ok {
+		// Verify it actually exists
+		if _, err := d.truenasClient.NFSShareGet(ctx, shareID); err == nil {
+			klog.Infof("NFS share already exists for %s (ID %d)", datasetName, shareID)
+			return nil
 		}
+		klog.Warningf("Stored NFS share ID %d not found, recreating...", shareID)
 	}
 
 	// Create NFS share
@@ -103,23 +147,23 @@ func (d *Driver) createNFSShare(ctx context.Context, datasetName string, volumeN
 	params := &truenas.NFSShareCreateParams{
 		Path:         ds.Mountpoint,
 		Comment:      comment,
-		Networks:     d.config.NFS.ShareAllowedNetworks,
-		Hosts:        d.config.NFS.ShareAllowedHosts,
+		Networks:     d.GetConfig().NFS.ShareAllowedNetworks,
+		Hosts:        d.GetConfig().NFS.ShareAllowedHosts,
 		Ro:           false,
-		MaprootUser:  d.config.NFS.ShareMaprootUser,
-		MaprootGroup: d.config.NFS.ShareMaprootGroup,
-		MapallUser:   d.config.NFS.ShareMapallUser,
-		MapallGroup:  d.config.NFS.ShareMapallGroup,
+		MaprootUser:  d.GetConfig().NFS.ShareMaprootUser,
+		MaprootGroup: d.GetConfig().NFS.ShareMaprootGroup,
+		MapallUser:   d.GetConfig().NFS.ShareMapallUser,
+		MapallGroup:  d.GetConfig().NFS.ShareMapallGroup,
 	}
 
 	share, err := d.truenasClient.NFSShareCreate(ctx, params)
 	if err != nil {
-		return status.Errorf(codes.Internal, "failed to create NFS share: %v", err)
+		return status.Errorf(truenasCode(err), "failed to create NFS share: %v", err)
 	}
 
 	// Store share ID in dataset property
-	if err := d.truenasClient.DatasetSetUserProperty(ctx, datasetName, PropNFSShareID, strconv.Itoa(share.ID)); err != nil {
-		return status.Errorf(codes.Internal, "failed to store NFS share ID: %v", err)
+	if err := d.setUserProperties(ctx, ds, map[string]string{PropNFSShareID: strconv.Itoa(share.ID)}); err != nil {
+		return status.Errorf(truenasCode(err), "failed to store NFS share ID: %v", err)
 	}
 
 	klog.Infof("Created NFS share ID %d for %s", share.ID, datasetName)
@@ -127,20 +171,15 @@ func (d *Driver) createNFSShare(ctx context.Context, datasetName string, volumeN
 }
 
 // deleteNFSShare deletes the NFS share for a dataset.
-func (d *Driver) deleteNFSShare(ctx context.Context, datasetName string) error {
+func (d *Driver) deleteNFSShare(ctx context.Context, ds *truenas.Dataset) error {
 	// Get share ID from dataset property
-	shareIDStr, err := d.truenasClient.DatasetGetUserProperty(ctx, datasetName, PropNFSShareID)
-	if err != nil || shareIDStr == "" || shareIDStr == "-" {
+	shareID, ok := storedID(ds, PropNFSShareID)
+	if !ok {
 		return nil // No share to delete
 	}
 
-	shareID, err := strconv.Atoi(shareIDStr)
-	if err != nil {
-		return nil
-	}
-
 	if err := d.truenasClient.NFSShareDelete(ctx, shareID); err != nil {
-		klog.Warningf("Failed to delete NFS share %d: %v", shareID, err)
+		return fmt.Errorf("failed to delete NFS share %d: %w", shareID, err)
 	}
 
 	klog.Infof("Deleted NFS share ID %d", shareID)
@@ -150,41 +189,36 @@ func (d *Driver) deleteNFSShare(ctx context.Context, datasetName string) error {
 // createISCSIShare creates iSCSI target, extent, and target-extent association.
 // This function is idempotent and includes retry logic for robustness during
 // high-load scenarios (e.g., volsync backup bursts).
-func (d *Driver) createISCSIShare(ctx context.Context, datasetName string, volumeName string) error {
+func (d *Driver) createISCSIShare(ctx context.Context, ds *truenas.Dataset, volumeName string) error {
+	datasetName := ds.Name
 	start := time.Now()
 	klog.Infof("createISCSIShare: starting for dataset %s", datasetName)
 
 	// Generate iSCSI name and disk path upfront
 	iscsiName := path.Base(datasetName)
-	if d.config.ISCSI.NameSuffix != "" {
-		iscsiName = iscsiName + d.config.ISCSI.NameSuffix
+	if d.GetConfig().ISCSI.NameSuffix != "" {
+		iscsiName = iscsiName + d.GetConfig().ISCSI.NameSuffix
 	}
 	diskPath := fmt.Sprintf("zvol/%s", datasetName)
 
 	// Step 1: Check if already fully configured (idempotency fast-path)
 	// We must verify not just the property, but that the resources actually exist.
-	existingTE, _ := d.truenasClient.DatasetGetUserProperty(ctx, datasetName, PropISCSITargetExtentID)
-	existingTgt, _ := d.truenasClient.DatasetGetUserProperty(ctx, datasetName, PropISCSITargetID)
-	existingExt, _ := d.truenasClient.DatasetGetUserProperty(ctx, datasetName, PropISCSIExtentID)
-
-	if existingTE != "" && existingTE != "-" && existingTgt != "" && existingTgt != "-" && existingExt != "" && existingExt != "-" {
-		teID, errTE := strconv.Atoi(existingTE)
-		tgtID, errTgt := strconv.Atoi(existingTgt)
-		extID, errExt := strconv.Atoi(existingExt)
-
-		if errTE == nil && errTgt == nil && errExt == nil {
-			// Verify everything exists
-			// Check target
-			if _, err := d.truenasClient.ISCSITargetGet(ctx, tgtID); err == nil {
-				// Check extent
-				if _, err := d.truenasClient.ISCSIExtentGet(ctx, extID); err == nil {
-					// Check association
-					if assoc, err := d.truenasClient.ISCSITargetExtentFind(ctx, tgtID, extID); err == nil && assoc != nil {
-						// Double check association ID matches
-						if assoc.ID == teID {
-							klog.Infof("iSCSI share already fully configured for %s (target=%d, extent=%d, assoc=%d)", datasetName, tgtID, extID, teID)
-							return nil
-						}
+	teID, okTE := storedID(ds, PropISCSITargetExtentID)
+	tgtID, okTgt := storedID(ds, PropISCSITargetID)
+	extID, okExt := storedID(ds, PropISCSIExtentID)
+
+	if okTE && okTgt && okExt {
+		// Verify everything exists
+		// Check target
+		if _, err := d.truenasClient.ISCSITargetGet(ctx, tgtID); err == nil {
+			// Check extent
+			if _, err := d.truenasClient.ISCSIExtentGet(ctx, extID); err == nil {
+				// Check association
+				if assoc, err := d.truenasClient.ISCSITargetExtentFind(ctx, tgtID, extID); err == nil && assoc != nil {
+					// Double check association ID matches
+					if assoc.ID == teID {
+						klog.Infof("iSCSI share already fully configured for %s (target=%d, extent=%d, assoc=%d)", datasetName, tgtID, extID, teID)
+						return nil
 					}
 				}
 			}
@@ -197,14 +231,11 @@ func (d *Driver) createISCSIShare(ctx context.Context, datasetName string, volum
 	var targetID int
 
 	// Check if we have a stored target ID
-	existingTargetID, _ := d.truenasClient.DatasetGetUserProperty(ctx, datasetName, PropISCSITargetID)
-	if existingTargetID != "" && existingTargetID != "-" {
-		if id, err := strconv.Atoi(existingTargetID); err == nil {
-			if t, err := d.truenasClient.ISCSITargetGet(ctx, id); err == nil {
-				target = t
-				targetID = t.ID
-				klog.V(4).Infof("Using existing target ID %d for %s", targetID, datasetName)
-			}
+	if id, ok := storedID(ds, PropISCSITargetID); ok {
+		if t, err := d.truenasClient.ISCSITargetGet(ctx, id); err == nil {
+			target = t
+			targetID = t.ID
+			klog.V(4).Infof("Using existing target ID %d for %s", targetID, datasetName)
 		}
 	}
 
@@ -220,7 +251,7 @@ func (d *Driver) createISCSIShare(ctx context.Context, datasetName string, volum
 	// Create target if needed
 	if target == nil {
 		targetGroups := []truenas.ISCSITargetGroup{}
-		for _, tg := range d.config.ISCSI.TargetGroups {
+		for _, tg := range d.GetConfig().ISCSI.TargetGroups {
 			var auth *int
 			if tg.Auth != nil && *tg.Auth > 0 {
 				auth = tg.Auth
@@ -236,22 +267,20 @@ func (d *Driver) createISCSIShare(ctx context.Context, datasetName string, volum
 		var err error
 		target, err = d.truenasClient.ISCSITargetCreate(ctx, iscsiName, "", "ISCSI", targetGroups)
 		if err != nil {
-			return status.Errorf(codes.Internal, "failed to create iSCSI target: %v", err)
+			return status.Errorf(truenasCode(err), "failed to create iSCSI target: %v", err)
 		}
 		targetID = target.ID
 		klog.Infof("Created iSCSI target %s (ID %d)", iscsiName, targetID)
 	}
 
-	// Store target ID
-	if err := d.truenasClient.DatasetSetUserProperty(ctx, datasetName, PropISCSITargetID, strconv.Itoa(targetID)); err != nil {
-		klog.Warningf("Failed to store iSCSI target ID: %v", err)
-	}
-
-	// Step 3: Wait for zvol to be ready before creating extent
+	// Step 3: Wait for zvol to be ready before creating extent, unless the dataset as
+	// read for this request already has its size.
 	// This is critical for cloned volumes which may not be immediately available
-	klog.V(4).Infof("Waiting for zvol %s to be ready before creating extent", datasetName)
-	if _, err := d.truenasClient.WaitForZvolReady(ctx, datasetName, zvolReadyTimeout); err != nil {
-		klog.Warningf("Zvol readiness check failed (will attempt extent creation anyway): %v", err)
+	if volsize, ok := ds.Volsize.Parsed.(float64); !ok || volsize <= 0 {
+		klog.V(4).Infof("Waiting for zvol %s to be ready before creating extent", datasetName)
+		if _, err := d.truenasClient.WaitForZvolReady(ctx, datasetName, zvolReadyTimeout); err != nil {
+			klog.Warningf("Zvol readiness check failed (will attempt extent creation anyway): %v", err)
+		}
 	}
 
 	// Step 4: Find or create extent with retry (idempotent)
@@ -259,14 +288,11 @@ func (d *Driver) createISCSIShare(ctx context.Context, datasetName string, volum
 	var extentID int
 
 	// Check if we have a stored extent ID
-	existingExtentID, _ := d.truenasClient.DatasetGetUserProperty(ctx, datasetName, PropISCSIExtentID)
-	if existingExtentID != "" && existingExtentID != "-" {
-		if id, err := strconv.Atoi(existingExtentID); err == nil {
-			if e, err := d.truenasClient.ISCSIExtentGet(ctx, id); err == nil {
-				extent = e
-				extentID = e.ID
-				klog.V(4).Infof("Using existing extent ID %d for %s", extentID, datasetName)
-			}
+	if id, ok := storedID(ds, PropISCSIExtentID); ok {
+		if e, err := d.truenasClient.ISCSIExtentGet(ctx, id); err == nil {
+			extent = e
+			extentID = e.ID
+			klog.V(4).Infof("Using existing extent ID %d for %s", extentID, datasetName)
 		}
 	}
 
@@ -310,8 +336,8 @@ func (d *Driver) createISCSIShare(ctx context.Context, datasetName string, volum
 				iscsiName,
 				diskPath,
 				comment,
-				d.config.ISCSI.ExtentBlocksize,
-				d.config.ISCSI.ExtentRpm,
+				d.GetConfig().ISCSI.ExtentBlocksize,
+				d.GetConfig().ISCSI.ExtentRpm,
 			)
 			if err == nil {
 				extentID = extent.ID
@@ -335,15 +361,10 @@ func (d *Driver) createISCSIShare(ctx context.Context, datasetName string, volum
 			if delErr := d.truenasClient.ISCSITargetDelete(ctx, targetID, true); delErr != nil {
 				klog.Warningf("Failed to cleanup iSCSI target after extent creation failure: %v", delErr)
 			}
-			return status.Errorf(codes.Internal, "failed to create iSCSI extent after %d attempts: %v", defaultShareRetryAttempts, lastErr)
+			return status.Errorf(truenasCode(lastErr), "failed to create iSCSI extent after %d attempts: %v", defaultShareRetryAttempts, lastErr)
 		}
 	}
 
-	// Store extent ID
-	if err := d.truenasClient.DatasetSetUserProperty(ctx, datasetName, PropISCSIExtentID, strconv.Itoa(extentID)); err != nil {
-		klog.Warningf("Failed to store iSCSI extent ID: %v", err)
-	}
-
 	// Step 5: Find or create target-extent association (idempotent)
 	var targetExtent *truenas.ISCSITargetExtent
 
@@ -360,14 +381,19 @@ func (d *Driver) createISCSIShare(ctx context.Context, datasetName string, volum
 		if err != nil {
 			// Don't cleanup target/extent as they may be reusable
 			klog.Errorf("Failed to create target-extent association: %v", err)
-			return status.Errorf(codes.Internal, "failed to create target-extent association: %v", err)
+			return status.Errorf(truenasCode(err), "failed to create target-extent association: %v", err)
 		}
 		klog.Infof("Created target-extent association (ID %d)", targetExtent.ID)
 	}
 
-	// Store target-extent ID
-	if err := d.truenasClient.DatasetSetUserProperty(ctx, datasetName, PropISCSITargetExtentID, strconv.Itoa(targetExtent.ID)); err != nil {
-		klog.Warningf("Failed to store iSCSI target-extent ID: %v", err)
+	// Store the IDs in one update. Without them deletion falls back to finding the
+	// target by name and the extent by disk path.
+	if err := d.setUserProperties(ctx, ds, map[string]string{
+		PropISCSITargetID:       strconv.Itoa(targetID),
+		PropISCSIExtentID:       strconv.Itoa(extentID),
+		PropISCSITargetExtentID: strconv.Itoa(targetExtent.ID),
+	}); err != nil {
+		klog.Warningf("Failed to store iSCSI share IDs: %v", err)
 	}
 
 	// Reload iSCSI service to ensure the new target is immediately discoverable.
@@ -388,51 +414,49 @@ func (d *Driver) createISCSIShare(ctx context.Context, datasetName string, volum
 // deleteISCSIShare deletes iSCSI resources for a dataset.
 // It tries to delete by stored property IDs first, then falls back to lookup by name
 // to handle cases where properties were never stored (e.g., failed volume creation).
-func (d *Driver) deleteISCSIShare(ctx context.Context, datasetName string) error {
+func (d *Driver) deleteISCSIShare(ctx context.Context, ds *truenas.Dataset, datasetName string) error {
 	// Generate the expected iSCSI name (same logic as createISCSIShare)
 	iscsiName := path.Base(datasetName)
-	if d.config.ISCSI.NameSuffix != "" {
-		iscsiName = iscsiName + d.config.ISCSI.NameSuffix
+	if d.GetConfig().ISCSI.NameSuffix != "" {
+		iscsiName = iscsiName + d.GetConfig().ISCSI.NameSuffix
 	}
 	diskPath := fmt.Sprintf("zvol/%s", datasetName)
 
 	var extDeleted, tgtDeleted bool
 
 	// Try to delete target-extent association by stored ID
-	if teIDStr, _ := d.truenasClient.DatasetGetUserProperty(ctx, datasetName, PropISCSITargetExtentID); teIDStr != "" && teIDStr != "-" {
-		if teID, err := strconv.Atoi(teIDStr); err == nil {
-			if err := d.truenasClient.ISCSITargetExtentDelete(ctx, teID, true); err != nil {
-				klog.Warningf("Failed to delete iSCSI target-extent %d: %v", teID, err)
-			}
+	if teID, ok := storedID(ds, PropISCSITargetExtentID); ok {
+		if err := d.truenasClient.ISCSITargetExtentDelete(ctx, teID, true); err != nil {
+			klog.Warningf("Failed to delete iSCSI target-extent %d: %v", teID, err)
 		}
 	}
 
 	// Try to delete extent by stored ID
-	if extIDStr, _ := d.truenasClient.DatasetGetUserProperty(ctx, datasetName, PropISCSIExtentID); extIDStr != "" && extIDStr != "-" {
-		if extID, err := strconv.Atoi(extIDStr); err == nil {
-			if err := d.truenasClient.ISCSIExtentDelete(ctx, extID, false, true); err != nil {
-				klog.Warningf("Failed to delete iSCSI extent %d: %v", extID, err)
-			} else {
-				extDeleted = true
-			}
+	if extID, ok := storedID(ds, PropISCSIExtentID); ok {
+		if err := d.truenasClient.ISCSIExtentDelete(ctx, extID, false, true); err != nil {
+			klog.Warningf("Failed to delete iSCSI extent %d: %v", extID, err)
+		} else {
+			extDeleted = true
 		}
 	}
 
 	// Try to delete target by stored ID
-	if tgtIDStr, _ := d.truenasClient.DatasetGetUserProperty(ctx, datasetName, PropISCSITargetID); tgtIDStr != "" && tgtIDStr != "-" {
-		if tgtID, err := strconv.Atoi(tgtIDStr); err == nil {
-			if err := d.truenasClient.ISCSITargetDelete(ctx, tgtID, true); err != nil {
-				klog.Warningf("Failed to delete iSCSI target %d: %v", tgtID, err)
-			} else {
-				tgtDeleted = true
-			}
+	if tgtID, ok := storedID(ds, PropISCSITargetID); ok {
+		if err := d.truenasClient.ISCSITargetDelete(ctx, tgtID, true); err != nil {
+			klog.Warningf("Failed to delete iSCSI target %d: %v", tgtID, err)
+		} else {
+			tgtDeleted = true
 		}
 	}
 
 	// Fallback: If extent was not deleted by ID, try to find and delete by disk path
-	// This handles cases where the dataset properties were never stored
+	// This handles cases where the dataset properties were never stored. Failures here
+	// are returned so the dataset isn't destroyed under a live share (BUG-014).
+	var errs []error
 	if !extDeleted {
-		if extent, err := d.truenasClient.ISCSIExtentFindByDisk(ctx, diskPath); err == nil && extent != nil {
+		if extent, err := d.truenasClient.ISCSIExtentFindByDisk(ctx, diskPath); err != nil {
+			errs = append(errs, fmt.Errorf("failed to look up extent for %s: %w", diskPath, err))
+		} else if extent != nil {
 			klog.V(4).Infof("Found orphaned extent by disk path %s (ID %d), deleting", diskPath, extent.ID)
 			// First delete any target-extent associations for this extent
 			if assocs, err := d.truenasClient.ISCSITargetExtentFindByExtent(ctx, extent.ID); err == nil {
@@ -443,7 +467,7 @@ func (d *Driver) deleteISCSIShare(ctx context.Context, datasetName string) error
 				}
 			}
 			if err := d.truenasClient.ISCSIExtentDelete(ctx, extent.ID, false, true); err != nil {
-				klog.Warningf("Failed to delete orphaned extent %d: %v", extent.ID, err)
+				errs = append(errs, fmt.Errorf("failed to delete extent %d: %w", extent.ID, err))
 			}
 		}
 	}
@@ -451,7 +475,9 @@ func (d *Driver) deleteISCSIShare(ctx context.Context, datasetName string) error
 	// Fallback: If target was not deleted by ID, try to find and delete by name
 	// This handles cases where target was created but property was never stored
 	if !tgtDeleted {
-		if target, err := d.truenasClient.ISCSITargetFindByName(ctx, iscsiName); err == nil && target != nil {
+		if target, err := d.truenasClient.ISCSITargetFindByName(ctx, iscsiName); err != nil {
+			errs = append(errs, fmt.Errorf("failed to look up target %s: %w", iscsiName, err))
+		} else if target != nil {
 			klog.V(4).Infof("Found orphaned target by name %s (ID %d), deleting", iscsiName, target.ID)
 			// First delete any target-extent associations for this target
 			if assocs, err := d.truenasClient.ISCSITargetExtentFindByTarget(ctx, target.ID); err == nil {
@@ -462,38 +488,39 @@ func (d *Driver) deleteISCSIShare(ctx context.Context, datasetName string) error
 				}
 			}
 			if err := d.truenasClient.ISCSITargetDelete(ctx, target.ID, true); err != nil {
-				klog.Warningf("Failed to delete orphaned target %d: %v", target.ID, err)
+				errs = append(errs, fmt.Errorf("failed to delete target %d: %w", target.ID, err))
 			}
 		}
 	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
 
 	klog.Infof("Deleted iSCSI resources for %s", datasetName)
 	return nil
 }
 
 // createNVMeoFShare creates NVMe-oF subsystem and namespace.
-func (d *Driver) createNVMeoFShare(ctx context.Context, datasetName string, volumeName string) error {
+func (d *Driver) createNVMeoFShare(ctx context.Context, ds *truenas.Dataset, volumeName string) error {
+	datasetName := ds.Name
+
 	// Check if already configured (idempotency)
-	existingProp, _ := d.truenasClient.DatasetGetUserProperty(ctx, datasetName, PropNVMeoFNamespaceID)
-	if existingProp != "" && existingProp != "-" {
-		nsID, err := strconv.Atoi(existingProp)
-		if err == nil {
-			// Verify it actually exists
-			if _, err := d.truenasClient.NVMeoFNamespaceGet(ctx, nsID); err == nil {
-				klog.Infof("NVMe-oF share already exists for %s (namespace %d)", datasetName, nsID)
-				return nil
-			}
-			klog.Warningf("Stored NVMe-oF namespace ID %d not found, recreating...", nsID)
+	if nsID, ok := storedID(ds, PropNVMeoFNamespaceID); ok {
+		// Verify it actually exists
+		if _, err := d.truenasClient.NVMeoFNamespaceGet(ctx, nsID); err == nil {
+			klog.Infof("NVMe-oF share already exists for %s (namespace %d)", datasetName, nsID)
+			return nil
 		}
+		klog.Warningf("Stored NVMe-oF namespace ID %d not found, recreating...", nsID)
 	}
 
 	// Generate NVMe-oF NQN
 	nqn := path.Base(datasetName)
-	if d.config.NVMeoF.NamePrefix != "" {
-		nqn = d.config.NVMeoF.NamePrefix + nqn
+	if d.GetConfig().NVMeoF.NamePrefix != "" {
+		nqn = d.GetConfig().NVMeoF.NamePrefix + nqn
 	}
-	if d.config.NVMeoF.NameSuffix != "" {
-		nqn = nqn + d.config.NVMeoF.NameSuffix
+	if d.GetConfig().NVMeoF.NameSuffix != "" {
+		nqn = nqn + d.GetConfig().NVMeoF.NameSuffix
 	}
 
 	// Generate serial (max 20 chars)
@@ -507,16 +534,12 @@ func (d *Driver) createNVMeoFShare(ctx context.Context, datasetName string, volu
 		ctx,
 		nqn,
 		serial,
-		d.config.NVMeoF.SubsystemAllowAnyHost,
-		d.config.NVMeoF.SubsystemHosts,
+		d.GetConfig().NVMeoF.SubsystemAllowAnyHost,
+		d.GetConfig().NVMeoF.SubsystemHosts,
 	)
 	if err != nil {
-		return status.Errorf(codes.Internal, "failed to create NVMe-oF subsystem: %v", err)
+		return status.Errorf(truenasCode(err), "failed to create NVMe-oF subsystem: %v", err)
 	}
-	if err := d.truenasClient.DatasetSetUserProperty(ctx, datasetName, PropNVMeoFSubsystemID, strconv.Itoa(subsys.ID)); err != nil {
-		return status.Errorf(codes.Internal, "failed to store NVMe-oF subsystem ID: %v", err)
-	}
-
 	// Create namespace
 	devicePath := fmt.Sprintf("/dev/zvol/%s", datasetName)
 	namespace, err := d.truenasClient.NVMeoFNamespaceCreate(ctx, subsys.ID, devicePath)
@@ -524,10 +547,13 @@ func (d *Driver) createNVMeoFShare(ctx context.Context, datasetName string, volu
 		if delErr := d.truenasClient.NVMeoFSubsystemDelete(ctx, subsys.ID); delErr != nil {
 			klog.Warningf("Failed to cleanup NVMe-oF subsystem: %v", delErr)
 		}
-		return status.Errorf(codes.Internal, "failed to create NVMe-oF namespace: %v", err)
+		return status.Errorf(truenasCode(err), "failed to create NVMe-oF namespace: %v", err)
 	}
-	if err := d.truenasClient.DatasetSetUserProperty(ctx, datasetName, PropNVMeoFNamespaceID, strconv.Itoa(namespace.ID)); err != nil {
-		return status.Errorf(codes.Internal, "failed to store NVMe-oF namespace ID: %v", err)
+	if err := d.setUserProperties(ctx, ds, map[string]string{
+		PropNVMeoFSubsystemID: strconv.Itoa(subsys.ID),
+		PropNVMeoFNamespaceID: strconv.Itoa(namespace.ID),
+	}); err != nil {
+		return status.Errorf(truenasCode(err), "failed to store NVMe-oF share IDs: %v", err)
 	}
 
 	klog.Infof("Created NVMe-oF subsystem=%d, namespace=%d for %s", subsys.ID, namespace.ID, datasetName)
@@ -535,24 +561,25 @@ func (d *Driver) createNVMeoFShare(ctx context.Context, datasetName string, volu
 }
 
 // deleteNVMeoFShare deletes NVMe-oF resources for a dataset.
-func (d *Driver) deleteNVMeoFShare(ctx context.Context, datasetName string) error {
+func (d *Driver) deleteNVMeoFShare(ctx context.Context, ds *truenas.Dataset, datasetName string) error {
+	var errs []error
+
 	// Delete namespace
-	if nsIDStr, _ := d.truenasClient.DatasetGetUserProperty(ctx, datasetName, PropNVMeoFNamespaceID); nsIDStr != "" && nsIDStr != "-" {
-		if nsID, err := strconv.Atoi(nsIDStr); err == nil {
-			if err := d.truenasClient.NVMeoFNamespaceDelete(ctx, nsID); err != nil {
-				klog.Warningf("Failed to delete NVMe-oF namespace %d: %v", nsID, err)
-			}
+	if nsID, ok := storedID(ds, PropNVMeoFNamespaceID); ok {
+		if err := d.truenasClient.NVMeoFNamespaceDelete(ctx, nsID); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete NVMe-oF namespace %d: %w", nsID, err))
 		}
 	}
 
 	// Delete subsystem
-	if ssIDStr, _ := d.truenasClient.DatasetGetUserProperty(ctx, datasetName, PropNVMeoFSubsystemID); ssIDStr != "" && ssIDStr != "-" {
-		if ssID, err := strconv.Atoi(ssIDStr); err == nil {
-			if err := d.truenasClient.NVMeoFSubsystemDelete(ctx, ssID); err != nil {
-				klog.Warningf("Failed to delete NVMe-oF subsystem %d: %v", ssID, err)
-			}
+	if ssID, ok := storedID(ds, PropNVMeoFSubsystemID); ok {
+		if err := d.truenasClient.NVMeoFSubsystemDelete(ctx, ssID); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete NVMe-oF subsystem %d: %w", ssID, err))
 		}
 	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
 
 	klog.Infof("Deleted NVMe-oF resources for %s", datasetName)
 	return nil
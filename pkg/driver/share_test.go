@@ -0,0 +1,116 @@
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newMockISCSIDriver returns an iSCSI driver backed by a MockClient.
+func newMockISCSIDriver() (*Driver, *truenas.MockClient) {
+	mockClient := truenas.NewMockClient()
+	_, _ = mockClient.DatasetCreate(context.Background(), &truenas.DatasetCreateParams{Name: "pool/parent"})
+	d := &Driver{
+		config: &Config{
+			DriverName: "org.truenas.csi.iscsi",
+			ZFS:        ZFSConfig{DatasetParentName: "pool/parent", ZvolBlocksize: "16K"},
+			ISCSI: ISCSIConfig{
+				TargetPortal:    "10.0.0.1:3260",
+				TargetGroups:    []ISCSITargetGroup{{Portal: 1, Initiator: 1, AuthMethod: "NONE"}},
+				ExtentBlocksize: 4096,
+				ExtentRpm:       "SSD",
+			},
+		},
+		truenasClient: mockClient,
+	}
+	return d, mockClient
+}
+
+func TestCreateVolumeRetriesISCSIExtent(t *testing.T) {
+	ctx := context.Background()
+	defer func(delay time.Duration) { defaultShareRetryDelay = delay }(defaultShareRetryDelay)
+	defaultShareRetryDelay = time.Millisecond
+	req := &csi.CreateVolumeRequest{
+		Name:               "vol-1",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+		VolumeCapabilities: volumeCapabilities,
+	}
+	busy := &truenas.APIError{Code: -32001, Message: "Method call error"}
+
+	// Extent creation fails twice, then succeeds within the same request
+	d, mockClient := newMockISCSIDriver()
+	mockClient.FailNext("ISCSIExtentCreate", busy, busy)
+	_, err := d.CreateVolume(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, mockClient.CallCount("ISCSIExtentCreate"))
+	assert.Len(t, mockClient.ISCSITargets, 1)
+	assert.Len(t, mockClient.ISCSIExtents, 1)
+	assert.Len(t, mockClient.TargetExtents, 1)
+
+	// Running out of attempts removes the target it created
+	d, mockClient = newMockISCSIDriver()
+	mockClient.FailAlways("ISCSIExtentCreate", busy)
+	_, err = d.CreateVolume(ctx, req)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, defaultShareRetryAttempts, mockClient.CallCount("ISCSIExtentCreate"))
+	assert.Empty(t, mockClient.ISCSITargets)
+}
+
+// BUG-014: a share that can't be deleted keeps its dataset, so the target isn't leaked.
+func TestDeleteVolumeKeepsDatasetWhenShareDeleteFails(t *testing.T) {
+	ctx := context.Background()
+	d, mockClient := newMockISCSIDriver()
+	_, err := d.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "vol-1",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+		VolumeCapabilities: volumeCapabilities,
+	})
+	assert.NoError(t, err)
+
+	// Both the delete by stored ID and the fallback by name fail
+	busy := &truenas.APIError{Code: -32001, Message: "Method call error"}
+	mockClient.FailNext("ISCSITargetDelete", busy, busy)
+	_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "vol-1"})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Contains(t, mockClient.Datasets, "pool/parent/vol-1")
+	assert.Len(t, mockClient.ISCSITargets, 1)
+	assert.Zero(t, mockClient.CallCount("DatasetDelete"))
+
+	// The retry cleans up, shares before the dataset
+	mockClient.ResetCalls()
+	_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "vol-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ISCSIExtentDelete", "ISCSITargetDelete", "DatasetDelete"},
+		mockClient.CallNames("ISCSIExtentDelete", "ISCSITargetDelete", "DatasetDelete"))
+	assert.NotContains(t, mockClient.Datasets, "pool/parent/vol-1")
+	assert.Empty(t, mockClient.ISCSITargets)
+	assert.Empty(t, mockClient.ISCSIExtents)
+}
+
+// BUG-015: resources created before a failed CreateVolume stored their IDs are found by
+// name and disk path and deleted with the volume.
+func TestDeleteVolumeCleansUpOrphanedISCSIResources(t *testing.T) {
+	ctx := context.Background()
+	d, mockClient := newMockISCSIDriver()
+	_, err := mockClient.DatasetCreate(ctx, &truenas.DatasetCreateParams{Name: "pool/parent/vol-1", Type: "VOLUME", Volsize: 1 << 30})
+	assert.NoError(t, err)
+	target, err := mockClient.ISCSITargetCreate(ctx, "vol-1", "", "ISCSI", nil)
+	assert.NoError(t, err)
+	extent, err := mockClient.ISCSIExtentCreate(ctx, "vol-1", "zvol/pool/parent/vol-1", "", 4096, "SSD")
+	assert.NoError(t, err)
+	_, err = mockClient.ISCSITargetExtentCreate(ctx, target.ID, extent.ID, 0)
+	assert.NoError(t, err)
+
+	_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "vol-1"})
+	assert.NoError(t, err)
+	assert.Empty(t, mockClient.ISCSITargets)
+	assert.Empty(t, mockClient.ISCSIExtents)
+	assert.Empty(t, mockClient.TargetExtents)
+	assert.NotContains(t, mockClient.Datasets, "pool/parent/vol-1")
+}
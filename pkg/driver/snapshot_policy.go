@@ -0,0 +1,245 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+)
+
+const (
+	// PropSnapshotPolicies lists the snapshot policies applied to a volume (comma-separated)
+	PropSnapshotPolicies = "truenas-csi:snapshot_policies"
+	// PropSnapshotPolicy names the policy that took a snapshot
+	PropSnapshotPolicy = "truenas-csi:snapshot_policy"
+)
+
+// snapshotPoliciesParam is the StorageClass parameter naming the policies for new volumes.
+const snapshotPoliciesParam = "snapshotPolicies"
+
+// snapshotSchedulerInterval is how often volumes are checked for due snapshots.
+const snapshotSchedulerInterval = time.Minute
+
+// policySnapshotTimeFormat is the UTC time in policy snapshot names, e.g. "hourly-20240501-130000".
+const policySnapshotTimeFormat = "20060102-150405"
+
+// snapshotSchedules maps schedule names to their period. Snapshots are due once per period,
+// aligned to UTC hours, midnights and Mondays.
+var snapshotSchedules = map[string]time.Duration{
+	"hourly": time.Hour,
+	"daily":  24 * time.Hour,
+	"weekly": 7 * 24 * time.Hour,
+}
+
+// parseSnapshotPolicies validates the snapshotPolicies StorageClass parameter against the
+// configured policies and returns it normalized.
+func parseSnapshotPolicies(value string, policies []SnapshotPolicy) (string, error) {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, p := range policies {
+			found = found || p.Name == name
+		}
+		if !found {
+			return "", status.Errorf(codes.InvalidArgument, "unknown snapshot policy %q in %s", name, snapshotPoliciesParam)
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ","), nil
+}
+
+// policiesFor returns the policies that apply to a volume.
+func policiesFor(ds *truenas.Dataset, policies []SnapshotPolicy) []SnapshotPolicy {
+	value := ""
+	if prop, ok := ds.UserProperties[PropSnapshotPolicies]; ok && prop.Value != "-" {
+		value = prop.Value
+	}
+	return selectPolicies(value, policies)
+}
+
+// selectPolicies returns the policies that apply to a volume whose snapshot policies
+// property is value.
+func selectPolicies(value string, policies []SnapshotPolicy) []SnapshotPolicy {
+	var named []string
+	if value != "" {
+		named = strings.Split(value, ",")
+	}
+
+	var result []SnapshotPolicy
+	for _, p := range policies {
+		if p.AllVolumes || containsString(named, p.Name) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// containsString returns true if values contains s.
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// policySnapshot is a snapshot taken by a policy.
+type policySnapshot struct {
+	snap  *truenas.Snapshot
+	taken time.Time
+}
+
+// policySnapshots returns the snapshots taken by policy, newest first. Only snapshots
+// tagged with the policy's name are included, so a snapshot someone else named like a
+// policy snapshot is never pruned. Snapshots created through CSI are never included.
+func policySnapshots(snapshots []*truenas.Snapshot, policy string) []policySnapshot {
+	var result []policySnapshot
+	for _, snap := range snapshots {
+		if _, ok := snap.UserProperties[PropCSISnapshotName]; ok {
+			continue
+		}
+		if prop, ok := snap.UserProperties[PropSnapshotPolicy]; !ok || prop.Value != policy {
+			continue
+		}
+		name, ok := extractSnapshotName(snap.ID)
+		if !ok || !strings.HasPrefix(name, policy+"-") {
+			continue
+		}
+		taken, err := time.Parse(policySnapshotTimeFormat, strings.TrimPrefix(name, policy+"-"))
+		if err != nil {
+			continue
+		}
+		result = append(result, policySnapshot{snap: snap, taken: taken})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].taken.After(result[j].taken) })
+	return result
+}
+
+// isPolicySnapshot reports whether a snapshot was taken by a snapshot policy.
+func isPolicySnapshot(snap *truenas.Snapshot) bool {
+	prop, ok := snap.UserProperties[PropSnapshotPolicy]
+	return ok && prop.Value != "" && prop.Value != "-"
+}
+
+// snapshotPolicyResult lists the snapshots created and pruned by one pass of the scheduler.
+type snapshotPolicyResult struct {
+	Created []string
+	Pruned  []string
+}
+
+// runSnapshotScheduler applies the snapshot policies every snapshotSchedulerInterval while
+// this replica is the leader.
+func (d *Driver) runSnapshotScheduler(ctx context.Context) {
+	ticker := time.NewTicker(snapshotSchedulerInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cfg := d.GetConfig()
+		if len(cfg.ZFS.SnapshotPolicies) == 0 || d.confirmLeader(ctx) != nil {
+			continue
+		}
+		result, err := applySnapshotPolicies(ctx, d.truenasClient, cfg.ZFS.DatasetParentName, cfg.ZFS.SnapshotPolicies, time.Now())
+		if err != nil {
+			klog.Warningf("Failed to apply snapshot policies: %v", err)
+		}
+		if len(result.Created) > 0 || len(result.Pruned) > 0 {
+			klog.Infof("Snapshot policies created %d and pruned %d snapshots", len(result.Created), len(result.Pruned))
+		}
+	}
+}
+
+// applySnapshotPolicies takes a snapshot of each volume under parent for every policy
+// that is due, and prunes policy snapshots beyond the policy's keep count. Snapshots that
+// volumes were cloned from are kept. The errors of all volumes are joined in the result.
+func applySnapshotPolicies(ctx context.Context, client truenas.ClientInterface, parent string, policies []SnapshotPolicy, now time.Time) (*snapshotPolicyResult, error) {
+	result := &snapshotPolicyResult{}
+	var errs []error
+
+	volumes, err := listVolumes(ctx, client, parent)
+	if err != nil {
+		return result, fmt.Errorf("failed to list volumes: %w", err)
+	}
+
+	for _, ds := range volumes {
+		if !isAdopted(ds) {
+			continue
+		}
+		applied := policiesFor(ds, policies)
+		if len(applied) == 0 {
+			continue
+		}
+		snapshots, err := client.SnapshotList(ctx, ds.Name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to list snapshots of %s: %w", ds.Name, err))
+			continue
+		}
+		for _, policy := range applied {
+			if err := applySnapshotPolicy(ctx, client, ds.Name, snapshots, policy, now, result); err != nil {
+				errs = append(errs, fmt.Errorf("snapshot policy %s on %s: %w", policy.Name, ds.Name, err))
+			}
+		}
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// applySnapshotPolicy applies one policy to one volume.
+func applySnapshotPolicy(ctx context.Context, client truenas.ClientInterface, datasetName string,
+	snapshots []*truenas.Snapshot, policy SnapshotPolicy, now time.Time, result *snapshotPolicyResult) error {
+	taken := policySnapshots(snapshots, policy.Name)
+
+	periodStart := now.UTC().Truncate(snapshotSchedules[policy.Schedule])
+	if len(taken) == 0 || taken[0].taken.Before(periodStart) {
+		name := policy.Name + "-" + now.UTC().Format(policySnapshotTimeFormat)
+		snap, err := client.SnapshotCreate(ctx, datasetName, name)
+		if err != nil {
+			return fmt.Errorf("failed to create snapshot: %w", err)
+		}
+		if err := client.SnapshotSetUserProperty(ctx, snap.ID, PropSnapshotPolicy, policy.Name); err != nil {
+			// Untagged, it would never be pruned; the next pass takes it again
+			if delErr := client.SnapshotDelete(ctx, snap.ID, false, false); delErr != nil {
+				klog.Warningf("Failed to remove untagged policy snapshot %s: %v", snap.ID, delErr)
+			}
+			return fmt.Errorf("failed to tag snapshot %s: %w", snap.ID, err)
+		}
+		result.Created = append(result.Created, snap.ID)
+		taken = append([]policySnapshot{{snap: snap, taken: now.UTC()}}, taken...)
+	}
+
+	if len(taken) <= policy.Keep {
+		return nil
+	}
+	var errs []error
+	for _, ps := range taken[policy.Keep:] {
+		// Volumes restored from the snapshot depend on it
+		if clones := ps.snap.GetClones(); len(clones) > 0 {
+			klog.V(4).Infof("Keeping snapshot %s, it has clones %v", ps.snap.ID, clones)
+			continue
+		}
+		if err := client.SnapshotDelete(ctx, ps.snap.ID, false, false); err != nil {
+			errs = append(errs, fmt.Errorf("failed to prune snapshot %s: %w", ps.snap.ID, err))
+			continue
+		}
+		result.Pruned = append(result.Pruned, ps.snap.ID)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,146 @@
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestApplySnapshotPolicies(t *testing.T) {
+	ctx := context.Background()
+	mockClient := truenas.NewMockClient()
+	policies := []SnapshotPolicy{
+		{Name: "hourly", Schedule: "hourly", Keep: 2},
+		{Name: "daily", Schedule: "daily", Keep: 1, AllVolumes: true},
+	}
+	d := &Driver{
+		config: &Config{
+			DriverName: "truenas-nfs",
+			ZFS:        ZFSConfig{DatasetParentName: "pool/parent", SnapshotPolicies: policies},
+			NFS:        NFSConfig{ShareHost: "1.2.3.4"},
+		},
+		truenasClient: mockClient,
+	}
+
+	_, err := d.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "vol-1", Parameters: map[string]string{"snapshotPolicies": "hourly"}, VolumeCapabilities: volumeCapabilities})
+	assert.NoError(t, err)
+	_, err = d.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "vol-2", VolumeCapabilities: volumeCapabilities})
+	assert.NoError(t, err)
+	assert.Equal(t, "hourly", mockClient.Datasets["pool/parent/vol-1"].UserProperties[PropSnapshotPolicies].Value)
+
+	// Unknown policies are rejected
+	_, err = d.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "vol-3", Parameters: map[string]string{"snapshotPolicies": "yearly"}, VolumeCapabilities: volumeCapabilities})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// A CSI snapshot that must never be pruned
+	_, err = d.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{Name: "csi-snap", SourceVolumeId: "vol-1"})
+	assert.NoError(t, err)
+
+	// Test Case 1: Both policies are due for vol-1, only the daily one for vol-2
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	result, err := applySnapshotPolicies(ctx, mockClient, "pool/parent", policies, now)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"pool/parent/vol-1@hourly-20240501-123000",
+		"pool/parent/vol-1@daily-20240501-123000",
+		"pool/parent/vol-2@daily-20240501-123000",
+	}, result.Created)
+	assert.Equal(t, "hourly", mockClient.Snapshots["pool/parent/vol-1@hourly-20240501-123000"].UserProperties[PropSnapshotPolicy].Value)
+
+	// Test Case 2: Nothing is due again within the same period
+	result, err = applySnapshotPolicies(ctx, mockClient, "pool/parent", policies, now.Add(20*time.Minute))
+	assert.NoError(t, err)
+	assert.Empty(t, result.Created)
+
+	// Test Case 3: Old snapshots beyond keep are pruned, except clone sources
+	result, err = applySnapshotPolicies(ctx, mockClient, "pool/parent", policies, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pool/parent/vol-1@hourly-20240501-133000"}, result.Created)
+	assert.Empty(t, result.Pruned)
+
+	mockClient.Snapshots["pool/parent/vol-1@hourly-20240501-123000"].Properties = map[string]interface{}{
+		"clones": map[string]interface{}{"value": "pool/parent/restored"},
+	}
+	result, err = applySnapshotPolicies(ctx, mockClient, "pool/parent", policies, now.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Empty(t, result.Pruned)
+	assert.Contains(t, mockClient.Snapshots, "pool/parent/vol-1@hourly-20240501-123000")
+
+	result, err = applySnapshotPolicies(ctx, mockClient, "pool/parent", policies, now.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"pool/parent/vol-1@hourly-20240501-133000",
+		"pool/parent/vol-1@daily-20240501-123000",
+		"pool/parent/vol-2@daily-20240501-123000",
+	}, result.Pruned)
+	assert.Contains(t, mockClient.Snapshots, "pool/parent/vol-1@csi-snap")
+
+	// Test Case 4: Snapshots named like policy snapshots but not tagged are never pruned
+	_, err = mockClient.SnapshotCreate(ctx, "pool/parent/vol-2", "daily-20240101-000000")
+	assert.NoError(t, err)
+	result, err = applySnapshotPolicies(ctx, mockClient, "pool/parent", policies, now.Add(24*time.Hour+time.Minute))
+	assert.NoError(t, err)
+	assert.Empty(t, result.Pruned)
+	assert.Contains(t, mockClient.Snapshots, "pool/parent/vol-2@daily-20240101-000000")
+
+	// Test Case 5: Policy snapshots are listed read-only with volume-qualified IDs
+	snapshots, err := d.ListSnapshots(ctx, &csi.ListSnapshotsRequest{SourceVolumeId: "vol-2"})
+	assert.NoError(t, err)
+	if assert.Len(t, snapshots.Entries, 1) {
+		assert.Equal(t, "vol-2@daily-20240502-123000", snapshots.Entries[0].Snapshot.SnapshotId)
+	}
+	_, err = d.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: "vol-2@daily-20240502-123000"})
+	assert.NoError(t, err)
+	assert.Contains(t, mockClient.Snapshots, "pool/parent/vol-2@daily-20240502-123000")
+}
+
+func TestPolicySnapshots(t *testing.T) {
+	tagged := func(policy string) map[string]truenas.UserProperty {
+		return map[string]truenas.UserProperty{PropSnapshotPolicy: {Value: policy}}
+	}
+	snapshots := []*truenas.Snapshot{
+		{ID: "pool/parent/vol@hourly-20240501-120000", UserProperties: tagged("hourly")},
+		{ID: "pool/parent/vol@hourly-20240501-130000", UserProperties: tagged("hourly")},
+		{ID: "pool/parent/vol@hourly-extra-20240501-140000", UserProperties: tagged("hourly")},
+		{ID: "pool/parent/vol@hourly-manual", UserProperties: tagged("hourly")},
+		{ID: "pool/parent/vol@hourly-20240501-150000", UserProperties: map[string]truenas.UserProperty{
+			PropCSISnapshotName: {Value: "hourly-20240501-150000"},
+		}},
+		// Named like policy snapshots, but taken by a user or another policy
+		{ID: "pool/parent/vol@hourly-20240501-160000"},
+		{ID: "pool/parent/vol@hourly-20240501-170000", UserProperties: tagged("daily")},
+	}
+	taken := policySnapshots(snapshots, "hourly")
+	if assert.Len(t, taken, 2) {
+		assert.Equal(t, "pool/parent/vol@hourly-20240501-130000", taken[0].snap.ID)
+		assert.Equal(t, "pool/parent/vol@hourly-20240501-120000", taken[1].snap.ID)
+	}
+}
+
+func TestSnapshotPolicyTagFailure(t *testing.T) {
+	ctx := context.Background()
+	mockClient := truenas.NewMockClient()
+	_, err := mockClient.DatasetCreate(ctx, &truenas.DatasetCreateParams{Name: "pool/parent/vol-1"})
+	assert.NoError(t, err)
+	assert.NoError(t, mockClient.DatasetSetUserProperty(ctx, "pool/parent/vol-1", PropManagedResource, "true"))
+	policies := []SnapshotPolicy{{Name: "daily", Schedule: "daily", Keep: 1, AllVolumes: true}}
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	// A snapshot that can't be tagged would never be pruned, so it isn't kept
+	mockClient.FailNext("SnapshotSetUserProperty", assert.AnError)
+	result, err := applySnapshotPolicies(ctx, mockClient, "pool/parent", policies, now)
+	assert.Error(t, err)
+	assert.Empty(t, result.Created)
+	assert.Empty(t, mockClient.Snapshots)
+
+	// and the next pass takes it again
+	result, err = applySnapshotPolicies(ctx, mockClient, "pool/parent", policies, now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pool/parent/vol-1@daily-20240501-123100"}, result.Created)
+}
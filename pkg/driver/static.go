@@ -0,0 +1,126 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+)
+
+// staticVolumeSeparator separates the protocol from the dataset in static volume handles,
+// e.g. "nfs://tank/media" or "iscsi://tank/zvols/db".
+const staticVolumeSeparator = "://"
+
+// volumeRef locates the dataset behind a volume ID.
+type volumeRef struct {
+	// datasetName is the full dataset or zvol path
+	datasetName string
+	// shareType is the protocol the volume is shared with (nfs, iscsi, nvmeof)
+	shareType string
+	// static is set for pre-existing datasets bound through a static volume handle
+	static bool
+}
+
+// parseStaticVolumeID splits a static volume handle into its protocol and dataset. Volume
+// IDs created by CreateVolume are dataset leaf names and never contain the separator.
+func parseStaticVolumeID(volumeID string) (shareType, datasetName string, ok bool) {
+	shareType, datasetName, ok = strings.Cut(volumeID, staticVolumeSeparator)
+	return shareType, datasetName, ok
+}
+
+// resolveVolume returns the dataset and protocol of a volume. Static handles carry both;
+// other IDs name a dataset under the parent dataset shared with the driver's protocol.
+func (d *Driver) resolveVolume(volumeID string) (*volumeRef, error) {
+	shareType, datasetName, ok := parseStaticVolumeID(volumeID)
+	if !ok {
+		cfg := d.GetConfig()
+		return &volumeRef{
+			datasetName: path.Join(cfg.ZFS.DatasetParentName, volumeID),
+			shareType:   cfg.GetDriverShareType(),
+		}, nil
+	}
+
+	switch shareType {
+	case "nfs", "iscsi", "nvmeof":
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported protocol %q in volume handle %s", shareType, volumeID)
+	}
+	if datasetName == "" || strings.HasPrefix(datasetName, "/") || strings.HasSuffix(datasetName, "/") ||
+		strings.Contains(datasetName, "@") || path.Clean(datasetName) != datasetName {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid dataset in volume handle %s", volumeID)
+	}
+	return &volumeRef{datasetName: datasetName, shareType: shareType, static: true}, nil
+}
+
+// isAdopted reports whether a dataset has been explicitly adopted, which allows
+// DeleteVolume to destroy a statically provisioned volume.
+func isAdopted(ds *truenas.Dataset) bool {
+	prop, ok := ds.UserProperties[PropManagedResource]
+	return ok && prop.Value == "true"
+}
+
+// getStaticVolumeContext shares a pre-existing dataset with the protocol from its volume
+// handle, creating the share on first use, and returns the volume context NodeStageVolume
+// expects. Context values set on the PV override the generated ones.
+func (d *Driver) getStaticVolumeContext(ctx context.Context, ref *volumeRef, overrides map[string]string) (map[string]string, error) {
+	ds, err := d.truenasClient.DatasetGet(ctx, ref.datasetName)
+	if err != nil {
+		if truenas.IsNotFoundError(err) {
+			return nil, status.Errorf(codes.NotFound, "dataset %s not found", ref.datasetName)
+		}
+		return nil, status.Errorf(truenasCode(err), "failed to get dataset %s: %v", ref.datasetName, err)
+	}
+
+	wantType := "VOLUME"
+	if ref.shareType == "nfs" {
+		wantType = "FILESYSTEM"
+	}
+	if ds.Type != wantType {
+		return nil, status.Errorf(codes.InvalidArgument, "dataset %s is a %s, %s requires a %s",
+			ref.datasetName, strings.ToLower(ds.Type), ref.shareType, strings.ToLower(wantType))
+	}
+
+	klog.Infof("Sharing static volume %s over %s", ref.datasetName, ref.shareType)
+	if err := d.ensureShareExists(ctx, ds, ref.datasetName, path.Base(ref.datasetName), ref.shareType); err != nil {
+		return nil, err
+	}
+
+	volumeContext, err := d.getVolumeContext(ctx, ds, ref.shareType)
+	if err != nil {
+		return nil, status.Errorf(truenasCode(err), "failed to get volume context for %s: %v", ref.datasetName, err)
+	}
+	for key, value := range overrides {
+		volumeContext[key] = value
+	}
+	return volumeContext, nil
+}
+
+// AdoptStaticVolume connects to TrueNAS with cfg and marks a pre-existing dataset as
+// managed, so that deleting a PV bound to it through a static volume handle destroys the
+// dataset and its share.
+func AdoptStaticVolume(ctx context.Context, cfg *Config, datasetName string) error {
+	client, err := truenas.NewClient(newClientConfig(&cfg.TrueNAS))
+	if err != nil {
+		return fmt.Errorf("failed to connect to TrueNAS: %w", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	return adoptStaticVolume(ctx, client, datasetName)
+}
+
+// adoptStaticVolume sets PropManagedResource on an existing dataset.
+func adoptStaticVolume(ctx context.Context, client truenas.ClientInterface, datasetName string) error {
+	if _, err := client.DatasetGet(ctx, datasetName); err != nil {
+		return fmt.Errorf("failed to get dataset %s: %w", datasetName, err)
+	}
+	if err := client.DatasetSetUserProperty(ctx, datasetName, PropManagedResource, "true"); err != nil {
+		return fmt.Errorf("failed to adopt dataset %s: %w", datasetName, err)
+	}
+	return nil
+}
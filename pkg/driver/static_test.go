@@ -0,0 +1,106 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestResolveVolume(t *testing.T) {
+	d := &Driver{
+		config: &Config{
+			DriverName: "truenas-iscsi",
+			ZFS:        ZFSConfig{DatasetParentName: "pool/parent"},
+		},
+	}
+
+	ref, err := d.resolveVolume("pvc-1")
+	assert.NoError(t, err)
+	assert.Equal(t, &volumeRef{datasetName: "pool/parent/pvc-1", shareType: "iscsi"}, ref)
+
+	ref, err = d.resolveVolume("nfs://tank/media")
+	assert.NoError(t, err)
+	assert.Equal(t, &volumeRef{datasetName: "tank/media", shareType: "nfs", static: true}, ref)
+
+	for _, id := range []string{"smb://tank/media", "nfs://", "nfs:///tank", "nfs://tank/media/", "nfs://tank/../media", "iscsi://tank/db@snap"} {
+		_, err := d.resolveVolume(id)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), id)
+	}
+}
+
+func TestStaticVolume(t *testing.T) {
+	ctx := context.Background()
+	mockClient := truenas.NewMockClient()
+	d := &Driver{
+		config: &Config{
+			DriverName: "truenas-nfs",
+			ZFS:        ZFSConfig{DatasetParentName: "pool/parent"},
+			NFS:        NFSConfig{ShareHost: "1.2.3.4"},
+		},
+		truenasClient: mockClient,
+	}
+	mockClient.Datasets["tank/media"] = &truenas.Dataset{
+		ID: "tank/media", Name: "tank/media", Type: "FILESYSTEM", Mountpoint: "/mnt/tank/media",
+		UserProperties: map[string]truenas.UserProperty{},
+	}
+
+	// Test Case 1: The share is created on first use
+	ref, err := d.resolveVolume("nfs://tank/media")
+	assert.NoError(t, err)
+	volumeContext, err := d.getStaticVolumeContext(ctx, ref, map[string]string{"server": "nas.example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, "nfs", volumeContext["node_attach_driver"])
+	assert.Equal(t, "/mnt/tank/media", volumeContext["share"])
+	assert.Equal(t, "nas.example.com", volumeContext["server"])
+	assert.Len(t, mockClient.NFSShares, 1)
+
+	// Test Case 2: The existing share is reused
+	_, err = d.getStaticVolumeContext(ctx, ref, nil)
+	assert.NoError(t, err)
+	assert.Len(t, mockClient.NFSShares, 1)
+
+	// Test Case 3: The dataset type must match the protocol
+	ref, err = d.resolveVolume("iscsi://tank/media")
+	assert.NoError(t, err)
+	_, err = d.getStaticVolumeContext(ctx, ref, nil)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Test Case 4: Controller calls resolve the dataset from the handle
+	_, err = d.ControllerGetVolume(ctx, &csi.ControllerGetVolumeRequest{VolumeId: "nfs://tank/media"})
+	assert.NoError(t, err)
+	_, err = d.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: "nfs://tank/media"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Test Case 5: Deleting a static volume that wasn't adopted keeps the dataset and share
+	_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "nfs://tank/media"})
+	assert.NoError(t, err)
+	assert.Contains(t, mockClient.Datasets, "tank/media")
+	assert.Len(t, mockClient.NFSShares, 1)
+
+	// Test Case 6: Adopted static volumes are deleted with their share
+	assert.NoError(t, adoptStaticVolume(ctx, mockClient, "tank/media"))
+	_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "nfs://tank/media"})
+	assert.NoError(t, err)
+	assert.NotContains(t, mockClient.Datasets, "tank/media")
+	assert.Empty(t, mockClient.NFSShares)
+
+	// Test Case 7: Adopting a missing dataset fails
+	assert.Error(t, adoptStaticVolume(ctx, mockClient, "tank/missing"))
+}
+
+func TestNodeStageVolumeContext(t *testing.T) {
+	d := &Driver{config: &Config{DriverName: "truenas-nfs"}}
+
+	// Dynamic volumes still require a volume context
+	_, err := d.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "pvc-1",
+		StagingTargetPath: t.TempDir(),
+		VolumeCapability:  volumeCapabilities[0],
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,118 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
+	"k8s.io/klog/v2"
+)
+
+// tracer is used for CSI RPC spans and controller-side fan-outs.
+// It delegates to the global provider, so it picks up SetupTracing even though
+// it is created at package init.
+var tracer = otel.Tracer("github.com/GizmoTickler/truenas-scale-csi/pkg/driver")
+
+// SetupTracing installs a global OpenTelemetry tracer provider that exports spans over OTLP/gRPC.
+// When tracing is disabled, a no-op shutdown function is returned and the default no-op provider stays in place.
+// The returned function flushes pending spans and must be called before the process exits.
+func SetupTracing(ctx context.Context, cfg *TracingConfig, serviceName string, version string) (func(context.Context) error, error) {
+	if cfg == nil || !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	sampleRatio := 1.0
+	if cfg.SampleRatio != nil {
+		sampleRatio = *cfg.SampleRatio
+	}
+
+	opts := []otlptracegrpc.Option{}
+	if cfg.Endpoint != "" {
+		opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", serviceName),
+		attribute.String("service.version", version),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build tracing resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	klog.Infof("OpenTelemetry tracing enabled (endpoint=%q, sampleRatio=%v)", cfg.Endpoint, sampleRatio)
+	return provider.Shutdown, nil
+}
+
+// spanGroup is an errgroup whose goroutines share a single child span.
+// The span is ended (and marked failed if needed) when Wait returns.
+type spanGroup struct {
+	*errgroup.Group
+	span trace.Span
+}
+
+// tracedGroup is a drop-in replacement for errgroup.WithContext that records the
+// fan-out as a child span named name, so parallel property updates show up as one
+// unit in a trace with their TrueNAS calls nested below it.
+func tracedGroup(ctx context.Context, name string) (*spanGroup, context.Context) {
+	ctx, span := tracer.Start(ctx, name)
+	g, gCtx := errgroup.WithContext(ctx)
+	return &spanGroup{Group: g, span: span}, gCtx
+}
+
+// Wait waits for all goroutines and ends the group span.
+func (g *spanGroup) Wait() error {
+	err := g.Group.Wait()
+	endSpan(g.span, err)
+	return err
+}
+
+// endSpan records err (if any) on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	span.End()
+}
+
+// requestAttributes extracts identifying fields common to CSI requests as span attributes.
+func requestAttributes(req interface{}) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{}
+	if r, ok := req.(interface{ GetVolumeId() string }); ok && r.GetVolumeId() != "" {
+		attrs = append(attrs, attribute.String("csi.volume_id", r.GetVolumeId()))
+	}
+	if r, ok := req.(interface{ GetName() string }); ok && r.GetName() != "" {
+		attrs = append(attrs, attribute.String("csi.name", r.GetName()))
+	}
+	if r, ok := req.(interface{ GetSnapshotId() string }); ok && r.GetSnapshotId() != "" {
+		attrs = append(attrs, attribute.String("csi.snapshot_id", r.GetSnapshotId()))
+	}
+	if r, ok := req.(interface{ GetSourceVolumeId() string }); ok && r.GetSourceVolumeId() != "" {
+		attrs = append(attrs, attribute.String("csi.source_volume_id", r.GetSourceVolumeId()))
+	}
+	return attrs
+}
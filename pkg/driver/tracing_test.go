@@ -0,0 +1,138 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	testExporter     *tracetest.InMemoryExporter
+	testExporterOnce sync.Once
+)
+
+// setupTestTracing installs an in-memory tracer provider once per test binary.
+// The package-level tracer binds to the first global provider, so it can't be swapped per test.
+func setupTestTracing(t *testing.T) *tracetest.InMemoryExporter {
+	testExporterOnce.Do(func() {
+		testExporter = tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(testExporter)))
+	})
+	testExporter.Reset()
+	return testExporter
+}
+
+func TestLogInterceptorSpan(t *testing.T) {
+	exporter := setupTestTracing(t)
+	d := &Driver{}
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/DeleteVolume"}
+	req := &csi.DeleteVolumeRequest{VolumeId: "vol-01"}
+
+	// Child spans started by the handler must nest under the RPC span
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		_, child := tracer.Start(ctx, "child")
+		child.End()
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	_, err := d.logInterceptor(context.Background(), req, info, handler)
+	assert.NoError(t, err)
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 2)
+	child, rpc := spans[0], spans[1]
+	assert.Equal(t, "csi.v1.Controller/DeleteVolume", rpc.Name)
+	assert.Equal(t, rpc.SpanContext.SpanID(), child.Parent.SpanID())
+	assert.Equal(t, otelcodes.Unset, rpc.Status.Code)
+
+	attrs := map[string]string{}
+	for _, kv := range rpc.Attributes {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	assert.Equal(t, "vol-01", attrs["csi.volume_id"])
+	assert.Equal(t, "1", attrs["csi.request_id"])
+	assert.Equal(t, "OK", attrs["rpc.grpc.status_code"])
+}
+
+func TestLogInterceptorSpanError(t *testing.T) {
+	exporter := setupTestTracing(t)
+	d := &Driver{}
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "missing")
+	}
+
+	_, err := d.logInterceptor(context.Background(), &csi.CreateVolumeRequest{Name: "pvc-1"}, info, handler)
+	assert.Error(t, err)
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, otelcodes.Error, spans[0].Status.Code)
+	assert.Len(t, spans[0].Events, 1) // recorded exception
+}
+
+func TestTracedGroup(t *testing.T) {
+	exporter := setupTestTracing(t)
+
+	g, gCtx := tracedGroup(context.Background(), "fanout")
+	g.Go(func() error {
+		_, child := tracer.Start(gCtx, "set-property")
+		child.End()
+		return nil
+	})
+	g.Go(func() error {
+		return errors.New("boom")
+	})
+	assert.Error(t, g.Wait())
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "fanout", spans[1].Name)
+	assert.Equal(t, otelcodes.Error, spans[1].Status.Code)
+	assert.Equal(t, spans[1].SpanContext.SpanID(), spans[0].Parent.SpanID())
+}
+
+func TestLogInterceptorAdmission(t *testing.T) {
+	setupTestTracing(t)
+	mock := truenas.NewMockClient()
+	d := &Driver{truenasClient: mock}
+	var priority truenas.Priority
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		priority = truenas.PriorityFromContext(ctx)
+		return nil, nil
+	}
+	call := func(method string) error {
+		priority = -1
+		_, err := d.logInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	// Cleanup goes ahead of other TrueNAS calls
+	assert.NoError(t, call("/csi.v1.Controller/DeleteVolume"))
+	assert.Equal(t, truenas.PriorityHigh, priority)
+	assert.NoError(t, call("/csi.v1.Controller/CreateVolume"))
+	assert.Equal(t, truenas.PriorityNormal, priority)
+
+	// While the breaker is open controller RPCs fail fast, without reaching the handler
+	mock.CircuitOpen = true
+	err := call("/csi.v1.Controller/CreateVolume")
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, truenas.Priority(-1), priority)
+	assert.NoError(t, call("/csi.v1.Controller/ControllerGetCapabilities"))
+	assert.NoError(t, call("/csi.v1.Node/NodeUnstageVolume"))
+	assert.Equal(t, truenas.PriorityHigh, priority)
+}
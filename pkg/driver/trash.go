@@ -0,0 +1,263 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+)
+
+const (
+	// PropDeletedAt records when a volume was moved to the trash (RFC 3339)
+	PropDeletedAt = "truenas-csi:deleted_at"
+	// PropDeletedFrom records the dataset a trashed volume was moved from
+	PropDeletedFrom = "truenas-csi:deleted_from"
+)
+
+// trashEnabled reports whether DeleteVolume should move datasetName to the trash rather
+// than destroy it. Datasets in another pool can't be renamed into the trash.
+func (c *Config) trashEnabled(datasetName string) bool {
+	trash := c.ZFS.Trash.DatasetParentName
+	return trash != "" && datasetPool(trash) == datasetPool(datasetName)
+}
+
+// trashVolume moves a dataset into the trash, stamping when and where it was deleted from.
+// The properties are set before the rename, so anything in the trash carries them, and
+// cleared again if the rename fails, so a live volume is never marked deleted. The share
+// IDs are dropped in the same update: the shares are already deleted, and TrueNAS may
+// reuse their IDs before a restore. Snapshots move with the dataset.
+func (d *Driver) trashVolume(ctx context.Context, datasetName string) (string, error) {
+	trash := d.GetConfig().ZFS.Trash.DatasetParentName
+	if err := ensureTrashDataset(ctx, d.truenasClient, trash); err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	updates := []truenas.UserPropertyUpdate{
+		{Key: PropDeletedAt, Value: now.Format(time.RFC3339)},
+		{Key: PropDeletedFrom, Value: datasetName},
+	}
+	for _, key := range shareProperties {
+		updates = append(updates, truenas.UserPropertyUpdate{Key: key, Remove: true})
+	}
+	_, err := d.truenasClient.DatasetUpdate(ctx, datasetName, &truenas.DatasetUpdateParams{UserPropertiesUpdate: updates})
+	if err != nil {
+		return "", fmt.Errorf("failed to mark dataset %s deleted: %w", datasetName, err)
+	}
+
+	// The timestamp keeps names unique when a volume ID is reused
+	trashedName := path.Join(trash, fmt.Sprintf("%s-%d", path.Base(datasetName), now.Unix()))
+	if err := d.truenasClient.DatasetRename(ctx, datasetName, trashedName); err != nil {
+		// Unmark it even if the request was cancelled
+		unmarkCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		_, unmarkErr := d.truenasClient.DatasetUpdate(unmarkCtx, datasetName, &truenas.DatasetUpdateParams{
+			UserPropertiesUpdate: []truenas.UserPropertyUpdate{
+				{Key: PropDeletedAt, Remove: true},
+				{Key: PropDeletedFrom, Remove: true},
+			},
+		})
+		if unmarkErr != nil {
+			klog.Warningf("Failed to clear deleted marks from %s after the move to the trash failed: %v", datasetName, unmarkErr)
+		}
+		return "", err
+	}
+	return trashedName, nil
+}
+
+// ensureTrashDataset creates the trash dataset if it doesn't exist yet.
+func ensureTrashDataset(ctx context.Context, client truenas.ClientInterface, trash string) error {
+	if _, err := client.DatasetGet(ctx, trash); err == nil {
+		return nil
+	} else if !truenas.IsNotFoundError(err) {
+		return fmt.Errorf("failed to get trash dataset %s: %w", trash, err)
+	}
+
+	klog.Infof("Creating trash dataset %s", trash)
+	if _, err := client.DatasetCreate(ctx, &truenas.DatasetCreateParams{Name: trash, Type: "FILESYSTEM"}); err != nil {
+		return fmt.Errorf("failed to create trash dataset %s: %w", trash, err)
+	}
+	return nil
+}
+
+// trashedAt returns when a dataset was moved to the trash, or false if it wasn't.
+func trashedAt(ds *truenas.Dataset) (time.Time, bool) {
+	prop, ok := ds.UserProperties[PropDeletedAt]
+	if !ok {
+		return time.Time{}, false
+	}
+	deletedAt, err := time.Parse(time.RFC3339, prop.Value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return deletedAt, true
+}
+
+// runTrashPurger destroys expired volumes in the trash every purge interval while this
+// replica is the leader.
+func (d *Driver) runTrashPurger(ctx context.Context) {
+	for {
+		// Re-read each time so reloaded settings apply
+		interval := time.Duration(d.GetConfig().ZFS.Trash.PurgeInterval) * time.Second
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		cfg := d.GetConfig()
+		if cfg.ZFS.Trash.DatasetParentName == "" || cfg.ZFS.Trash.Retention < 0 || d.confirmLeader(ctx) != nil {
+			continue
+		}
+		retention := time.Duration(cfg.ZFS.Trash.Retention) * time.Second
+		purged, err := purgeTrash(ctx, d.truenasClient, cfg.ZFS.Trash.DatasetParentName, retention, time.Now())
+		if err != nil {
+			klog.Warningf("Failed to purge trash: %v", err)
+		}
+		if len(purged) > 0 {
+			klog.Infof("Purged %d expired volumes from the trash", len(purged))
+		}
+	}
+}
+
+// purgeTrash destroys the trashed volumes directly under trash that were deleted more than
+// retention before now, with their snapshots. Volumes that fail are left for the next pass.
+func purgeTrash(ctx context.Context, client truenas.ClientInterface, trash string, retention time.Duration, now time.Time) ([]string, error) {
+	datasets, err := listVolumes(ctx, client, trash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trash: %w", err)
+	}
+
+	var purged []string
+	var errs []error
+	for _, ds := range datasets {
+		deletedAt, ok := trashedAt(ds)
+		if !ok || now.Sub(deletedAt) < retention {
+			continue
+		}
+		klog.Infof("Purging trashed volume %s", ds.Name)
+		if err := client.DatasetDelete(ctx, ds.Name, true, true); err != nil {
+			errs = append(errs, fmt.Errorf("failed to purge %s: %w", ds.Name, err))
+			continue
+		}
+		purged = append(purged, ds.Name)
+	}
+	return purged, errors.Join(errs...)
+}
+
+// RecoveredVolume describes a volume restored from the trash or failed over to a secondary.
+type RecoveredVolume struct {
+	// VolumeID is the volume handle to put in the PV
+	VolumeID string
+	// VolumeName is the CSI volume name the volume was created with, if known
+	VolumeName string
+	// DatasetName is the dataset the volume was restored to
+	DatasetName string
+	// CapacityBytes is the size of the volume
+	CapacityBytes int64
+	// ShareType is the protocol the volume is shared with
+	ShareType string
+	// VolumeContext is the volume context to put in the PV
+	VolumeContext map[string]string
+}
+
+// RestoreVolume connects to TrueNAS with cfg and restores a trashed dataset as a volume
+// under zfs.datasetParentName, sharing it again. trashed is a dataset name, or the name of
+// a dataset in the trash. An empty volumeID reuses the ID the volume was deleted with.
+func RestoreVolume(ctx context.Context, cfg *Config, trashed string, volumeID string) (*RecoveredVolume, error) {
+	client, err := truenas.NewClient(newClientConfig(&cfg.TrueNAS))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to TrueNAS: %w", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	d := &Driver{name: cfg.DriverName, config: cfg, truenasClient: client}
+	return d.restoreVolume(ctx, trashed, volumeID)
+}
+
+// restoreVolume moves a trashed dataset back under the parent dataset, clears its deletion
+// properties and recreates its share.
+func (d *Driver) restoreVolume(ctx context.Context, trashed string, volumeID string) (*RecoveredVolume, error) {
+	cfg := d.GetConfig()
+	if !strings.Contains(trashed, "/") {
+		if cfg.ZFS.Trash.DatasetParentName == "" {
+			return nil, fmt.Errorf("zfs.trash.datasetParentName is not set, give the full dataset name")
+		}
+		trashed = path.Join(cfg.ZFS.Trash.DatasetParentName, trashed)
+	}
+
+	ds, err := d.truenasClient.DatasetGet(ctx, trashed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dataset %s: %w", trashed, err)
+	}
+	if _, ok := trashedAt(ds); !ok {
+		return nil, fmt.Errorf("dataset %s is not a trashed volume", trashed)
+	}
+
+	if volumeID == "" {
+		from := ds.UserProperties[PropDeletedFrom].Value
+		if path.Dir(from) != cfg.ZFS.DatasetParentName {
+			return nil, fmt.Errorf("dataset %s was not deleted from %s, a volume ID is required", trashed, cfg.ZFS.DatasetParentName)
+		}
+		volumeID = path.Base(from)
+	}
+	if volumeID != d.sanitizeVolumeID(volumeID) {
+		return nil, fmt.Errorf("invalid volume ID %q", volumeID)
+	}
+
+	shareType := cfg.GetDriverShareType()
+	wantType := "VOLUME"
+	if shareType == "nfs" {
+		wantType = "FILESYSTEM"
+	}
+	if ds.Type != wantType {
+		return nil, fmt.Errorf("dataset %s is a %s, the %s driver requires a %s",
+			trashed, strings.ToLower(ds.Type), shareType, strings.ToLower(wantType))
+	}
+
+	datasetName := path.Join(cfg.ZFS.DatasetParentName, volumeID)
+	if exists, err := d.truenasClient.DatasetExists(ctx, datasetName); err != nil {
+		return nil, fmt.Errorf("failed to check dataset %s: %w", datasetName, err)
+	} else if exists {
+		return nil, fmt.Errorf("dataset %s already exists, choose another volume ID", datasetName)
+	}
+
+	if err := d.truenasClient.DatasetRename(ctx, trashed, datasetName); err != nil {
+		return nil, err
+	}
+	if _, err := d.truenasClient.DatasetUpdate(ctx, datasetName, &truenas.DatasetUpdateParams{
+		UserPropertiesUpdate: []truenas.UserPropertyUpdate{
+			{Key: PropDeletedAt, Remove: true},
+			{Key: PropDeletedFrom, Remove: true},
+		},
+	}); err != nil {
+		return nil, fmt.Errorf("failed to clear deletion properties on %s: %w", datasetName, err)
+	}
+
+	ds, err = d.truenasClient.DatasetGet(ctx, datasetName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dataset %s: %w", datasetName, err)
+	}
+	if err := d.ensureShareExists(ctx, ds, datasetName, volumeID, shareType); err != nil {
+		return nil, err
+	}
+	volumeContext, err := d.getVolumeContext(ctx, ds, shareType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get volume context for %s: %w", datasetName, err)
+	}
+
+	return &RecoveredVolume{
+		VolumeID:      volumeID,
+		VolumeName:    ds.UserProperties[PropCSIVolumeName].Value,
+		DatasetName:   datasetName,
+		CapacityBytes: d.getDatasetCapacity(ds),
+		ShareType:     shareType,
+		VolumeContext: volumeContext,
+	}, nil
+}
@@ -0,0 +1,136 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrashVolume(t *testing.T) {
+	ctx := context.Background()
+	mockClient := truenas.NewMockClient()
+	d := &Driver{
+		config: &Config{
+			DriverName: "truenas-nfs",
+			ZFS: ZFSConfig{
+				DatasetParentName: "pool/parent",
+				Trash:             TrashConfig{DatasetParentName: "pool/trash", Retention: 3600},
+			},
+			NFS: NFSConfig{ShareHost: "1.2.3.4"},
+		},
+		truenasClient: mockClient,
+	}
+
+	_, err := d.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "vol-01", VolumeCapabilities: volumeCapabilities})
+	assert.NoError(t, err)
+	_, err = mockClient.SnapshotCreate(ctx, "pool/parent/vol-01", "snap-1")
+	assert.NoError(t, err)
+
+	// Test Case 1: A failed move leaves the volume unmarked
+	mockClient.FailNext("DatasetRename", errors.New("dataset is busy"))
+	_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "vol-01"})
+	assert.Error(t, err)
+	assert.NotContains(t, mockClient.Datasets["pool/parent/vol-01"].UserProperties, PropDeletedAt)
+	assert.NotContains(t, mockClient.Datasets["pool/parent/vol-01"].UserProperties, PropDeletedFrom)
+
+	// Test Case 2: DeleteVolume moves the dataset and its snapshots to the trash
+	_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "vol-01"})
+	assert.NoError(t, err)
+	assert.NotContains(t, mockClient.Datasets, "pool/parent/vol-01")
+	assert.Contains(t, mockClient.Datasets, "pool/trash")
+	assert.Empty(t, mockClient.NFSShares)
+
+	var trashed *truenas.Dataset
+	for name, ds := range mockClient.Datasets {
+		if strings.HasPrefix(name, "pool/trash/vol-01-") {
+			trashed = ds
+		}
+	}
+	if !assert.NotNil(t, trashed) {
+		return
+	}
+	assert.Equal(t, "pool/parent/vol-01", trashed.UserProperties[PropDeletedFrom].Value)
+	assert.NotContains(t, trashed.UserProperties, PropNFSShareID)
+	_, ok := trashedAt(trashed)
+	assert.True(t, ok)
+	assert.Contains(t, mockClient.Snapshots, trashed.Name+"@snap-1")
+
+	// Test Case 3: Deleting again succeeds without touching the trash
+	_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "vol-01"})
+	assert.NoError(t, err)
+	assert.Contains(t, mockClient.Datasets, trashed.Name)
+
+	// Test Case 4: Restore moves it back under its old ID and shares it again, even after
+	// TrueNAS reused the deleted share's ID for another share
+	mockClient.NFSShares[1] = &truenas.NFSShare{ID: 1, Path: "/mnt/pool/parent/other"}
+	restored, err := d.restoreVolume(ctx, trashed.Name, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "vol-01", restored.VolumeID)
+	assert.Equal(t, "nfs", restored.VolumeContext["node_attach_driver"])
+	assert.Len(t, mockClient.NFSShares, 2)
+	ds := mockClient.Datasets["pool/parent/vol-01"]
+	if assert.NotNil(t, ds) {
+		assert.Equal(t, "2", ds.UserProperties[PropNFSShareID].Value)
+		assert.NotContains(t, ds.UserProperties, PropDeletedAt)
+		assert.NotContains(t, ds.UserProperties, PropDeletedFrom)
+	}
+
+	// Test Case 5: Only trashed datasets can be restored, and not over an existing volume
+	_, err = d.restoreVolume(ctx, "pool/parent/vol-01", "vol-02")
+	assert.Error(t, err)
+	_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "vol-01"})
+	assert.NoError(t, err)
+	_, err = d.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "vol-01", VolumeCapabilities: volumeCapabilities})
+	assert.NoError(t, err)
+	for name := range mockClient.Datasets {
+		if strings.HasPrefix(name, "pool/trash/") {
+			_, err = d.restoreVolume(ctx, path.Base(name), "")
+			assert.ErrorContains(t, err, "already exists")
+		}
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	ctx := context.Background()
+	mockClient := truenas.NewMockClient()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	trashed := func(name string, deletedAt time.Time) {
+		mockClient.Datasets[name] = &truenas.Dataset{
+			ID: name, Name: name,
+			UserProperties: map[string]truenas.UserProperty{
+				PropDeletedAt: {Value: deletedAt.Format(time.RFC3339)},
+			},
+		}
+	}
+	trashed("pool/trash/old-1", now.Add(-48*time.Hour))
+	trashed("pool/trash/new-1", now.Add(-time.Hour))
+	// Not in the trash, or not stamped by DeleteVolume
+	trashed("pool/parent/vol-1", now.Add(-48*time.Hour))
+	mockClient.Datasets["pool/trash/manual"] = &truenas.Dataset{
+		ID: "pool/trash/manual", Name: "pool/trash/manual", UserProperties: map[string]truenas.UserProperty{},
+	}
+
+	purged, err := purgeTrash(ctx, mockClient, "pool/trash", 24*time.Hour, now)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pool/trash/old-1"}, purged)
+	assert.NotContains(t, mockClient.Datasets, "pool/trash/old-1")
+	assert.Contains(t, mockClient.Datasets, "pool/trash/new-1")
+	assert.Contains(t, mockClient.Datasets, "pool/parent/vol-1")
+	assert.Contains(t, mockClient.Datasets, "pool/trash/manual")
+}
+
+func TestTrashEnabled(t *testing.T) {
+	cfg := &Config{ZFS: ZFSConfig{Trash: TrashConfig{DatasetParentName: "pool/trash"}}}
+	assert.True(t, cfg.trashEnabled("pool/parent/vol-1"))
+	// Datasets can't be renamed across pools
+	assert.False(t, cfg.trashEnabled("tank/media"))
+	assert.False(t, (&Config{}).trashEnabled("pool/parent/vol-1"))
+}
@@ -0,0 +1,93 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+)
+
+// VerifyTrueNAS connects to TrueNAS with cfg and checks that the release supports the
+// configured protocol and that the resources it references exist: the parent datasets, the iSCSI portal and initiator groups, and an NVMe-oF port
+// for the configured transport. It reports every problem found.
+func VerifyTrueNAS(ctx context.Context, cfg *Config) error {
+	client, err := truenas.NewClient(newClientConfig(&cfg.TrueNAS))
+	if err != nil {
+		return fmt.Errorf("failed to connect to TrueNAS: %w", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	return verifyTrueNAS(ctx, client, cfg)
+}
+
+// verifyTrueNAS checks the resources cfg references against client.
+func verifyTrueNAS(ctx context.Context, client truenas.ClientInterface, cfg *Config) error {
+	// Nothing else is worth checking on a release the driver can't use
+	if err := checkTrueNASRelease(ctx, client, cfg); err != nil {
+		return err
+	}
+
+	var errs []error
+
+	if _, err := client.DatasetGet(ctx, cfg.ZFS.DatasetParentName); err != nil {
+		errs = append(errs, fmt.Errorf("zfs.datasetParentName %q: %w", cfg.ZFS.DatasetParentName, err))
+	}
+	if name := cfg.ZFS.DetachedSnapshotsDatasetParentName; name != "" {
+		if _, err := client.DatasetGet(ctx, name); err != nil {
+			errs = append(errs, fmt.Errorf("zfs.detachedSnapshotsDatasetParentName %q: %w", name, err))
+		}
+	}
+
+	switch cfg.GetDriverShareType() {
+	case "iscsi":
+		for i, tg := range cfg.ISCSI.TargetGroups {
+			if _, err := client.ISCSIPortalGet(ctx, tg.Portal); err != nil {
+				errs = append(errs, fmt.Errorf("iscsi.targetGroups[%d].portal: %w", i, err))
+			}
+			// Initiator group 0 allows all initiators
+			if tg.Initiator > 0 {
+				if _, err := client.ISCSIInitiatorGet(ctx, tg.Initiator); err != nil {
+					errs = append(errs, fmt.Errorf("iscsi.targetGroups[%d].initiator: %w", i, err))
+				}
+			}
+		}
+	case "nvmeof":
+		if err := verifyNVMeoFPort(ctx, client, &cfg.NVMeoF); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkTrueNASRelease fails if the connected TrueNAS release is unsupported or lacks the
+// API of the configured protocol.
+func checkTrueNASRelease(ctx context.Context, client truenas.ClientInterface, cfg *Config) error {
+	caps, err := client.Capabilities(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to detect TrueNAS capabilities: %w", err)
+	}
+	return caps.Check(cfg.GetDriverShareType())
+}
+
+// verifyNVMeoFPort checks that an NVMe-oF port listens on the configured transport and
+// service ID, at the configured address or a wildcard address.
+func verifyNVMeoFPort(ctx context.Context, client truenas.ClientInterface, cfg *NVMeoFConfig) error {
+	ports, err := client.NVMeoFPortList(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range ports {
+		if !strings.EqualFold(p.Transport, cfg.Transport) || p.Port != cfg.TransportServiceID {
+			continue
+		}
+		if p.Address == cfg.TransportAddress || p.Address == "0.0.0.0" || p.Address == "::" {
+			return nil
+		}
+	}
+	return fmt.Errorf("no NVMe-oF %s port on %s:%d; create one under Shares > NVMe-oF > Ports",
+		cfg.Transport, cfg.TransportAddress, cfg.TransportServiceID)
+}
@@ -0,0 +1,176 @@
+package driver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas"
+	"github.com/stretchr/testify/assert"
+)
+
+const validateTestConfig = `driver: truenas-iscsi
+truenas:
+  host: truenas.local
+  apiKey: 1-key
+zfs:
+  datasetParentName: tank/k8s/volumes
+iscsi:
+  targetPortal: truenas.local:3260
+  targetGroups:
+    - portal: 1
+      initiator: 2
+      authMethod: NONE
+`
+
+// loadTestConfig writes data to a temporary file and loads it.
+func loadTestConfig(t *testing.T, data string) (*Config, error) {
+	path := filepath.Join(t.TempDir(), "driver.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(data), 0600))
+	return LoadConfig(path)
+}
+
+func TestLoadConfigUnknownKeys(t *testing.T) {
+	_, err := loadTestConfig(t, validateTestConfig)
+	assert.NoError(t, err)
+
+	// Test Case 1: Unknown top-level section
+	_, err = loadTestConfig(t, strings.Replace(validateTestConfig, "truenas:", "truenass:", 1))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "truenass")
+	}
+
+	// Test Case 2: Unknown nested key
+	_, err = loadTestConfig(t, strings.Replace(validateTestConfig, "portal: 1", "portalGroup: 1", 1))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "portalGroup")
+	}
+
+	// Test Case 3: Empty file reports missing fields rather than a parse error
+	_, err = loadTestConfig(t, "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "truenas.host is required")
+}
+
+func TestLoadConfigSampleRatio(t *testing.T) {
+	// Test Case 1: An absent sampleRatio samples every trace
+	cfg, err := loadTestConfig(t, validateTestConfig)
+	if assert.NoError(t, err) && assert.NotNil(t, cfg.Tracing.SampleRatio) {
+		assert.Equal(t, 1.0, *cfg.Tracing.SampleRatio)
+	}
+
+	// Test Case 2: An explicit 0 turns sampling off rather than falling back to the default
+	cfg, err = loadTestConfig(t, "tracing:\n  sampleRatio: 0\n"+validateTestConfig)
+	if assert.NoError(t, err) && assert.NotNil(t, cfg.Tracing.SampleRatio) {
+		assert.Equal(t, 0.0, *cfg.Tracing.SampleRatio)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     string
+		new     string
+		wantErr string
+	}{
+		{"zvol blocksize", "zfs:\n", "zfs:\n  zvolBlocksize: 24K\n", "zfs.zvolBlocksize"},
+		{"extent blocksize", "iscsi:\n", "iscsi:\n  extentBlocksize: 8192\n", "iscsi.extentBlocksize"},
+		{"extent rpm", "iscsi:\n", "iscsi:\n  extentRpm: 3600\n", "iscsi.extentRpm"},
+		{"avail threshold", "iscsi:\n", "iscsi:\n  extentAvailThreshold: 100\n", "iscsi.extentAvailThreshold"},
+		{"auth method", "authMethod: NONE", "authMethod: None-ish", "iscsi.targetGroups[0].authMethod"},
+		{"chap without auth", "authMethod: NONE", "authMethod: CHAP", "iscsi.targetGroups[0].auth is required"},
+		{"missing portal", "portal: 1", "portal: 0", "iscsi.targetGroups[0].portal"},
+		{"port", "host: truenas.local", "host: truenas.local\n  port: 70000", "truenas.port"},
+		{"protocol", "host: truenas.local", "host: truenas.local\n  protocol: ftp", "truenas.protocol"},
+		{"nvme transport", "iscsi:\n", "nvmeof:\n  transport: fc\niscsi:\n", "nvmeof.transport"},
+		{"trash pool", "zfs:\n", "zfs:\n  trash:\n    datasetParentName: other/trash\n", "zfs.trash.datasetParentName must be in the same pool"},
+		{"nested trash", "zfs:\n", "zfs:\n  trash:\n    datasetParentName: tank/k8s/volumes/trash\n", "zfs.trash.datasetParentName and tank/k8s/volumes must not be nested"},
+		{"trash retention", "zfs:\n", "zfs:\n  trash:\n    retention: -2\n", "zfs.trash.retention"},
+		{"policy schedule", "zfs:\n", "zfs:\n  snapshotPolicies:\n    - name: hourly\n      schedule: minutely\n      keep: 1\n", "zfs.snapshotPolicies[0].schedule"},
+		{"policy name", "zfs:\n", "zfs:\n  snapshotPolicies:\n    - name: Hourly_1\n      schedule: hourly\n      keep: 1\n", "zfs.snapshotPolicies[0].name"},
+		{"policy keep", "zfs:\n", "zfs:\n  snapshotPolicies:\n    - name: hourly\n      schedule: hourly\n", "zfs.snapshotPolicies[0].keep"},
+		{"replication credentials", "zfs:\n", "zfs:\n  replication:\n    targetDatasetParentName: backup/k8s\n", "zfs.replication.sshCredentials is required"},
+		{"replication policies", "zfs:\n", "zfs:\n  replication:\n    targetDatasetParentName: backup/k8s\n    sshCredentials: 1\n", "zfs.replication requires zfs.snapshotPolicies"},
+		{"replication schedule", "zfs:\n", "zfs:\n  replication:\n    schedule: hourly\n", "zfs.replication.schedule"},
+		{"sample ratio", "zfs:\n", "tracing:\n  sampleRatio: 1.5\nzfs:\n", "tracing.sampleRatio"},
+		{"nested datasets", "zfs:\n", "zfs:\n  detachedSnapshotsDatasetParentName: tank/k8s/volumes/snaps\n", "must not be nested"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, strings.Replace(validateTestConfig, tt.old, tt.new, 1))
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+
+	// Every problem is reported, not just the first
+	data := strings.Replace(validateTestConfig, "  host: truenas.local\n", "", 1)
+	data = strings.Replace(data, "portal: 1", "portal: 0", 1)
+	_, err := loadTestConfig(t, data)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "truenas.host is required")
+		assert.Contains(t, err.Error(), "iscsi.targetGroups[0].portal")
+	}
+}
+
+func TestExampleConfigs(t *testing.T) {
+	for _, name := range []string{"truenas-nfs.yaml", "truenas-iscsi.yaml", "truenas-nvmeof.yaml"} {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadConfig(filepath.Join("..", "..", "examples", name))
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestVerifyTrueNAS(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := loadTestConfig(t, validateTestConfig)
+	assert.NoError(t, err)
+
+	mockClient := truenas.NewMockClient()
+
+	// Test Case 1: Nothing exists yet
+	err = verifyTrueNAS(ctx, mockClient, cfg)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "zfs.datasetParentName")
+		assert.Contains(t, err.Error(), "iscsi.targetGroups[0].portal")
+		assert.Contains(t, err.Error(), "iscsi.targetGroups[0].initiator")
+	}
+
+	// Test Case 2: All referenced resources exist
+	mockClient.Datasets["tank/k8s/volumes"] = &truenas.Dataset{ID: "tank/k8s/volumes", Name: "tank/k8s/volumes"}
+	mockClient.ISCSIPortals[1] = &truenas.ISCSIPortal{ID: 1}
+	mockClient.ISCSIInitiators[2] = &truenas.ISCSIInitiator{ID: 2}
+	assert.NoError(t, verifyTrueNAS(ctx, mockClient, cfg))
+
+	// Test Case 3: NVMe-oF port must match transport and service ID (mock has tcp 0.0.0.0:4420)
+	cfg.DriverName = "truenas-nvmeof"
+	cfg.NVMeoF.Transport = "tcp"
+	cfg.NVMeoF.TransportAddress = "truenas.local"
+	cfg.NVMeoF.TransportServiceID = 4420
+	assert.NoError(t, verifyTrueNAS(ctx, mockClient, cfg))
+
+	cfg.NVMeoF.TransportServiceID = 4421
+	err = verifyTrueNAS(ctx, mockClient, cfg)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "no NVMe-oF tcp port")
+	}
+
+	// Test Case 4: Releases without the protocol's API fail before anything else
+	mockClient.ServerCapabilities = &truenas.Capabilities{Version: "25.04.2", Major: 25, Minor: 4, SnapshotPrefix: "pool.snapshot"}
+	err = verifyTrueNAS(ctx, mockClient, cfg)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "NVMe-oF requires TrueNAS SCALE 25.10")
+		assert.NotContains(t, err.Error(), "port")
+	}
+	cfg.DriverName = "truenas-iscsi"
+	assert.NoError(t, verifyTrueNAS(ctx, mockClient, cfg))
+	mockClient.ServerCapabilities = &truenas.Capabilities{Version: "TrueNAS-SCALE-24.10.2", Major: 24, Minor: 10, SnapshotPrefix: "zfs.snapshot"}
+	err = verifyTrueNAS(ctx, mockClient, cfg)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "TrueNAS TrueNAS-SCALE-24.10.2 is not supported")
+	}
+}
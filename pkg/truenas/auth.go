@@ -0,0 +1,207 @@
+package truenas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// authRejectedBackoff is how long rejected credentials are remembered before TrueNAS is
+// asked again. It keeps the connection pool from hammering TrueNAS (and tripping account
+// lockouts) with credentials it has already refused.
+const authRejectedBackoff = 60 * time.Second
+
+// AuthError is returned when TrueNAS rejects the configured credentials.
+type AuthError struct {
+	Method  string
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("TrueNAS rejected %s: %s", e.Method, e.Message)
+}
+
+// IsAuthError returns true if the error indicates TrueNAS rejected the credentials.
+func IsAuthError(err error) bool {
+	var authErr *AuthError
+	return errors.As(err, &authErr)
+}
+
+// authenticator logs connections in and is shared by every connection in a pool, so a
+// token generated on one connection is reused by the others and a rejection is reported once.
+//
+// With username/password, the first login uses auth.login and then requests a temporary
+// token from auth.generate_token; reconnects use auth.login_with_token so the password
+// isn't resent each time. The token is refreshed by the heartbeat once half its TTL has
+// passed, and an expired or rejected token falls back to the password.
+type authenticator struct {
+	apiKey   string
+	username string
+	password string
+	tokenTTL time.Duration
+
+	mu           sync.Mutex
+	token        string
+	tokenExpires time.Time
+	refreshing   bool
+	rejectedAt   time.Time
+	rejectedErr  error
+}
+
+// newAuthenticator returns an authenticator for the client's credentials.
+func newAuthenticator(cfg *ClientConfig) *authenticator {
+	return &authenticator{
+		apiKey:   cfg.APIKey,
+		username: cfg.Username,
+		password: cfg.Password,
+		tokenTTL: cfg.TokenTTL,
+	}
+}
+
+// login authenticates a freshly opened connection. It returns an *AuthError without
+// contacting TrueNAS if the credentials were rejected within authRejectedBackoff.
+func (a *authenticator) login(c *Connection) error {
+	a.mu.Lock()
+	if a.rejectedErr != nil && time.Since(a.rejectedAt) < authRejectedBackoff {
+		err := a.rejectedErr
+		a.mu.Unlock()
+		return err
+	}
+	token := a.token
+	if time.Now().After(a.tokenExpires) {
+		token = ""
+	}
+	a.mu.Unlock()
+
+	if token != "" {
+		err := a.loginWith(c, "auth.login_with_token", token)
+		if err == nil {
+			return nil
+		}
+		if !IsAuthError(err) {
+			return err
+		}
+		// Tokens are revoked when TrueNAS restarts; fall back to the password
+		klog.V(2).Infof("Conn %d: Token login rejected, logging in with password", c.id)
+		a.clearToken(token)
+	}
+
+	var err error
+	if a.apiKey != "" {
+		err = a.loginWith(c, "auth.login_with_api_key", a.apiKey)
+	} else {
+		err = a.loginWith(c, "auth.login", a.username, a.password)
+	}
+	if err != nil {
+		if IsAuthError(err) {
+			a.rejected(err)
+		}
+		return err
+	}
+	a.accepted()
+	return nil
+}
+
+// loginWith calls an auth.login* method, converting a false result or a permission error
+// into an *AuthError. Other errors, such as a middleware still starting, are returned
+// as they are so the login is retried.
+func (a *authenticator) loginWith(c *Connection, method string, params ...interface{}) error {
+	result, err := c.callDirect(method, params...)
+	if err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.Kind() == ErrorPermission {
+			return &AuthError{Method: method, Message: apiErr.Message}
+		}
+		return err
+	}
+	if success, ok := result.(bool); !ok || !success {
+		return &AuthError{Method: method, Message: "invalid or revoked credentials"}
+	}
+	return nil
+}
+
+// rejected records a credential rejection and warns once per episode.
+func (a *authenticator) rejected(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.rejectedErr == nil {
+		if a.apiKey != "" {
+			klog.Errorf("TrueNAS rejected the API key; it may have been revoked or expired. "+
+				"Update truenas.apiKey with a valid key (retrying every %v): %v", authRejectedBackoff, err)
+		} else {
+			klog.Errorf("TrueNAS rejected the password for user %q; check truenas.username and truenas.password "+
+				"(retrying every %v): %v", a.username, authRejectedBackoff, err)
+		}
+	}
+	a.rejectedErr = err
+	a.rejectedAt = time.Now()
+}
+
+// accepted clears a previous rejection.
+func (a *authenticator) accepted() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.rejectedErr != nil {
+		klog.Infof("TrueNAS accepted the credentials again")
+	}
+	a.rejectedErr = nil
+}
+
+// clearToken forgets token unless another connection already replaced it.
+func (a *authenticator) clearToken(token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token == token {
+		a.token = ""
+		a.tokenExpires = time.Time{}
+	}
+}
+
+// startRefresh returns true if tokens are enabled, the current one is missing or past
+// half its lifetime, and no other connection is already refreshing it. The caller must
+// then call refreshToken.
+func (a *authenticator) startRefresh() bool {
+	if a.apiKey != "" || a.tokenTTL <= 0 {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.refreshing || (a.token != "" && time.Until(a.tokenExpires) >= a.tokenTTL/2) {
+		return false
+	}
+	a.refreshing = true
+	return true
+}
+
+// refreshToken generates a new token over an authenticated connection.
+func (a *authenticator) refreshToken(ctx context.Context, c *Connection) error {
+	defer func() {
+		a.mu.Lock()
+		a.refreshing = false
+		a.mu.Unlock()
+	}()
+
+	result, err := c.CallWithContext(ctx, "auth.generate_token", int(a.tokenTTL.Seconds()))
+	if err != nil {
+		return fmt.Errorf("failed to generate token: %w", err)
+	}
+	token, ok := result.(string)
+	if !ok || token == "" {
+		return fmt.Errorf("unexpected auth.generate_token result: %T", result)
+	}
+
+	a.mu.Lock()
+	a.token = token
+	// Leave a margin so a token is never used right as it expires
+	a.tokenExpires = time.Now().Add(a.tokenTTL - a.tokenTTL/10)
+	a.mu.Unlock()
+
+	klog.V(4).Infof("Conn %d: Refreshed TrueNAS auth token (ttl %v)", c.id, a.tokenTTL)
+	return nil
+}
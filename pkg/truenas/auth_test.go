@@ -0,0 +1,170 @@
+package truenas
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// authServer is a minimal TrueNAS WebSocket endpoint that answers the auth methods.
+type authServer struct {
+	apiKey   string
+	password string
+	busy     int // Logins answered with EBUSY before the credentials are checked
+
+	mu     sync.Mutex
+	calls  []string
+	tokens map[string]bool
+}
+
+func (s *authServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	for {
+		var req rpcRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.calls = append(s.calls, req.Method)
+		var result interface{} = true
+		var rpcErr *rpcError
+		switch req.Method {
+		case "auth.login_with_api_key":
+			result = req.Params[0] == s.apiKey
+			if s.busy > 0 {
+				s.busy--
+				rpcErr = &rpcError{Code: -32001, Message: "Method call error", Data: map[string]interface{}{
+					"error": float64(errnoEBUSY), "errname": "EBUSY", "reason": "[EBUSY] Middleware is starting",
+				}}
+			}
+		case "auth.login":
+			result = req.Params[1] == s.password
+		case "auth.login_with_token":
+			result = s.tokens[req.Params[0].(string)]
+		case "auth.generate_token":
+			token := "token-" + strconv.Itoa(len(s.tokens))
+			s.tokens[token] = true
+			result = token
+		}
+		s.mu.Unlock()
+
+		resp := rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rpcErr}
+		if rpcErr != nil {
+			resp.Result = nil
+		}
+		if err := conn.WriteJSON(resp); err != nil {
+			return
+		}
+	}
+}
+
+// count returns how many times method was called.
+func (s *authServer) count(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, call := range s.calls {
+		if call == method {
+			n++
+		}
+	}
+	return n
+}
+
+// newAuthTestConfig returns a single-connection client config for the test server.
+func newAuthTestConfig(server *httptest.Server) *ClientConfig {
+	hostPort := strings.TrimPrefix(server.URL, "http://")
+	host, port, _ := strings.Cut(hostPort, ":")
+	portNum, _ := strconv.Atoi(port)
+	return &ClientConfig{
+		Host:           host,
+		Port:           portNum,
+		Protocol:       "http",
+		MaxConnections: 1,
+		RetryInterval:  10 * time.Millisecond,
+	}
+}
+
+func TestRevokedAPIKey(t *testing.T) {
+	backend := &authServer{apiKey: "1-valid", tokens: map[string]bool{}}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	cfg := newAuthTestConfig(server)
+	cfg.APIKey = "1-revoked"
+
+	_, err := NewClient(cfg)
+	assert.Error(t, err)
+	assert.True(t, IsAuthError(err))
+	assert.Contains(t, err.Error(), "invalid or revoked credentials")
+
+	// The rejection isn't retried, and the second connect in NewClient fails fast
+	assert.Equal(t, 1, backend.count("auth.login_with_api_key"))
+}
+
+func TestLoginRetriesTransientErrors(t *testing.T) {
+	backend := &authServer{apiKey: "1-valid", busy: 2, tokens: map[string]bool{}}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	cfg := newAuthTestConfig(server)
+	cfg.APIKey = "1-valid"
+
+	// Errors other than a rejection aren't credential failures, so the login is retried
+	client, err := NewClient(cfg)
+	assert.NoError(t, err)
+	defer func() { _ = client.Close() }()
+	assert.Equal(t, 3, backend.count("auth.login_with_api_key"))
+}
+
+func TestPasswordLoginWithToken(t *testing.T) {
+	backend := &authServer{password: "secret", tokens: map[string]bool{}}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	cfg := newAuthTestConfig(server)
+	cfg.Username = "root"
+	cfg.Password = "secret"
+	cfg.TokenTTL = 10 * time.Minute
+
+	client, err := NewClient(cfg)
+	assert.NoError(t, err)
+	defer func() { _ = client.Close() }()
+	assert.Equal(t, 1, backend.count("auth.login"))
+
+	// A token is generated once the connection is up
+	assert.Eventually(t, func() bool { return backend.count("auth.generate_token") == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// Reconnects use the token instead of the password
+	conn := client.currentPool().conns[0]
+	conn.handleDisconnect()
+	assert.NoError(t, conn.connect())
+	assert.Equal(t, 1, backend.count("auth.login"))
+	assert.Equal(t, 1, backend.count("auth.login_with_token"))
+
+	// A revoked token falls back to the password
+	backend.mu.Lock()
+	backend.tokens = map[string]bool{}
+	backend.mu.Unlock()
+	conn.handleDisconnect()
+	assert.NoError(t, conn.connect())
+	assert.Equal(t, 2, backend.count("auth.login"))
+}
+
+func TestNewClientRequiresCredentials(t *testing.T) {
+	_, err := NewClient(&ClientConfig{Host: "truenas", Username: "root"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,525 @@
+package truenas
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// cacheRetryInterval is how long the cache waits before syncing again after a failed sync.
+const cacheRetryInterval = 10 * time.Second
+
+// cacheCreateTimeout is how long a collection is bypassed after one of our creates while
+// waiting for the event announcing the new row.
+const cacheCreateTimeout = 5 * time.Second
+
+// cacheDependents are collections whose rows reference another collection's rows, and are
+// removed with them.
+var cacheDependents = map[string][]struct{ collection, field string }{
+	"iscsi.target": {{"iscsi.targetextent", "target"}},
+	"iscsi.extent": {{"iscsi.targetextent", "extent"}},
+	"nvmet.subsys": {{"nvmet.namespace", "subsystem"}},
+}
+
+// cacheEntry is a cached row. Rows our own writes may have changed are dirty until an
+// event brings their new state, and aren't served meanwhile.
+type cacheEntry struct {
+	row   map[string]interface{}
+	dirty bool
+}
+
+// objectCache answers queries for datasets and snapshots under a parent dataset, NFS
+// shares, iSCSI targets, extents and associations, and NVMe-oF subsystems and namespaces.
+// It is primed with one query per collection and kept fresh by core.subscribe events
+// on one pooled connection.
+//
+// The cache only ever serves rows it knows to be current. Queries with options or with
+// filters other than equality, queries matching nothing, queries made while the
+// subscribed connection is down or a sync is running, and queries of a collection with
+// one of our creates in flight or awaiting its event go to TrueNAS, so objects created
+// moments ago are still found and a lost event costs a round-trip rather than a wrong answer.
+type objectCache struct {
+	pool   *connPool
+	parent string
+	caps   func(ctx context.Context) (*Capabilities, error)
+
+	mu         sync.RWMutex
+	tables     map[string]map[string]*cacheEntry // Collection, then row ID
+	removed    map[string]bool                   // Rows removed by events while syncing
+	creating   map[string]int                    // Our creates in flight, by collection
+	awaiting   map[string]map[string]time.Time   // Rows we created whose event hasn't arrived, until when they're waited for
+	conn       *Connection                       // Delivers the events
+	generation uint64                            // Of conn when it subscribed
+	synced     bool
+	syncing    bool
+	lastSync   time.Time
+	closed     bool
+}
+
+// newObjectCache returns an empty cache for pool. Call syncInBackground to prime it.
+func newObjectCache(pool *connPool, parent string, caps func(ctx context.Context) (*Capabilities, error)) *objectCache {
+	return &objectCache{pool: pool, parent: strings.TrimSuffix(parent, "/"), caps: caps}
+}
+
+// close stops the cache from syncing again.
+func (c *objectCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	c.synced = false
+}
+
+// valid reports whether the cached rows are complete and current. Callers hold mu.
+func (c *objectCache) valid() bool {
+	return c.synced && c.conn.IsConnected() && atomic.LoadUint64(&c.conn.generation) == c.generation
+}
+
+// lookup answers a query from the cache. ok is false if TrueNAS must be asked.
+func (c *objectCache) lookup(method string, params []interface{}) (interface{}, bool) {
+	collection, op := splitMethod(method)
+	if op != "query" {
+		return nil, false
+	}
+	filters, ok := equalityFilters(params)
+	if !ok {
+		return nil, false
+	}
+
+	c.mu.RLock()
+	table, cached := c.tables[collection]
+	if cached && c.creatingRows(collection) {
+		// A partial list would miss the new row
+		c.mu.RUnlock()
+		return nil, false
+	}
+	if !cached || !c.valid() {
+		// Queries of collections the cache doesn't keep don't need it synced
+		startSync := cached || c.tables == nil
+		c.mu.RUnlock()
+		if startSync {
+			c.syncInBackground()
+		}
+		return nil, false
+	}
+	var ids []string
+	var rows []map[string]interface{}
+	for id, entry := range table {
+		if !matchesFilters(entry.row, filters) {
+			continue
+		}
+		if entry.dirty {
+			c.mu.RUnlock()
+			return nil, false
+		}
+		ids = append(ids, id)
+		rows = append(rows, entry.row)
+	}
+	c.mu.RUnlock()
+	if len(rows) == 0 {
+		return nil, false
+	}
+
+	// Copies, in ID order like TrueNAS returns them
+	sort.Sort(rowsByID{ids, rows})
+	result := make([]interface{}, len(rows))
+	for i, row := range rows {
+		result[i] = jsonValue(row)
+	}
+	klog.V(5).Infof("Answered %s from the object cache (%d rows)", method, len(rows))
+	return result, true
+}
+
+// invalidate marks the rows a write is about to change as dirty, before the write is
+// sent so the event announcing the change can't arrive first. Writes the cache can't
+// attribute to rows make it sync again.
+func (c *objectCache) invalidate(method string, params []interface{}) {
+	collection, op := splitMethod(method)
+	switch op {
+	case "query", "get_instance":
+		// Reads change nothing
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	table, cached := c.tables[collection]
+	if !cached {
+		return
+	}
+	if op == "create" {
+		// New rows arrive through events; until then the collection is bypassed
+		if c.creating == nil {
+			c.creating = make(map[string]int)
+		}
+		c.creating[collection]++
+		return
+	}
+
+	var id interface{}
+	if len(params) > 0 {
+		id = params[0]
+	}
+	if op == "clone" {
+		// Cloning changes the snapshot's clone list
+		id = argField(id, "snapshot")
+	}
+	switch id.(type) {
+	case string, int, int64, float64:
+	default:
+		c.synced = false
+		return
+	}
+	if op == "promote" || op == "rename" {
+		// Moves snapshots and origins between datasets
+		c.synced = false
+		return
+	}
+
+	key := cacheKey(id)
+	markDirty(table, key)
+	if name, ok := id.(string); ok {
+		// Recursive operations change the children and snapshots too
+		for _, t := range c.tables {
+			for rowKey := range t {
+				if strings.HasPrefix(rowKey, name+"/") || strings.HasPrefix(rowKey, name+"@") {
+					markDirty(t, rowKey)
+				}
+			}
+		}
+	}
+	for _, dep := range cacheDependents[collection] {
+		for rowKey, entry := range c.tables[dep.collection] {
+			if cacheKey(entry.row[dep.field]) == key {
+				markDirty(c.tables[dep.collection], rowKey)
+			}
+		}
+	}
+}
+
+// created ends a create counted by invalidate. The collection stays bypassed until the
+// event adding the new row arrives, or cacheCreateTimeout passes. Failed creates add nothing.
+func (c *objectCache) created(method string, result interface{}, err error) {
+	collection, op := splitMethod(method)
+	if op != "create" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.creating[collection] == 0 {
+		return
+	}
+	c.creating[collection]--
+	if err != nil {
+		return
+	}
+	// Rows are identified by the ID TrueNAS returns; results without one, such as job
+	// IDs, wait for the timeout
+	var key string
+	if row, ok := jsonValue(result).(map[string]interface{}); ok && row["id"] != nil {
+		key = cacheKey(row["id"])
+		if _, arrived := c.tables[collection][key]; arrived {
+			return
+		}
+	}
+	if c.awaiting == nil {
+		c.awaiting = make(map[string]map[string]time.Time)
+	}
+	if c.awaiting[collection] == nil {
+		c.awaiting[collection] = make(map[string]time.Time)
+	}
+	now := time.Now()
+	for pending, until := range c.awaiting[collection] {
+		if now.After(until) {
+			delete(c.awaiting[collection], pending)
+		}
+	}
+	c.awaiting[collection][key] = now.Add(cacheCreateTimeout)
+}
+
+// creatingRows reports whether one of our creates in collection is in flight or its row
+// hasn't arrived yet. Callers hold mu.
+func (c *objectCache) creatingRows(collection string) bool {
+	if c.creating[collection] > 0 {
+		return true
+	}
+	now := time.Now()
+	for _, until := range c.awaiting[collection] {
+		if now.Before(until) {
+			return true
+		}
+	}
+	return false
+}
+
+// markDirty marks a row dirty if it is cached.
+func markDirty(table map[string]*cacheEntry, key string) {
+	if entry, ok := table[key]; ok {
+		entry.dirty = true
+	}
+}
+
+// syncInBackground starts a sync unless one is running or the last one just failed.
+func (c *objectCache) syncInBackground() {
+	c.mu.Lock()
+	if c.closed || c.syncing || time.Since(c.lastSync) < cacheRetryInterval {
+		c.mu.Unlock()
+		return
+	}
+	c.syncing = true
+	c.lastSync = time.Now()
+	c.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), c.pool.config.Timeout)
+		defer cancel()
+		err := c.sync(ctx)
+
+		c.mu.Lock()
+		c.syncing = false
+		if err == nil {
+			// A successful sync may be followed by another as soon as it's needed
+			c.lastSync = time.Time{}
+		}
+		c.mu.Unlock()
+		if err != nil {
+			klog.Warningf("Object cache sync failed, using direct calls: %v", err)
+		}
+	}()
+}
+
+// sync subscribes to every cached collection on the first pooled connection and loads
+// the current rows.
+func (c *objectCache) sync(ctx context.Context) error {
+	caps, err := c.caps(ctx)
+	if err != nil {
+		return err
+	}
+	collections := []string{"pool.dataset", caps.SnapshotPrefix, "sharing.nfs", "iscsi.target", "iscsi.extent", "iscsi.targetextent"}
+	if caps.NVMeoF {
+		collections = append(collections, "nvmet.subsys", "nvmet.namespace")
+	}
+
+	conn := c.pool.conns[0]
+	if !conn.IsConnected() {
+		if err := conn.connect(); err != nil {
+			return err
+		}
+	}
+	generation := atomic.LoadUint64(&conn.generation)
+
+	tables := make(map[string]map[string]*cacheEntry, len(collections))
+	for _, collection := range collections {
+		tables[collection] = make(map[string]*cacheEntry)
+	}
+	c.mu.Lock()
+	resubscribe := c.conn != conn || c.generation != generation
+	c.tables, c.removed, c.conn, c.generation, c.synced = tables, make(map[string]bool), conn, generation, false
+	c.mu.Unlock()
+
+	// Subscribe before querying, so no change falls between the two
+	if resubscribe {
+		conn.eventMu.Lock()
+		conn.onEvent = func(method string, params interface{}) { c.handleEvent(conn, method, params) }
+		conn.eventMu.Unlock()
+		for _, collection := range collections {
+			if _, err := conn.CallWithContext(ctx, "core.subscribe", collection+".query"); err != nil {
+				return fmt.Errorf("failed to subscribe to %s: %w", collection, err)
+			}
+		}
+	}
+
+	for _, collection := range collections {
+		var filters []interface{}
+		if collection == "pool.dataset" || collection == caps.SnapshotPrefix {
+			filters = []interface{}{[]interface{}{"id", "^", c.parent + "/"}}
+		}
+		result, err := conn.CallWithContext(ctx, collection+".query", filters, map[string]interface{}{})
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", collection, err)
+		}
+		rows, _ := result.([]interface{})
+
+		c.mu.Lock()
+		for _, r := range rows {
+			row, ok := r.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			key := cacheKey(row["id"])
+			// Events that arrived meanwhile are at least as new as the query
+			if _, seen := tables[collection][key]; seen || c.removed[collection+" "+key] {
+				continue
+			}
+			tables[collection][key] = &cacheEntry{row: row}
+		}
+		c.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || atomic.LoadUint64(&conn.generation) != generation {
+		return fmt.Errorf("connection lost during sync")
+	}
+	c.removed = nil
+	c.synced = true
+	count := 0
+	for _, table := range tables {
+		count += len(table)
+	}
+	klog.V(2).Infof("Object cache synced: %d objects in %d collections", count, len(tables))
+	return nil
+}
+
+// handleEvent applies a collection_update event from conn.
+func (c *objectCache) handleEvent(conn *Connection, method string, params interface{}) {
+	if method != "collection_update" {
+		return
+	}
+	event, _ := params.(map[string]interface{})
+	name, _ := event["collection"].(string)
+	collection := strings.TrimSuffix(name, ".query")
+	key := cacheKey(event["id"])
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	table, cached := c.tables[collection]
+	if conn != c.conn || !cached || !c.inScope(collection, key) {
+		return
+	}
+
+	fields, _ := event["fields"].(map[string]interface{})
+	switch event["msg"] {
+	case "added":
+		if fields == nil {
+			return
+		}
+		if _, ok := fields["id"]; !ok {
+			fields["id"] = event["id"]
+		}
+		table[key] = &cacheEntry{row: fields}
+		delete(c.removed, collection+" "+key)
+		delete(c.awaiting[collection], key)
+	case "changed":
+		entry, ok := table[key]
+		if !ok {
+			// Only whole rows are cached; the next sync picks it up
+			return
+		}
+		for field, value := range fields {
+			entry.row[field] = value
+		}
+		entry.dirty = false
+	case "removed":
+		delete(table, key)
+		if c.removed != nil {
+			c.removed[collection+" "+key] = true
+		}
+	}
+}
+
+// inScope reports whether a row belongs in the cache: datasets and snapshots must be
+// under the parent. Callers hold mu.
+func (c *objectCache) inScope(collection, key string) bool {
+	if collection == "pool.dataset" || strings.HasSuffix(collection, ".snapshot") {
+		return strings.HasPrefix(key, c.parent+"/")
+	}
+	return true
+}
+
+// splitMethod splits "iscsi.target.query" into "iscsi.target" and "query".
+func splitMethod(method string) (string, string) {
+	i := strings.LastIndex(method, ".")
+	if i < 0 {
+		return method, ""
+	}
+	return method[:i], method[i+1:]
+}
+
+// equalityFilters returns the filters of a query made only of "=" comparisons and no
+// options, the queries the cache can answer.
+func equalityFilters(params []interface{}) ([][]interface{}, bool) {
+	if len(params) == 0 || len(params) > 2 {
+		return nil, false
+	}
+	wire, _ := jsonValue(params).([]interface{})
+	if len(wire) == 2 {
+		if options, ok := wire[1].(map[string]interface{}); !ok || len(options) > 0 {
+			return nil, false
+		}
+	}
+	list, ok := wire[0].([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, false
+	}
+	filters := make([][]interface{}, 0, len(list))
+	for _, f := range list {
+		filter, ok := f.([]interface{})
+		if !ok || len(filter) != 3 || filter[1] != "=" {
+			return nil, false
+		}
+		if _, ok := filter[0].(string); !ok {
+			return nil, false
+		}
+		filters = append(filters, filter)
+	}
+	return filters, true
+}
+
+// matchesFilters reports whether row satisfies every equality filter.
+func matchesFilters(row map[string]interface{}, filters [][]interface{}) bool {
+	for _, filter := range filters {
+		if !reflect.DeepEqual(row[filter[0].(string)], filter[2]) {
+			return false
+		}
+	}
+	return true
+}
+
+// cacheKey returns the key of a row ID: dataset names as they are, numbers in decimal.
+func cacheKey(id interface{}) string {
+	switch id := id.(type) {
+	case float64:
+		return fmt.Sprintf("%d", int64(id))
+	default:
+		return fmt.Sprint(id)
+	}
+}
+
+// argField returns field of a map parameter, or nil.
+func argField(param interface{}, field string) interface{} {
+	if m, ok := jsonValue(param).(map[string]interface{}); ok {
+		return m[field]
+	}
+	return nil
+}
+
+// rowsByID sorts rows by their IDs, numerically where the IDs are numbers.
+type rowsByID struct {
+	ids  []string
+	rows []map[string]interface{}
+}
+
+func (r rowsByID) Len() int { return len(r.ids) }
+func (r rowsByID) Swap(i, j int) {
+	r.ids[i], r.ids[j] = r.ids[j], r.ids[i]
+	r.rows[i], r.rows[j] = r.rows[j], r.rows[i]
+}
+func (r rowsByID) Less(i, j int) bool {
+	if len(r.ids[i]) != len(r.ids[j]) && isDigits(r.ids[i]) && isDigits(r.ids[j]) {
+		return len(r.ids[i]) < len(r.ids[j])
+	}
+	return r.ids[i] < r.ids[j]
+}
+
+// isDigits reports whether s is a non-negative decimal number.
+func isDigits(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
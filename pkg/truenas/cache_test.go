@@ -0,0 +1,194 @@
+package truenas
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas/faketruenas"
+	"github.com/stretchr/testify/assert"
+)
+
+// cacheSynced reports whether the client's object cache can answer queries.
+func cacheSynced(c *Client) bool {
+	cache := c.currentPool().cache
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	return cache.valid()
+}
+
+func TestObjectCacheAgainstFake(t *testing.T) {
+	ctx := context.Background()
+	server := faketruenas.New(faketruenas.Config{})
+	defer server.Close()
+	newClient := func(cacheParent string) *Client {
+		client, err := NewClient(&ClientConfig{
+			Host:           server.Host(),
+			Port:           server.Port(),
+			Protocol:       "http",
+			APIKey:         "1-key",
+			MaxConnections: 1,
+			CacheParent:    cacheParent,
+		})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		t.Cleanup(func() { _ = client.Close() })
+		return client
+	}
+	waitFor := func(condition func() bool) {
+		t.Helper()
+		assert.Eventually(t, condition, 5*time.Second, 10*time.Millisecond)
+	}
+	queries := func() int { return server.CallCount("pool.dataset.query") }
+
+	// direct makes the changes other controllers, or the TrueNAS UI, would
+	direct := newClient("")
+	_, err := direct.DatasetCreate(ctx, &DatasetCreateParams{Name: "tank/k8s", Type: "FILESYSTEM"})
+	assert.NoError(t, err)
+	_, err = direct.DatasetCreate(ctx, &DatasetCreateParams{Name: "tank/k8s/vol1", Type: "VOLUME", Volsize: 1 << 30, Sparse: true})
+	assert.NoError(t, err)
+
+	client := newClient("tank/k8s")
+	waitFor(func() bool { return cacheSynced(client) })
+
+	// Synced rows are served without calls, and match what TrueNAS returns
+	before := queries()
+	cached, err := client.DatasetGet(ctx, "tank/k8s/vol1")
+	assert.NoError(t, err)
+	assert.Equal(t, before, queries())
+	want, err := direct.DatasetGet(ctx, "tank/k8s/vol1")
+	assert.NoError(t, err)
+	assert.Equal(t, want, cached)
+
+	// Datasets outside the parent, and queries the cache can't evaluate, go to TrueNAS
+	before = queries()
+	_, err = client.DatasetGet(ctx, "tank/k8s")
+	assert.NoError(t, err)
+	_, err = client.DatasetList(ctx, "tank/k8s", 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, before+2, queries())
+
+	// Changes made elsewhere arrive as events
+	assert.NoError(t, direct.DatasetSetUserProperty(ctx, "tank/k8s/vol1", "test:owner", "other"))
+	waitFor(func() bool {
+		value, err := client.DatasetGetUserProperty(ctx, "tank/k8s/vol1", "test:owner")
+		return err == nil && value == "other"
+	})
+	_, err = direct.DatasetCreate(ctx, &DatasetCreateParams{Name: "tank/k8s/vol2", Type: "VOLUME", Volsize: 1 << 30, Sparse: true})
+	assert.NoError(t, err)
+	waitFor(func() bool {
+		before := queries()
+		_, err := client.DatasetGet(ctx, "tank/k8s/vol2")
+		return err == nil && queries() == before
+	})
+
+	// Our own writes are never answered with the row from before them
+	for _, owner := range []string{"a", "b", "c"} {
+		assert.NoError(t, client.DatasetSetUserProperty(ctx, "tank/k8s/vol1", "test:owner", owner))
+		value, err := client.DatasetGetUserProperty(ctx, "tank/k8s/vol1", "test:owner")
+		assert.NoError(t, err)
+		assert.Equal(t, owner, value)
+	}
+
+	// Removed rows are gone, and a miss is confirmed with TrueNAS
+	assert.NoError(t, direct.DatasetDelete(ctx, "tank/k8s/vol2", false, false))
+	waitFor(func() bool {
+		_, err := client.DatasetGet(ctx, "tank/k8s/vol2")
+		return err != nil
+	})
+
+	// Numeric IDs and other collections
+	target, err := client.ISCSITargetCreate(ctx, "vol1", "", "ISCSI", nil)
+	assert.NoError(t, err)
+	waitFor(func() bool {
+		before := server.CallCount("iscsi.target.query")
+		got, err := client.ISCSITargetFindByName(ctx, "vol1")
+		return err == nil && got.ID == target.ID && server.CallCount("iscsi.target.query") == before
+	})
+
+	// While disconnected every call goes to TrueNAS, until the cache has synced again
+	server.Disconnect()
+	waitFor(func() bool { return !cacheSynced(client) })
+	before = queries()
+	_, err = client.DatasetGet(ctx, "tank/k8s/vol1")
+	assert.NoError(t, err)
+	assert.Greater(t, queries(), before)
+	waitFor(func() bool { return cacheSynced(client) })
+	before = queries()
+	value, err := client.DatasetGetUserProperty(ctx, "tank/k8s/vol1", "test:owner")
+	assert.NoError(t, err)
+	assert.Equal(t, "c", value)
+	assert.Equal(t, before, queries())
+}
+
+func TestObjectCacheDelayedCreateEvent(t *testing.T) {
+	ctx := context.Background()
+	server, direct := newFakeClient(t, faketruenas.Config{APIKey: "1-valid"})
+	_, err := direct.DatasetCreate(ctx, &DatasetCreateParams{Name: "tank/k8s", Type: "FILESYSTEM"})
+	assert.NoError(t, err)
+	_, err = direct.DatasetCreate(ctx, &DatasetCreateParams{Name: "tank/k8s/vol1", Type: "VOLUME", Volsize: 1 << 30, Sparse: true})
+	assert.NoError(t, err)
+	_, err = direct.SnapshotCreate(ctx, "tank/k8s/vol1", "a")
+	assert.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		Host:           server.Host(),
+		Port:           server.Port(),
+		Protocol:       "http",
+		APIKey:         "1-valid",
+		MaxConnections: 1,
+		CacheParent:    "tank/k8s",
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	assert.Eventually(t, func() bool { return cacheSynced(client) }, 5*time.Second, 10*time.Millisecond)
+	queries := func() int { return server.CallCount("pool.snapshot.query") }
+
+	// Until the event adding a snapshot we created arrives, lists include it from TrueNAS
+	server.PauseEvents()
+	_, err = client.SnapshotCreate(ctx, "tank/k8s/vol1", "b")
+	assert.NoError(t, err)
+	before := queries()
+	snapshots, err := client.SnapshotList(ctx, "tank/k8s/vol1")
+	assert.NoError(t, err)
+	assert.Len(t, snapshots, 2)
+	assert.Greater(t, queries(), before)
+
+	// and once it has, from the cache
+	server.ResumeEvents()
+	assert.Eventually(t, func() bool {
+		before := queries()
+		snapshots, err := client.SnapshotList(ctx, "tank/k8s/vol1")
+		return err == nil && len(snapshots) == 2 && queries() == before
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// A failed create leaves nothing to wait for
+	_, err = client.SnapshotCreate(ctx, "tank/k8s/missing", "b")
+	assert.Error(t, err)
+	before = queries()
+	_, err = client.SnapshotList(ctx, "tank/k8s/vol1")
+	assert.NoError(t, err)
+	assert.Equal(t, before, queries())
+}
+
+func TestObjectCacheFilters(t *testing.T) {
+	filters, ok := equalityFilters([]interface{}{[][]interface{}{{"name", "=", "vol1"}}, map[string]interface{}{}})
+	assert.True(t, ok)
+	assert.Equal(t, [][]interface{}{{"name", "=", "vol1"}}, filters)
+	assert.True(t, matchesFilters(map[string]interface{}{"name": "vol1", "id": float64(1)}, filters))
+	assert.False(t, matchesFilters(map[string]interface{}{"name": "vol2"}, filters))
+
+	for _, params := range [][]interface{}{
+		nil,
+		{[]interface{}{}},
+		{[][]interface{}{{"name", "^", "vol"}}},
+		{[][]interface{}{{"name", "=", "vol1"}}, map[string]interface{}{"limit": 1}},
+		{[]interface{}{[]interface{}{"OR", []interface{}{}}}},
+	} {
+		_, ok := equalityFilters(params)
+		assert.False(t, ok, "%v", params)
+	}
+}
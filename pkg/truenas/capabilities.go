@@ -0,0 +1,187 @@
+package truenas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"k8s.io/klog/v2"
+)
+
+// Oldest TrueNAS SCALE release the driver supports, and the first with NVMe-oF.
+const (
+	minSupportedMajor, minSupportedMinor = 25, 4
+	minNVMeoFMajor, minNVMeoFMinor       = 25, 10
+)
+
+// versionPattern finds the release in system.version, which is "25.04.2" on current
+// releases and "TrueNAS-SCALE-24.10.2" on older ones.
+var versionPattern = regexp.MustCompile(`(\d+)\.(\d+)`)
+
+// Capabilities describes the API of the connected TrueNAS release, detected from
+// system.version and by probing the methods and fields that differ between releases.
+type Capabilities struct {
+	Version           string   // As reported by system.version
+	Major             int      // Parsed from Version, zero if it couldn't be
+	Minor             int      // Parsed from Version
+	SnapshotPrefix    string   // "pool.snapshot" (25.04+) or "zfs.snapshot" (24.x)
+	NVMeoF            bool     // nvmet.* is available (25.10+)
+	ISCSIExtentFields []string // Fields iscsi.extent.create accepts, nil if unknown
+}
+
+// AtLeast reports whether the release is major.minor or later. An unparsed version is
+// assumed to be recent.
+func (c *Capabilities) AtLeast(major, minor int) bool {
+	if c.Major == 0 {
+		return true
+	}
+	return c.Major > major || (c.Major == major && c.Minor >= minor)
+}
+
+// Check returns an error if the release can't serve shareType ("nfs", "iscsi" or "nvmeof").
+func (c *Capabilities) Check(shareType string) error {
+	if !c.AtLeast(minSupportedMajor, minSupportedMinor) {
+		return fmt.Errorf("TrueNAS %s is not supported: the driver requires TrueNAS SCALE %d.%02d or later",
+			c.Version, minSupportedMajor, minSupportedMinor)
+	}
+	if shareType == "nvmeof" && !c.NVMeoF {
+		return fmt.Errorf("TrueNAS %s has no NVMe-oF API (nvmet.*): NVMe-oF requires TrueNAS SCALE %d.%d or later",
+			c.Version, minNVMeoFMajor, minNVMeoFMinor)
+	}
+	return nil
+}
+
+// acceptsISCSIExtentField reports whether iscsi.extent.create takes field.
+func (c *Capabilities) acceptsISCSIExtentField(field string) bool {
+	return c.ISCSIExtentFields == nil || slices.Contains(c.ISCSIExtentFields, field)
+}
+
+// Capabilities returns the capabilities of the connected release. They are detected on
+// first use and again after any pooled connection reconnects or the pool is rebuilt,
+// so an upgrade of TrueNAS is picked up without restarting the driver.
+func (c *Client) Capabilities(ctx context.Context) (*Capabilities, error) {
+	c.capMu.Lock()
+	defer c.capMu.Unlock()
+
+	pool := c.currentPool()
+	var connects uint64
+	if pool != nil {
+		connects = atomic.LoadUint64(&pool.connects)
+	}
+	if c.caps != nil && c.capsPool == pool && c.capsConnects == connects {
+		return c.caps, nil
+	}
+
+	caps, err := c.detectCapabilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if c.caps == nil || c.caps.String() != caps.String() {
+		klog.Infof("Detected TrueNAS capabilities: %s", caps)
+	}
+	c.caps, c.capsPool, c.capsConnects = caps, pool, connects
+	c.lastCaps.Store(caps)
+	return caps, nil
+}
+
+// CachedCapabilities returns the last detected capabilities, or nil if none have been
+// detected yet. Unlike Capabilities it never calls TrueNAS or waits for a detection in
+// progress, so the result may predate a reconnect.
+func (c *Client) CachedCapabilities() *Capabilities {
+	return c.lastCaps.Load()
+}
+
+// String summarizes the capabilities for logs.
+func (c *Capabilities) String() string {
+	return fmt.Sprintf("version=%s snapshots=%s nvmeof=%t iscsiExtentFields=%d",
+		c.Version, c.SnapshotPrefix, c.NVMeoF, len(c.ISCSIExtentFields))
+}
+
+// detectCapabilities queries the version and probes the API.
+func (c *Client) detectCapabilities(ctx context.Context) (*Capabilities, error) {
+	caps := &Capabilities{}
+	result, err := c.Call(ctx, "system.version")
+	var apiErr *APIError
+	if err != nil && !errors.As(err, &apiErr) {
+		return nil, fmt.Errorf("failed to get TrueNAS version: %w", err)
+	}
+	caps.Version, _ = result.(string)
+	if m := versionPattern.FindStringSubmatch(caps.Version); m != nil {
+		caps.Major, _ = strconv.Atoi(m[1])
+		caps.Minor, _ = strconv.Atoi(m[2])
+	}
+
+	// TrueNAS 25.04 moved zfs.snapshot.* to pool.snapshot.*
+	caps.SnapshotPrefix = "zfs.snapshot"
+	for _, prefix := range []string{"pool.snapshot", "zfs.snapshot"} {
+		ok, err := c.probe(ctx, prefix+".query", []interface{}{}, map[string]interface{}{"limit": 1})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			caps.SnapshotPrefix = prefix
+			break
+		}
+	}
+
+	if caps.NVMeoF, err = c.probe(ctx, "nvmet.subsys.query", []interface{}{}, map[string]interface{}{"limit": 1}); err != nil {
+		return nil, err
+	}
+
+	if caps.ISCSIExtentFields, err = c.methodFields(ctx, "iscsi.extent", "iscsi.extent.create"); err != nil {
+		return nil, err
+	}
+	return caps, nil
+}
+
+// probe calls method and reports whether TrueNAS served it. Only errors that didn't come
+// from TrueNAS, such as a lost connection, are returned.
+func (c *Client) probe(ctx context.Context, method string, params ...interface{}) (bool, error) {
+	_, err := c.Call(ctx, method, params...)
+	if err == nil {
+		return true, nil
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		klog.V(4).Infof("Probe of %s failed: %v", method, err)
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to probe %s: %w", method, err)
+}
+
+// methodFields returns the fields the first argument of method accepts, according to the
+// schema core.get_methods reports for service. It is nil if the schema isn't available.
+func (c *Client) methodFields(ctx context.Context, service, method string) ([]string, error) {
+	result, err := c.Call(ctx, "core.get_methods", service)
+	if err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			klog.V(4).Infof("core.get_methods(%s) failed: %v", service, err)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get %s methods: %w", service, err)
+	}
+
+	methods, _ := result.(map[string]interface{})
+	info, _ := methods[method].(map[string]interface{})
+	accepts, _ := info["accepts"].([]interface{})
+	if len(accepts) == 0 {
+		return nil, nil
+	}
+	schema, _ := accepts[0].(map[string]interface{})
+	properties, ok := schema["properties"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	fields := make([]string, 0, len(properties))
+	for field := range properties {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
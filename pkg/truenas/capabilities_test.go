@@ -0,0 +1,105 @@
+package truenas
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas/faketruenas"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapabilitiesAgainstFake(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       faketruenas.Config
+		want      Capabilities
+		supported map[string]bool
+	}{
+		{
+			name:      "25.10",
+			cfg:       faketruenas.Config{},
+			want:      Capabilities{Version: "25.10.0", Major: 25, Minor: 10, SnapshotPrefix: "pool.snapshot", NVMeoF: true},
+			supported: map[string]bool{"nfs": true, "iscsi": true, "nvmeof": true},
+		},
+		{
+			name:      "25.04",
+			cfg:       faketruenas.Config{Version: "25.04.2"},
+			want:      Capabilities{Version: "25.04.2", Major: 25, Minor: 4, SnapshotPrefix: "pool.snapshot"},
+			supported: map[string]bool{"nfs": true, "iscsi": true, "nvmeof": false},
+		},
+		{
+			name:      "24.10",
+			cfg:       faketruenas.Config{Version: "TrueNAS-SCALE-24.10.2", SnapshotAPIPrefix: "zfs.snapshot"},
+			want:      Capabilities{Version: "TrueNAS-SCALE-24.10.2", Major: 24, Minor: 10, SnapshotPrefix: "zfs.snapshot"},
+			supported: map[string]bool{"nfs": false, "iscsi": false, "nvmeof": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, client := newFakeClient(t, tt.cfg)
+			caps, err := client.Capabilities(context.Background())
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Contains(t, caps.ISCSIExtentFields, "insecure_tpc")
+			caps.ISCSIExtentFields = nil
+			assert.Equal(t, tt.want, *caps)
+			for shareType, supported := range tt.supported {
+				assert.Equal(t, supported, caps.Check(shareType) == nil, shareType)
+			}
+		})
+	}
+}
+
+func TestCapabilitiesRedetectedAfterReconnect(t *testing.T) {
+	ctx := context.Background()
+	server, client := newFakeClient(t, faketruenas.Config{})
+
+	_, err := client.Capabilities(ctx)
+	assert.NoError(t, err)
+	_, err = client.Capabilities(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, server.CallCount("system.version"))
+
+	// TrueNAS may have been upgraded while the connection was down
+	server.Disconnect()
+	assert.Eventually(t, func() bool { return !client.IsConnected() }, 5*time.Second, 10*time.Millisecond)
+	_, err = client.DatasetGet(ctx, "tank")
+	assert.NoError(t, err)
+	_, err = client.Capabilities(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, server.CallCount("system.version"))
+}
+
+func TestCachedCapabilities(t *testing.T) {
+	_, client := newFakeClient(t, faketruenas.Config{})
+
+	// Test Case 1: Nothing is cached before the first detection
+	assert.Nil(t, client.CachedCapabilities())
+
+	// Test Case 2: The detected capabilities are returned
+	caps, err := client.Capabilities(context.Background())
+	assert.NoError(t, err)
+	assert.Same(t, caps, client.CachedCapabilities())
+
+	// Test Case 3: A detection in progress doesn't block the cached value
+	client.capMu.Lock()
+	defer client.capMu.Unlock()
+	assert.Same(t, caps, client.CachedCapabilities())
+}
+
+func TestISCSIExtentCreateOmitsUnsupportedFields(t *testing.T) {
+	ctx := context.Background()
+	server, client := newFakeClient(t, faketruenas.Config{
+		ISCSIExtentFields: []string{"name", "type", "disk", "comment", "blocksize", "pblocksize", "rpm", "ro", "enabled"},
+	})
+	_, err := client.DatasetCreate(ctx, &DatasetCreateParams{Name: "tank/vol", Type: "VOLUME", Volsize: 1 << 30, Sparse: true})
+	assert.NoError(t, err)
+
+	// insecure_tpc would be rejected as an extra input
+	extent, err := client.ISCSIExtentCreate(ctx, "vol", "zvol/tank/vol", "", 4096, "SSD")
+	assert.NoError(t, err)
+	assert.Equal(t, "vol", extent.Name)
+	assert.Equal(t, 1, server.CallCount("iscsi.extent.create"))
+}
@@ -6,69 +6,25 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net/http"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/klog/v2"
 )
 
-// APIError represents an error from the TrueNAS API.
-type APIError struct {
-	Code    int
-	Message string
-	Data    interface{}
-}
-
-func (e *APIError) Error() string {
-	return fmt.Sprintf("TrueNAS API error [%d]: %s", e.Code, e.Message)
-}
-
-// IsNotFoundError returns true if the error indicates a resource was not found.
-func IsNotFoundError(err error) bool {
-	if err == nil {
-		return false
-	}
-	if apiErr, ok := err.(*APIError); ok {
-		// Common "not found" error codes from TrueNAS
-		return apiErr.Code == -1 || strings.Contains(strings.ToLower(apiErr.Message), "not found") ||
-			strings.Contains(strings.ToLower(apiErr.Message), "does not exist")
-	}
-	errStr := strings.ToLower(err.Error())
-	return strings.Contains(errStr, "not found") || strings.Contains(errStr, "does not exist")
-}
-
-// IsAlreadyExistsError returns true if the error indicates a resource already exists.
-func IsAlreadyExistsError(err error) bool {
-	if err == nil {
-		return false
-	}
-	if apiErr, ok := err.(*APIError); ok {
-		return strings.Contains(strings.ToLower(apiErr.Message), "already exists")
-	}
-	return strings.Contains(strings.ToLower(err.Error()), "already exists")
-}
-
-// IsConnectionError returns true if the error indicates a connection problem.
-func IsConnectionError(err error) bool {
-	if err == nil {
-		return false
-	}
-	errStr := strings.ToLower(err.Error())
-	return strings.Contains(errStr, "connection") ||
-		strings.Contains(errStr, "timeout") ||
-		strings.Contains(errStr, "refused") ||
-		strings.Contains(errStr, "connection lost")
-}
-
 // ClientConfig holds the configuration for the TrueNAS client.
 type ClientConfig struct {
 	Host              string
 	Port              int
 	Protocol          string
 	APIKey            string
+	Username          string // Used with Password when APIKey is empty
+	Password          string
+	TokenTTL          time.Duration // Lifetime of tokens used to reconnect after a password login (0 disables)
 	AllowInsecure     bool
 	Timeout           time.Duration
 	ConnectTimeout    time.Duration
@@ -77,6 +33,14 @@ type ClientConfig struct {
 	HeartbeatInterval time.Duration // Interval for WebSocket heartbeat (default: 30s)
 	MaxConnections    int           // Maximum number of concurrent connections (default: 5)
 	MaxConcurrentReqs int           // Maximum number of concurrent API requests (default: 10)
+	TargetLatency     time.Duration // Calls slower than this lower the concurrency limit (default: 5s)
+	BreakerThreshold  int           // Consecutive timed out or failed calls that open the circuit breaker (default: 5, negative disables)
+	BreakerCooldown   time.Duration // How long the open breaker refuses calls before a trial call (default: 10s)
+	TLS               TLSConfig     // CA bundle, pinning and client certificate settings
+	RecordFile        string        // Appends every API call, with credentials redacted, to this JSONL file
+	CacheParent       string        // Caches objects under this dataset, kept fresh by core.subscribe ("" disables)
+
+	tlsBuilder *tlsConfigBuilder // Built from TLS by NewClient
 }
 
 // writeRequest represents a request to be written to the WebSocket.
@@ -123,6 +87,19 @@ type Connection struct {
 	closeMu             sync.Mutex
 	writeLoopDoneClosed bool
 	heartbeatDoneClosed bool
+
+	// Credentials, shared with the rest of the pool
+	auth *authenticator
+
+	// Counts successful connects, shared with the rest of the pool
+	connects *uint64
+
+	// Successful connects of this connection (atomic); subscriptions end with each one
+	generation uint64
+
+	// Receives notifications, such as core.subscribe events, on the read loop
+	eventMu sync.RWMutex
+	onEvent func(method string, params interface{})
 }
 
 // NewConnection creates a new connection instance.
@@ -134,6 +111,7 @@ func NewConnection(id int, cfg *ClientConfig) *Connection {
 		writeCh:       make(chan writeRequest, 100),
 		writeLoopDone: make(chan struct{}),
 		heartbeatDone: make(chan struct{}),
+		auth:          newAuthenticator(cfg),
 	}
 	c.connCond = sync.NewCond(&c.connMu)
 	return c
@@ -141,10 +119,31 @@ func NewConnection(id int, cfg *ClientConfig) *Connection {
 
 // Client is a TrueNAS API client using WebSocket JSON-RPC 2.0 with connection pooling.
 type Client struct {
-	config    *ClientConfig
-	pool      []*Connection
-	next      uint64        // For round-robin selection
-	semaphore chan struct{} // Limits concurrent requests to prevent TrueNAS overload
+	mu   sync.RWMutex
+	pool *connPool // Replaced by Reconfigure
+	next uint64    // For round-robin selection
+
+	replay *replayer // Answers calls from recordings instead of the pool (NewReplayClient)
+
+	// Detected by Capabilities; redetected when capsPool reconnects or is replaced
+	capMu        sync.Mutex
+	caps         *Capabilities
+	capsPool     *connPool
+	capsConnects uint64
+	// Last detected capabilities, read by CachedCapabilities without taking capMu
+	lastCaps atomic.Pointer[Capabilities]
+}
+
+// connPool is a set of connections sharing one configuration.
+type connPool struct {
+	config   *ClientConfig
+	conns    []*Connection
+	limiter  *limiter     // Adapts concurrent requests to how TrueNAS copes
+	breaker  *breaker     // Fails calls fast while TrueNAS isn't answering (nil if disabled)
+	recorder *recorder    // Set when config.RecordFile is
+	cache    *objectCache // Set when config.CacheParent is
+	dedup    dedup        // Shares requests between identical reads in flight
+	connects uint64       // Successful connects of any connection (atomic)
 }
 
 // rpcRequest is a JSON-RPC 2.0 request.
@@ -155,12 +154,17 @@ type rpcRequest struct {
 	Params  []interface{} `json:"params,omitempty"`
 }
 
-// rpcResponse is a JSON-RPC 2.0 response.
+// rpcResponse is a JSON-RPC 2.0 response. Notifications carry Method and Params instead
+// of an ID.
 type rpcResponse struct {
 	JSONRPC string      `json:"jsonrpc"`
 	ID      int64       `json:"id"`
 	Result  interface{} `json:"result,omitempty"`
 	Error   *rpcError   `json:"error,omitempty"`
+	Method  string      `json:"method,omitempty"`
+	Params  interface{} `json:"params,omitempty"`
+
+	lost bool // The connection closed before a response arrived
 }
 
 // rpcError is a JSON-RPC 2.0 error.
@@ -172,11 +176,57 @@ type rpcError struct {
 
 // NewClient creates a new TrueNAS API client.
 func NewClient(cfg *ClientConfig) (*Client, error) {
+	pool, err := newConnPool(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{pool: pool}
+	c.attachCache(pool)
+	return c, nil
+}
+
+// attachCache gives pool an object cache if its configuration asks for one.
+func (c *Client) attachCache(pool *connPool) {
+	if pool.config.CacheParent != "" {
+		pool.cache = newObjectCache(pool, pool.config.CacheParent, c.Capabilities)
+		pool.cache.syncInBackground()
+	}
+}
+
+// Reconfigure replaces the connection pool with one built from cfg. The new pool must
+// connect before it is swapped in, so a bad configuration leaves the client unchanged.
+// Connections, the request limiter and the circuit breaker belong to the pool and are
+// swapped together under c.mu, so a new connection count and request limit take effect
+// at once; calls started on the old pool finish with its limits. The old pool is closed
+// once in-flight requests have had time to finish.
+func (c *Client) Reconfigure(cfg *ClientConfig) error {
+	if c.replay != nil {
+		return fmt.Errorf("a replay client can't be reconfigured")
+	}
+	pool, err := newConnPool(cfg)
+	if err != nil {
+		return err
+	}
+	c.attachCache(pool)
+
+	c.mu.Lock()
+	old := c.pool
+	c.pool = pool
+	c.mu.Unlock()
+
+	klog.Infof("TrueNAS connection pool rebuilt for %s:%d", cfg.Host, cfg.Port)
+	time.AfterFunc(old.config.Timeout, func() { _ = old.close() })
+	return nil
+}
+
+// newConnPool applies defaults to cfg and connects a new pool, failing only if no
+// connection can be established.
+func newConnPool(cfg *ClientConfig) (*connPool, error) {
 	if cfg.Host == "" {
 		return nil, fmt.Errorf("host is required")
 	}
-	if cfg.APIKey == "" {
-		return nil, fmt.Errorf("api key is required")
+	if cfg.APIKey == "" && (cfg.Username == "" || cfg.Password == "") {
+		return nil, fmt.Errorf("api key or username and password are required")
 	}
 	if cfg.Timeout == 0 {
 		cfg.Timeout = 60 * time.Second
@@ -209,16 +259,46 @@ func NewClient(cfg *ClientConfig) (*Client, error) {
 	if cfg.MaxConcurrentReqs == 0 {
 		cfg.MaxConcurrentReqs = 10 // Limit concurrent requests to prevent overwhelming TrueNAS
 	}
+	if cfg.TargetLatency == 0 {
+		cfg.TargetLatency = 5 * time.Second
+	}
+	if cfg.BreakerThreshold == 0 {
+		cfg.BreakerThreshold = 5
+	}
+	if cfg.BreakerCooldown == 0 {
+		cfg.BreakerCooldown = 10 * time.Second
+	}
+	if cfg.Protocol == "https" {
+		builder, err := newTLSConfigBuilder(cfg.TLS, cfg.Host, cfg.AllowInsecure)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS configuration: %w", err)
+		}
+		cfg.tlsBuilder = builder
+	}
 
-	client := &Client{
-		config:    cfg,
-		pool:      make([]*Connection, cfg.MaxConnections),
-		semaphore: make(chan struct{}, cfg.MaxConcurrentReqs),
+	pool := &connPool{
+		config:  cfg,
+		conns:   make([]*Connection, cfg.MaxConnections),
+		limiter: newLimiter(cfg.MaxConcurrentReqs, cfg.TargetLatency),
+	}
+	if cfg.BreakerThreshold > 0 {
+		pool.breaker = newBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown)
+	}
+	if cfg.RecordFile != "" {
+		rec, err := newRecorder(cfg.RecordFile, cfg.APIKey, cfg.Password)
+		if err != nil {
+			return nil, err
+		}
+		pool.recorder = rec
+		klog.Infof("Recording TrueNAS API calls to %s", cfg.RecordFile)
 	}
 
 	// Initialize connection pool
+	auth := newAuthenticator(cfg)
 	for i := 0; i < cfg.MaxConnections; i++ {
-		client.pool[i] = NewConnection(i, cfg)
+		pool.conns[i] = NewConnection(i, cfg)
+		pool.conns[i].auth = auth
+		pool.conns[i].connects = &pool.connects
 	}
 
 	// Connect initially (at least one connection)
@@ -228,7 +308,7 @@ func NewClient(cfg *ClientConfig) (*Client, error) {
 	var wg sync.WaitGroup
 
 	var errMu sync.Mutex
-	for _, conn := range client.pool {
+	for _, conn := range pool.conns {
 		wg.Add(1)
 		go func(c *Connection) {
 			defer wg.Done()
@@ -242,7 +322,7 @@ func NewClient(cfg *ClientConfig) (*Client, error) {
 	wg.Wait()
 
 	// Check how many connected
-	for _, conn := range client.pool {
+	for _, conn := range pool.conns {
 		if conn.IsConnected() {
 			connected++
 		}
@@ -250,12 +330,39 @@ func NewClient(cfg *ClientConfig) (*Client, error) {
 
 	if connected == 0 {
 		// Try one last time synchronously to get the error
-		if err := client.pool[0].connect(); err != nil {
+		if err := pool.conns[0].connect(); err != nil {
+			_ = pool.close()
 			return nil, fmt.Errorf("failed to establish any connections (last error: %v): %w", lastErr, err)
 		}
 	}
 
-	return client, nil
+	return pool, nil
+}
+
+// close closes all connections in the pool.
+func (p *connPool) close() error {
+	var lastErr error
+	for _, conn := range p.conns {
+		if err := conn.Close(); err != nil {
+			lastErr = err
+		}
+	}
+	if p.cache != nil {
+		p.cache.close()
+	}
+	if p.recorder != nil {
+		if err := p.recorder.close(); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// currentPool returns the active connection pool.
+func (c *Client) currentPool() *connPool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.pool
 }
 
 // connect establishes the WebSocket connection and authenticates.
@@ -310,7 +417,10 @@ func (c *Connection) connectWithRetry() error {
 	dialer := websocket.Dialer{
 		HandshakeTimeout: c.config.ConnectTimeout,
 	}
-	if c.config.AllowInsecure {
+	if c.config.tlsBuilder != nil {
+		// Rebuilt per connect so rotated CA bundles and client certificates are picked up
+		dialer.TLSClientConfig = c.config.tlsBuilder.build()
+	} else if c.config.AllowInsecure {
 		dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	}
 
@@ -343,24 +453,33 @@ func (c *Connection) connectWithRetry() error {
 			continue
 		}
 
+		// The loops get their channels as arguments, since the fields are replaced on reconnect
+		writeCh := make(chan writeRequest, 100)
+		writeLoopDone := make(chan struct{})
+		heartbeatDone := make(chan struct{})
+
 		c.mu.Lock()
 		c.conn = conn
 		c.closed = false
-		c.writeCh = make(chan writeRequest, 100)
-		c.writeLoopDone = make(chan struct{})
-		c.heartbeatDone = make(chan struct{})
+		c.writeCh = writeCh
 		c.mu.Unlock()
 
 		c.closeMu.Lock()
+		c.writeLoopDone = writeLoopDone
+		c.heartbeatDone = heartbeatDone
 		c.writeLoopDoneClosed = false
 		c.heartbeatDoneClosed = false
 		c.closeMu.Unlock()
 
 		go c.readMessages()
-		go c.writeLoop()
+		go c.writeLoop(writeCh, writeLoopDone)
 
-		if err := c.authenticateDirect(); err != nil {
+		if err := c.auth.login(c); err != nil {
 			c.cleanupConnection()
+			if IsAuthError(err) {
+				// Retrying won't help until the credentials are fixed
+				return fmt.Errorf("authentication failed: %w", err)
+			}
 			lastErr = fmt.Errorf("authentication failed: %w", err)
 			continue
 		}
@@ -370,7 +489,11 @@ func (c *Connection) connectWithRetry() error {
 		atomic.StoreInt64(&c.lastPong, time.Now().Unix())
 		c.mu.Unlock()
 
-		go c.heartbeatLoop()
+		go c.heartbeatLoop(heartbeatDone)
+		atomic.AddUint64(&c.generation, 1)
+		if c.connects != nil {
+			atomic.AddUint64(c.connects, 1)
+		}
 
 		klog.Infof("Conn %d: Connected and authenticated", c.id)
 		return nil
@@ -401,8 +524,9 @@ func (c *Connection) cleanupConnection() {
 	c.closeMu.Unlock()
 }
 
-// authenticateDirect performs API key authentication using direct write.
-func (c *Connection) authenticateDirect() error {
+// callDirect makes a JSON-RPC call by writing to the WebSocket directly. It is used for
+// authentication, before the connection is marked connected.
+func (c *Connection) callDirect(method string, params ...interface{}) (interface{}, error) {
 	c.mu.Lock()
 	c.messageID++
 	id := c.messageID
@@ -410,14 +534,14 @@ func (c *Connection) authenticateDirect() error {
 	c.mu.Unlock()
 
 	if conn == nil {
-		return fmt.Errorf("no connection")
+		return nil, &ConnectionError{Method: method, Err: fmt.Errorf("no connection")}
 	}
 
 	req := rpcRequest{
 		JSONRPC: "2.0",
 		ID:      id,
-		Method:  "auth.login_with_api_key",
-		Params:  []interface{}{c.config.APIKey},
+		Method:  method,
+		Params:  params,
 	}
 
 	respChan := make(chan *rpcResponse, 1)
@@ -429,38 +553,39 @@ func (c *Connection) authenticateDirect() error {
 		c.pendingMu.Lock()
 		delete(c.pending, id)
 		c.pendingMu.Unlock()
-		return fmt.Errorf("failed to send auth request: %w", err)
+		return nil, &ConnectionError{Method: method, Err: fmt.Errorf("failed to send %s request: %w", method, err)}
 	}
 
 	select {
 	case resp := <-respChan:
+		if resp.lost {
+			return nil, &ConnectionError{Method: method, Err: errConnectionLost}
+		}
+		// Any response (including API errors) proves the connection is alive
+		atomic.StoreInt64(&c.lastPong, time.Now().Unix())
 		if resp.Error != nil {
-			return &APIError{
+			return nil, &APIError{
 				Code:    resp.Error.Code,
 				Message: resp.Error.Message,
 				Data:    resp.Error.Data,
 			}
 		}
-		success, ok := resp.Result.(bool)
-		if !ok || !success {
-			return fmt.Errorf("authentication returned unexpected result: %v", resp.Result)
-		}
-		return nil
+		return resp.Result, nil
 	case <-time.After(c.config.Timeout):
 		c.pendingMu.Lock()
 		delete(c.pending, id)
 		c.pendingMu.Unlock()
-		return fmt.Errorf("authentication timeout")
+		return nil, &ConnectionError{Method: method, Err: fmt.Errorf("%s timeout", method)}
 	}
 }
 
 // writeLoop handles all WebSocket writes.
-func (c *Connection) writeLoop() {
+func (c *Connection) writeLoop(writeCh <-chan writeRequest, done <-chan struct{}) {
 	for {
 		select {
-		case <-c.writeLoopDone:
+		case <-done:
 			return
-		case req, ok := <-c.writeCh:
+		case req, ok := <-writeCh:
 			if !ok {
 				return
 			}
@@ -485,13 +610,15 @@ func (c *Connection) writeLoop() {
 }
 
 // heartbeatLoop sends periodic pings.
-func (c *Connection) heartbeatLoop() {
+func (c *Connection) heartbeatLoop(done <-chan struct{}) {
 	ticker := time.NewTicker(c.config.HeartbeatInterval)
 	defer ticker.Stop()
 
+	c.refreshTokenIfNeeded()
+
 	for {
 		select {
-		case <-c.heartbeatDone:
+		case <-done:
 			return
 		case <-ticker.C:
 			c.mu.RLock()
@@ -517,11 +644,26 @@ func (c *Connection) heartbeatLoop() {
 				}
 			} else {
 				atomic.StoreInt64(&c.lastPong, time.Now().Unix())
+				c.refreshTokenIfNeeded()
 			}
 		}
 	}
 }
 
+// refreshTokenIfNeeded generates a new auth token when password logins are in use and
+// the current token is missing or past half its lifetime.
+func (c *Connection) refreshTokenIfNeeded() {
+	if !c.auth.startRefresh() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.auth.refreshToken(ctx, c); err != nil {
+		// Reconnects fall back to the password, so this isn't fatal
+		klog.Warningf("Conn %d: %v", c.id, err)
+	}
+}
+
 // readMessages reads incoming WebSocket messages.
 func (c *Connection) readMessages() {
 	for {
@@ -546,6 +688,16 @@ func (c *Connection) readMessages() {
 			return
 		}
 
+		if resp.Method != "" {
+			c.eventMu.RLock()
+			onEvent := c.onEvent
+			c.eventMu.RUnlock()
+			if onEvent != nil {
+				onEvent(resp.Method, resp.Params)
+			}
+			continue
+		}
+
 		c.pendingMu.Lock()
 		if ch, ok := c.pending[resp.ID]; ok {
 			ch <- &resp
@@ -583,13 +735,7 @@ func (c *Connection) handleDisconnect() {
 	c.pendingMu.Lock()
 	for id, ch := range c.pending {
 		select {
-		case ch <- &rpcResponse{
-			ID: id,
-			Error: &rpcError{
-				Code:    -1,
-				Message: "connection lost",
-			},
-		}:
+		case ch <- &rpcResponse{ID: id, lost: true}:
 		default:
 		}
 		delete(c.pending, id)
@@ -607,7 +753,10 @@ func (c *Connection) CallWithContext(ctx context.Context, method string, params
 
 	if conn == nil {
 		if err := c.connect(); err != nil {
-			return nil, err
+			if IsAuthError(err) {
+				return nil, err
+			}
+			return nil, &ConnectionError{Method: method, Err: err}
 		}
 	}
 
@@ -649,7 +798,7 @@ func (c *Connection) CallWithContext(ctx context.Context, method string, params
 			c.pendingMu.Lock()
 			delete(c.pending, id)
 			c.pendingMu.Unlock()
-			return nil, fmt.Errorf("failed to send request: %w", err)
+			return nil, &ConnectionError{Method: method, Err: fmt.Errorf("failed to send request: %w", err)}
 		}
 	case <-ctx.Done():
 		c.pendingMu.Lock()
@@ -662,6 +811,9 @@ func (c *Connection) CallWithContext(ctx context.Context, method string, params
 
 	select {
 	case resp := <-respChan:
+		if resp.lost {
+			return nil, &ConnectionError{Method: method, Err: errConnectionLost}
+		}
 		if resp.Error != nil {
 			return nil, &APIError{
 				Code:    resp.Error.Code,
@@ -674,7 +826,7 @@ func (c *Connection) CallWithContext(ctx context.Context, method string, params
 		c.pendingMu.Lock()
 		delete(c.pending, id)
 		c.pendingMu.Unlock()
-		return nil, fmt.Errorf("request timeout: %s", method)
+		return nil, &ConnectionError{Method: method, Err: fmt.Errorf("request timeout: %s: %w", method, ctx.Err())}
 	}
 }
 
@@ -728,17 +880,71 @@ func (c *Client) Call(ctx context.Context, method string, params ...interface{})
 }
 
 // CallWithContext makes a JSON-RPC call with a context using the connection pool.
-// Uses a semaphore to limit concurrent requests and prevent overwhelming TrueNAS.
+// Concurrent requests are limited adaptively (see WithPriority), and fail fast with
+// ErrCircuitOpen while TrueNAS keeps timing out or dropping connections.
 // Implements automatic retry on connection errors with exponential backoff.
+// Each call is recorded as a client span that nests under any span in ctx, and appended
+// to the record file if one is configured. Queries the object cache can answer don't
+// reach TrueNAS.
 func (c *Client) CallWithContext(ctx context.Context, method string, params ...interface{}) (interface{}, error) {
-	// Acquire semaphore slot (limit concurrent requests)
-	select {
-	case c.semaphore <- struct{}{}:
-		// Got a slot, continue
-	case <-ctx.Done():
-		return nil, fmt.Errorf("context cancelled while waiting for request slot: %w", ctx.Err())
+	if c.replay != nil {
+		return c.replay.call(ctx, method, params)
+	}
+
+	pool := c.currentPool()
+	if pool.cache != nil {
+		if result, ok := pool.cache.lookup(method, params); ok {
+			return result, nil
+		}
+		pool.cache.invalidate(method, params)
 	}
-	defer func() { <-c.semaphore }() // Release slot when done
+
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "truenas "+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "jsonrpc"),
+			attribute.String("rpc.method", method),
+			attribute.String("server.address", pool.config.Host),
+		),
+	)
+	result, err := pool.dedup.do(ctx, method, params, func(ctx context.Context) (interface{}, error) {
+		return c.callWithRetry(ctx, pool, span, method, params...)
+	})
+	endSpan(span, err)
+	if pool.cache != nil {
+		pool.cache.created(method, result, err)
+	}
+	if pool.recorder != nil {
+		pool.recorder.record(method, params, result, err, start)
+	}
+	return result, err
+}
+
+// callWithRetry performs the concurrency-limited call with connection retries.
+func (c *Client) callWithRetry(ctx context.Context, pool *connPool, span trace.Span, method string, params ...interface{}) (result interface{}, err error) {
+	var trial bool
+	if pool.breaker != nil {
+		if trial, err = pool.breaker.allow(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Acquire a request slot (limit concurrent requests)
+	priority := PriorityFromContext(ctx)
+	if err := pool.limiter.acquire(ctx, priority); err != nil {
+		// Never sent, so it says nothing about TrueNAS
+		if pool.breaker != nil {
+			pool.breaker.abandon(trial)
+		}
+		return nil, err
+	}
+	if pool.breaker != nil {
+		defer func() { pool.breaker.record(ctx, trial, err) }()
+	}
+	span.AddEvent("request slot acquired", trace.WithAttributes(attribute.String("priority", priority.String())))
+	start := time.Now()
+	defer func() { pool.limiter.release(ctx, time.Since(start), err) }()
 
 	const maxRetries = 3
 	var lastErr error
@@ -746,7 +952,7 @@ func (c *Client) CallWithContext(ctx context.Context, method string, params ...i
 
 	for attempt := 0; attempt < maxRetries; attempt++ {
 		// Select best available connection
-		conn := c.selectConnection()
+		conn := pool.selectConnection(&c.next)
 
 		result, err := conn.CallWithContext(ctx, method, params...)
 		if err == nil {
@@ -761,6 +967,11 @@ func (c *Client) CallWithContext(ctx context.Context, method string, params ...i
 
 		lastErr = err
 		klog.V(2).Infof("API call %s failed on conn %d (attempt %d/%d): %v", method, conn.id, attempt+1, maxRetries, err)
+		span.AddEvent("retry", trace.WithAttributes(
+			attribute.Int("attempt", attempt+1),
+			attribute.Int("connection", conn.id),
+			attribute.String("error", err.Error()),
+		))
 
 		// Don't retry on last attempt or if context is done
 		if attempt < maxRetries-1 {
@@ -780,15 +991,16 @@ func (c *Client) CallWithContext(ctx context.Context, method string, params ...i
 }
 
 // selectConnection selects the best available connection from the pool.
-// Prefers connected connections and uses round-robin for load balancing.
-func (c *Client) selectConnection() *Connection {
-	poolSize := uint64(len(c.pool))
+// Prefers connected connections and uses round-robin, advancing next, for load balancing.
+func (p *connPool) selectConnection(next *uint64) *Connection {
+	conns := p.conns
+	poolSize := uint64(len(conns))
 
 	// Try to find a connected connection using round-robin
-	startIdx := atomic.AddUint64(&c.next, 1) % poolSize
+	startIdx := atomic.AddUint64(next, 1) % poolSize
 	for i := uint64(0); i < poolSize; i++ {
 		idx := (startIdx + i) % poolSize
-		conn := c.pool[idx]
+		conn := conns[idx]
 		if conn.IsConnected() {
 			return conn
 		}
@@ -796,23 +1008,23 @@ func (c *Client) selectConnection() *Connection {
 
 	// No connected connections - return the round-robin selection
 	// The connection will attempt to reconnect when used
-	return c.pool[startIdx]
+	return conns[startIdx]
 }
 
 // Close closes all connections in the pool.
 func (c *Client) Close() error {
-	var lastErr error
-	for _, conn := range c.pool {
-		if err := conn.Close(); err != nil {
-			lastErr = err
-		}
+	if c.replay != nil {
+		return nil
 	}
-	return lastErr
+	return c.currentPool().close()
 }
 
 // IsConnected returns true if at least one connection is active.
 func (c *Client) IsConnected() bool {
-	for _, conn := range c.pool {
+	if c.replay != nil {
+		return true
+	}
+	for _, conn := range c.currentPool().conns {
 		if conn.IsConnected() {
 			return true
 		}
@@ -820,6 +1032,36 @@ func (c *Client) IsConnected() bool {
 	return false
 }
 
+// Available returns an error wrapping ErrCircuitOpen while the circuit breaker refuses
+// calls, so callers can fail fast before starting work that needs TrueNAS.
+func (c *Client) Available() error {
+	if c.replay != nil {
+		return nil
+	}
+	if b := c.currentPool().breaker; b != nil && b.open() {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// LastResponse returns when any pooled connection last received a response
+// (authentication, heartbeat or API call). It is the zero time if none has.
+func (c *Client) LastResponse() time.Time {
+	if c.replay != nil {
+		return time.Now()
+	}
+	var latest int64
+	for _, conn := range c.currentPool().conns {
+		if ts := atomic.LoadInt64(&conn.lastPong); ts > latest {
+			latest = ts
+		}
+	}
+	if latest == 0 {
+		return time.Time{}
+	}
+	return time.Unix(latest, 0)
+}
+
 // ServiceReload reloads a TrueNAS service configuration.
 // This is useful for forcing services like iSCSI to pick up new configuration
 // after creating targets/extents via the API.
@@ -830,7 +1072,8 @@ func (c *Client) IsConnected() bool {
 // retry logic to handle propagation delays.
 func (c *Client) ServiceReload(ctx context.Context, service string) error {
 	klog.V(4).Infof("Reloading service: %s", service)
-	_, err := c.Call(ctx, "service.reload", service)
+	// service.reload is a job on newer TrueNAS releases; CallJob waits for it either way
+	_, err := c.CallJob(ctx, "service.reload", service)
 	if err != nil {
 		// Don't try restart - it could disrupt existing sessions/connections.
 		// Just log and continue; the node-side retry logic will handle propagation delays.
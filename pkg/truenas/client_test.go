@@ -0,0 +1,273 @@
+package truenas
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas/faketruenas"
+	"github.com/stretchr/testify/assert"
+)
+
+// newFakeClient starts a fake TrueNAS and connects a single-connection client to it.
+func newFakeClient(t *testing.T, cfg faketruenas.Config) (*faketruenas.Server, *Client) {
+	t.Helper()
+	server := faketruenas.New(cfg)
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(&ClientConfig{
+		Host:              server.Host(),
+		Port:              server.Port(),
+		Protocol:          "http",
+		APIKey:            "1-valid",
+		MaxConnections:    1,
+		RetryInterval:     10 * time.Millisecond,
+		HeartbeatInterval: 50 * time.Millisecond,
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return server, client
+}
+
+func TestClientAuthAgainstFake(t *testing.T) {
+	server := faketruenas.New(faketruenas.Config{APIKey: "1-valid"})
+	defer server.Close()
+
+	_, err := NewClient(&ClientConfig{
+		Host:           server.Host(),
+		Port:           server.Port(),
+		Protocol:       "http",
+		APIKey:         "1-revoked",
+		MaxConnections: 1,
+		RetryInterval:  10 * time.Millisecond,
+	})
+	assert.Error(t, err)
+	assert.True(t, IsAuthError(err))
+}
+
+func TestClientReconnectAndHeartbeat(t *testing.T) {
+	ctx := context.Background()
+	server, client := newFakeClient(t, faketruenas.Config{APIKey: "1-valid"})
+	assert.Equal(t, 1, server.CallCount("auth.login_with_api_key"))
+
+	// A middleware restart drops the connection; the next call reconnects and logs in again
+	server.Disconnect()
+	assert.Eventually(t, func() bool { return !client.IsConnected() }, 5*time.Second, 10*time.Millisecond)
+	_, err := client.DatasetGet(ctx, "tank")
+	assert.NoError(t, err)
+	assert.True(t, client.IsConnected())
+	assert.Equal(t, 2, server.CallCount("auth.login_with_api_key"))
+
+	// Heartbeats ping the server
+	assert.Eventually(t, func() bool { return server.CallCount("core.ping") > 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestClientReconfigure(t *testing.T) {
+	ctx := context.Background()
+	server, client := newFakeClient(t, faketruenas.Config{APIKey: "1-valid"})
+	old := client.currentPool()
+
+	// The connection count and request limit change together
+	assert.NoError(t, client.Reconfigure(&ClientConfig{
+		Host:              server.Host(),
+		Port:              server.Port(),
+		Protocol:          "http",
+		APIKey:            "1-valid",
+		MaxConnections:    3,
+		MaxConcurrentReqs: 4,
+	}))
+	pool := client.currentPool()
+	assert.NotSame(t, old, pool)
+	assert.Len(t, pool.conns, 3)
+	assert.Equal(t, float64(4), pool.limiter.max)
+	_, err := client.DatasetGet(ctx, "tank")
+	assert.NoError(t, err)
+
+	// A configuration that can't connect leaves the client unchanged
+	assert.Error(t, client.Reconfigure(&ClientConfig{Host: server.Host(), Port: server.Port(), Protocol: "http"}))
+	assert.Same(t, pool, client.currentPool())
+}
+
+func TestDatasetsAgainstFake(t *testing.T) {
+	ctx := context.Background()
+	_, client := newFakeClient(t, faketruenas.Config{PoolFree: 10 << 30})
+
+	_, err := client.DatasetCreate(ctx, &DatasetCreateParams{Name: "tank/k8s"})
+	assert.NoError(t, err)
+
+	// Filesystem with a quota
+	ds, err := client.DatasetCreate(ctx, &DatasetCreateParams{Name: "tank/k8s/fs", Type: "FILESYSTEM", Refquota: 1 << 30})
+	assert.NoError(t, err)
+	assert.Equal(t, "tank/k8s/fs", ds.ID)
+	assert.Equal(t, "tank", ds.Pool)
+	assert.Equal(t, "/mnt/tank/k8s/fs", ds.Mountpoint)
+	assert.Equal(t, float64(1<<30), ds.Refquota.Parsed)
+	assert.Equal(t, "1G", ds.Refquota.Value)
+
+	// Thick zvol consumes pool space
+	ds, err = client.DatasetCreate(ctx, &DatasetCreateParams{Name: "tank/k8s/zvol", Type: "VOLUME", Volsize: 2 << 30, Volblocksize: "16K"})
+	assert.NoError(t, err)
+	assert.Equal(t, "VOLUME", ds.Type)
+	assert.Equal(t, float64(2<<30), ds.Volsize.Parsed)
+	assert.Equal(t, float64(16384), ds.Volblocksize.Parsed)
+	avail, err := client.GetPoolAvailable(ctx, "tank/k8s")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8<<30), avail)
+
+	// Parent must exist
+	_, err = client.DatasetCreate(ctx, &DatasetCreateParams{Name: "tank/missing/fs"})
+	assert.Error(t, err)
+
+	// User properties
+	assert.NoError(t, client.DatasetSetUserProperty(ctx, "tank/k8s/fs", "truenas-csi:managed_resource", "true"))
+	value, err := client.DatasetGetUserProperty(ctx, "tank/k8s/fs", "truenas-csi:managed_resource")
+	assert.NoError(t, err)
+	assert.Equal(t, "true", value)
+
+	// Expansion; zvols can't shrink
+	assert.NoError(t, client.DatasetExpand(ctx, "tank/k8s/zvol", 3<<30))
+	assert.Error(t, client.DatasetExpand(ctx, "tank/k8s/zvol", 1<<30))
+
+	// Listing under a parent with pagination
+	datasets, err := client.DatasetList(ctx, "tank/k8s", 1, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, datasets, 1) {
+		assert.Equal(t, "tank/k8s/zvol", datasets[0].Name)
+	}
+
+	// Renames run as a job
+	assert.NoError(t, client.DatasetRename(ctx, "tank/k8s/fs", "tank/k8s/renamed"))
+	exists, err := client.DatasetExists(ctx, "tank/k8s/renamed")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// Children block a non-recursive delete
+	assert.Error(t, client.DatasetDelete(ctx, "tank/k8s", false, false))
+	assert.NoError(t, client.DatasetDelete(ctx, "tank/k8s", true, true))
+	exists, err = client.DatasetExists(ctx, "tank/k8s/zvol")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestSnapshotsAgainstFake(t *testing.T) {
+	for _, prefix := range []string{"pool.snapshot", "zfs.snapshot"} {
+		t.Run(prefix, func(t *testing.T) {
+			ctx := context.Background()
+			_, client := newFakeClient(t, faketruenas.Config{SnapshotAPIPrefix: prefix})
+			caps, err := client.Capabilities(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, prefix, caps.SnapshotPrefix)
+
+			_, err = client.DatasetCreate(ctx, &DatasetCreateParams{Name: "tank/vol", Type: "VOLUME", Volsize: 1 << 30, Sparse: true})
+			assert.NoError(t, err)
+
+			snap, err := client.SnapshotCreate(ctx, "tank/vol", "snap-1")
+			assert.NoError(t, err)
+			assert.Equal(t, "tank/vol@snap-1", snap.ID)
+			assert.Equal(t, "tank/vol", snap.Dataset)
+			assert.InDelta(t, time.Now().Unix(), snap.GetCreationTime(), 5)
+
+			assert.NoError(t, client.SnapshotSetUserProperty(ctx, snap.ID, "truenas-csi:csi_snapshot_name", "snap-1"))
+			found, err := client.SnapshotFindByName(ctx, "tank", "snap-1")
+			assert.NoError(t, err)
+			if assert.NotNil(t, found) {
+				assert.Equal(t, "snap-1", found.UserProperties["truenas-csi:csi_snapshot_name"].Value)
+			}
+
+			// Clones keep the source's size
+			assert.NoError(t, client.SnapshotClone(ctx, snap.ID, "tank/clone"))
+			clone, err := client.WaitForZvolReady(ctx, "tank/clone", time.Second)
+			assert.NoError(t, err)
+			assert.Equal(t, float64(1<<30), clone.Volsize.Parsed)
+			snap, err = client.SnapshotGet(ctx, snap.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"tank/clone"}, snap.GetClones())
+
+			// The clone is deleted before the snapshot it depends on
+			assert.NoError(t, client.SnapshotDelete(ctx, snap.ID, false, false))
+			_, err = client.SnapshotGet(ctx, snap.ID)
+			assert.Error(t, err)
+			exists, err := client.DatasetExists(ctx, "tank/clone")
+			assert.NoError(t, err)
+			assert.False(t, exists)
+
+			// Deleting a missing snapshot succeeds
+			assert.NoError(t, client.SnapshotDelete(ctx, snap.ID, false, false))
+		})
+	}
+}
+
+func TestSharesAgainstFake(t *testing.T) {
+	ctx := context.Background()
+	_, client := newFakeClient(t, faketruenas.Config{})
+
+	_, err := client.DatasetCreate(ctx, &DatasetCreateParams{Name: "tank/fs"})
+	assert.NoError(t, err)
+	_, err = client.DatasetCreate(ctx, &DatasetCreateParams{Name: "tank/zvol", Type: "VOLUME", Volsize: 1 << 30, Sparse: true})
+	assert.NoError(t, err)
+
+	// NFS
+	share, err := client.NFSShareCreate(ctx, &NFSShareCreateParams{Path: "/mnt/tank/fs", Networks: []string{"10.0.0.0/8"}, MaprootUser: "root"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8"}, share.Networks)
+	assert.Equal(t, "root", share.MaprootUser)
+	found, err := client.NFSShareFindByPath(ctx, "/mnt/tank/fs")
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, share.ID, found.ID)
+	}
+	assert.NoError(t, client.NFSShareDelete(ctx, share.ID))
+
+	// iSCSI
+	global, err := client.ISCSIGlobalConfigGet(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "iqn.2005-10.org.freenas.ctl", global.Basename)
+	groups := []ISCSITargetGroup{{Portal: 1, Initiator: 1, AuthMethod: "NONE"}}
+	target, err := client.ISCSITargetCreate(ctx, "zvol", "", "ISCSI", groups)
+	assert.NoError(t, err)
+	assert.Equal(t, groups, target.Groups)
+	// Re-creating finds the existing target
+	again, err := client.ISCSITargetCreate(ctx, "zvol", "", "ISCSI", groups)
+	assert.NoError(t, err)
+	assert.Equal(t, target.ID, again.ID)
+	extent, err := client.ISCSIExtentCreate(ctx, "zvol", "zvol/tank/zvol", "test", 4096, "SSD")
+	assert.NoError(t, err)
+	assert.Equal(t, 4096, extent.Blocksize)
+	assert.NotEmpty(t, extent.Naa)
+	_, err = client.ISCSIExtentCreate(ctx, "other", "zvol/tank/missing", "test", 4096, "SSD")
+	assert.Error(t, err)
+	te, err := client.ISCSITargetExtentCreate(ctx, target.ID, extent.ID, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, te.LunID)
+	found2, err := client.ISCSITargetExtentFind(ctx, target.ID, extent.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found2) {
+		assert.Equal(t, te.ID, found2.ID)
+	}
+
+	// NVMe-oF
+	ports, err := client.NVMeoFPortList(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, ports, 1) {
+		assert.Equal(t, 4420, ports[0].Port)
+	}
+	subsys, err := client.NVMeoFSubsystemCreate(ctx, "nqn.2011-06.com.truenas:zvol", "serial", true, nil)
+	assert.NoError(t, err)
+	ns, err := client.NVMeoFNamespaceCreate(ctx, subsys.ID, "/dev/zvol/tank/zvol")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, ns.NSID)
+	subsys, err = client.NVMeoFSubsystemGet(ctx, subsys.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{ns.ID}, subsys.Namespaces)
+
+	// Destroying the zvol removes its extent and namespace
+	assert.NoError(t, client.DatasetDelete(ctx, "tank/zvol", true, true))
+	_, err = client.ISCSIExtentGet(ctx, extent.ID)
+	assert.Error(t, err)
+	_, err = client.NVMeoFNamespaceGet(ctx, ns.ID)
+	assert.Error(t, err)
+	assert.NoError(t, client.NVMeoFSubsystemDelete(ctx, subsys.ID))
+	assert.NoError(t, client.ISCSITargetDelete(ctx, target.ID, true))
+}
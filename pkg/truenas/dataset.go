@@ -3,9 +3,12 @@ package truenas
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/klog/v2"
 )
 
@@ -108,7 +111,8 @@ func (c *Client) DatasetDelete(ctx context.Context, name string, recursive bool,
 		"force":     force,
 	}
 
-	_, err := c.Call(ctx, "pool.dataset.delete", name, options)
+	// Recursive deletes of large datasets can run as a job on TrueNAS; wait for it to finish
+	_, err := c.CallJob(ctx, "pool.dataset.delete", name, options)
 	if err != nil {
 		// Handle "not found" errors as success (idempotency)
 		if IsNotFoundError(err) {
@@ -131,7 +135,7 @@ func (c *Client) DatasetGet(ctx context.Context, name string) (*Dataset, error)
 
 	datasets, ok := result.([]interface{})
 	if !ok || len(datasets) == 0 {
-		return nil, fmt.Errorf("dataset not found: %s", name)
+		return nil, notFoundf("dataset not found: %s", name)
 	}
 
 	return parseDataset(datasets[0])
@@ -147,6 +151,20 @@ func (c *Client) DatasetUpdate(ctx context.Context, name string, params *Dataset
 	return parseDataset(result)
 }
 
+// DatasetRename renames a dataset, moving it under a different parent in the same pool.
+// Its snapshots are renamed with it.
+func (c *Client) DatasetRename(ctx context.Context, name string, newName string) error {
+	options := map[string]interface{}{
+		"new_name": newName,
+	}
+
+	// Renames run as a job on TrueNAS; wait for it to finish
+	if _, err := c.CallJob(ctx, "pool.dataset.rename", name, options); err != nil {
+		return fmt.Errorf("failed to rename dataset: %w", err)
+	}
+	return nil
+}
+
 // DatasetList lists datasets matching the given filters.
 func (c *Client) DatasetList(ctx context.Context, parentName string, limit int, offset int) ([]*Dataset, error) {
 	filters := make([][]interface{}, 0)
@@ -191,10 +209,24 @@ func (c *Client) DatasetList(ctx context.Context, parentName string, limit int,
 
 // DatasetSetUserProperty sets a user property on a dataset.
 func (c *Client) DatasetSetUserProperty(ctx context.Context, name string, key string, value string) error {
-	params := &DatasetUpdateParams{
-		UserPropertiesUpdate: []UserPropertyUpdate{
-			{Key: key, Value: value},
-		},
+	return c.DatasetSetUserProperties(ctx, name, map[string]string{key: value})
+}
+
+// DatasetSetUserProperties sets several user properties on a dataset with a single
+// pool.dataset.update call.
+func (c *Client) DatasetSetUserProperties(ctx context.Context, name string, properties map[string]string) error {
+	if len(properties) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	params := &DatasetUpdateParams{}
+	for _, key := range keys {
+		params.UserPropertiesUpdate = append(params.UserPropertiesUpdate, UserPropertyUpdate{Key: key, Value: properties[key]})
 	}
 
 	_, err := c.DatasetUpdate(ctx, name, params)
@@ -238,7 +270,7 @@ func (c *Client) GetPoolAvailable(ctx context.Context, poolName string) (int64,
 
 	pools, ok := result.([]interface{})
 	if !ok || len(pools) == 0 {
-		return 0, fmt.Errorf("pool not found: %s", pool)
+		return 0, notFoundf("pool not found: %s", pool)
 	}
 
 	poolData, ok := pools[0].(map[string]interface{})
@@ -358,6 +390,10 @@ func parseProperty(data interface{}) DatasetProperty {
 // This is important after clone operations where the dataset may not be
 // immediately available for subsequent operations.
 func (c *Client) WaitForDatasetReady(ctx context.Context, name string, timeout time.Duration) (*Dataset, error) {
+	// Polls show up as child "truenas pool.dataset.query" spans
+	ctx, span := tracer.Start(ctx, "truenas WaitForDatasetReady", trace.WithAttributes(attribute.String("truenas.dataset", name)))
+	defer span.End()
+
 	start := time.Now()
 	pollInterval := 100 * time.Millisecond
 	maxPollInterval := 2 * time.Second
@@ -394,6 +430,10 @@ func (c *Client) WaitForDatasetReady(ctx context.Context, name string, timeout t
 // WaitForZvolReady waits for a zvol to be ready with a valid volsize.
 // After cloning, the zvol may not immediately have all properties available.
 func (c *Client) WaitForZvolReady(ctx context.Context, name string, timeout time.Duration) (*Dataset, error) {
+	// Polls show up as child "truenas pool.dataset.query" spans
+	ctx, span := tracer.Start(ctx, "truenas WaitForZvolReady", trace.WithAttributes(attribute.String("truenas.dataset", name)))
+	defer span.End()
+
 	start := time.Now()
 	pollInterval := 100 * time.Millisecond
 	maxPollInterval := 2 * time.Second
@@ -446,7 +486,7 @@ func (c *Client) WaitForZvolReady(ctx context.Context, name string, timeout time
 func (c *Client) DatasetExists(ctx context.Context, name string) (bool, error) {
 	_, err := c.DatasetGet(ctx, name)
 	if err != nil {
-		if IsNotFoundError(err) || strings.Contains(err.Error(), "not found") {
+		if IsNotFoundError(err) {
 			return false, nil
 		}
 		return false, err
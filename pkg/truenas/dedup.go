@@ -0,0 +1,65 @@
+package truenas
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"golang.org/x/sync/singleflight"
+	"k8s.io/klog/v2"
+)
+
+// dedup shares one request between identical reads in flight at the same time, such as
+// the lookups of the same dataset made by concurrent CreateVolume retries. A read only
+// joins a request that started after the last write began or ended, so it never gets an
+// answer from before a write it could have seen.
+type dedup struct {
+	group  singleflight.Group
+	writes uint64 // Writes started plus writes finished (atomic)
+}
+
+// isRead reports whether a method only reads, so identical calls can share a request.
+func isRead(method string) bool {
+	_, op := splitMethod(method)
+	return op == "query" || op == "get_instance" || op == "config"
+}
+
+// do makes the call through call, or waits for an identical read already in flight.
+// Callers that share a request get copies of its result.
+func (d *dedup) do(ctx context.Context, method string, params []interface{}, call func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if !isRead(method) {
+		atomic.AddUint64(&d.writes, 1)
+		defer atomic.AddUint64(&d.writes, 1)
+		return call(ctx)
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return call(ctx)
+	}
+	// Cleanup calls don't wait behind a normal priority request
+	key := fmt.Sprintf("%d %v %s %s", atomic.LoadUint64(&d.writes), PriorityFromContext(ctx), method, data)
+
+	ch := d.group.DoChan(key, func() (interface{}, error) { return call(ctx) })
+	select {
+	case res := <-ch:
+		if !res.Shared {
+			return res.Val, res.Err
+		}
+		// The request ran with the context of whichever caller made it; if that caller
+		// gave up, the others make their own
+		if isContextError(res.Err) && ctx.Err() == nil {
+			return call(ctx)
+		}
+		klog.V(5).Infof("Shared in-flight %s", method)
+		return jsonValue(res.Val), res.Err
+	case <-ctx.Done():
+		return nil, &ConnectionError{Method: method, Err: fmt.Errorf("request timeout: %s: %w", method, ctx.Err())}
+	}
+}
+
+// isContextError reports whether err comes from a cancelled or expired context.
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
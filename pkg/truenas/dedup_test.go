@@ -0,0 +1,90 @@
+package truenas
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedup(t *testing.T) {
+	var d dedup
+	var calls int32
+	release := make(chan struct{})
+	call := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case <-release:
+			return []interface{}{map[string]interface{}{"id": "tank/a"}}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	inFlight := func(n int32) func() bool {
+		return func() bool { return atomic.LoadInt32(&calls) == n }
+	}
+	params := []interface{}{[][]interface{}{{"id", "=", "tank/a"}}, map[string]interface{}{}}
+	ctx := context.Background()
+
+	// Identical reads share one request, and each gets its own copy of the result
+	var wg sync.WaitGroup
+	results := make([]interface{}, 3)
+	read := func(i int) {
+		defer wg.Done()
+		result, err := d.do(ctx, "pool.dataset.query", params, call)
+		assert.NoError(t, err)
+		results[i] = result
+	}
+	wg.Add(1)
+	go read(0)
+	assert.Eventually(t, inFlight(1), time.Second, time.Millisecond)
+	wg.Add(2)
+	go read(1)
+	go read(2)
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, results[0], results[2])
+	results[1].([]interface{})[0].(map[string]interface{})["id"] = "changed"
+	assert.NotEqual(t, results[1], results[2])
+
+	// A read never joins one that started before a write
+	atomic.StoreInt32(&calls, 0)
+	release = make(chan struct{})
+	wg.Add(1)
+	go read(0)
+	assert.Eventually(t, inFlight(1), time.Second, time.Millisecond)
+	_, err := d.do(ctx, "pool.dataset.update", []interface{}{"tank/a", map[string]interface{}{}}, func(ctx context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	assert.NoError(t, err)
+	wg.Add(1)
+	go read(1)
+	assert.Eventually(t, inFlight(2), time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// Callers whose request was made by one that gave up make their own
+	atomic.StoreInt32(&calls, 0)
+	release = make(chan struct{})
+	leaderCtx, cancel := context.WithCancel(ctx)
+	leaderDone := make(chan error)
+	go func() {
+		_, err := d.do(leaderCtx, "pool.dataset.query", params, call)
+		leaderDone <- err
+	}()
+	assert.Eventually(t, inFlight(1), time.Second, time.Millisecond)
+	wg.Add(1)
+	go read(0)
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	assert.Error(t, <-leaderDone)
+	assert.Eventually(t, inFlight(2), time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.NotNil(t, results[0])
+}
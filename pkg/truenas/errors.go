@@ -0,0 +1,305 @@
+package truenas
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errno values the middleware reports in error data.
+const (
+	errnoEPERM      = 1
+	errnoENOENT     = 2
+	errnoEACCES     = 13
+	errnoEBUSY      = 16
+	errnoEEXIST     = 17
+	errnoEINVAL     = 22
+	errnoENOSPC     = 28
+	errnoEOPNOTSUPP = 95
+	errnoEDQUOT     = 122
+)
+
+// errnos maps the errno names the middleware prefixes reasons with, as in "[ENOENT] ...".
+var errnos = map[string]int{
+	"EPERM":      errnoEPERM,
+	"ENOENT":     errnoENOENT,
+	"EACCES":     errnoEACCES,
+	"EBUSY":      errnoEBUSY,
+	"EEXIST":     errnoEEXIST,
+	"EINVAL":     errnoEINVAL,
+	"ENOSPC":     errnoENOSPC,
+	"EOPNOTSUPP": errnoEOPNOTSUPP,
+	"EDQUOT":     errnoEDQUOT,
+}
+
+// errnoPrefix returns the errno named by the "[ENOENT]" prefix of a middleware message,
+// or 0 if it has none.
+func errnoPrefix(message string) int {
+	if !strings.HasPrefix(message, "[") {
+		return 0
+	}
+	name, _, _ := strings.Cut(message[1:], "]")
+	return errnos[name]
+}
+
+// JSON-RPC error codes sent by the middleware.
+const (
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+// ErrorKind classifies a failed call by what went wrong, from the errno and JSON-RPC code
+// TrueNAS reports rather than the wording of its messages, which changes between releases.
+type ErrorKind int
+
+const (
+	ErrorUnknown       ErrorKind = iota
+	ErrorNotFound                // ENOENT
+	ErrorAlreadyExists           // EEXIST
+	ErrorBusy                    // EBUSY, e.g. a dataset in use
+	ErrorInvalid                 // EINVAL, or any other failed validation
+	ErrorPermission              // EPERM, EACCES
+	ErrorNoSpace                 // ENOSPC, EDQUOT
+	ErrorUnsupported             // Unknown method or EOPNOTSUPP
+	ErrorUnavailable             // No answer: connection failures, timeouts, an open circuit breaker
+)
+
+var errorKindNames = map[ErrorKind]string{
+	ErrorUnknown:       "unknown",
+	ErrorNotFound:      "not found",
+	ErrorAlreadyExists: "already exists",
+	ErrorBusy:          "busy",
+	ErrorInvalid:       "invalid",
+	ErrorPermission:    "permission denied",
+	ErrorNoSpace:       "no space",
+	ErrorUnsupported:   "unsupported",
+	ErrorUnavailable:   "unavailable",
+}
+
+func (k ErrorKind) String() string {
+	return errorKindNames[k]
+}
+
+// errnoKind returns the kind of an errno, or ErrorUnknown for errnos without one.
+func errnoKind(errno int) ErrorKind {
+	switch errno {
+	case errnoENOENT:
+		return ErrorNotFound
+	case errnoEEXIST:
+		return ErrorAlreadyExists
+	case errnoEBUSY:
+		return ErrorBusy
+	case errnoEINVAL:
+		return ErrorInvalid
+	case errnoEPERM, errnoEACCES:
+		return ErrorPermission
+	case errnoENOSPC, errnoEDQUOT:
+		return ErrorNoSpace
+	case errnoEOPNOTSUPP:
+		return ErrorUnsupported
+	}
+	return ErrorUnknown
+}
+
+// ErrorKindOf classifies err, looking through wrapped errors.
+func ErrorKindOf(err error) ErrorKind {
+	var apiErr *APIError
+	var jobErr *JobError
+	var connErr *ConnectionError
+	switch {
+	case err == nil:
+		return ErrorUnknown
+	case errors.Is(err, ErrNotFound):
+		return ErrorNotFound
+	case errors.As(err, &apiErr):
+		return apiErr.Kind()
+	case errors.As(err, &jobErr):
+		return jobErr.Kind()
+	case errors.As(err, &connErr), errors.Is(err, ErrCircuitOpen):
+		return ErrorUnavailable
+	}
+	return ErrorUnknown
+}
+
+// IsNotFoundError returns true if the error indicates a resource was not found.
+func IsNotFoundError(err error) bool {
+	return ErrorKindOf(err) == ErrorNotFound
+}
+
+// IsAlreadyExistsError returns true if the error indicates a resource already exists.
+func IsAlreadyExistsError(err error) bool {
+	return ErrorKindOf(err) == ErrorAlreadyExists
+}
+
+// IsConnectionError returns true if the call got no answer from TrueNAS, so retrying it
+// on another connection may succeed.
+func IsConnectionError(err error) bool {
+	var connErr *ConnectionError
+	return errors.As(err, &connErr)
+}
+
+// ErrNotFound is wrapped by errors for lookups that found nothing.
+var ErrNotFound = errors.New("not found")
+
+// notFoundError is a lookup that found nothing, with a message naming what was looked for.
+type notFoundError struct {
+	msg string
+}
+
+func (e *notFoundError) Error() string { return e.msg }
+
+func (e *notFoundError) Is(target error) bool { return target == ErrNotFound }
+
+// notFoundf returns an error wrapping ErrNotFound with a formatted message.
+func notFoundf(format string, args ...interface{}) error {
+	return &notFoundError{msg: fmt.Sprintf(format, args...)}
+}
+
+// errConnectionLost fails the calls pending on a connection that closed.
+var errConnectionLost = errors.New("connection lost")
+
+// ConnectionError is a call that got no answer from TrueNAS: the connection couldn't be
+// established or was lost, or the call timed out. TrueNAS may still have run it.
+type ConnectionError struct {
+	Method string
+	Err    error
+}
+
+func (e *ConnectionError) Error() string { return e.Err.Error() }
+
+func (e *ConnectionError) Unwrap() error { return e.Err }
+
+// APIError represents an error from the TrueNAS API. Data carries the middleware's
+// details, decoded by the methods below:
+//
+//	{"error": 2, "errname": "ENOENT", "reason": "[ENOENT] ...",
+//	 "extra": [["pool_dataset_create.name", "Path tank/a already exists", 17]],
+//	 "trace": {"class": "ValidationErrors", "formatted": "Traceback ..."}}
+type APIError struct {
+	Code    int
+	Message string
+	Data    interface{}
+}
+
+func (e *APIError) Error() string {
+	if reason := e.Reason(); reason != "" && reason != e.Message {
+		return fmt.Sprintf("TrueNAS API error [%d]: %s: %s", e.Code, e.Message, reason)
+	}
+	return fmt.Sprintf("TrueNAS API error [%d]: %s", e.Code, e.Message)
+}
+
+// data returns Data as an object, or nil.
+func (e *APIError) data() map[string]interface{} {
+	data, _ := e.Data.(map[string]interface{})
+	return data
+}
+
+// Errno returns the errno of the error, or 0 if TrueNAS didn't send one.
+func (e *APIError) Errno() int {
+	errno, _ := e.data()["error"].(float64)
+	return int(errno)
+}
+
+// Reason returns the middleware's description of the error, such as
+// "[EBUSY] Failed to delete dataset: cannot destroy 'tank/a': dataset is busy".
+func (e *APIError) Reason() string {
+	reason, _ := e.data()["reason"].(string)
+	return reason
+}
+
+// ValidationErrors returns the failed fields of an "Invalid params" error.
+func (e *APIError) ValidationErrors() []ValidationError {
+	if e.Code != codeInvalidParams {
+		return nil
+	}
+	return validationErrors(e.data()["extra"])
+}
+
+// Trace returns the middleware traceback sent with the error, or nil.
+func (e *APIError) Trace() *ErrorTrace {
+	trace, ok := e.data()["trace"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	t := &ErrorTrace{}
+	t.Class, _ = trace["class"].(string)
+	t.Formatted, _ = trace["formatted"].(string)
+	return t
+}
+
+// Kind classifies the error by its errno. Validation errors are classified by the errno
+// of their first field, which says why it failed, e.g. ENOENT for an instance that
+// doesn't exist; later fields are ignored, and a first field without one falls back to
+// the errno of the error. Errors without a known errno fall back to their message and
+// reason: CallError defaults to EFAULT, and old releases send no errno at all.
+func (e *APIError) Kind() ErrorKind {
+	if e.Code == codeMethodNotFound {
+		return ErrorUnsupported
+	}
+	errno := e.Errno()
+	if fields := e.ValidationErrors(); len(fields) > 0 && fields[0].Errno != 0 {
+		errno = fields[0].Errno
+	}
+	if kind := errnoKind(errno); kind != ErrorUnknown {
+		return kind
+	}
+	if kind := messageKind(e.Message, e.Reason()); kind != ErrorUnknown {
+		return kind
+	}
+	if e.Code == codeInvalidParams {
+		return ErrorInvalid
+	}
+	return ErrorUnknown
+}
+
+// messageKind classifies an error without a known errno by the wording of its messages.
+func messageKind(messages ...string) ErrorKind {
+	for _, message := range messages {
+		message = strings.ToLower(message)
+		switch {
+		case strings.Contains(message, "not found") || strings.Contains(message, "does not exist"):
+			return ErrorNotFound
+		case strings.Contains(message, "already exists"):
+			return ErrorAlreadyExists
+		}
+	}
+	return ErrorUnknown
+}
+
+// ValidationError is one field rejected by the middleware's argument validation.
+type ValidationError struct {
+	Attribute string // Schema path, e.g. "pool_dataset_create.name"
+	Message   string
+	Errno     int
+}
+
+func (v ValidationError) String() string {
+	return v.Attribute + ": " + v.Message
+}
+
+// validationErrors decodes the [attribute, message, errno] triples of validation errors.
+func validationErrors(extra interface{}) []ValidationError {
+	items, _ := extra.([]interface{})
+	var fields []ValidationError
+	for _, item := range items {
+		triple, ok := item.([]interface{})
+		if !ok || len(triple) < 2 {
+			continue
+		}
+		var field ValidationError
+		field.Attribute, _ = triple[0].(string)
+		field.Message, _ = triple[1].(string)
+		if len(triple) > 2 {
+			errno, _ := triple[2].(float64)
+			field.Errno = int(errno)
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// ErrorTrace is the traceback the middleware attaches to errors and failed jobs.
+type ErrorTrace struct {
+	Class     string // Python exception class, e.g. "CallError" or "ValidationErrors"
+	Formatted string
+}
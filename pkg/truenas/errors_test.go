@@ -0,0 +1,142 @@
+package truenas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestErrorPayloads decodes the error payloads in testdata/error_payloads.jsonl. They are
+// written by hand in the shape of the TrueNAS 25.04 middleware's errors, without
+// tracebacks, and replayed through a client so they take the same decoding path.
+func TestErrorPayloads(t *testing.T) {
+	ctx := context.Background()
+	recordings, err := ReadRecordings("testdata/error_payloads.jsonl")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	client, err := NewReplayClient("testdata/error_payloads.jsonl")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	call := func(i int) error {
+		_, err := client.CallJob(ctx, recordings[i].Method, recordings[i].Params...)
+		return err
+	}
+
+	tests := []struct {
+		name       string
+		recording  int
+		kind       ErrorKind
+		errno      int
+		fields     []ValidationError
+		traceClass string
+	}{
+		{
+			name: "instance does not exist", recording: 0, kind: ErrorNotFound, errno: 22,
+			fields:     []ValidationError{{Attribute: "None", Message: "'tank/k8s/pvc-missing' does not exist", Errno: 2}},
+			traceClass: "ValidationErrors",
+		},
+		{
+			name: "dataset exists", recording: 1, kind: ErrorAlreadyExists, errno: 22,
+			fields:     []ValidationError{{Attribute: "pool_dataset_create.name", Message: "Path tank/k8s/pvc-1 already exists", Errno: 17}},
+			traceClass: "ValidationErrors",
+		},
+		{
+			name: "invalid fields", recording: 2, kind: ErrorInvalid, errno: 22,
+			fields: []ValidationError{
+				{Attribute: "pool_dataset_create.volsize", Message: "Volume size should be a multiple of 16384", Errno: 22},
+				{Attribute: "pool_dataset_create.volblocksize", Message: "Must be specified for volumes", Errno: 22},
+			},
+			traceClass: "ValidationErrors",
+		},
+		{name: "out of space", recording: 3, kind: ErrorNoSpace, errno: 28, traceClass: "CallError"},
+		{name: "dataset busy", recording: 4, kind: ErrorBusy, errno: 16, traceClass: "CallError"},
+		{
+			// Says "does not exist", but the disk path is an argument, not the resource
+			name: "invalid device path", recording: 5, kind: ErrorInvalid, errno: 22,
+			fields:     []ValidationError{{Attribute: "iscsi_extent_create.disk", Message: "Device path zvol/tank/k8s/pvc-5 does not exist", Errno: 22}},
+			traceClass: "ValidationErrors",
+		},
+		{name: "unknown method", recording: 6, kind: ErrorUnsupported, errno: 22},
+		// CallError defaults to EFAULT, leaving the reason to say what failed
+		{name: "default errno", recording: 10, kind: ErrorNotFound, errno: 14, traceClass: "CallError"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := call(tt.recording)
+			var apiErr *APIError
+			if !assert.True(t, errors.As(err, &apiErr), "%v", err) {
+				return
+			}
+			assert.Equal(t, tt.kind, ErrorKindOf(err), "%v", err)
+			assert.Equal(t, tt.errno, apiErr.Errno())
+			assert.Equal(t, tt.fields, apiErr.ValidationErrors())
+			if tt.traceClass == "" {
+				assert.Nil(t, apiErr.Trace())
+			} else if assert.NotNil(t, apiErr.Trace()) {
+				assert.Equal(t, tt.traceClass, apiErr.Trace().Class)
+			}
+			assert.Contains(t, err.Error(), apiErr.Reason())
+		})
+	}
+
+	t.Run("failed job", func(t *testing.T) {
+		err := call(7)
+		var jobErr *JobError
+		if !assert.True(t, errors.As(err, &jobErr), "%v", err) {
+			return
+		}
+		assert.Equal(t, 8812, jobErr.ID)
+		assert.Equal(t, ErrorNotFound, ErrorKindOf(err))
+		assert.Equal(t, 22, jobErr.Errno())
+		assert.Equal(t, []ValidationError{{Attribute: "pool.dataset.delete.id", Message: "tank/k8s/pvc-8 does not exist", Errno: 2}}, jobErr.ValidationErrors())
+	})
+
+	t.Run("connection lost", func(t *testing.T) {
+		err := call(9)
+		assert.Equal(t, ErrorUnavailable, ErrorKindOf(err))
+		assert.True(t, IsConnectionError(err))
+		assert.False(t, IsNotFoundError(err))
+	})
+}
+
+func TestErrorKindOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		kind ErrorKind
+	}{
+		{"nil", nil, ErrorUnknown},
+		{"lookup", notFoundf("dataset not found: tank/a"), ErrorNotFound},
+		{"wrapped lookup", fmt.Errorf("failed to get share: %w", notFoundf("share not found")), ErrorNotFound},
+		{"wrapped api error", fmt.Errorf("failed: %w", &APIError{Code: -32001, Data: map[string]interface{}{"error": float64(13)}}), ErrorPermission},
+		{"quota", &APIError{Code: -32001, Data: map[string]interface{}{"error": float64(122)}}, ErrorNoSpace},
+		{"message only", &APIError{Code: -32001, Message: "Dataset tank/a does not exist"}, ErrorNotFound},
+		{"unknown errno", &APIError{Code: -32001, Message: "Dataset tank/a does not exist", Data: map[string]interface{}{"error": float64(5)}}, ErrorNotFound},
+		{"known errno over message", &APIError{Code: -32602, Message: "Device path zvol/tank/a does not exist", Data: map[string]interface{}{"error": float64(22)}}, ErrorInvalid},
+		{"unknown errno without match", &APIError{Code: -32001, Message: "Method call error", Data: map[string]interface{}{"error": float64(14), "reason": "[EFAULT] I/O failure"}}, ErrorUnknown},
+		{"data without errno", &APIError{Code: -32001, Message: "Dataset tank/a does not exist", Data: map[string]interface{}{"reason": "Dataset tank/a does not exist"}}, ErrorNotFound},
+		{"invalid without errno", &APIError{Code: -32602, Message: "Invalid params", Data: map[string]interface{}{"extra": []interface{}{[]interface{}{"a", "bad"}}}}, ErrorInvalid},
+		{"mixed validation errnos", &APIError{Code: -32602, Message: "Invalid params", Data: map[string]interface{}{"error": float64(22), "extra": []interface{}{
+			[]interface{}{"pool_dataset_update.id", "does not exist", float64(2)},
+			[]interface{}{"pool_dataset_update.name", "already exists", float64(17)},
+		}}}, ErrorNotFound},
+		{"first field without errno", &APIError{Code: -32602, Message: "Invalid params", Data: map[string]interface{}{"error": float64(17), "extra": []interface{}{
+			[]interface{}{"pool_dataset_create.comments", "bad"},
+			[]interface{}{"pool_dataset_create.name", "already exists", float64(2)},
+		}}}, ErrorAlreadyExists},
+		{"job message", &JobError{Message: "[EEXIST] Path tank/a already exists"}, ErrorAlreadyExists},
+		{"timeout", &ConnectionError{Method: "pool.dataset.query", Err: context.DeadlineExceeded}, ErrorUnavailable},
+		{"circuit open", fmt.Errorf("%w after 5 failed calls", ErrCircuitOpen), ErrorUnavailable},
+		{"other", errors.New("does not exist"), ErrorUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.kind, ErrorKindOf(tt.err))
+		})
+	}
+}
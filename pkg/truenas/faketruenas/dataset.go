@@ -0,0 +1,596 @@
+package faketruenas
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dataset is a ZFS filesystem or zvol.
+type dataset struct {
+	name           string
+	typ            string // FILESYSTEM or VOLUME
+	volsize        int64
+	volblocksize   string
+	sparse         bool
+	quota          int64
+	refquota       int64
+	reservation    int64
+	refreservation int64
+	readonly       string
+	origin         string // Snapshot the dataset was cloned from
+	userProps      map[string]string
+}
+
+// snapshot is a ZFS snapshot.
+type snapshot struct {
+	dataset   string
+	name      string // Part after the @
+	created   time.Time
+	userProps map[string]string
+}
+
+func (snap *snapshot) id() string {
+	return snap.dataset + "@" + snap.name
+}
+
+// pool returns the pool a dataset or snapshot belongs to.
+func pool(name string) string {
+	p, _, _ := strings.Cut(name, "/")
+	p, _, _ = strings.Cut(p, "@")
+	return p
+}
+
+// parent returns the parent dataset name, or "" for a pool root.
+func parent(name string) string {
+	i := strings.LastIndex(name, "/")
+	if i < 0 {
+		return ""
+	}
+	return name[:i]
+}
+
+// isDescendant reports whether name is ancestor itself or below it.
+func isDescendant(name, ancestor string) bool {
+	return name == ancestor || strings.HasPrefix(name, ancestor+"/")
+}
+
+// used is the space a dataset consumes: a thick zvol reserves its whole size.
+func (ds *dataset) used() int64 {
+	if ds.typ == "VOLUME" && !ds.sparse {
+		return ds.volsize
+	}
+	return 0
+}
+
+// poolFree returns the free space left in a pool.
+func (s *Server) poolFree(name string) int64 {
+	free := s.cfg.PoolFree
+	for _, ds := range s.datasets {
+		if pool(ds.name) == name {
+			free -= ds.used()
+		}
+	}
+	if free < 0 {
+		return 0
+	}
+	return free
+}
+
+// sizeProperty renders a numeric ZFS property. Zero is rendered as unset.
+func sizeProperty(n int64, source string) map[string]interface{} {
+	if n == 0 {
+		return map[string]interface{}{"value": nil, "rawvalue": "0", "parsed": nil, "source": source}
+	}
+	return map[string]interface{}{
+		"value":    humanSize(n),
+		"rawvalue": strconv.FormatInt(n, 10),
+		"parsed":   float64(n),
+		"source":   source,
+	}
+}
+
+// stringProperty renders a string ZFS property.
+func stringProperty(v string, source string) map[string]interface{} {
+	return map[string]interface{}{"value": v, "rawvalue": v, "parsed": v, "source": source}
+}
+
+// humanSize formats bytes the way zfs get does, e.g. 1G or 1.50G.
+func humanSize(n int64) string {
+	units := []string{"B", "K", "M", "G", "T", "P"}
+	v := float64(n)
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if v == float64(int64(v)) {
+		return strconv.FormatInt(int64(v), 10) + units[i]
+	}
+	return strconv.FormatFloat(v, 'f', 2, 64) + units[i]
+}
+
+// renderDataset returns a dataset as pool.dataset.query does.
+func (s *Server) renderDataset(ds *dataset) map[string]interface{} {
+	available := s.poolFree(pool(ds.name))
+	if ds.refquota > 0 && ds.refquota-ds.used() < available {
+		available = ds.refquota - ds.used()
+	}
+
+	userProps := make(map[string]interface{}, len(ds.userProps))
+	for key, value := range ds.userProps {
+		userProps[key] = stringProperty(value, "LOCAL")
+	}
+
+	readonly := ds.readonly
+	if readonly == "" {
+		readonly = "OFF"
+	}
+
+	m := map[string]interface{}{
+		"id":              ds.name,
+		"name":            ds.name,
+		"pool":            pool(ds.name),
+		"type":            ds.typ,
+		"encrypted":       false,
+		"children":        []interface{}{},
+		"mountpoint":      "/mnt/" + ds.name,
+		"used":            sizeProperty(ds.used(), "NONE"),
+		"available":       sizeProperty(available, "NONE"),
+		"quota":           sizeProperty(ds.quota, "LOCAL"),
+		"refquota":        sizeProperty(ds.refquota, "LOCAL"),
+		"reservation":     sizeProperty(ds.reservation, "LOCAL"),
+		"refreservation":  sizeProperty(ds.refreservation, "LOCAL"),
+		"readonly":        stringProperty(readonly, "LOCAL"),
+		"origin":          stringProperty(ds.origin, "NONE"),
+		"user_properties": userProps,
+	}
+	if ds.typ == "VOLUME" {
+		m["mountpoint"] = nil
+		m["volsize"] = sizeProperty(ds.volsize, "LOCAL")
+		blocksize, _ := parseSize(ds.volblocksize)
+		m["volblocksize"] = map[string]interface{}{
+			"value":    ds.volblocksize,
+			"rawvalue": strconv.FormatInt(blocksize, 10),
+			"parsed":   float64(blocksize),
+			"source":   "DEFAULT",
+		}
+	}
+	return m
+}
+
+// parseSize parses a size such as 16K.
+func parseSize(v string) (int64, bool) {
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(v, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(v, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(v, "G"):
+		mult = 1 << 30
+	}
+	n, err := strconv.ParseInt(strings.TrimRight(v, "KMG"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n * mult, true
+}
+
+// clones returns the datasets cloned from a snapshot.
+func (s *Server) clones(snapshotID string) []string {
+	var names []string
+	for _, ds := range s.datasets {
+		if ds.origin == snapshotID {
+			names = append(names, ds.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// renderSnapshot returns a snapshot as <prefix>.query does.
+func (s *Server) renderSnapshot(snap *snapshot) map[string]interface{} {
+	created := strconv.FormatInt(snap.created.Unix(), 10)
+	clones := strings.Join(s.clones(snap.id()), ",")
+	properties := map[string]interface{}{
+		"used": sizeProperty(0, "NONE"),
+		"creation": map[string]interface{}{
+			"value":    snap.created.Format("Mon Jan 2 15:04 2006"),
+			"rawvalue": created,
+			"parsed":   map[string]interface{}{"$date": float64(snap.created.UnixMilli())},
+			"source":   "NONE",
+		},
+		"clones": stringProperty(clones, "NONE"),
+	}
+	for key, value := range snap.userProps {
+		properties[key] = stringProperty(value, "LOCAL")
+	}
+
+	return map[string]interface{}{
+		"id":            snap.id(),
+		"name":          snap.id(),
+		"snapshot_name": snap.name,
+		"dataset":       snap.dataset,
+		"pool":          pool(snap.dataset),
+		"type":          "SNAPSHOT",
+		"holds":         map[string]interface{}{},
+		"properties":    properties,
+	}
+}
+
+// sortedDatasets returns the datasets ordered by name.
+func (s *Server) sortedDatasets() []*dataset {
+	datasets := make([]*dataset, 0, len(s.datasets))
+	for _, ds := range s.datasets {
+		datasets = append(datasets, ds)
+	}
+	sort.Slice(datasets, func(i, j int) bool { return datasets[i].name < datasets[j].name })
+	return datasets
+}
+
+// sortedSnapshots returns the snapshots ordered by ID.
+func (s *Server) sortedSnapshots() []*snapshot {
+	snapshots := make([]*snapshot, 0, len(s.snapshots))
+	for _, snap := range s.snapshots {
+		snapshots = append(snapshots, snap)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].id() < snapshots[j].id() })
+	return snapshots
+}
+
+// Datasets returns the names of all datasets, including pool roots, in order.
+func (s *Server) Datasets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for _, ds := range s.sortedDatasets() {
+		names = append(names, ds.name)
+	}
+	return names
+}
+
+// Snapshots returns the IDs of all snapshots in order.
+func (s *Server) Snapshots() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for _, snap := range s.sortedSnapshots() {
+		ids = append(ids, snap.id())
+	}
+	return ids
+}
+
+// registerDatasets adds pool.query and pool.dataset.*.
+func (s *Server) registerDatasets() {
+	s.handlers["pool.query"] = func(params []interface{}) (interface{}, error) {
+		var rows []map[string]interface{}
+		for i, name := range s.cfg.Pools {
+			free := s.poolFree(name)
+			rows = append(rows, map[string]interface{}{
+				"id":      float64(i + 1),
+				"name":    name,
+				"status":  "ONLINE",
+				"healthy": true,
+				"topology": map[string]interface{}{
+					"data": []interface{}{map[string]interface{}{
+						"type": "MIRROR",
+						"stats": map[string]interface{}{
+							"size":      float64(s.cfg.PoolFree),
+							"allocated": float64(s.cfg.PoolFree - free),
+							"free":      float64(free),
+						},
+					}},
+				},
+			})
+		}
+		return query(rows, params)
+	}
+
+	s.handlers["pool.dataset.query"] = func(params []interface{}) (interface{}, error) {
+		var rows []map[string]interface{}
+		for _, ds := range s.sortedDatasets() {
+			rows = append(rows, s.renderDataset(ds))
+		}
+		return query(rows, params)
+	}
+
+	s.handlers["pool.dataset.create"] = func(params []interface{}) (interface{}, error) {
+		p := argMap(params, 0)
+		name, _ := p["name"].(string)
+		if _, ok := s.datasets[name]; ok {
+			return nil, ValidationError("pool_dataset_create.name", EEXIST, "Path %s already exists", name)
+		}
+		if _, ok := s.datasets[parent(name)]; !ok {
+			return nil, ValidationError("pool_dataset_create.name", ENOENT, "Parent dataset %s does not exist", parent(name))
+		}
+
+		ds := &dataset{name: name, typ: "FILESYSTEM", userProps: map[string]string{}}
+		if typ, ok := p["type"].(string); ok && typ != "" {
+			ds.typ = typ
+		}
+		if ds.typ == "VOLUME" {
+			volsize, _ := p["volsize"].(float64)
+			if volsize <= 0 {
+				return nil, ValidationError("pool_dataset_create.volsize", EINVAL, "This field is required")
+			}
+			ds.volsize = int64(volsize)
+			ds.volblocksize = "16K"
+			if v, ok := p["volblocksize"].(string); ok && v != "" {
+				ds.volblocksize = v
+			}
+			ds.sparse, _ = p["sparse"].(bool)
+			if !ds.sparse && ds.volsize > s.poolFree(pool(name)) {
+				return nil, ValidationError("pool_dataset_create.volsize", EINVAL, "Not enough free space in pool %s", pool(name))
+			}
+		}
+		for field, dst := range map[string]*int64{
+			"quota": &ds.quota, "refquota": &ds.refquota,
+			"reservation": &ds.reservation, "refreservation": &ds.refreservation,
+		} {
+			if v, ok := p[field].(float64); ok {
+				*dst = int64(v)
+			}
+		}
+		ds.readonly, _ = p["readonly"].(string)
+
+		s.datasets[name] = ds
+		return s.renderDataset(ds), nil
+	}
+
+	s.handlers["pool.dataset.update"] = func(params []interface{}) (interface{}, error) {
+		name := argString(params, 0)
+		ds, ok := s.datasets[name]
+		if !ok {
+			return nil, instanceNotFound(name)
+		}
+		p := argMap(params, 1)
+
+		if v, ok := p["volsize"].(float64); ok {
+			if ds.typ != "VOLUME" {
+				return nil, ValidationError("pool_dataset_update.volsize", EINVAL, "Volsize can only be specified for VOLUME")
+			}
+			if int64(v) < ds.volsize {
+				return nil, ValidationError("pool_dataset_update.volsize", EINVAL, "You cannot shrink a zvol from GUI as this may lead to data loss.")
+			}
+			ds.volsize = int64(v)
+		}
+		for field, dst := range map[string]*int64{
+			"quota": &ds.quota, "refquota": &ds.refquota,
+			"reservation": &ds.reservation, "refreservation": &ds.refreservation,
+		} {
+			if v, ok := p[field]; ok {
+				n, _ := v.(float64)
+				*dst = int64(n)
+			}
+		}
+		if v, ok := p["readonly"].(string); ok {
+			ds.readonly = v
+		}
+		if updates, ok := p["user_properties_update"].([]interface{}); ok {
+			for _, u := range updates {
+				update, _ := u.(map[string]interface{})
+				key, _ := update["key"].(string)
+				if !strings.Contains(key, ":") {
+					return nil, ValidationError("pool_dataset_update.user_properties_update.key", EINVAL, "User property name must contain a colon (:)")
+				}
+				if remove, _ := update["remove"].(bool); remove {
+					delete(ds.userProps, key)
+					continue
+				}
+				value, _ := update["value"].(string)
+				ds.userProps[key] = value
+			}
+		}
+		return s.renderDataset(ds), nil
+	}
+
+	s.handlers["pool.dataset.delete"] = func(params []interface{}) (interface{}, error) {
+		name := argString(params, 0)
+		options := argMap(params, 1)
+		if _, ok := s.datasets[name]; !ok {
+			return nil, instanceNotFound(name)
+		}
+		if parent(name) == "" {
+			return nil, ValidationError("pool_dataset_delete.id", EINVAL, "Root datasets can not be deleted")
+		}
+
+		recursive, _ := options["recursive"].(bool)
+		var doomed []string
+		for dsName := range s.datasets {
+			if isDescendant(dsName, name) {
+				doomed = append(doomed, dsName)
+			}
+		}
+		if !recursive && (len(doomed) > 1 || s.hasSnapshots(name)) {
+			return nil, CallError(EBUSY, "Failed to delete dataset: cannot destroy '%s': filesystem has children\nuse '-r' to destroy the following datasets", name)
+		}
+		for _, snap := range s.snapshots {
+			if !isDescendant(snap.dataset, name) {
+				continue
+			}
+			for _, clone := range s.clones(snap.id()) {
+				if !isDescendant(clone, name) {
+					return nil, CallError(EBUSY, "Failed to delete dataset: cannot destroy '%s': filesystem has dependent clones\nuse '-R' to destroy the following datasets:\n%s", name, clone)
+				}
+			}
+		}
+
+		for _, dsName := range doomed {
+			s.removeAttachments(dsName)
+			delete(s.datasets, dsName)
+		}
+		for id, snap := range s.snapshots {
+			if isDescendant(snap.dataset, name) {
+				delete(s.snapshots, id)
+			}
+		}
+		return true, nil
+	}
+
+	s.handlers["pool.dataset.rename"] = func(params []interface{}) (interface{}, error) {
+		name := argString(params, 0)
+		newName, _ := argMap(params, 1)["new_name"].(string)
+		if _, ok := s.datasets[name]; !ok {
+			return nil, instanceNotFound(name)
+		}
+		if _, ok := s.datasets[newName]; ok {
+			return nil, ValidationError("pool_dataset_rename.new_name", EEXIST, "%s already exists", newName)
+		}
+		if _, ok := s.datasets[parent(newName)]; !ok || pool(newName) != pool(name) {
+			return nil, ValidationError("pool_dataset_rename.new_name", EINVAL, "Parent dataset %s does not exist", parent(newName))
+		}
+
+		rename := func(old string) string {
+			if isDescendant(old, name) {
+				return newName + strings.TrimPrefix(old, name)
+			}
+			return old
+		}
+		datasets := make(map[string]*dataset, len(s.datasets))
+		for _, ds := range s.datasets {
+			ds.name = rename(ds.name)
+			if ds.origin != "" {
+				dsName, snapName, _ := strings.Cut(ds.origin, "@")
+				ds.origin = rename(dsName) + "@" + snapName
+			}
+			datasets[ds.name] = ds
+		}
+		snapshots := make(map[string]*snapshot, len(s.snapshots))
+		for _, snap := range s.snapshots {
+			snap.dataset = rename(snap.dataset)
+			snapshots[snap.id()] = snap
+		}
+		s.datasets = datasets
+		s.snapshots = snapshots
+		return s.job("pool.dataset.rename", true, nil), nil
+	}
+}
+
+// hasSnapshots reports whether a dataset has snapshots of its own.
+func (s *Server) hasSnapshots(name string) bool {
+	for _, snap := range s.snapshots {
+		if snap.dataset == name {
+			return true
+		}
+	}
+	return false
+}
+
+// registerSnapshots adds the snapshot methods under the configured prefix.
+func (s *Server) registerSnapshots() {
+	prefix := s.cfg.SnapshotAPIPrefix
+
+	s.handlers[prefix+".query"] = func(params []interface{}) (interface{}, error) {
+		var rows []map[string]interface{}
+		for _, snap := range s.sortedSnapshots() {
+			rows = append(rows, s.renderSnapshot(snap))
+		}
+		return query(rows, params)
+	}
+
+	s.handlers[prefix+".get_instance"] = func(params []interface{}) (interface{}, error) {
+		id := argString(params, 0)
+		snap, ok := s.snapshots[id]
+		if !ok {
+			return nil, instanceNotFound(id)
+		}
+		return s.renderSnapshot(snap), nil
+	}
+
+	s.handlers[prefix+".create"] = func(params []interface{}) (interface{}, error) {
+		p := argMap(params, 0)
+		dsName, _ := p["dataset"].(string)
+		name, _ := p["name"].(string)
+		if _, ok := s.datasets[dsName]; !ok {
+			return nil, ValidationError("snapshot_create.dataset", ENOENT, "Dataset %s does not exist", dsName)
+		}
+		snap := &snapshot{dataset: dsName, name: name, created: time.Now(), userProps: map[string]string{}}
+		if _, ok := s.snapshots[snap.id()]; ok {
+			return nil, ValidationError("snapshot_create.name", EEXIST, "Snapshot %s already exists", snap.id())
+		}
+		s.snapshots[snap.id()] = snap
+		return s.renderSnapshot(snap), nil
+	}
+
+	s.handlers[prefix+".update"] = func(params []interface{}) (interface{}, error) {
+		id := argString(params, 0)
+		snap, ok := s.snapshots[id]
+		if !ok {
+			return nil, instanceNotFound(id)
+		}
+		if updates, ok := argMap(params, 1)["user_properties_update"].([]interface{}); ok {
+			for _, u := range updates {
+				update, _ := u.(map[string]interface{})
+				key, _ := update["key"].(string)
+				if remove, _ := update["remove"].(bool); remove {
+					delete(snap.userProps, key)
+					continue
+				}
+				value, _ := update["value"].(string)
+				snap.userProps[key] = value
+			}
+		}
+		return s.renderSnapshot(snap), nil
+	}
+
+	s.handlers[prefix+".delete"] = func(params []interface{}) (interface{}, error) {
+		id := argString(params, 0)
+		if _, ok := s.snapshots[id]; !ok {
+			return nil, instanceNotFound(id)
+		}
+		if clones := s.clones(id); len(clones) > 0 {
+			return nil, ValidationError("options.defer", EBUSY, "Snapshot %s has dependent clones: %s", id, strings.Join(clones, ","))
+		}
+		delete(s.snapshots, id)
+		return true, nil
+	}
+
+	s.handlers[prefix+".clone"] = func(params []interface{}) (interface{}, error) {
+		p := argMap(params, 0)
+		id, _ := p["snapshot"].(string)
+		dst, _ := p["dataset_dst"].(string)
+		snap, ok := s.snapshots[id]
+		if !ok {
+			return nil, ValidationError("snapshot_clone.snapshot", ENOENT, "Snapshot %s does not exist", id)
+		}
+		if _, ok := s.datasets[dst]; ok {
+			return nil, ValidationError("snapshot_clone.dataset_dst", EEXIST, "Dataset %s already exists", dst)
+		}
+		if _, ok := s.datasets[parent(dst)]; !ok {
+			return nil, ValidationError("snapshot_clone.dataset_dst", ENOENT, "Parent dataset %s does not exist", parent(dst))
+		}
+
+		// Clones keep the source's shape but not its local user properties
+		source := s.datasets[snap.dataset]
+		s.datasets[dst] = &dataset{
+			name:         dst,
+			typ:          source.typ,
+			volsize:      source.volsize,
+			volblocksize: source.volblocksize,
+			sparse:       true,
+			origin:       id,
+			userProps:    map[string]string{},
+		}
+		return true, nil
+	}
+
+	s.handlers[prefix+".rollback"] = func(params []interface{}) (interface{}, error) {
+		id := argString(params, 0)
+		snap, ok := s.snapshots[id]
+		if !ok {
+			return nil, instanceNotFound(id)
+		}
+		recursive, _ := argMap(params, 1)["recursive"].(bool)
+		for _, other := range s.snapshots {
+			if other.dataset == snap.dataset && other.created.After(snap.created) {
+				if !recursive {
+					return nil, CallError(EBUSY, "cannot rollback to '%s': more recent snapshots or bookmarks exist\nuse '-r' to force deletion of the following snapshots and bookmarks:\n%s", id, other.id())
+				}
+				delete(s.snapshots, other.id())
+			}
+		}
+		return nil, nil
+	}
+}
@@ -0,0 +1,181 @@
+package faketruenas
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// query applies TrueNAS query-filters and query-options (params[0] and params[1]) to rows.
+// Supported operators are = != > >= < <= ~ in nin ^ $, and the options limit, offset,
+// order_by, count and get. Field names may be dotted to reach nested objects.
+func query(rows []map[string]interface{}, params []interface{}) (interface{}, error) {
+	var filters []interface{}
+	if len(params) > 0 {
+		filters, _ = params[0].([]interface{})
+	}
+	options := argMap(params, 1)
+
+	matched := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		ok, err := matchAll(row, filters)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, row)
+		}
+	}
+
+	if orderBy, ok := options["order_by"].([]interface{}); ok {
+		sortRows(matched, orderBy)
+	}
+	if offset, ok := options["offset"].(float64); ok && offset > 0 {
+		if int(offset) >= len(matched) {
+			matched = matched[:0]
+		} else {
+			matched = matched[int(offset):]
+		}
+	}
+	if limit, ok := options["limit"].(float64); ok && limit > 0 && int(limit) < len(matched) {
+		matched = matched[:int(limit)]
+	}
+
+	if count, _ := options["count"].(bool); count {
+		return float64(len(matched)), nil
+	}
+	if get, _ := options["get"].(bool); get {
+		if len(matched) == 0 {
+			return nil, CallError(ENOENT, "Object not found")
+		}
+		return matched[0], nil
+	}
+
+	result := make([]interface{}, len(matched))
+	for i, row := range matched {
+		result[i] = row
+	}
+	return result, nil
+}
+
+// matchAll reports whether row satisfies every filter.
+func matchAll(row map[string]interface{}, filters []interface{}) (bool, error) {
+	for _, f := range filters {
+		filter, ok := f.([]interface{})
+		if !ok || len(filter) != 3 {
+			return false, ValidationError("query-filters", EINVAL, "Invalid filter %v", f)
+		}
+		field, _ := filter[0].(string)
+		op, _ := filter[1].(string)
+		ok, err := match(lookup(row, field), op, filter[2])
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// match applies a single filter operator.
+func match(value interface{}, op string, operand interface{}) (bool, error) {
+	switch op {
+	case "=":
+		return equal(value, operand), nil
+	case "!=":
+		return !equal(value, operand), nil
+	case ">", ">=", "<", "<=":
+		a, aok := value.(float64)
+		b, bok := operand.(float64)
+		if !aok || !bok {
+			sa, _ := value.(string)
+			sb, _ := operand.(string)
+			return compare(strings.Compare(sa, sb), op), nil
+		}
+		switch {
+		case a < b:
+			return compare(-1, op), nil
+		case a > b:
+			return compare(1, op), nil
+		}
+		return compare(0, op), nil
+	case "~":
+		s, _ := value.(string)
+		pattern, _ := operand.(string)
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return false, ValidationError("query-filters", EINVAL, "Invalid regex %q", pattern)
+		}
+		return re.MatchString(s), nil
+	case "in", "nin":
+		list, _ := operand.([]interface{})
+		found := false
+		for _, item := range list {
+			if equal(value, item) {
+				found = true
+				break
+			}
+		}
+		return found == (op == "in"), nil
+	case "^":
+		s, _ := value.(string)
+		prefix, _ := operand.(string)
+		return strings.HasPrefix(s, prefix), nil
+	case "$":
+		s, _ := value.(string)
+		suffix, _ := operand.(string)
+		return strings.HasSuffix(s, suffix), nil
+	}
+	return false, ValidationError("query-filters", EINVAL, "Invalid operation: %s", op)
+}
+
+// compare converts a three-way comparison result into the outcome of op.
+func compare(c int, op string) bool {
+	switch op {
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	}
+	return c <= 0
+}
+
+// equal compares decoded JSON values.
+func equal(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// lookup returns the value of a dotted field name, or nil if it doesn't exist.
+func lookup(row map[string]interface{}, field string) interface{} {
+	var value interface{} = row
+	for _, part := range strings.Split(field, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[part]
+	}
+	return value
+}
+
+// sortRows orders rows by the order_by fields; a leading "-" sorts descending.
+func sortRows(rows []map[string]interface{}, orderBy []interface{}) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, o := range orderBy {
+			field, _ := o.(string)
+			desc := strings.HasPrefix(field, "-")
+			field = strings.TrimPrefix(field, "-")
+			a, b := lookup(rows[i], field), lookup(rows[j], field)
+			if equal(a, b) {
+				continue
+			}
+			less, _ := match(a, "<", b)
+			return less != desc
+		}
+		return false
+	})
+}
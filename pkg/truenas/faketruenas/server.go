@@ -0,0 +1,667 @@
+// Package faketruenas is an in-process TrueNAS SCALE middleware for integration tests.
+// It serves the JSON-RPC 2.0 WebSocket API at /api/current and keeps pools, datasets,
+// snapshots, NFS shares, iSCSI and NVMe-oF objects in memory, so the real truenas.Client
+// (and the driver on top of it) can be exercised end to end without a TrueNAS system.
+//
+// Results and errors follow the shapes TrueNAS 25.04 sends on the wire: numbers are
+// JSON numbers, dataset properties are {value, rawvalue, parsed, source} objects,
+// validation failures are -32602 "Invalid params" and call errors are -32001
+// "Method call error", both with errno details in the error data.
+package faketruenas
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// JSON-RPC error codes sent by the TrueNAS middleware.
+const (
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeCallError      = -32001
+)
+
+// Errno values used in error data.
+const (
+	ENOENT = 2
+	EACCES = 13
+	EBUSY  = 16
+	EEXIST = 17
+	EINVAL = 22
+)
+
+var errnoNames = map[int]string{
+	ENOENT: "ENOENT",
+	EACCES: "EACCES",
+	EBUSY:  "EBUSY",
+	EEXIST: "EEXIST",
+	EINVAL: "EINVAL",
+}
+
+// Error is a middleware error. Handlers return it to control the JSON-RPC error sent
+// to the client; any other error is sent as an EINVAL call error.
+type Error struct {
+	Code    int
+	Message string
+	Errno   int
+	Reason  string
+	// Attribute is the schema path of the invalid field for validation errors.
+	Attribute string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("[%d] %s: %s", e.Code, e.Message, e.Reason)
+}
+
+// data renders the error payload the middleware puts in the JSON-RPC error.
+func (e *Error) data() map[string]interface{} {
+	errname := errnoNames[e.Errno]
+	reason := e.Reason
+	var extra interface{}
+	if e.Code == CodeInvalidParams {
+		extra = []interface{}{[]interface{}{e.Attribute, e.Reason, float64(e.Errno)}}
+		reason = fmt.Sprintf("[%s] %s: %s", errname, e.Attribute, e.Reason)
+	}
+	return map[string]interface{}{
+		"error":   float64(e.Errno),
+		"errname": errname,
+		"reason":  reason,
+		"trace":   map[string]interface{}{"class": "CallError", "frames": []interface{}{}, "formatted": ""},
+		"extra":   extra,
+	}
+}
+
+// CallError returns a -32001 "Method call error" with the given errno.
+func CallError(errno int, format string, args ...interface{}) *Error {
+	return &Error{Code: CodeCallError, Message: "Method call error", Errno: errno, Reason: fmt.Sprintf(format, args...)}
+}
+
+// ValidationError returns a -32602 "Invalid params" error for one schema attribute.
+func ValidationError(attribute string, errno int, format string, args ...interface{}) *Error {
+	return &Error{Code: CodeInvalidParams, Message: "Invalid params", Errno: errno, Attribute: attribute, Reason: fmt.Sprintf(format, args...)}
+}
+
+// instanceNotFound is the error get_instance, update and delete return for unknown IDs.
+func instanceNotFound(id interface{}) *Error {
+	return ValidationError("None", ENOENT, "%v does not exist", id)
+}
+
+// unauthenticatedMethods may be called before logging in.
+var unauthenticatedMethods = map[string]bool{
+	"auth.login":              true,
+	"auth.login_with_api_key": true,
+	"auth.login_with_token":   true,
+	"core.ping":               true,
+}
+
+// Handler implements one API method. params are the decoded JSON-RPC params.
+type Handler func(params []interface{}) (interface{}, error)
+
+// Config configures a Server.
+type Config struct {
+	// APIKey is accepted by auth.login_with_api_key. Empty accepts any key.
+	APIKey string
+	// Username and Password are accepted by auth.login. Empty accepts any credentials.
+	Username string
+	Password string
+	// Pools are created as root datasets (default: tank)
+	Pools []string
+	// PoolFree is the free space reported for each pool in bytes (default: 100GiB)
+	PoolFree int64
+	// SnapshotAPIPrefix is "pool.snapshot" (TrueNAS 25.04+, default) or "zfs.snapshot" (24.x).
+	// Only the configured prefix is served.
+	SnapshotAPIPrefix string
+	// Version is returned by system.version (default: 25.10.0). nvmet.* is only served
+	// from 25.10 on.
+	Version string
+	// ISCSIExtentFields restricts the fields iscsi.extent.create accepts and core.get_methods
+	// reports, to mimic releases that dropped or haven't added a field. Empty accepts all.
+	ISCSIExtentFields []string
+}
+
+// Server is a fake TrueNAS middleware listening on a local port.
+type Server struct {
+	cfg    Config
+	server *httptest.Server
+
+	mu        sync.Mutex
+	handlers  map[string]Handler // Built-in methods, called with mu held
+	overrides map[string]Handler // Set by Handle, called without mu
+	calls     []string
+	conns     map[*websocket.Conn]bool
+
+	// core.subscribe subscriptions by connection, then ID, and the rows last published
+	// for each subscribed collection
+	subscriptions map[*websocket.Conn]map[string]string
+	published     map[string]map[string]interface{}
+	nextSubID     int
+	eventsPaused  bool
+	writeMu       sync.Mutex // Serializes writes, as events go to other connections
+
+	datasets  map[string]*dataset
+	snapshots map[string]*snapshot
+	tables    map[string]*table
+	tokens    map[string]bool
+	jobs      []map[string]interface{}
+}
+
+// New starts a fake TrueNAS server. Close it when done.
+func New(cfg Config) *Server {
+	if len(cfg.Pools) == 0 {
+		cfg.Pools = []string{"tank"}
+	}
+	if cfg.PoolFree == 0 {
+		cfg.PoolFree = 100 << 30
+	}
+	if cfg.SnapshotAPIPrefix == "" {
+		cfg.SnapshotAPIPrefix = "pool.snapshot"
+	}
+	if cfg.Version == "" {
+		cfg.Version = "25.10.0"
+	}
+
+	s := &Server{
+		cfg:       cfg,
+		handlers:  make(map[string]Handler),
+		overrides: make(map[string]Handler),
+		conns:     make(map[*websocket.Conn]bool),
+		published: make(map[string]map[string]interface{}),
+		datasets:  make(map[string]*dataset),
+		snapshots: make(map[string]*snapshot),
+		tables:    make(map[string]*table),
+		tokens:    make(map[string]bool),
+	}
+	for _, pool := range cfg.Pools {
+		s.datasets[pool] = &dataset{name: pool, typ: "FILESYSTEM", userProps: map[string]string{}}
+	}
+
+	s.registerCore()
+	s.registerDatasets()
+	s.registerSnapshots()
+	s.registerSharing()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/current", s.serveWebSocket)
+	s.server = httptest.NewServer(mux)
+	return s
+}
+
+// Close disconnects all clients and stops the server.
+func (s *Server) Close() {
+	s.Disconnect()
+	s.server.Close()
+}
+
+// Host returns the address the server listens on.
+func (s *Server) Host() string {
+	host, _, _ := strings.Cut(strings.TrimPrefix(s.server.URL, "http://"), ":")
+	return host
+}
+
+// Port returns the port the server listens on.
+func (s *Server) Port() int {
+	_, port, _ := strings.Cut(strings.TrimPrefix(s.server.URL, "http://"), ":")
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+// Handle replaces the implementation of method, or adds a method the server doesn't
+// implement. Overrides run without the server lock, so they may call other Server methods.
+func (s *Server) Handle(method string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides[method] = h
+}
+
+// Calls returns the methods called so far, in order.
+func (s *Server) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+// CallCount returns how many times method was called.
+func (s *Server) CallCount(method string) int {
+	n := 0
+	for _, call := range s.Calls() {
+		if call == method {
+			n++
+		}
+	}
+	return n
+}
+
+// Disconnect closes every open WebSocket connection, as a middleware restart would.
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	conns := make([]*websocket.Conn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.mu.Unlock()
+
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+}
+
+// request is a JSON-RPC 2.0 request.
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  []interface{}   `json:"params"`
+}
+
+// serveWebSocket upgrades the connection and answers requests until it closes.
+// Requests on one connection are answered in order.
+func (s *Server) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.conns[conn] = false
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		delete(s.subscriptions, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	for {
+		var req request
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+
+		result, err := s.dispatch(conn, req.Method, req.Params)
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+		if err != nil {
+			apiErr, ok := err.(*Error)
+			if !ok {
+				apiErr = CallError(EINVAL, "%v", err)
+			}
+			resp["error"] = map[string]interface{}{
+				"code":    apiErr.Code,
+				"message": apiErr.Message,
+				"data":    apiErr.data(),
+			}
+		} else {
+			resp["result"] = result
+		}
+		if err := s.write(conn, resp); err != nil {
+			return
+		}
+		s.publish()
+	}
+}
+
+// write sends one message on conn.
+func (s *Server) write(conn *websocket.Conn, msg interface{}) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return conn.WriteJSON(msg)
+}
+
+// subscribe adds a core.subscribe subscription to "<collection>.query" events.
+func (s *Server) subscribe(conn *websocket.Conn, name string) (interface{}, error) {
+	collection := strings.TrimSuffix(name, ".query")
+	if !strings.HasSuffix(name, ".query") || s.handlers[name] == nil {
+		return nil, CallError(ENOENT, "Event %s does not exist", name)
+	}
+	if s.subscriptions == nil {
+		s.subscriptions = make(map[*websocket.Conn]map[string]string)
+	}
+	if s.subscriptions[conn] == nil {
+		s.subscriptions[conn] = make(map[string]string)
+	}
+	if _, ok := s.published[collection]; !ok {
+		s.published[collection] = s.rows(collection)
+	}
+	s.nextSubID++
+	id := fmt.Sprintf("sub-%d", s.nextSubID)
+	s.subscriptions[conn][id] = collection
+	return id, nil
+}
+
+// PauseEvents holds back collection_update events, as a busy middleware delays them,
+// until ResumeEvents.
+func (s *Server) PauseEvents() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.eventsPaused = true
+}
+
+// ResumeEvents sends the events held back since PauseEvents.
+func (s *Server) ResumeEvents() {
+	s.mu.Lock()
+	s.eventsPaused = false
+	s.mu.Unlock()
+	s.publish()
+}
+
+// publish sends collection_update events for every row of a subscribed collection that
+// was added, changed or removed since the last publish, like the middleware does for
+// CRUD services.
+func (s *Server) publish() {
+	type event struct {
+		conn *websocket.Conn
+		msg  map[string]interface{}
+	}
+	var events []event
+
+	s.mu.Lock()
+	if s.eventsPaused {
+		s.mu.Unlock()
+		return
+	}
+	for collection, before := range s.published {
+		var subscribers []*websocket.Conn
+		for conn, subs := range s.subscriptions {
+			for _, c := range subs {
+				if c == collection {
+					subscribers = append(subscribers, conn)
+					break
+				}
+			}
+		}
+		if len(subscribers) == 0 {
+			continue
+		}
+		after := s.rows(collection)
+		s.published[collection] = after
+
+		var changes []map[string]interface{}
+		for _, id := range sortedKeys(after) {
+			row := after[id].(map[string]interface{})
+			if old, ok := before[id]; !ok {
+				changes = append(changes, map[string]interface{}{"msg": "added", "id": row["id"], "fields": row})
+			} else if !equal(old, row) {
+				changes = append(changes, map[string]interface{}{"msg": "changed", "id": row["id"], "fields": row})
+			}
+		}
+		for _, id := range sortedKeys(before) {
+			if _, ok := after[id]; !ok {
+				changes = append(changes, map[string]interface{}{"msg": "removed", "id": before[id].(map[string]interface{})["id"]})
+			}
+		}
+		for _, change := range changes {
+			change["collection"] = collection + ".query"
+			for _, conn := range subscribers {
+				events = append(events, event{conn, map[string]interface{}{"jsonrpc": "2.0", "method": "collection_update", "params": change}})
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	for _, e := range events {
+		_ = s.write(e.conn, e.msg)
+	}
+}
+
+// rows returns copies of every row of collection, keyed by ID. Callers hold mu.
+func (s *Server) rows(collection string) map[string]interface{} {
+	rows := make(map[string]interface{})
+	result, err := s.handlers[collection+".query"](nil)
+	if err != nil {
+		return rows
+	}
+	data, _ := json.Marshal(result)
+	var list []interface{}
+	_ = json.Unmarshal(data, &list)
+	for _, r := range list {
+		if row, ok := r.(map[string]interface{}); ok {
+			rows[fmt.Sprint(row["id"])] = row
+		}
+	}
+	return rows
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// dispatch runs one method, enforcing authentication for everything but auth.* and core.ping.
+func (s *Server) dispatch(conn *websocket.Conn, method string, params []interface{}) (interface{}, error) {
+	s.mu.Lock()
+	s.calls = append(s.calls, method)
+	authenticated := s.conns[conn]
+	override := s.overrides[method]
+	handler := s.handlers[method]
+
+	if !authenticated && !unauthenticatedMethods[method] {
+		s.mu.Unlock()
+		return nil, CallError(EACCES, "Not authenticated")
+	}
+
+	if override != nil {
+		s.mu.Unlock()
+		return override(params)
+	}
+	defer s.mu.Unlock()
+
+	switch method {
+	case "auth.login_with_api_key":
+		ok := s.cfg.APIKey == "" || argString(params, 0) == s.cfg.APIKey
+		s.conns[conn] = ok
+		return ok, nil
+	case "auth.login":
+		ok := (s.cfg.Username == "" || argString(params, 0) == s.cfg.Username) &&
+			(s.cfg.Password == "" || argString(params, 1) == s.cfg.Password)
+		s.conns[conn] = ok
+		return ok, nil
+	case "auth.login_with_token":
+		ok := s.tokens[argString(params, 0)]
+		s.conns[conn] = ok
+		return ok, nil
+	case "auth.logout":
+		s.conns[conn] = false
+		return true, nil
+	case "core.subscribe":
+		return s.subscribe(conn, argString(params, 0))
+	case "core.unsubscribe":
+		delete(s.subscriptions[conn], argString(params, 0))
+		return nil, nil
+	}
+
+	if handler == nil {
+		return nil, &Error{Code: CodeMethodNotFound, Message: "Method not found", Errno: EINVAL, Reason: fmt.Sprintf("Method %q not found", method)}
+	}
+	return handler(params)
+}
+
+// registerCore adds core.*, system.version, auth.generate_token and service.reload.
+func (s *Server) registerCore() {
+	s.handlers["core.ping"] = func(params []interface{}) (interface{}, error) {
+		return "pong", nil
+	}
+	s.handlers["system.version"] = func(params []interface{}) (interface{}, error) {
+		return s.cfg.Version, nil
+	}
+	s.handlers["core.get_methods"] = func(params []interface{}) (interface{}, error) {
+		return s.methods(argString(params, 0)), nil
+	}
+	s.handlers["auth.generate_token"] = func(params []interface{}) (interface{}, error) {
+		token := fmt.Sprintf("token-%d", len(s.tokens)+1)
+		s.tokens[token] = true
+		return token, nil
+	}
+	s.handlers["core.get_jobs"] = func(params []interface{}) (interface{}, error) {
+		return query(s.jobs, params)
+	}
+	s.handlers["service.reload"] = func(params []interface{}) (interface{}, error) {
+		return s.job("service.reload", true, nil), nil
+	}
+}
+
+// methods describes the methods of service, or of every service if it is empty, like
+// core.get_methods. Only iscsi.extent.create has a schema for its arguments.
+func (s *Server) methods(service string) map[string]interface{} {
+	methods := make(map[string]interface{})
+	for method := range s.handlers {
+		if service != "" && !strings.HasPrefix(method, service+".") {
+			continue
+		}
+		methods[method] = map[string]interface{}{"accepts": []interface{}{}}
+	}
+	if info, ok := methods["iscsi.extent.create"].(map[string]interface{}); ok {
+		properties := make(map[string]interface{})
+		for _, field := range s.iscsiExtentFields() {
+			properties[field] = map[string]interface{}{}
+		}
+		info["accepts"] = []interface{}{map[string]interface{}{
+			"type":       "object",
+			"title":      "iscsi_extent_create",
+			"properties": properties,
+		}}
+	}
+	return methods
+}
+
+// RevokeTokens invalidates every token issued by auth.generate_token.
+func (s *Server) RevokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = make(map[string]bool)
+}
+
+// job records a finished job and returns its ID, as methods that run as jobs do.
+// A non-nil err fails the job.
+func (s *Server) job(method string, result interface{}, err error) float64 {
+	id := float64(len(s.jobs) + 1)
+	job := map[string]interface{}{
+		"id":        id,
+		"method":    method,
+		"state":     "SUCCESS",
+		"progress":  map[string]interface{}{"percent": float64(100), "description": ""},
+		"result":    result,
+		"error":     nil,
+		"exception": nil,
+		"exc_info":  nil,
+	}
+	if err != nil {
+		callErr, ok := err.(*Error)
+		if !ok {
+			callErr = CallError(EINVAL, "%s", err.Error())
+		}
+		errname := errnoNames[callErr.Errno]
+		job["state"] = "FAILED"
+		job["result"] = nil
+		job["error"] = fmt.Sprintf("[%s] %s", errname, callErr.Reason)
+		job["exception"] = "Traceback (most recent call last):\n" + err.Error()
+		job["exc_info"] = map[string]interface{}{
+			"repr":  fmt.Sprintf("CallError('%s', %d)", callErr.Reason, callErr.Errno),
+			"type":  "CallError",
+			"errno": float64(callErr.Errno),
+			"extra": nil,
+		}
+	}
+	s.jobs = append(s.jobs, job)
+	return id
+}
+
+// table holds objects with integer IDs, such as shares and iSCSI targets.
+type table struct {
+	nextID int
+	rows   map[int]map[string]interface{}
+}
+
+// table returns the named table, creating it on first use.
+func (s *Server) table(name string) *table {
+	t, ok := s.tables[name]
+	if !ok {
+		t = &table{rows: make(map[int]map[string]interface{})}
+		s.tables[name] = t
+	}
+	return t
+}
+
+// insert assigns the next ID to row and stores it.
+func (t *table) insert(row map[string]interface{}) map[string]interface{} {
+	t.nextID++
+	row["id"] = float64(t.nextID)
+	t.rows[t.nextID] = row
+	return row
+}
+
+// list returns the rows ordered by ID.
+func (t *table) list() []map[string]interface{} {
+	ids := make([]int, 0, len(t.rows))
+	for id := range t.rows {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	rows := make([]map[string]interface{}, 0, len(ids))
+	for _, id := range ids {
+		rows = append(rows, t.rows[id])
+	}
+	return rows
+}
+
+// find returns the first row where field equals value.
+func (t *table) find(field string, value interface{}) map[string]interface{} {
+	for _, row := range t.list() {
+		if equal(row[field], value) {
+			return row
+		}
+	}
+	return nil
+}
+
+// argString returns params[i] as a string, or "" if it isn't one.
+func argString(params []interface{}, i int) string {
+	if i < len(params) {
+		if v, ok := params[i].(string); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// argInt returns params[i] as an int, or 0 if it isn't a number.
+func argInt(params []interface{}, i int) int {
+	if i < len(params) {
+		if v, ok := params[i].(float64); ok {
+			return int(v)
+		}
+	}
+	return 0
+}
+
+// argBool returns params[i] as a bool, or false if it isn't one.
+func argBool(params []interface{}, i int) bool {
+	if i < len(params) {
+		if v, ok := params[i].(bool); ok {
+			return v
+		}
+	}
+	return false
+}
+
+// argMap returns params[i] as an object, or an empty one if it isn't one.
+func argMap(params []interface{}, i int) map[string]interface{} {
+	if i < len(params) {
+		if v, ok := params[i].(map[string]interface{}); ok {
+			return v
+		}
+	}
+	return map[string]interface{}{}
+}
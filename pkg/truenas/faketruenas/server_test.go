@@ -0,0 +1,124 @@
+package faketruenas
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// call sends one JSON-RPC request and returns the decoded response.
+func call(t *testing.T, conn *websocket.Conn, method string, params ...interface{}) map[string]interface{} {
+	t.Helper()
+	assert.NoError(t, conn.WriteJSON(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": method, "params": params}))
+	var resp map[string]interface{}
+	assert.NoError(t, conn.ReadJSON(&resp))
+	return resp
+}
+
+// dial connects to the server's API endpoint.
+func dial(t *testing.T, s *Server) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s:%d/api/current", s.Host(), s.Port()), nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestServer(t *testing.T) {
+	s := New(Config{APIKey: "1-valid"})
+	defer s.Close()
+	conn := dial(t, s)
+
+	// Calls before logging in are rejected
+	resp := call(t, conn, "pool.dataset.query")
+	assert.Equal(t, float64(CodeCallError), resp["error"].(map[string]interface{})["code"])
+
+	assert.Equal(t, false, call(t, conn, "auth.login_with_api_key", "1-revoked")["result"])
+	assert.Equal(t, true, call(t, conn, "auth.login_with_api_key", "1-valid")["result"])
+
+	// Validation errors carry the attribute and errno
+	resp = call(t, conn, "pool.dataset.create", map[string]interface{}{"name": "tank"})
+	apiErr := resp["error"].(map[string]interface{})
+	assert.Equal(t, float64(CodeInvalidParams), apiErr["code"])
+	assert.Equal(t, "Invalid params", apiErr["message"])
+	data := apiErr["data"].(map[string]interface{})
+	assert.Equal(t, "EEXIST", data["errname"])
+	assert.Equal(t, "[EEXIST] pool_dataset_create.name: Path tank already exists", data["reason"])
+
+	// Unknown methods
+	resp = call(t, conn, "pool.unknown")
+	assert.Equal(t, float64(CodeMethodNotFound), resp["error"].(map[string]interface{})["code"])
+
+	// Overrides replace built-in methods
+	s.Handle("pool.dataset.query", func(params []interface{}) (interface{}, error) {
+		return nil, CallError(EBUSY, "middleware is busy")
+	})
+	resp = call(t, conn, "pool.dataset.query")
+	assert.Equal(t, "middleware is busy", resp["error"].(map[string]interface{})["data"].(map[string]interface{})["reason"])
+
+	assert.Equal(t, []string{
+		"pool.dataset.query",
+		"auth.login_with_api_key",
+		"auth.login_with_api_key",
+		"pool.dataset.create",
+		"pool.unknown",
+		"pool.dataset.query",
+	}, s.Calls())
+}
+
+func TestQuery(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"id": float64(1), "name": "tank/a", "props": map[string]interface{}{"size": float64(30)}},
+		{"id": float64(2), "name": "tank/b", "props": map[string]interface{}{"size": float64(10)}},
+		{"id": float64(3), "name": "tank/b/c", "props": map[string]interface{}{"size": float64(20)}},
+	}
+
+	tests := []struct {
+		name    string
+		filters []interface{}
+		options map[string]interface{}
+		want    []float64
+	}{
+		{"no filters", nil, nil, []float64{1, 2, 3}},
+		{"equal", []interface{}{[]interface{}{"id", "=", float64(2)}}, nil, []float64{2}},
+		{"not equal", []interface{}{[]interface{}{"name", "!=", "tank/a"}}, nil, []float64{2, 3}},
+		{"prefix", []interface{}{[]interface{}{"name", "^", "tank/b"}}, nil, []float64{2, 3}},
+		{"suffix", []interface{}{[]interface{}{"name", "$", "/c"}}, nil, []float64{3}},
+		{"regex", []interface{}{[]interface{}{"name", "~", ".*/[ab]$"}}, nil, []float64{1, 2}},
+		{"in", []interface{}{[]interface{}{"id", "in", []interface{}{float64(1), float64(3)}}}, nil, []float64{1, 3}},
+		{"nin", []interface{}{[]interface{}{"id", "nin", []interface{}{float64(1), float64(3)}}}, nil, []float64{2}},
+		{"nested greater", []interface{}{[]interface{}{"props.size", ">", float64(15)}}, nil, []float64{1, 3}},
+		{"and", []interface{}{[]interface{}{"name", "^", "tank/b"}, []interface{}{"props.size", "<=", float64(10)}}, nil, []float64{2}},
+		{"order by", nil, map[string]interface{}{"order_by": []interface{}{"props.size"}}, []float64{2, 3, 1}},
+		{"order by descending", nil, map[string]interface{}{"order_by": []interface{}{"-name"}}, []float64{3, 2, 1}},
+		{"limit and offset", nil, map[string]interface{}{"limit": float64(1), "offset": float64(1)}, []float64{2}},
+		{"offset past end", nil, map[string]interface{}{"offset": float64(5)}, []float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sorted := append([]map[string]interface{}(nil), rows...)
+			result, err := query(sorted, []interface{}{tt.filters, tt.options})
+			assert.NoError(t, err)
+			ids := []float64{}
+			for _, row := range result.([]interface{}) {
+				ids = append(ids, row.(map[string]interface{})["id"].(float64))
+			}
+			assert.Equal(t, tt.want, ids)
+		})
+	}
+
+	// get returns a single object
+	result, err := query(rows, []interface{}{[]interface{}{[]interface{}{"id", "=", float64(3)}}, map[string]interface{}{"get": true}})
+	assert.NoError(t, err)
+	assert.Equal(t, "tank/b/c", result.(map[string]interface{})["name"])
+	_, err = query(rows, []interface{}{[]interface{}{[]interface{}{"id", "=", float64(4)}}, map[string]interface{}{"get": true}})
+	assert.Error(t, err)
+
+	// Invalid operators are validation errors
+	_, err = query(rows, []interface{}{[]interface{}{[]interface{}{"id", "like", "x"}}})
+	assert.Equal(t, CodeInvalidParams, err.(*Error).Code)
+}
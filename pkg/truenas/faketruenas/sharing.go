@@ -0,0 +1,460 @@
+package faketruenas
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Tables holding sharing objects, named after their API namespace.
+const (
+	nfsShares       = "sharing.nfs"
+	iscsiTargets    = "iscsi.target"
+	iscsiExtents    = "iscsi.extent"
+	iscsiTargetExts = "iscsi.targetextent"
+	iscsiPortals    = "iscsi.portal"
+	iscsiInitiators = "iscsi.initiator"
+	nvmetSubsystems = "nvmet.subsys"
+	nvmetNamespaces = "nvmet.namespace"
+	nvmetPorts      = "nvmet.port"
+)
+
+// Defaults of a fresh TrueNAS install.
+const (
+	iscsiBasename    = "iqn.2005-10.org.freenas.ctl"
+	defaultISCSIPort = 3260
+	defaultNVMePort  = 4420
+)
+
+// iscsiNameRegexp matches the names TrueNAS accepts for targets.
+var iscsiNameRegexp = regexp.MustCompile(`^[-a-z0-9.:]+$`)
+
+// stringList returns a list field of params, defaulting to an empty list.
+func stringList(p map[string]interface{}, field string) []interface{} {
+	if v, ok := p[field].([]interface{}); ok {
+		return v
+	}
+	return []interface{}{}
+}
+
+// zvolName returns the zvol behind an extent disk or namespace device path.
+func zvolName(path string) string {
+	path = strings.TrimPrefix(path, "/dev/")
+	return strings.TrimPrefix(path, "zvol/")
+}
+
+// isZvol reports whether name is an existing zvol.
+func (s *Server) isZvol(name string) bool {
+	ds, ok := s.datasets[name]
+	return ok && ds.typ == "VOLUME"
+}
+
+// removeAttachments deletes the shares of a dataset that is being destroyed, as the
+// middleware does for NFS shares, iSCSI extents and NVMe-oF namespaces.
+func (s *Server) removeAttachments(name string) {
+	shares := s.table(nfsShares)
+	for id, share := range shares.rows {
+		if share["path"] == "/mnt/"+name {
+			delete(shares.rows, id)
+		}
+	}
+	extents := s.table(iscsiExtents)
+	for id, extent := range extents.rows {
+		if disk, _ := extent["disk"].(string); zvolName(disk) == name {
+			s.deleteTargetExtents("extent", id)
+			delete(extents.rows, id)
+		}
+	}
+	namespaces := s.table(nvmetNamespaces)
+	for id, ns := range namespaces.rows {
+		if path, _ := ns["device_path"].(string); zvolName(path) == name {
+			delete(namespaces.rows, id)
+		}
+	}
+}
+
+// deleteTargetExtents removes the associations that reference a target or an extent.
+func (s *Server) deleteTargetExtents(field string, id int) {
+	assocs := s.table(iscsiTargetExts)
+	for assocID, assoc := range assocs.rows {
+		if assoc[field] == float64(id) {
+			delete(assocs.rows, assocID)
+		}
+	}
+}
+
+// registerTable adds <name>.query, plus <name>.delete(id) unless deleteFn is nil.
+func (s *Server) registerTable(name string, deleteFn func(id int, params []interface{}) error) {
+	s.handlers[name+".query"] = func(params []interface{}) (interface{}, error) {
+		rows := s.table(name).list()
+		if name == nvmetSubsystems {
+			for _, row := range rows {
+				row["namespaces"] = s.namespaceIDs(row["id"])
+			}
+		}
+		return query(rows, params)
+	}
+	if deleteFn == nil {
+		return
+	}
+	s.handlers[name+".delete"] = func(params []interface{}) (interface{}, error) {
+		id := argInt(params, 0)
+		if _, ok := s.table(name).rows[id]; !ok {
+			return nil, instanceNotFound(id)
+		}
+		if err := deleteFn(id, params); err != nil {
+			return nil, err
+		}
+		delete(s.table(name).rows, id)
+		return true, nil
+	}
+}
+
+// namespaceIDs returns the namespaces of an NVMe-oF subsystem.
+func (s *Server) namespaceIDs(subsystem interface{}) []interface{} {
+	ids := []interface{}{}
+	for _, ns := range s.table(nvmetNamespaces).list() {
+		if ns["subsystem"] == subsystem {
+			ids = append(ids, ns["id"])
+		}
+	}
+	return ids
+}
+
+// registerSharing adds sharing.nfs.*, iscsi.* and nvmet.*.
+func (s *Server) registerSharing() {
+	s.table(iscsiPortals).insert(map[string]interface{}{
+		"tag":     float64(1),
+		"comment": "",
+		"listen":  []interface{}{map[string]interface{}{"ip": "0.0.0.0", "port": float64(defaultISCSIPort)}},
+	})
+	s.table(iscsiInitiators).insert(map[string]interface{}{
+		"tag":        float64(1),
+		"comment":    "",
+		"initiators": []interface{}{},
+	})
+	s.table(nvmetPorts).insert(map[string]interface{}{
+		"index":        float64(1),
+		"transport":    "TCP",
+		"addr_trtype":  "TCP",
+		"addr_adrfam":  "IPV4",
+		"addr_traddr":  "0.0.0.0",
+		"addr_trsvcid": float64(defaultNVMePort),
+		"subsystems":   []interface{}{},
+		"enabled":      true,
+	})
+
+	s.registerNFS()
+	s.registerISCSI()
+	if major, minor := s.release(); major > 25 || (major == 25 && minor >= 10) {
+		s.registerNVMeoF()
+	}
+}
+
+// release parses the major and minor release from Config.Version.
+func (s *Server) release() (int, int) {
+	var major, minor int
+	_, _ = fmt.Sscanf(s.cfg.Version, "%d.%d", &major, &minor)
+	return major, minor
+}
+
+// allISCSIExtentFields are the fields iscsi.extent.create accepts on 25.04.
+var allISCSIExtentFields = []string{
+	"name", "type", "disk", "serial", "path", "filesize", "blocksize", "pblocksize",
+	"avail_threshold", "comment", "insecure_tpc", "xen", "rpm", "ro", "enabled", "product_id",
+}
+
+// iscsiExtentFields returns the fields iscsi.extent.create accepts.
+func (s *Server) iscsiExtentFields() []string {
+	if len(s.cfg.ISCSIExtentFields) > 0 {
+		return s.cfg.ISCSIExtentFields
+	}
+	return allISCSIExtentFields
+}
+
+// registerNFS adds sharing.nfs.*.
+func (s *Server) registerNFS() {
+	shares := s.table(nfsShares)
+
+	s.registerTable(nfsShares, func(id int, params []interface{}) error { return nil })
+
+	s.handlers["sharing.nfs.create"] = func(params []interface{}) (interface{}, error) {
+		p := argMap(params, 0)
+		path, _ := p["path"].(string)
+		if _, ok := s.datasets[strings.TrimPrefix(path, "/mnt/")]; !ok || !strings.HasPrefix(path, "/mnt/") {
+			return nil, ValidationError("sharingnfs_create.path", ENOENT, "Path %s does not exist", path)
+		}
+		if shares.find("path", path) != nil {
+			return nil, ValidationError("sharingnfs_create.path", EEXIST, "Another NFS share already exports this dataset for some network")
+		}
+
+		share := map[string]interface{}{
+			"path":             path,
+			"aliases":          []interface{}{},
+			"comment":          "",
+			"networks":         stringList(p, "networks"),
+			"hosts":            stringList(p, "hosts"),
+			"ro":               false,
+			"maproot_user":     nil,
+			"maproot_group":    nil,
+			"mapall_user":      nil,
+			"mapall_group":     nil,
+			"security":         stringList(p, "security"),
+			"enabled":          true,
+			"locked":           false,
+			"expose_snapshots": false,
+		}
+		for _, field := range []string{"comment", "ro", "maproot_user", "maproot_group", "mapall_user", "mapall_group", "enabled"} {
+			if v, ok := p[field]; ok {
+				share[field] = v
+			}
+		}
+		return shares.insert(share), nil
+	}
+
+	s.handlers["sharing.nfs.update"] = func(params []interface{}) (interface{}, error) {
+		id := argInt(params, 0)
+		share, ok := shares.rows[id]
+		if !ok {
+			return nil, instanceNotFound(id)
+		}
+		for field, v := range argMap(params, 1) {
+			if field == "id" {
+				continue
+			}
+			share[field] = v
+		}
+		return share, nil
+	}
+}
+
+// registerISCSI adds the iscsi.* methods.
+func (s *Server) registerISCSI() {
+	targets := s.table(iscsiTargets)
+	extents := s.table(iscsiExtents)
+	assocs := s.table(iscsiTargetExts)
+
+	s.handlers["iscsi.global.config"] = func(params []interface{}) (interface{}, error) {
+		return map[string]interface{}{
+			"id":                   float64(1),
+			"basename":             iscsiBasename,
+			"isns_servers":         []interface{}{},
+			"listen_port":          float64(defaultISCSIPort),
+			"pool_avail_threshold": nil,
+			"alua":                 false,
+		}, nil
+	}
+
+	s.registerTable(iscsiPortals, nil)
+	s.registerTable(iscsiInitiators, nil)
+
+	s.registerTable(iscsiTargets, func(id int, params []interface{}) error {
+		s.deleteTargetExtents("target", id)
+		return nil
+	})
+	s.handlers["iscsi.target.create"] = func(params []interface{}) (interface{}, error) {
+		p := argMap(params, 0)
+		name, _ := p["name"].(string)
+		if !iscsiNameRegexp.MatchString(name) {
+			return nil, ValidationError("iscsi_target_create.name", EINVAL, "Lowercase alphanumeric characters plus dot (.), dash (-), and colon (:) are allowed.")
+		}
+		if targets.find("name", name) != nil {
+			return nil, ValidationError("iscsi_target_create.name", EEXIST, "Target name already exists")
+		}
+
+		groups := []interface{}{}
+		for i, g := range stringList(p, "groups") {
+			group, _ := g.(map[string]interface{})
+			if _, ok := s.table(iscsiPortals).rows[int(toFloat(group["portal"]))]; !ok {
+				return nil, ValidationError(fmt.Sprintf("iscsi_target_create.groups.%d.portal", i), ENOENT, "%v Portal not found in database.", group["portal"])
+			}
+			rendered := map[string]interface{}{
+				"portal":     group["portal"],
+				"initiator":  group["initiator"],
+				"authmethod": "NONE",
+				"auth":       nil,
+			}
+			if v, ok := group["authmethod"].(string); ok && v != "" {
+				rendered["authmethod"] = v
+			}
+			if v, ok := group["auth"]; ok {
+				rendered["auth"] = v
+			}
+			groups = append(groups, rendered)
+		}
+
+		mode, _ := p["mode"].(string)
+		if mode == "" {
+			mode = "ISCSI"
+		}
+		return targets.insert(map[string]interface{}{
+			"name":          name,
+			"alias":         p["alias"],
+			"mode":          mode,
+			"groups":        groups,
+			"auth_networks": []interface{}{},
+		}), nil
+	}
+
+	s.registerTable(iscsiExtents, func(id int, params []interface{}) error {
+		s.deleteTargetExtents("extent", id)
+		return nil
+	})
+	s.handlers["iscsi.extent.create"] = func(params []interface{}) (interface{}, error) {
+		p := argMap(params, 0)
+		for field := range p {
+			if !slices.Contains(s.iscsiExtentFields(), field) {
+				return nil, ValidationError("iscsi_extent_create."+field, EINVAL, "Extra inputs are not permitted")
+			}
+		}
+		name, _ := p["name"].(string)
+		disk, _ := p["disk"].(string)
+		if extents.find("name", name) != nil {
+			return nil, ValidationError("iscsi_extent_create.name", EEXIST, "Extent name must be unique")
+		}
+		if !strings.HasPrefix(disk, "zvol/") || !s.isZvol(zvolName(disk)) {
+			return nil, ValidationError("iscsi_extent_create.disk", ENOENT, "Disk %s does not exist", disk)
+		}
+		if extents.find("disk", disk) != nil {
+			return nil, ValidationError("iscsi_extent_create.disk", EEXIST, "Disk currently in use by extent")
+		}
+
+		extent := map[string]interface{}{
+			"name":            name,
+			"type":            "DISK",
+			"disk":            disk,
+			"path":            disk,
+			"filesize":        "0",
+			"comment":         "",
+			"blocksize":       float64(512),
+			"pblocksize":      false,
+			"avail_threshold": nil,
+			"insecure_tpc":    true,
+			"xen":             false,
+			"rpm":             "SSD",
+			"ro":              false,
+			"enabled":         true,
+			"vendor":          "TrueNAS",
+			"locked":          false,
+		}
+		for _, field := range []string{"comment", "blocksize", "pblocksize", "insecure_tpc", "xen", "rpm", "ro", "enabled"} {
+			if v, ok := p[field]; ok {
+				extent[field] = v
+			}
+		}
+		extents.insert(extent)
+		id := int(extent["id"].(float64))
+		extent["serial"] = fmt.Sprintf("%012x", id)
+		extent["naa"] = fmt.Sprintf("0x6589cfc000000%019x", id)
+		return extent, nil
+	}
+
+	s.registerTable(iscsiTargetExts, func(id int, params []interface{}) error { return nil })
+	s.handlers["iscsi.targetextent.create"] = func(params []interface{}) (interface{}, error) {
+		p := argMap(params, 0)
+		target := toFloat(p["target"])
+		extent := toFloat(p["extent"])
+		if _, ok := targets.rows[int(target)]; !ok {
+			return nil, ValidationError("iscsi_targetextent_create.target", ENOENT, "Target %v does not exist", p["target"])
+		}
+		if _, ok := extents.rows[int(extent)]; !ok {
+			return nil, ValidationError("iscsi_targetextent_create.extent", ENOENT, "Extent %v does not exist", p["extent"])
+		}
+		if assocs.find("extent", extent) != nil {
+			return nil, ValidationError("iscsi_targetextent_create.extent", EEXIST, "Extent is already in use")
+		}
+
+		lunID := float64(0)
+		for _, assoc := range assocs.list() {
+			if assoc["target"] == target && toFloat(assoc["lunid"]) >= lunID {
+				lunID = toFloat(assoc["lunid"]) + 1
+			}
+		}
+		if v, ok := p["lunid"].(float64); ok {
+			for _, assoc := range assocs.list() {
+				if assoc["target"] == target && assoc["lunid"] == v {
+					return nil, ValidationError("iscsi_targetextent_create.lunid", EEXIST, "LUN ID is already being used for this target.")
+				}
+			}
+			lunID = v
+		}
+		return assocs.insert(map[string]interface{}{
+			"target": target,
+			"extent": extent,
+			"lunid":  lunID,
+		}), nil
+	}
+}
+
+// registerNVMeoF adds the nvmet.* methods.
+func (s *Server) registerNVMeoF() {
+	subsystems := s.table(nvmetSubsystems)
+	namespaces := s.table(nvmetNamespaces)
+
+	s.registerTable(nvmetPorts, nil)
+	s.handlers["nvmet.port.transport_address_choices"] = func(params []interface{}) (interface{}, error) {
+		return []interface{}{"0.0.0.0"}, nil
+	}
+
+	s.registerTable(nvmetSubsystems, func(id int, params []interface{}) error {
+		if ids := s.namespaceIDs(float64(id)); len(ids) > 0 {
+			return CallError(EBUSY, "Subsystem %d still has namespaces %v", id, ids)
+		}
+		return nil
+	})
+	s.handlers["nvmet.subsys.create"] = func(params []interface{}) (interface{}, error) {
+		p := argMap(params, 0)
+		nqn, _ := p["nqn"].(string)
+		if subsystems.find("nqn", nqn) != nil {
+			return nil, ValidationError("nvmet_subsys_create.nqn", EEXIST, "Subsystem with this NQN already exists")
+		}
+		allowAnyHost, _ := p["allow_any_host"].(bool)
+		subsys := subsystems.insert(map[string]interface{}{
+			"name":           nqn,
+			"nqn":            nqn,
+			"serial":         p["serial"],
+			"allow_any_host": allowAnyHost,
+			"hosts":          stringList(p, "hosts"),
+		})
+		subsys["namespaces"] = []interface{}{}
+		return subsys, nil
+	}
+
+	s.registerTable(nvmetNamespaces, func(id int, params []interface{}) error { return nil })
+	s.handlers["nvmet.namespace.create"] = func(params []interface{}) (interface{}, error) {
+		p := argMap(params, 0)
+		subsystem := toFloat(p["subsystem"])
+		devicePath, _ := p["device_path"].(string)
+		if _, ok := subsystems.rows[int(subsystem)]; !ok {
+			return nil, ValidationError("nvmet_namespace_create.subsystem", ENOENT, "Subsystem %v does not exist", p["subsystem"])
+		}
+		if !s.isZvol(zvolName(devicePath)) {
+			return nil, ValidationError("nvmet_namespace_create.device_path", ENOENT, "Device %s does not exist", devicePath)
+		}
+		for _, ns := range namespaces.list() {
+			if zvolName(ns["device_path"].(string)) == zvolName(devicePath) {
+				return nil, ValidationError("nvmet_namespace_create.device_path", EEXIST, "Device %s is already used by namespace %v", devicePath, ns["id"])
+			}
+		}
+
+		enabled := true
+		if v, ok := p["enabled"].(bool); ok {
+			enabled = v
+		}
+		return namespaces.insert(map[string]interface{}{
+			"subsystem":   subsystem,
+			"nsid":        float64(len(s.namespaceIDs(subsystem)) + 1),
+			"device_type": "ZVOL",
+			"device":      "/dev/zvol/" + zvolName(devicePath),
+			"device_path": devicePath,
+			"enabled":     enabled,
+		}), nil
+	}
+}
+
+// toFloat returns a decoded JSON number, or 0.
+func toFloat(v interface{}) float64 {
+	f, _ := v.(float64)
+	return f
+}
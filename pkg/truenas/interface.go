@@ -11,16 +11,28 @@ type ClientInterface interface {
 	// Core methods
 	Close() error
 	IsConnected() bool
+	Available() error
+	LastResponse() time.Time
+	Reconfigure(cfg *ClientConfig) error
 	Call(ctx context.Context, method string, params ...interface{}) (interface{}, error)
 	CallWithContext(ctx context.Context, method string, params ...interface{}) (interface{}, error) // Deprecated: Use Call instead
+	Capabilities(ctx context.Context) (*Capabilities, error)
+	CachedCapabilities() *Capabilities
+
+	// Job methods
+	CallJob(ctx context.Context, method string, params ...interface{}) (interface{}, error)
+	JobGet(ctx context.Context, id int) (*Job, error)
+	JobWait(ctx context.Context, id int, onProgress JobProgressFunc) (*Job, error)
 
 	// Dataset methods
 	DatasetCreate(ctx context.Context, params *DatasetCreateParams) (*Dataset, error)
 	DatasetDelete(ctx context.Context, name string, recursive bool, force bool) error
 	DatasetGet(ctx context.Context, name string) (*Dataset, error)
 	DatasetUpdate(ctx context.Context, name string, params *DatasetUpdateParams) (*Dataset, error)
+	DatasetRename(ctx context.Context, name string, newName string) error
 	DatasetList(ctx context.Context, parentName string, limit int, offset int) ([]*Dataset, error)
 	DatasetSetUserProperty(ctx context.Context, name string, key string, value string) error
+	DatasetSetUserProperties(ctx context.Context, name string, properties map[string]string) error
 	DatasetGetUserProperty(ctx context.Context, name string, key string) (string, error)
 	DatasetExpand(ctx context.Context, name string, newSize int64) error
 	DatasetExists(ctx context.Context, name string) (bool, error)
@@ -66,6 +78,8 @@ type ClientInterface interface {
 	ISCSITargetExtentFindByTarget(ctx context.Context, targetID int) ([]*ISCSITargetExtent, error)
 	ISCSITargetExtentFindByExtent(ctx context.Context, extentID int) ([]*ISCSITargetExtent, error)
 	ISCSIGlobalConfigGet(ctx context.Context) (*ISCSIGlobalConfig, error)
+	ISCSIPortalGet(ctx context.Context, id int) (*ISCSIPortal, error)
+	ISCSIInitiatorGet(ctx context.Context, id int) (*ISCSIInitiator, error)
 
 	// NVMe-oF methods
 	NVMeoFSubsystemCreate(ctx context.Context, nqn string, serial string, allowAnyHost bool, hosts []string) (*NVMeoFSubsystem, error)
@@ -78,4 +92,9 @@ type ClientInterface interface {
 	NVMeoFNamespaceFindByDevice(ctx context.Context, subsystemID int, devicePath string) (*NVMeoFNamespace, error)
 	NVMeoFPortList(ctx context.Context) ([]*NVMeoFPort, error)
 	NVMeoFGetTransportAddresses(ctx context.Context, transport string) ([]string, error)
+
+	// Replication methods
+	ReplicationCreate(ctx context.Context, params *ReplicationCreateParams) (*ReplicationTask, error)
+	ReplicationDelete(ctx context.Context, id int) error
+	ReplicationGet(ctx context.Context, id int) (*ReplicationTask, error)
 }
@@ -3,7 +3,8 @@ package truenas
 import (
 	"context"
 	"fmt"
-	"strings"
+
+	"k8s.io/klog/v2"
 )
 
 // ISCSITarget represents an iSCSI target from the TrueNAS API.
@@ -58,6 +59,20 @@ type ISCSIGlobalConfig struct {
 	PoolAvailThreshold int    `json:"pool_avail_threshold"`
 }
 
+// ISCSIPortal represents an iSCSI portal group from the TrueNAS API.
+type ISCSIPortal struct {
+	ID      int    `json:"id"`
+	Tag     int    `json:"tag"`
+	Comment string `json:"comment"`
+}
+
+// ISCSIInitiator represents an iSCSI initiator group from the TrueNAS API.
+type ISCSIInitiator struct {
+	ID      int    `json:"id"`
+	Tag     int    `json:"tag"`
+	Comment string `json:"comment"`
+}
+
 // ISCSITargetCreate creates a new iSCSI target.
 func (c *Client) ISCSITargetCreate(ctx context.Context, name string, alias string, mode string, groups []ISCSITargetGroup) (*ISCSITarget, error) {
 	// Convert groups to maps, omitting auth field when nil (TrueNAS API prefers no field vs null)
@@ -88,7 +103,7 @@ func (c *Client) ISCSITargetCreate(ctx context.Context, name string, alias strin
 	if err != nil {
 		// TrueNAS returns "Invalid params" when target already exists (not a helpful error message)
 		// Check if target exists and return it if so
-		if strings.Contains(err.Error(), "already exists") || strings.Contains(err.Error(), "Invalid params") {
+		if kind := ErrorKindOf(err); kind == ErrorAlreadyExists || kind == ErrorInvalid {
 			existing, findErr := c.ISCSITargetFindByName(ctx, name)
 			if findErr == nil && existing != nil {
 				return existing, nil
@@ -104,8 +119,7 @@ func (c *Client) ISCSITargetCreate(ctx context.Context, name string, alias strin
 func (c *Client) ISCSITargetDelete(ctx context.Context, id int, force bool) error {
 	_, err := c.Call(ctx, "iscsi.target.delete", id, force)
 	if err != nil {
-		if strings.Contains(err.Error(), "does not exist") ||
-			strings.Contains(err.Error(), "not found") {
+		if IsNotFoundError(err) {
 			return nil
 		}
 		return fmt.Errorf("failed to delete iSCSI target: %w", err)
@@ -123,7 +137,7 @@ func (c *Client) ISCSITargetGet(ctx context.Context, id int) (*ISCSITarget, erro
 
 	targets, ok := result.([]interface{})
 	if !ok || len(targets) == 0 {
-		return nil, fmt.Errorf("iSCSI target not found: %d", id)
+		return nil, notFoundf("iSCSI target not found: %d", id)
 	}
 
 	return parseISCSITarget(targets[0])
@@ -159,12 +173,21 @@ func (c *Client) ISCSIExtentCreate(ctx context.Context, name string, diskPath st
 		"ro":           false,
 		"enabled":      true,
 	}
+	// Leave out fields this release doesn't accept rather than have the create rejected
+	if caps, err := c.Capabilities(ctx); err == nil {
+		for field := range params {
+			if !caps.acceptsISCSIExtentField(field) {
+				klog.V(4).Infof("TrueNAS %s doesn't accept iSCSI extent field %q, omitting it", caps.Version, field)
+				delete(params, field)
+			}
+		}
+	}
 
 	result, err := c.Call(ctx, "iscsi.extent.create", params)
 	if err != nil {
 		// TrueNAS returns "Invalid params" when extent already exists
 		// Check if extent exists and return it if so
-		if strings.Contains(err.Error(), "already exists") || strings.Contains(err.Error(), "Invalid params") {
+		if kind := ErrorKindOf(err); kind == ErrorAlreadyExists || kind == ErrorInvalid {
 			existing, findErr := c.ISCSIExtentFindByName(ctx, name)
 			if findErr == nil && existing != nil {
 				return existing, nil
@@ -180,8 +203,7 @@ func (c *Client) ISCSIExtentCreate(ctx context.Context, name string, diskPath st
 func (c *Client) ISCSIExtentDelete(ctx context.Context, id int, remove bool, force bool) error {
 	_, err := c.Call(ctx, "iscsi.extent.delete", id, remove, force)
 	if err != nil {
-		if strings.Contains(err.Error(), "does not exist") ||
-			strings.Contains(err.Error(), "not found") {
+		if IsNotFoundError(err) {
 			return nil
 		}
 		return fmt.Errorf("failed to delete iSCSI extent: %w", err)
@@ -199,7 +221,7 @@ func (c *Client) ISCSIExtentGet(ctx context.Context, id int) (*ISCSIExtent, erro
 
 	extents, ok := result.([]interface{})
 	if !ok || len(extents) == 0 {
-		return nil, fmt.Errorf("iSCSI extent not found: %d", id)
+		return nil, notFoundf("iSCSI extent not found: %d", id)
 	}
 
 	return parseISCSIExtent(extents[0])
@@ -235,7 +257,7 @@ func (c *Client) ISCSITargetExtentCreate(ctx context.Context, targetID int, exte
 	if err != nil {
 		// TrueNAS returns "Invalid params" when association already exists
 		// Check if association exists and return it if so
-		if strings.Contains(err.Error(), "already exists") || strings.Contains(err.Error(), "Invalid params") {
+		if kind := ErrorKindOf(err); kind == ErrorAlreadyExists || kind == ErrorInvalid {
 			existing, findErr := c.ISCSITargetExtentFind(ctx, targetID, extentID)
 			if findErr == nil && existing != nil {
 				return existing, nil
@@ -251,8 +273,7 @@ func (c *Client) ISCSITargetExtentCreate(ctx context.Context, targetID int, exte
 func (c *Client) ISCSITargetExtentDelete(ctx context.Context, id int, force bool) error {
 	_, err := c.Call(ctx, "iscsi.targetextent.delete", id, force)
 	if err != nil {
-		if strings.Contains(err.Error(), "does not exist") ||
-			strings.Contains(err.Error(), "not found") {
+		if IsNotFoundError(err) {
 			return nil
 		}
 		return fmt.Errorf("failed to delete target-extent association: %w", err)
@@ -289,6 +310,66 @@ func (c *Client) ISCSIGlobalConfigGet(ctx context.Context) (*ISCSIGlobalConfig,
 	return parseISCSIGlobalConfig(result)
 }
 
+// ISCSIPortalGet retrieves an iSCSI portal group by ID.
+func (c *Client) ISCSIPortalGet(ctx context.Context, id int) (*ISCSIPortal, error) {
+	filters := [][]interface{}{{"id", "=", id}}
+	result, err := c.Call(ctx, "iscsi.portal.query", filters, map[string]interface{}{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get iSCSI portal: %w", err)
+	}
+
+	portals, ok := result.([]interface{})
+	if !ok || len(portals) == 0 {
+		return nil, notFoundf("iSCSI portal not found: %d", id)
+	}
+
+	m, ok := portals[0].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected iSCSI portal format")
+	}
+	portal := &ISCSIPortal{}
+	if v, ok := m["id"].(float64); ok {
+		portal.ID = int(v)
+	}
+	if v, ok := m["tag"].(float64); ok {
+		portal.Tag = int(v)
+	}
+	if v, ok := m["comment"].(string); ok {
+		portal.Comment = v
+	}
+	return portal, nil
+}
+
+// ISCSIInitiatorGet retrieves an iSCSI initiator group by ID.
+func (c *Client) ISCSIInitiatorGet(ctx context.Context, id int) (*ISCSIInitiator, error) {
+	filters := [][]interface{}{{"id", "=", id}}
+	result, err := c.Call(ctx, "iscsi.initiator.query", filters, map[string]interface{}{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get iSCSI initiator group: %w", err)
+	}
+
+	initiators, ok := result.([]interface{})
+	if !ok || len(initiators) == 0 {
+		return nil, notFoundf("iSCSI initiator group not found: %d", id)
+	}
+
+	m, ok := initiators[0].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected iSCSI initiator group format")
+	}
+	initiator := &ISCSIInitiator{}
+	if v, ok := m["id"].(float64); ok {
+		initiator.ID = int(v)
+	}
+	if v, ok := m["tag"].(float64); ok {
+		initiator.Tag = int(v)
+	}
+	if v, ok := m["comment"].(string); ok {
+		initiator.Comment = v
+	}
+	return initiator, nil
+}
+
 // parseISCSITarget converts raw API response to ISCSITarget.
 func parseISCSITarget(data interface{}) (*ISCSITarget, error) {
 	m, ok := data.(map[string]interface{})
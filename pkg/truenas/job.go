@@ -0,0 +1,256 @@
+package truenas
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog/v2"
+)
+
+// Job states reported by core.get_jobs.
+const (
+	JobStateWaiting = "WAITING"
+	JobStateRunning = "RUNNING"
+	JobStateSuccess = "SUCCESS"
+	JobStateFailed  = "FAILED"
+	JobStateAborted = "ABORTED"
+)
+
+// JobProgress is the progress reported by a running job.
+type JobProgress struct {
+	Percent     float64 `json:"percent"`
+	Description string  `json:"description"`
+}
+
+// Job represents a TrueNAS middleware job.
+type Job struct {
+	ID        int         `json:"id"`
+	Method    string      `json:"method"`
+	State     string      `json:"state"`
+	Progress  JobProgress `json:"progress"`
+	Result    interface{} `json:"result"`
+	Error     string      `json:"error"`
+	Exception string      `json:"exception"`
+	ExcInfo   *JobExcInfo `json:"exc_info"`
+}
+
+// JobExcInfo describes the exception a failed job raised.
+type JobExcInfo struct {
+	Type  string      `json:"type"` // e.g. "CallError", or "VALIDATION" for validation errors
+	Errno int         `json:"errno"`
+	Extra interface{} `json:"extra"`
+	Repr  string      `json:"repr"`
+}
+
+// Done returns true if the job has finished (successfully or not).
+func (j *Job) Done() bool {
+	return j.State == JobStateSuccess || j.State == JobStateFailed || j.State == JobStateAborted
+}
+
+// JobError is returned when a job finishes in the FAILED or ABORTED state.
+type JobError struct {
+	ID      int
+	Method  string
+	State   string
+	Message string
+	ExcInfo *JobExcInfo // nil if TrueNAS didn't describe the exception
+	Trace   string      // Formatted traceback
+}
+
+func (e *JobError) Error() string {
+	return fmt.Sprintf("TrueNAS job %d (%s) %s: %s", e.ID, e.Method, e.State, e.Message)
+}
+
+// Errno returns the errno the job failed with, from its exception or the "[ENOENT]"
+// prefix of its message, or 0 if TrueNAS didn't send one.
+func (e *JobError) Errno() int {
+	if e.ExcInfo != nil && e.ExcInfo.Errno != 0 {
+		return e.ExcInfo.Errno
+	}
+	return errnoPrefix(e.Message)
+}
+
+// ValidationErrors returns the failed fields if the job failed validation.
+func (e *JobError) ValidationErrors() []ValidationError {
+	if e.ExcInfo == nil {
+		return nil
+	}
+	return validationErrors(e.ExcInfo.Extra)
+}
+
+// Kind classifies the failure like APIError.Kind.
+func (e *JobError) Kind() ErrorKind {
+	errno := e.Errno()
+	if fields := e.ValidationErrors(); len(fields) > 0 && fields[0].Errno != 0 {
+		errno = fields[0].Errno
+	}
+	if kind := errnoKind(errno); kind != ErrorUnknown {
+		return kind
+	}
+	if kind := messageKind(e.Message); kind != ErrorUnknown {
+		return kind
+	}
+	if e.ExcInfo != nil && (e.ExcInfo.Type == "VALIDATION" || e.ExcInfo.Type == "ValidationErrors") {
+		return ErrorInvalid
+	}
+	return ErrorUnknown
+}
+
+// JobProgressFunc is called with each progress update observed while waiting on a job.
+type JobProgressFunc func(job *Job)
+
+// CallJob makes an API call that TrueNAS may run as a job. If the call returns a job ID,
+// it waits for the job to finish and returns the job's result; otherwise the synchronous
+// result is returned unchanged. Only use it for methods that return a job ID or a
+// non-numeric result, since an integer result is taken to be a job ID.
+func (c *Client) CallJob(ctx context.Context, method string, params ...interface{}) (interface{}, error) {
+	result, err := c.Call(ctx, method, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	jobID, ok := jobIDFromResult(result)
+	if !ok {
+		return result, nil
+	}
+
+	klog.V(4).Infof("%s started job %d", method, jobID)
+	job, err := c.JobWait(ctx, jobID, func(job *Job) {
+		klog.V(4).Infof("Job %d (%s): %.0f%% %s", job.ID, method, job.Progress.Percent, job.Progress.Description)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return job.Result, nil
+}
+
+// JobGet returns the current state of a job.
+func (c *Client) JobGet(ctx context.Context, id int) (*Job, error) {
+	filters := [][]interface{}{{"id", "=", id}}
+
+	result, err := c.Call(ctx, "core.get_jobs", filters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job %d: %w", id, err)
+	}
+
+	jobs, ok := result.([]interface{})
+	if !ok || len(jobs) == 0 {
+		return nil, notFoundf("job not found: %d", id)
+	}
+
+	return parseJob(jobs[0])
+}
+
+// JobWait polls core.get_jobs until the job finishes, calling onProgress (if set) whenever
+// the reported progress changes. It returns a *JobError if the job fails or is aborted.
+// Cancelling ctx stops waiting but does not abort the job on TrueNAS.
+func (c *Client) JobWait(ctx context.Context, id int, onProgress JobProgressFunc) (*Job, error) {
+	ctx, span := tracer.Start(ctx, "truenas job.wait", trace.WithAttributes(attribute.Int("truenas.job_id", id)))
+	defer span.End()
+
+	pollInterval := 250 * time.Millisecond
+	maxPollInterval := 2 * time.Second
+	var last JobProgress
+
+	for {
+		job, err := c.JobGet(ctx, id)
+		if err != nil {
+			endSpan(span, err)
+			return nil, err
+		}
+
+		if job.Progress != last {
+			last = job.Progress
+			span.AddEvent("progress", trace.WithAttributes(
+				attribute.Float64("percent", job.Progress.Percent),
+				attribute.String("description", job.Progress.Description),
+			))
+			if onProgress != nil {
+				onProgress(job)
+			}
+		}
+
+		if job.Done() {
+			span.SetAttributes(attribute.String("truenas.job_state", job.State))
+			if job.State != JobStateSuccess {
+				err := &JobError{ID: job.ID, Method: job.Method, State: job.State, Message: job.Error, ExcInfo: job.ExcInfo, Trace: job.Exception}
+				endSpan(span, err)
+				return job, err
+			}
+			return job, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("context cancelled waiting for job %d: %w", id, ctx.Err())
+		case <-time.After(pollInterval):
+		}
+
+		pollInterval *= 2
+		if pollInterval > maxPollInterval {
+			pollInterval = maxPollInterval
+		}
+	}
+}
+
+// jobIDFromResult returns the job ID if result is an integer (JSON numbers decode as float64).
+func jobIDFromResult(result interface{}) (int, bool) {
+	switch v := result.(type) {
+	case float64:
+		if v > 0 && v == math.Trunc(v) {
+			return int(v), true
+		}
+	case int:
+		if v > 0 {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// parseJob parses a job from core.get_jobs.
+func parseJob(data interface{}) (*Job, error) {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected job format")
+	}
+
+	job := &Job{Result: m["result"]}
+	if v, ok := m["id"].(float64); ok {
+		job.ID = int(v)
+	}
+	if v, ok := m["method"].(string); ok {
+		job.Method = v
+	}
+	if v, ok := m["state"].(string); ok {
+		job.State = v
+	}
+	if v, ok := m["error"].(string); ok {
+		job.Error = v
+	}
+	if v, ok := m["exception"].(string); ok {
+		job.Exception = v
+	}
+	if v, ok := m["exc_info"].(map[string]interface{}); ok {
+		job.ExcInfo = &JobExcInfo{Extra: v["extra"]}
+		job.ExcInfo.Type, _ = v["type"].(string)
+		job.ExcInfo.Repr, _ = v["repr"].(string)
+		if errno, ok := v["errno"].(float64); ok {
+			job.ExcInfo.Errno = int(errno)
+		}
+	}
+	if progress, ok := m["progress"].(map[string]interface{}); ok {
+		if v, ok := progress["percent"].(float64); ok {
+			job.Progress.Percent = v
+		}
+		if v, ok := progress["description"].(string); ok {
+			job.Progress.Description = v
+		}
+	}
+
+	return job, nil
+}
@@ -0,0 +1,138 @@
+package truenas
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas/faketruenas"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobIDFromResult(t *testing.T) {
+	tests := []struct {
+		name   string
+		result interface{}
+		id     int
+		isJob  bool
+	}{
+		{"job id", float64(1234), 1234, true},
+		{"bool result", true, 0, false},
+		{"object result", map[string]interface{}{"id": "pool/ds"}, 0, false},
+		{"fractional", 1.5, 0, false},
+		{"nil", nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, ok := jobIDFromResult(tt.result)
+			assert.Equal(t, tt.isJob, ok)
+			assert.Equal(t, tt.id, id)
+		})
+	}
+}
+
+func TestParseJob(t *testing.T) {
+	job, err := parseJob(map[string]interface{}{
+		"id":     float64(42),
+		"method": "pool.dataset.delete",
+		"state":  "FAILED",
+		"error":  "[ENOENT] Dataset pool/parent/vol-01 does not exist",
+		"progress": map[string]interface{}{
+			"percent":     float64(100),
+			"description": "Deleting dataset",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 42, job.ID)
+	assert.True(t, job.Done())
+	assert.Equal(t, 100.0, job.Progress.Percent)
+	assert.Equal(t, "Deleting dataset", job.Progress.Description)
+
+	// Job failures keep the middleware message so the usual error helpers still work
+	jobErr := &JobError{ID: job.ID, Method: job.Method, State: job.State, Message: job.Error}
+	assert.True(t, IsNotFoundError(jobErr))
+
+	running, err := parseJob(map[string]interface{}{"id": float64(43), "state": "RUNNING"})
+	assert.NoError(t, err)
+	assert.False(t, running.Done())
+
+	_, err = parseJob("bad")
+	assert.Error(t, err)
+}
+
+// scriptJob makes core.get_jobs return the given job states one poll at a time, repeating
+// the last one, and returns the number of polls seen so far.
+func scriptJob(server *faketruenas.Server, states ...map[string]interface{}) *atomic.Int32 {
+	var polls atomic.Int32
+	server.Handle("core.get_jobs", func(params []interface{}) (interface{}, error) {
+		n := int(polls.Add(1))
+		if n > len(states) {
+			n = len(states)
+		}
+		return []interface{}{states[n-1]}, nil
+	})
+	return &polls
+}
+
+func jobState(state string, percent float64, description string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":       float64(7),
+		"method":   "pool.dataset.delete",
+		"state":    state,
+		"progress": map[string]interface{}{"percent": percent, "description": description},
+	}
+}
+
+func TestJobWait(t *testing.T) {
+	// Test Case 1: A running job is polled until it succeeds, reporting each progress change
+	server, client := newFakeClient(t, faketruenas.Config{})
+	done := jobState(JobStateSuccess, 100, "Done")
+	done["result"] = true
+	polls := scriptJob(server,
+		jobState("RUNNING", 10, "Starting"),
+		jobState("RUNNING", 10, "Starting"),
+		jobState("RUNNING", 60, "Deleting snapshots"),
+		done,
+	)
+
+	var progress []float64
+	job, err := client.JobWait(context.Background(), 7, func(job *Job) {
+		progress = append(progress, job.Progress.Percent)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, JobStateSuccess, job.State)
+	assert.Equal(t, true, job.Result)
+	assert.Equal(t, []float64{10, 60, 100}, progress)
+	assert.Equal(t, int32(4), polls.Load())
+
+	// Test Case 2: A running job that then fails is returned as a JobError
+	server, client = newFakeClient(t, faketruenas.Config{})
+	failed := jobState(JobStateFailed, 40, "Deleting snapshots")
+	failed["error"] = "[EBUSY] Failed to delete dataset: cannot destroy 'tank/k8s/pvc-1': dataset is busy"
+	failed["exc_info"] = map[string]interface{}{"type": "CallError", "errno": float64(16), "repr": "CallError(...)"}
+	scriptJob(server, jobState("RUNNING", 40, "Deleting snapshots"), failed)
+
+	job, err = client.JobWait(context.Background(), 7, nil)
+	var jobErr *JobError
+	if assert.True(t, errors.As(err, &jobErr)) {
+		assert.Equal(t, 7, jobErr.ID)
+		assert.Equal(t, JobStateFailed, jobErr.State)
+		assert.Equal(t, 16, jobErr.Errno())
+		assert.Equal(t, ErrorBusy, jobErr.Kind())
+	}
+	assert.Equal(t, JobStateFailed, job.State)
+
+	// Test Case 3: Cancelling the context while the job is still running stops waiting
+	server, client = newFakeClient(t, faketruenas.Config{})
+	polls = scriptJob(server, jobState("RUNNING", 5, "Starting"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	job, err = client.JobWait(ctx, 7, nil)
+	assert.Nil(t, job)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), "waiting for job 7")
+	assert.Equal(t, int32(1), polls.Load())
+}
@@ -0,0 +1,255 @@
+package truenas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Priority orders calls waiting for a request slot.
+type Priority int
+
+const (
+	// PriorityNormal is the default for calls.
+	PriorityNormal Priority = iota
+	// PriorityHigh is for cleanup, such as deleting volumes and removing shares. Waiting
+	// high priority calls get slots first, and one slot is kept free for them, so cleanup
+	// isn't starved by a burst of creates.
+	PriorityHigh
+)
+
+func (p Priority) String() string {
+	if p == PriorityHigh {
+		return "high"
+	}
+	return "normal"
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a context whose TrueNAS calls wait for a request slot at priority p.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFromContext returns the priority set by WithPriority, or PriorityNormal.
+func PriorityFromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return PriorityNormal
+}
+
+// isOverload reports whether a call failed in a way that suggests TrueNAS is overloaded
+// or unreachable: it timed out or the connection failed, rather than TrueNAS answering
+// with an error. Calls cancelled by the caller say nothing about TrueNAS.
+func isOverload(ctx context.Context, err error) bool {
+	if err == nil || errors.Is(ctx.Err(), context.Canceled) {
+		return false
+	}
+	var apiErr *APIError
+	return !errors.As(err, &apiErr)
+}
+
+// limiter is an AIMD concurrency limit on requests to TrueNAS. The limit grows by one
+// for each window of calls answered within the target latency, up to the configured
+// maximum, and halves when calls time out, fail to reach TrueNAS or exceed the target.
+// It halves at most once per target latency, so a burst of slow calls caused by one
+// stall counts once.
+type limiter struct {
+	mu           sync.Mutex
+	limit        float64
+	max          float64
+	target       time.Duration
+	inFlight     int
+	waiting      [PriorityHigh + 1][]*slotWaiter
+	lastDecrease time.Time
+}
+
+// slotWaiter is a call waiting for a slot. ready is closed when it is granted one.
+type slotWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// newLimiter returns a limiter starting at max concurrent calls.
+func newLimiter(max int, target time.Duration) *limiter {
+	return &limiter{limit: float64(max), max: float64(max), target: target}
+}
+
+// acquire waits for a request slot. Each successful acquire must be followed by release.
+func (l *limiter) acquire(ctx context.Context, p Priority) error {
+	l.mu.Lock()
+	if l.waitingAtLeast(p) == 0 && l.available(p) {
+		l.inFlight++
+		l.mu.Unlock()
+		return nil
+	}
+	w := &slotWaiter{ready: make(chan struct{})}
+	l.waiting[p] = append(l.waiting[p], w)
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if w.granted {
+			// Granted as the context ended; pass the slot on
+			l.inFlight--
+			l.grant()
+		} else {
+			for i, other := range l.waiting[p] {
+				if other == w {
+					l.waiting[p] = append(l.waiting[p][:i], l.waiting[p][i+1:]...)
+					break
+				}
+			}
+		}
+		return fmt.Errorf("context cancelled while waiting for request slot: %w", ctx.Err())
+	}
+}
+
+// release returns a slot and adjusts the limit by how the call went.
+func (l *limiter) release(ctx context.Context, latency time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+
+	switch {
+	case isOverload(ctx, err) || latency > l.target:
+		if time.Since(l.lastDecrease) >= l.target && l.limit > 1 {
+			l.limit = max(1, l.limit/2)
+			l.lastDecrease = time.Now()
+			klog.V(2).Infof("TrueNAS request limit lowered to %d (latency %v, error: %v)", int(l.limit), latency, err)
+		}
+	case errors.Is(ctx.Err(), context.Canceled):
+	default:
+		l.limit = min(l.max, l.limit+1/l.limit)
+	}
+	l.grant()
+}
+
+// grant hands free slots to waiting calls, high priority first. Callers hold mu.
+func (l *limiter) grant() {
+	for p := PriorityHigh; p >= PriorityNormal; p-- {
+		for len(l.waiting[p]) > 0 && l.available(p) {
+			w := l.waiting[p][0]
+			l.waiting[p] = l.waiting[p][1:]
+			w.granted = true
+			l.inFlight++
+			close(w.ready)
+		}
+		if len(l.waiting[p]) > 0 {
+			// Lower priorities wait behind this one
+			return
+		}
+	}
+}
+
+// available reports whether a call at priority p may start now. Normal calls leave one
+// slot for high priority ones when the limit allows more than one. Callers hold mu.
+func (l *limiter) available(p Priority) bool {
+	limit := int(l.limit)
+	if p == PriorityNormal && limit > 1 {
+		limit--
+	}
+	return l.inFlight < limit
+}
+
+// waitingAtLeast returns how many calls of priority p or higher are waiting. Callers hold mu.
+func (l *limiter) waitingAtLeast(p Priority) int {
+	n := 0
+	for ; p <= PriorityHigh; p++ {
+		n += len(l.waiting[p])
+	}
+	return n
+}
+
+// ErrCircuitOpen is returned without calling TrueNAS while the circuit breaker is open,
+// after repeated calls timed out or couldn't reach it.
+var ErrCircuitOpen = errors.New("TrueNAS API circuit breaker is open")
+
+// breaker stops calls to a TrueNAS that keeps timing out or dropping connections, so
+// requests fail fast instead of queueing behind calls that won't be answered. After the
+// cooldown one call is let through; the breaker closes when it succeeds.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int // Consecutive overload failures
+	openUntil time.Time
+	probing   bool
+}
+
+// newBreaker returns a breaker that opens after threshold consecutive failures.
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow returns an error wrapping ErrCircuitOpen if the call must not be made. trial is
+// true for the one call let through after the cooldown; it must be followed by record
+// once the call is answered, or abandon if it is never sent.
+func (b *breaker) allow() (trial bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return false, nil
+	}
+	if wait := time.Until(b.openUntil); wait > 0 {
+		return false, fmt.Errorf("%w after %d failed calls, retrying in %v", ErrCircuitOpen, b.failures, wait.Round(time.Second))
+	}
+	if b.probing {
+		return false, fmt.Errorf("%w, waiting for a trial call", ErrCircuitOpen)
+	}
+	b.probing = true
+	return true, nil
+}
+
+// abandon gives up the trial, letting the next call make one.
+func (b *breaker) abandon(trial bool) {
+	if !trial {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// record counts the outcome of a call sent to TrueNAS. Only the trial call ends the
+// trial; the late answer of a call sent before the breaker opened doesn't.
+func (b *breaker) record(ctx context.Context, trial bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if trial {
+		b.probing = false
+	}
+	switch {
+	case isOverload(ctx, err):
+		b.failures++
+		if b.failures >= b.threshold {
+			if b.failures == b.threshold {
+				klog.Warningf("TrueNAS API circuit breaker opened after %d failed calls: %v", b.failures, err)
+			}
+			b.openUntil = time.Now().Add(b.cooldown)
+		}
+	case errors.Is(ctx.Err(), context.Canceled):
+	default:
+		if b.failures >= b.threshold {
+			klog.Infof("TrueNAS API circuit breaker closed")
+		}
+		b.failures = 0
+	}
+}
+
+// open reports whether calls are currently being refused.
+func (b *breaker) open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.threshold && (time.Now().Before(b.openUntil) || b.probing)
+}
@@ -0,0 +1,147 @@
+package truenas
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas/faketruenas"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiterAIMD(t *testing.T) {
+	ctx := context.Background()
+	l := newLimiter(8, 50*time.Millisecond)
+	timeout := errors.New("request timeout: pool.dataset.query")
+
+	// Timeouts halve the limit, once per target latency
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, l.acquire(ctx, PriorityNormal))
+		l.release(ctx, time.Millisecond, timeout)
+	}
+	assert.Equal(t, 4.0, l.limit)
+	time.Sleep(60 * time.Millisecond)
+	assert.NoError(t, l.acquire(ctx, PriorityNormal))
+	l.release(ctx, 100*time.Millisecond, nil)
+	assert.Equal(t, 2.0, l.limit)
+
+	// Errors from TrueNAS and cancelled calls aren't congestion; fast calls grow the limit
+	// by one per window
+	assert.NoError(t, l.acquire(ctx, PriorityHigh))
+	l.release(ctx, time.Millisecond, &APIError{Code: -32001, Message: "Method call error"})
+	cancelled, cancel := context.WithCancel(ctx)
+	assert.NoError(t, l.acquire(cancelled, PriorityNormal))
+	cancel()
+	l.release(cancelled, time.Second, cancelled.Err())
+	assert.Equal(t, 2.5, l.limit)
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, l.acquire(ctx, PriorityNormal))
+		l.release(ctx, time.Millisecond, nil)
+	}
+	assert.Equal(t, 8.0, l.limit)
+	assert.Equal(t, 0, l.inFlight)
+}
+
+func TestLimiterPriority(t *testing.T) {
+	ctx := context.Background()
+	l := newLimiter(2, time.Second)
+
+	// Normal calls leave a slot for cleanup
+	assert.NoError(t, l.acquire(ctx, PriorityNormal))
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, l.acquire(short, PriorityNormal), context.DeadlineExceeded)
+	assert.NoError(t, l.acquire(ctx, PriorityHigh))
+
+	// Waiting cleanup goes first when a slot frees up
+	order := make(chan Priority, 2)
+	for _, p := range []Priority{PriorityNormal, PriorityHigh} {
+		go func(p Priority) {
+			if l.acquire(ctx, p) == nil {
+				order <- p
+			}
+		}(p)
+		assert.Eventually(t, func() bool {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			return len(l.waiting[p]) == 1
+		}, time.Second, time.Millisecond)
+	}
+	l.release(ctx, time.Millisecond, nil)
+	assert.Equal(t, PriorityHigh, <-order)
+	l.release(ctx, time.Millisecond, nil)
+	l.release(ctx, time.Millisecond, nil)
+	assert.Equal(t, PriorityNormal, <-order)
+}
+
+func TestBreaker(t *testing.T) {
+	ctx := context.Background()
+	b := newBreaker(2, 50*time.Millisecond)
+	lost := errors.New("connection lost")
+
+	// API errors mean TrueNAS answered
+	for i := 0; i < 3; i++ {
+		trial, err := b.allow()
+		assert.NoError(t, err)
+		b.record(ctx, trial, &APIError{Code: -32602, Message: "Invalid params"})
+	}
+	for i := 0; i < 2; i++ {
+		trial, err := b.allow()
+		assert.NoError(t, err)
+		b.record(ctx, trial, lost)
+	}
+	assert.True(t, b.open())
+	_, err := b.allow()
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	// After the cooldown one trial call goes through; its failure reopens the breaker
+	time.Sleep(60 * time.Millisecond)
+	assert.False(t, b.open())
+	trial, err := b.allow()
+	assert.NoError(t, err)
+	assert.True(t, trial)
+	_, err = b.allow()
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	// A late answer to an older call doesn't end the trial
+	b.record(ctx, false, lost)
+	_, err = b.allow()
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	b.record(ctx, trial, lost)
+	_, err = b.allow()
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	// A trial that is never sent lets the next call make one
+	time.Sleep(60 * time.Millisecond)
+	trial, err = b.allow()
+	assert.NoError(t, err)
+	b.abandon(trial)
+
+	// and a successful trial closes it
+	trial, err = b.allow()
+	assert.NoError(t, err)
+	assert.True(t, trial)
+	b.record(ctx, trial, nil)
+	assert.False(t, b.open())
+	trial, err = b.allow()
+	assert.NoError(t, err)
+	assert.False(t, trial)
+}
+
+func TestBreakerIgnoresSlotWaits(t *testing.T) {
+	_, client := newFakeClient(t, faketruenas.Config{APIKey: "1-valid"})
+	pool := client.currentPool()
+
+	// Calls that time out waiting for a request slot never reach TrueNAS
+	for i := 0; i < int(pool.limiter.max); i++ {
+		assert.NoError(t, pool.limiter.acquire(context.Background(), PriorityHigh))
+	}
+	for i := 0; i < 2*pool.breaker.threshold; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := client.DatasetGet(ctx, "tank")
+		cancel()
+		assert.Error(t, err)
+	}
+	assert.False(t, pool.breaker.open())
+	assert.Zero(t, pool.breaker.failures)
+}
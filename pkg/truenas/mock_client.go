@@ -3,6 +3,8 @@ package truenas
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -12,57 +14,317 @@ type MockClient struct {
 	mu sync.RWMutex
 
 	// Mock data
-	Datasets       map[string]*Dataset
-	Snapshots      map[string]*Snapshot
-	NFSShares      map[int]*NFSShare
-	ISCSITargets   map[int]*ISCSITarget
-	ISCSIExtents   map[int]*ISCSIExtent
-	TargetExtents  map[int]*ISCSITargetExtent
-	NVMeSubsystems map[int]*NVMeoFSubsystem
-	NVMeNamespaces map[int]*NVMeoFNamespace
-	PoolAvailable  int64
-
-	// Error injection
+	Datasets        map[string]*Dataset
+	Snapshots       map[string]*Snapshot
+	NFSShares       map[int]*NFSShare
+	ISCSITargets    map[int]*ISCSITarget
+	ISCSIExtents    map[int]*ISCSIExtent
+	TargetExtents   map[int]*ISCSITargetExtent
+	ISCSIPortals    map[int]*ISCSIPortal
+	ISCSIInitiators map[int]*ISCSIInitiator
+	NVMeSubsystems  map[int]*NVMeoFSubsystem
+	NVMeNamespaces  map[int]*NVMeoFNamespace
+	Replications    map[int]*ReplicationTask
+	PoolAvailable   int64
+
+	// Error injection: InjectError fails every call, FailNext and FailAlways fail single
+	// methods. Use SetInjectError to change InjectError while calls may be running.
 	InjectError error
+
+	// InjectError, fault scripts, latency and the call log, guarded by faultMu so calls
+	// can sleep without holding mu
+	faultMu  sync.Mutex
+	scripts  map[string][]error
+	failures map[string]error
+	latency  map[string]time.Duration
+	calls    []MockCall
+
+	// Connectivity simulation (zero LastResponseTime reports the current time)
+	Disconnected     bool
+	LastResponseTime time.Time
+	CircuitOpen      bool // Available returns ErrCircuitOpen
+
+	// Last configuration passed to Reconfigure
+	ReconfiguredWith *ClientConfig
+
+	// Returned by Capabilities (default: a release supporting every protocol)
+	ServerCapabilities *Capabilities
+	// Returned by CachedCapabilities once Capabilities has succeeded
+	detectedCapabilities *Capabilities
 }
 
 // NewMockClient creates a new MockClient.
 func NewMockClient() *MockClient {
 	return &MockClient{
-		Datasets:       make(map[string]*Dataset),
-		Snapshots:      make(map[string]*Snapshot),
-		NFSShares:      make(map[int]*NFSShare),
-		ISCSITargets:   make(map[int]*ISCSITarget),
-		ISCSIExtents:   make(map[int]*ISCSIExtent),
-		TargetExtents:  make(map[int]*ISCSITargetExtent),
-		NVMeSubsystems: make(map[int]*NVMeoFSubsystem),
-		NVMeNamespaces: make(map[int]*NVMeoFNamespace),
-		PoolAvailable:  100 * 1024 * 1024 * 1024, // 100 GiB default
+		Datasets:        make(map[string]*Dataset),
+		Snapshots:       make(map[string]*Snapshot),
+		NFSShares:       make(map[int]*NFSShare),
+		ISCSITargets:    make(map[int]*ISCSITarget),
+		ISCSIExtents:    make(map[int]*ISCSIExtent),
+		TargetExtents:   make(map[int]*ISCSITargetExtent),
+		ISCSIPortals:    make(map[int]*ISCSIPortal),
+		ISCSIInitiators: make(map[int]*ISCSIInitiator),
+		NVMeSubsystems:  make(map[int]*NVMeoFSubsystem),
+		NVMeNamespaces:  make(map[int]*NVMeoFNamespace),
+		Replications:    make(map[int]*ReplicationTask),
+		PoolAvailable:   100 * 1024 * 1024 * 1024, // 100 GiB default
+		ServerCapabilities: &Capabilities{
+			Version:        "25.10.0",
+			Major:          25,
+			Minor:          10,
+			SnapshotPrefix: "pool.snapshot",
+			NVMeoF:         true,
+		},
+	}
+}
+
+// MockCall is a call made to a MockClient method.
+type MockCall struct {
+	Method string
+	Args   []interface{}
+}
+
+// String renders the call like DatasetDelete(tank/vol, true, false).
+func (c MockCall) String() string {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = fmt.Sprintf("%v", arg)
+	}
+	return fmt.Sprintf("%s(%s)", c.Method, strings.Join(args, ", "))
+}
+
+// FailNext scripts the next calls to a method: the first call returns errs[0], the second
+// errs[1] and so on, with nil entries succeeding. Later calls behave normally. Scripting a
+// method again appends to its script.
+func (m *MockClient) FailNext(method string, errs ...error) {
+	m.faultMu.Lock()
+	defer m.faultMu.Unlock()
+	if m.scripts == nil {
+		m.scripts = make(map[string][]error)
+	}
+	m.scripts[method] = append(m.scripts[method], errs...)
+}
+
+// FailAlways makes every call to a method return err once its script is used up. A nil err
+// clears the failure.
+func (m *MockClient) FailAlways(method string, err error) {
+	m.faultMu.Lock()
+	defer m.faultMu.Unlock()
+	if m.failures == nil {
+		m.failures = make(map[string]error)
+	}
+	if err == nil {
+		delete(m.failures, method)
+		return
+	}
+	m.failures[method] = err
+}
+
+// SetInjectError makes every call return err, or stops failing them when err is nil.
+func (m *MockClient) SetInjectError(err error) {
+	m.faultMu.Lock()
+	defer m.faultMu.Unlock()
+	m.InjectError = err
+}
+
+// SetLatency delays calls to a method, or to every method without its own latency when
+// method is empty. Calls return the context's error if it's done first.
+func (m *MockClient) SetLatency(method string, d time.Duration) {
+	m.faultMu.Lock()
+	defer m.faultMu.Unlock()
+	if m.latency == nil {
+		m.latency = make(map[string]time.Duration)
+	}
+	m.latency[method] = d
+}
+
+// Calls returns the calls made so far, in order.
+func (m *MockClient) Calls() []MockCall {
+	m.faultMu.Lock()
+	defer m.faultMu.Unlock()
+	return append([]MockCall(nil), m.calls...)
+}
+
+// CallNames returns the methods called so far, in order, optionally only those in filter.
+func (m *MockClient) CallNames(filter ...string) []string {
+	var names []string
+	for _, call := range m.Calls() {
+		if len(filter) == 0 || slices.Contains(filter, call.Method) {
+			names = append(names, call.Method)
+		}
+	}
+	return names
+}
+
+// CallCount returns how many times a method was called.
+func (m *MockClient) CallCount(method string) int {
+	return len(m.CallNames(method))
+}
+
+// ResetCalls clears the call log.
+func (m *MockClient) ResetCalls() {
+	m.faultMu.Lock()
+	defer m.faultMu.Unlock()
+	m.calls = nil
+}
+
+// fault logs a call, waits out its latency and returns the error scripted for it.
+func (m *MockClient) fault(ctx context.Context, method string, args ...interface{}) error {
+	m.faultMu.Lock()
+	m.calls = append(m.calls, MockCall{Method: method, Args: args})
+	latency, ok := m.latency[method]
+	if !ok {
+		latency = m.latency[""]
+	}
+	var err error
+	if script := m.scripts[method]; len(script) > 0 {
+		err = script[0]
+		m.scripts[method] = script[1:]
+	} else {
+		err = m.failures[method]
+	}
+	if err == nil {
+		err = m.InjectError
+	}
+	m.faultMu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// MockNotFoundError returns the validation error TrueNAS gives for an instance that
+// doesn't exist, which IsNotFoundError recognizes, for scripting MockClient.
+func MockNotFoundError(format string, args ...interface{}) error {
+	return mockValidationError("None", errnoENOENT, "ENOENT", fmt.Sprintf(format, args...))
+}
+
+// MockAlreadyExistsError returns the validation error TrueNAS gives for a duplicate,
+// which IsAlreadyExistsError recognizes.
+func MockAlreadyExistsError(format string, args ...interface{}) error {
+	return mockValidationError("None", errnoEEXIST, "EEXIST", fmt.Sprintf(format, args...)+" already exists")
+}
+
+// MockInvalidParamsError returns the "Invalid params" validation error TrueNAS gives for
+// an argument it rejects.
+func MockInvalidParamsError(attribute, format string, args ...interface{}) error {
+	return mockValidationError(attribute, errnoEINVAL, "EINVAL", fmt.Sprintf(format, args...))
+}
+
+// mockValidationError builds an "Invalid params" error for one attribute, as sent on the wire.
+func mockValidationError(attribute string, errno int, errname, reason string) error {
+	return &APIError{
+		Code:    codeInvalidParams,
+		Message: "Invalid params",
+		Data: map[string]interface{}{
+			"error":   float64(errno),
+			"errname": errname,
+			"reason":  fmt.Sprintf("[%s] %s: %s", errname, attribute, reason),
+			"extra":   []interface{}{[]interface{}{attribute, reason, float64(errno)}},
+		},
 	}
 }
 
 // Core methods
 func (m *MockClient) Close() error      { return nil }
-func (m *MockClient) IsConnected() bool { return true }
+func (m *MockClient) IsConnected() bool { return !m.Disconnected }
+func (m *MockClient) Available() error {
+	if m.CircuitOpen {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+func (m *MockClient) LastResponse() time.Time {
+	if m.LastResponseTime.IsZero() {
+		return time.Now()
+	}
+	return m.LastResponseTime
+}
+func (m *MockClient) Reconfigure(cfg *ClientConfig) error {
+	if err := m.fault(context.Background(), "Reconfigure", cfg); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ReconfiguredWith = cfg
+	return nil
+}
+func (m *MockClient) Capabilities(ctx context.Context) (*Capabilities, error) {
+	if err := m.fault(ctx, "Capabilities"); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.detectedCapabilities = m.ServerCapabilities
+	return m.ServerCapabilities, nil
+}
+
+// CachedCapabilities returns what the last successful Capabilities call returned.
+func (m *MockClient) CachedCapabilities() *Capabilities {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.detectedCapabilities
+}
 func (m *MockClient) Call(ctx context.Context, method string, params ...interface{}) (interface{}, error) {
+	if err := m.fault(ctx, "Call", method, params); err != nil {
+		return nil, err
+	}
 	return nil, nil
 }
 func (m *MockClient) CallWithContext(ctx context.Context, method string, params ...interface{}) (interface{}, error) {
+	if err := m.fault(ctx, "CallWithContext", method, params); err != nil {
+		return nil, err
+	}
 	return nil, nil
 }
 
+// Job methods
+func (m *MockClient) CallJob(ctx context.Context, method string, params ...interface{}) (interface{}, error) {
+	if err := m.fault(ctx, "CallJob", method, params); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+func (m *MockClient) JobGet(ctx context.Context, id int) (*Job, error) {
+	if err := m.fault(ctx, "JobGet", id); err != nil {
+		return nil, err
+	}
+	return nil, notFoundf("job not found: %d", id)
+}
+func (m *MockClient) JobWait(ctx context.Context, id int, onProgress JobProgressFunc) (*Job, error) {
+	if err := m.fault(ctx, "JobWait", id); err != nil {
+		return nil, err
+	}
+	return nil, notFoundf("job not found: %d", id)
+}
+
+// copyDataset returns a copy of ds that shares no state with it, like a fresh API response.
+func copyDataset(ds *Dataset) *Dataset {
+	c := *ds
+	c.UserProperties = make(map[string]UserProperty, len(ds.UserProperties))
+	for key, prop := range ds.UserProperties {
+		c.UserProperties[key] = prop
+	}
+	return &c
+}
+
 // Dataset methods
 func (m *MockClient) DatasetCreate(ctx context.Context, params *DatasetCreateParams) (*Dataset, error) {
+	if err := m.fault(ctx, "DatasetCreate", params); err != nil {
+		return nil, err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if m.InjectError != nil {
-		return nil, m.InjectError
-	}
 	if _, exists := m.Datasets[params.Name]; exists {
 		// Simulate "already exists" behavior if needed, or return error
 		// For now, let's just overwrite or return existing
-		return m.Datasets[params.Name], nil
+		return copyDataset(m.Datasets[params.Name]), nil
 	}
 
 	ds := &Dataset{
@@ -74,85 +336,147 @@ func (m *MockClient) DatasetCreate(ctx context.Context, params *DatasetCreatePar
 		Refquota:       DatasetProperty{Parsed: float64(params.Refquota)},
 	}
 	m.Datasets[params.Name] = ds
-	return ds, nil
+	return copyDataset(ds), nil
 }
 
 func (m *MockClient) DatasetDelete(ctx context.Context, name string, recursive bool, force bool) error {
+	if err := m.fault(ctx, "DatasetDelete", name, recursive, force); err != nil {
+		return err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if m.InjectError != nil {
-		return m.InjectError
-	}
 	delete(m.Datasets, name)
 	return nil
 }
 
 func (m *MockClient) DatasetGet(ctx context.Context, name string) (*Dataset, error) {
+	if err := m.fault(ctx, "DatasetGet", name); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	if m.InjectError != nil {
-		return nil, m.InjectError
-	}
 	if ds, ok := m.Datasets[name]; ok {
-		return ds, nil
+		return copyDataset(ds), nil
 	}
-	return nil, &APIError{Code: -1, Message: "dataset not found"}
+	return nil, MockNotFoundError("dataset not found")
 }
 
 func (m *MockClient) DatasetUpdate(ctx context.Context, name string, params *DatasetUpdateParams) (*Dataset, error) {
+	if err := m.fault(ctx, "DatasetUpdate", name, params); err != nil {
+		return nil, err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if m.InjectError != nil {
-		return nil, m.InjectError
-	}
 	ds, ok := m.Datasets[name]
 	if !ok {
-		return nil, &APIError{Code: -1, Message: "dataset not found"}
+		return nil, MockNotFoundError("dataset not found")
 	}
 
 	if params.Volsize > 0 {
 		ds.Volsize = DatasetProperty{Parsed: float64(params.Volsize)}
 	}
+	for _, update := range params.UserPropertiesUpdate {
+		if update.Remove {
+			delete(ds.UserProperties, update.Key)
+		} else {
+			ds.UserProperties[update.Key] = UserProperty{Value: update.Value}
+		}
+	}
 	// Handle other updates as needed
-	return ds, nil
+	return copyDataset(ds), nil
+}
+
+func (m *MockClient) DatasetRename(ctx context.Context, name string, newName string) error {
+	if err := m.fault(ctx, "DatasetRename", name, newName); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ds, ok := m.Datasets[name]
+	if !ok {
+		return MockNotFoundError("dataset not found")
+	}
+	if _, exists := m.Datasets[newName]; exists {
+		return MockAlreadyExistsError("dataset")
+	}
+	delete(m.Datasets, name)
+	ds.ID = newName
+	ds.Name = newName
+	m.Datasets[newName] = ds
+
+	// Snapshots move with the dataset
+	for id, snap := range m.Snapshots {
+		if snap.Dataset != name {
+			continue
+		}
+		delete(m.Snapshots, id)
+		snap.Dataset = newName
+		snap.ID = newName + "@" + snap.Name
+		m.Snapshots[snap.ID] = snap
+	}
+	return nil
 }
 
 func (m *MockClient) DatasetList(ctx context.Context, parentName string, limit int, offset int) ([]*Dataset, error) {
+	if err := m.fault(ctx, "DatasetList", parentName, limit, offset); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	var list []*Dataset
 	for _, ds := range m.Datasets {
-		list = append(list, ds)
+		list = append(list, copyDataset(ds))
 	}
 	return list, nil
 }
 
 func (m *MockClient) DatasetSetUserProperty(ctx context.Context, name string, key string, value string) error {
+	if err := m.fault(ctx, "DatasetSetUserProperty", name, key, value); err != nil {
+		return err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if m.InjectError != nil {
-		return m.InjectError
-	}
 	ds, ok := m.Datasets[name]
 	if !ok {
-		return &APIError{Code: -1, Message: "dataset not found"}
+		return MockNotFoundError("dataset not found")
 	}
 	ds.UserProperties[key] = UserProperty{Value: value}
 	return nil
 }
 
+func (m *MockClient) DatasetSetUserProperties(ctx context.Context, name string, properties map[string]string) error {
+	if err := m.fault(ctx, "DatasetSetUserProperties", name, properties); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ds, ok := m.Datasets[name]
+	if !ok {
+		return MockNotFoundError("dataset not found")
+	}
+	for key, value := range properties {
+		ds.UserProperties[key] = UserProperty{Value: value}
+	}
+	return nil
+}
+
 func (m *MockClient) DatasetGetUserProperty(ctx context.Context, name string, key string) (string, error) {
+	if err := m.fault(ctx, "DatasetGetUserProperty", name, key); err != nil {
+		return "", err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	ds, ok := m.Datasets[name]
 	if !ok {
-		return "", &APIError{Code: -1, Message: "dataset not found"}
+		return "", MockNotFoundError("dataset not found")
 	}
 	if prop, ok := ds.UserProperties[key]; ok {
 		return prop.Value, nil
@@ -161,27 +485,33 @@ func (m *MockClient) DatasetGetUserProperty(ctx context.Context, name string, ke
 }
 
 func (m *MockClient) DatasetExpand(ctx context.Context, name string, newSize int64) error {
+	if err := m.fault(ctx, "DatasetExpand", name, newSize); err != nil {
+		return err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if m.InjectError != nil {
-		return m.InjectError
-	}
 	ds, ok := m.Datasets[name]
 	if !ok {
-		return &APIError{Code: -1, Message: "dataset not found"}
+		return MockNotFoundError("dataset not found")
 	}
 	ds.Volsize = DatasetProperty{Parsed: float64(newSize)}
 	return nil
 }
 
 func (m *MockClient) GetPoolAvailable(ctx context.Context, poolName string) (int64, error) {
+	if err := m.fault(ctx, "GetPoolAvailable", poolName); err != nil {
+		return 0, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	return m.PoolAvailable, nil
 }
 
 func (m *MockClient) DatasetExists(ctx context.Context, name string) (bool, error) {
+	if err := m.fault(ctx, "DatasetExists", name); err != nil {
+		return false, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -190,21 +520,27 @@ func (m *MockClient) DatasetExists(ctx context.Context, name string) (bool, erro
 }
 
 func (m *MockClient) WaitForDatasetReady(ctx context.Context, name string, timeout time.Duration) (*Dataset, error) {
+	if err := m.fault(ctx, "WaitForDatasetReady", name, timeout); err != nil {
+		return nil, err
+	}
 	return m.DatasetGet(ctx, name)
 }
 
 func (m *MockClient) WaitForZvolReady(ctx context.Context, name string, timeout time.Duration) (*Dataset, error) {
+	if err := m.fault(ctx, "WaitForZvolReady", name, timeout); err != nil {
+		return nil, err
+	}
 	return m.DatasetGet(ctx, name)
 }
 
 // Snapshot methods
 func (m *MockClient) SnapshotCreate(ctx context.Context, dataset string, name string) (*Snapshot, error) {
+	if err := m.fault(ctx, "SnapshotCreate", dataset, name); err != nil {
+		return nil, err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if m.InjectError != nil {
-		return nil, m.InjectError
-	}
 	id := fmt.Sprintf("%s@%s", dataset, name)
 	snap := &Snapshot{
 		ID:             id,
@@ -217,30 +553,33 @@ func (m *MockClient) SnapshotCreate(ctx context.Context, dataset string, name st
 }
 
 func (m *MockClient) SnapshotDelete(ctx context.Context, snapshotID string, defer_ bool, recursive bool) error {
+	if err := m.fault(ctx, "SnapshotDelete", snapshotID, defer_, recursive); err != nil {
+		return err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if m.InjectError != nil {
-		return m.InjectError
-	}
 	delete(m.Snapshots, snapshotID)
 	return nil
 }
 
 func (m *MockClient) SnapshotGet(ctx context.Context, snapshotID string) (*Snapshot, error) {
+	if err := m.fault(ctx, "SnapshotGet", snapshotID); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	if m.InjectError != nil {
-		return nil, m.InjectError
-	}
 	if snap, ok := m.Snapshots[snapshotID]; ok {
 		return snap, nil
 	}
-	return nil, &APIError{Code: -1, Message: "snapshot not found"}
+	return nil, MockNotFoundError("snapshot not found")
 }
 
 func (m *MockClient) SnapshotList(ctx context.Context, dataset string) ([]*Snapshot, error) {
+	if err := m.fault(ctx, "SnapshotList", dataset); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -254,6 +593,9 @@ func (m *MockClient) SnapshotList(ctx context.Context, dataset string) ([]*Snaps
 }
 
 func (m *MockClient) SnapshotListAll(ctx context.Context, parentDataset string, limit int, offset int) ([]*Snapshot, error) {
+	if err := m.fault(ctx, "SnapshotListAll", parentDataset, limit, offset); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -265,12 +607,12 @@ func (m *MockClient) SnapshotListAll(ctx context.Context, parentDataset string,
 }
 
 func (m *MockClient) SnapshotFindByName(ctx context.Context, parentDataset string, name string) (*Snapshot, error) {
+	if err := m.fault(ctx, "SnapshotFindByName", parentDataset, name); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	if m.InjectError != nil {
-		return nil, m.InjectError
-	}
 	for _, snap := range m.Snapshots {
 		if snap.Name == name && snap.Dataset == parentDataset {
 			return snap, nil
@@ -280,27 +622,27 @@ func (m *MockClient) SnapshotFindByName(ctx context.Context, parentDataset strin
 }
 
 func (m *MockClient) SnapshotSetUserProperty(ctx context.Context, snapshotID string, key string, value string) error {
+	if err := m.fault(ctx, "SnapshotSetUserProperty", snapshotID, key, value); err != nil {
+		return err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if m.InjectError != nil {
-		return m.InjectError
-	}
 	snap, ok := m.Snapshots[snapshotID]
 	if !ok {
-		return &APIError{Code: -1, Message: "snapshot not found"}
+		return MockNotFoundError("snapshot not found")
 	}
 	snap.UserProperties[key] = UserProperty{Value: value}
 	return nil
 }
 
 func (m *MockClient) SnapshotClone(ctx context.Context, snapshotID string, newDatasetName string) error {
+	if err := m.fault(ctx, "SnapshotClone", snapshotID, newDatasetName); err != nil {
+		return err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if m.InjectError != nil {
-		return m.InjectError
-	}
 	// Create a new dataset as a clone
 	m.Datasets[newDatasetName] = &Dataset{
 		ID:             newDatasetName,
@@ -311,17 +653,20 @@ func (m *MockClient) SnapshotClone(ctx context.Context, snapshotID string, newDa
 }
 
 func (m *MockClient) SnapshotRollback(ctx context.Context, snapshotID string, force bool, recursive bool, recursiveClones bool) error {
+	if err := m.fault(ctx, "SnapshotRollback", snapshotID, force, recursive, recursiveClones); err != nil {
+		return err
+	}
 	return nil
 }
 
 // NFS methods
 func (m *MockClient) NFSShareCreate(ctx context.Context, params *NFSShareCreateParams) (*NFSShare, error) {
+	if err := m.fault(ctx, "NFSShareCreate", params); err != nil {
+		return nil, err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if m.InjectError != nil {
-		return nil, m.InjectError
-	}
 	id := len(m.NFSShares) + 1
 	share := &NFSShare{
 		ID:   id,
@@ -332,6 +677,9 @@ func (m *MockClient) NFSShareCreate(ctx context.Context, params *NFSShareCreateP
 }
 
 func (m *MockClient) NFSShareDelete(ctx context.Context, id int) error {
+	if err := m.fault(ctx, "NFSShareDelete", id); err != nil {
+		return err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -340,16 +688,22 @@ func (m *MockClient) NFSShareDelete(ctx context.Context, id int) error {
 }
 
 func (m *MockClient) NFSShareGet(ctx context.Context, id int) (*NFSShare, error) {
+	if err := m.fault(ctx, "NFSShareGet", id); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	if share, ok := m.NFSShares[id]; ok {
 		return share, nil
 	}
-	return nil, fmt.Errorf("share not found")
+	return nil, notFoundf("share not found")
 }
 
 func (m *MockClient) NFSShareFindByPath(ctx context.Context, path string) (*NFSShare, error) {
+	if err := m.fault(ctx, "NFSShareFindByPath", path); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -362,6 +716,9 @@ func (m *MockClient) NFSShareFindByPath(ctx context.Context, path string) (*NFSS
 }
 
 func (m *MockClient) NFSShareList(ctx context.Context) ([]*NFSShare, error) {
+	if err := m.fault(ctx, "NFSShareList"); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -373,6 +730,9 @@ func (m *MockClient) NFSShareList(ctx context.Context) ([]*NFSShare, error) {
 }
 
 func (m *MockClient) NFSShareUpdate(ctx context.Context, id int, params map[string]interface{}) (*NFSShare, error) {
+	if err := m.fault(ctx, "NFSShareUpdate", id, params); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -381,11 +741,17 @@ func (m *MockClient) NFSShareUpdate(ctx context.Context, id int, params map[stri
 
 // Service methods
 func (m *MockClient) ServiceReload(ctx context.Context, service string) error {
+	if err := m.fault(ctx, "ServiceReload", service); err != nil {
+		return err
+	}
 	return nil
 }
 
 // iSCSI methods
 func (m *MockClient) ISCSITargetCreate(ctx context.Context, name string, alias string, mode string, groups []ISCSITargetGroup) (*ISCSITarget, error) {
+	if err := m.fault(ctx, "ISCSITargetCreate", name, alias, mode, groups); err != nil {
+		return nil, err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -395,6 +761,9 @@ func (m *MockClient) ISCSITargetCreate(ctx context.Context, name string, alias s
 	return target, nil
 }
 func (m *MockClient) ISCSITargetDelete(ctx context.Context, id int, force bool) error {
+	if err := m.fault(ctx, "ISCSITargetDelete", id, force); err != nil {
+		return err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -402,15 +771,21 @@ func (m *MockClient) ISCSITargetDelete(ctx context.Context, id int, force bool)
 	return nil
 }
 func (m *MockClient) ISCSITargetGet(ctx context.Context, id int) (*ISCSITarget, error) {
+	if err := m.fault(ctx, "ISCSITargetGet", id); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	if t, ok := m.ISCSITargets[id]; ok {
 		return t, nil
 	}
-	return nil, fmt.Errorf("not found")
+	return nil, notFoundf("not found")
 }
 func (m *MockClient) ISCSITargetFindByName(ctx context.Context, name string) (*ISCSITarget, error) {
+	if err := m.fault(ctx, "ISCSITargetFindByName", name); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -422,6 +797,9 @@ func (m *MockClient) ISCSITargetFindByName(ctx context.Context, name string) (*I
 	return nil, nil
 }
 func (m *MockClient) ISCSIExtentCreate(ctx context.Context, name string, diskPath string, comment string, blocksize int, rpm string) (*ISCSIExtent, error) {
+	if err := m.fault(ctx, "ISCSIExtentCreate", name, diskPath, comment, blocksize, rpm); err != nil {
+		return nil, err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -431,6 +809,9 @@ func (m *MockClient) ISCSIExtentCreate(ctx context.Context, name string, diskPat
 	return ext, nil
 }
 func (m *MockClient) ISCSIExtentDelete(ctx context.Context, id int, remove bool, force bool) error {
+	if err := m.fault(ctx, "ISCSIExtentDelete", id, remove, force); err != nil {
+		return err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -438,15 +819,21 @@ func (m *MockClient) ISCSIExtentDelete(ctx context.Context, id int, remove bool,
 	return nil
 }
 func (m *MockClient) ISCSIExtentGet(ctx context.Context, id int) (*ISCSIExtent, error) {
+	if err := m.fault(ctx, "ISCSIExtentGet", id); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	if e, ok := m.ISCSIExtents[id]; ok {
 		return e, nil
 	}
-	return nil, fmt.Errorf("not found")
+	return nil, notFoundf("not found")
 }
 func (m *MockClient) ISCSIExtentFindByName(ctx context.Context, name string) (*ISCSIExtent, error) {
+	if err := m.fault(ctx, "ISCSIExtentFindByName", name); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -458,6 +845,9 @@ func (m *MockClient) ISCSIExtentFindByName(ctx context.Context, name string) (*I
 	return nil, nil
 }
 func (m *MockClient) ISCSIExtentFindByDisk(ctx context.Context, diskPath string) (*ISCSIExtent, error) {
+	if err := m.fault(ctx, "ISCSIExtentFindByDisk", diskPath); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -469,6 +859,9 @@ func (m *MockClient) ISCSIExtentFindByDisk(ctx context.Context, diskPath string)
 	return nil, nil
 }
 func (m *MockClient) ISCSITargetExtentCreate(ctx context.Context, targetID int, extentID int, lunID int) (*ISCSITargetExtent, error) {
+	if err := m.fault(ctx, "ISCSITargetExtentCreate", targetID, extentID, lunID); err != nil {
+		return nil, err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -478,6 +871,9 @@ func (m *MockClient) ISCSITargetExtentCreate(ctx context.Context, targetID int,
 	return te, nil
 }
 func (m *MockClient) ISCSITargetExtentDelete(ctx context.Context, id int, force bool) error {
+	if err := m.fault(ctx, "ISCSITargetExtentDelete", id, force); err != nil {
+		return err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -485,6 +881,9 @@ func (m *MockClient) ISCSITargetExtentDelete(ctx context.Context, id int, force
 	return nil
 }
 func (m *MockClient) ISCSITargetExtentFind(ctx context.Context, targetID int, extentID int) (*ISCSITargetExtent, error) {
+	if err := m.fault(ctx, "ISCSITargetExtentFind", targetID, extentID); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -496,6 +895,9 @@ func (m *MockClient) ISCSITargetExtentFind(ctx context.Context, targetID int, ex
 	return nil, nil
 }
 func (m *MockClient) ISCSITargetExtentFindByTarget(ctx context.Context, targetID int) ([]*ISCSITargetExtent, error) {
+	if err := m.fault(ctx, "ISCSITargetExtentFindByTarget", targetID); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -508,6 +910,9 @@ func (m *MockClient) ISCSITargetExtentFindByTarget(ctx context.Context, targetID
 	return results, nil
 }
 func (m *MockClient) ISCSITargetExtentFindByExtent(ctx context.Context, extentID int) ([]*ISCSITargetExtent, error) {
+	if err := m.fault(ctx, "ISCSITargetExtentFindByExtent", extentID); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -520,11 +925,41 @@ func (m *MockClient) ISCSITargetExtentFindByExtent(ctx context.Context, extentID
 	return results, nil
 }
 func (m *MockClient) ISCSIGlobalConfigGet(ctx context.Context) (*ISCSIGlobalConfig, error) {
+	if err := m.fault(ctx, "ISCSIGlobalConfigGet"); err != nil {
+		return nil, err
+	}
 	return &ISCSIGlobalConfig{Basename: "iqn.2005-10.org.freenas.ctl"}, nil
 }
+func (m *MockClient) ISCSIPortalGet(ctx context.Context, id int) (*ISCSIPortal, error) {
+	if err := m.fault(ctx, "ISCSIPortalGet", id); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if p, ok := m.ISCSIPortals[id]; ok {
+		return p, nil
+	}
+	return nil, notFoundf("iSCSI portal not found: %d", id)
+}
+func (m *MockClient) ISCSIInitiatorGet(ctx context.Context, id int) (*ISCSIInitiator, error) {
+	if err := m.fault(ctx, "ISCSIInitiatorGet", id); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if i, ok := m.ISCSIInitiators[id]; ok {
+		return i, nil
+	}
+	return nil, notFoundf("iSCSI initiator group not found: %d", id)
+}
 
 // NVMe-oF methods
 func (m *MockClient) NVMeoFSubsystemCreate(ctx context.Context, nqn string, serial string, allowAnyHost bool, hosts []string) (*NVMeoFSubsystem, error) {
+	if err := m.fault(ctx, "NVMeoFSubsystemCreate", nqn, serial, allowAnyHost, hosts); err != nil {
+		return nil, err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -534,6 +969,9 @@ func (m *MockClient) NVMeoFSubsystemCreate(ctx context.Context, nqn string, seri
 	return sub, nil
 }
 func (m *MockClient) NVMeoFSubsystemDelete(ctx context.Context, id int) error {
+	if err := m.fault(ctx, "NVMeoFSubsystemDelete", id); err != nil {
+		return err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -541,15 +979,21 @@ func (m *MockClient) NVMeoFSubsystemDelete(ctx context.Context, id int) error {
 	return nil
 }
 func (m *MockClient) NVMeoFSubsystemGet(ctx context.Context, id int) (*NVMeoFSubsystem, error) {
+	if err := m.fault(ctx, "NVMeoFSubsystemGet", id); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	if s, ok := m.NVMeSubsystems[id]; ok {
 		return s, nil
 	}
-	return nil, fmt.Errorf("not found")
+	return nil, notFoundf("not found")
 }
 func (m *MockClient) NVMeoFSubsystemFindByNQN(ctx context.Context, nqn string) (*NVMeoFSubsystem, error) {
+	if err := m.fault(ctx, "NVMeoFSubsystemFindByNQN", nqn); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -561,6 +1005,9 @@ func (m *MockClient) NVMeoFSubsystemFindByNQN(ctx context.Context, nqn string) (
 	return nil, nil
 }
 func (m *MockClient) NVMeoFNamespaceCreate(ctx context.Context, subsystemID int, devicePath string) (*NVMeoFNamespace, error) {
+	if err := m.fault(ctx, "NVMeoFNamespaceCreate", subsystemID, devicePath); err != nil {
+		return nil, err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -570,6 +1017,9 @@ func (m *MockClient) NVMeoFNamespaceCreate(ctx context.Context, subsystemID int,
 	return ns, nil
 }
 func (m *MockClient) NVMeoFNamespaceDelete(ctx context.Context, id int) error {
+	if err := m.fault(ctx, "NVMeoFNamespaceDelete", id); err != nil {
+		return err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -577,15 +1027,21 @@ func (m *MockClient) NVMeoFNamespaceDelete(ctx context.Context, id int) error {
 	return nil
 }
 func (m *MockClient) NVMeoFNamespaceGet(ctx context.Context, id int) (*NVMeoFNamespace, error) {
+	if err := m.fault(ctx, "NVMeoFNamespaceGet", id); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	if n, ok := m.NVMeNamespaces[id]; ok {
 		return n, nil
 	}
-	return nil, fmt.Errorf("not found")
+	return nil, notFoundf("not found")
 }
 func (m *MockClient) NVMeoFNamespaceFindByDevice(ctx context.Context, subsystemID int, devicePath string) (*NVMeoFNamespace, error) {
+	if err := m.fault(ctx, "NVMeoFNamespaceFindByDevice", subsystemID, devicePath); err != nil {
+		return nil, err
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -597,8 +1053,59 @@ func (m *MockClient) NVMeoFNamespaceFindByDevice(ctx context.Context, subsystemI
 	return nil, nil
 }
 func (m *MockClient) NVMeoFPortList(ctx context.Context) ([]*NVMeoFPort, error) {
+	if err := m.fault(ctx, "NVMeoFPortList"); err != nil {
+		return nil, err
+	}
 	return []*NVMeoFPort{{ID: 1, Transport: "tcp", Address: "0.0.0.0", Port: 4420}}, nil
 }
 func (m *MockClient) NVMeoFGetTransportAddresses(ctx context.Context, transport string) ([]string, error) {
+	if err := m.fault(ctx, "NVMeoFGetTransportAddresses", transport); err != nil {
+		return nil, err
+	}
 	return []string{"0.0.0.0"}, nil
 }
+
+// Replication methods
+func (m *MockClient) ReplicationCreate(ctx context.Context, params *ReplicationCreateParams) (*ReplicationTask, error) {
+	if err := m.fault(ctx, "ReplicationCreate", params); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := len(m.Replications) + 1
+	task := &ReplicationTask{
+		ID:             id,
+		Name:           params.Name,
+		SourceDatasets: params.SourceDatasets,
+		TargetDataset:  params.TargetDataset,
+		Enabled:        params.Enabled,
+		State:          ReplicationState{State: "PENDING"},
+	}
+	m.Replications[id] = task
+	return task, nil
+}
+
+func (m *MockClient) ReplicationDelete(ctx context.Context, id int) error {
+	if err := m.fault(ctx, "ReplicationDelete", id); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.Replications, id)
+	return nil
+}
+
+func (m *MockClient) ReplicationGet(ctx context.Context, id int) (*ReplicationTask, error) {
+	if err := m.fault(ctx, "ReplicationGet", id); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if task, ok := m.Replications[id]; ok {
+		return task, nil
+	}
+	return nil, notFoundf("replication task not found: %d", id)
+}
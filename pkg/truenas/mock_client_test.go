@@ -2,7 +2,9 @@ package truenas
 
 import (
 	"context"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -68,3 +70,87 @@ func TestMockClient_NFSShareFindByPath(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Nil(t, share)
 }
+
+func TestMockClient_Faults(t *testing.T) {
+	ctx := context.Background()
+	client := NewMockClient()
+	invalid := MockInvalidParamsError("iscsi_extent_create.name", "Extent name must be unique")
+
+	// Scripted calls fail in order, then the method behaves normally
+	client.FailNext("ISCSIExtentCreate", invalid, nil, invalid)
+	_, err := client.ISCSIExtentCreate(ctx, "a", "zvol/a", "", 4096, "SSD")
+	assert.Equal(t, invalid, err)
+	_, err = client.ISCSIExtentCreate(ctx, "a", "zvol/a", "", 4096, "SSD")
+	assert.NoError(t, err)
+	_, err = client.ISCSIExtentCreate(ctx, "b", "zvol/b", "", 4096, "SSD")
+	assert.Error(t, err)
+	_, err = client.ISCSIExtentCreate(ctx, "b", "zvol/b", "", 4096, "SSD")
+	assert.NoError(t, err)
+	assert.Len(t, client.ISCSIExtents, 2)
+
+	// Permanent failures apply until cleared, and only to their method
+	client.FailAlways("DatasetGet", MockNotFoundError("dataset pool/a not found"))
+	_, err = client.DatasetGet(ctx, "pool/a")
+	assert.True(t, IsNotFoundError(err))
+	_, err = client.DatasetCreate(ctx, &DatasetCreateParams{Name: "pool/a"})
+	assert.NoError(t, err)
+	_, err = client.DatasetGet(ctx, "pool/a")
+	assert.Error(t, err)
+	client.FailAlways("DatasetGet", nil)
+	_, err = client.DatasetGet(ctx, "pool/a")
+	assert.NoError(t, err)
+
+	// Error shapes the driver branches on
+	assert.True(t, IsAlreadyExistsError(MockAlreadyExistsError("Path pool/a")))
+	assert.Contains(t, invalid.Error(), "Invalid params")
+
+	// The log records calls in order with their arguments
+	assert.Equal(t, []string{"ISCSIExtentCreate", "ISCSIExtentCreate", "ISCSIExtentCreate", "ISCSIExtentCreate", "DatasetGet", "DatasetCreate", "DatasetGet", "DatasetGet"}, client.CallNames())
+	assert.Equal(t, []string{"DatasetCreate"}, client.CallNames("DatasetCreate"))
+	assert.Equal(t, "DatasetGet(pool/a)", client.Calls()[4].String())
+	client.ResetCalls()
+	assert.Empty(t, client.Calls())
+}
+
+func TestMockClient_InjectErrorConcurrent(t *testing.T) {
+	client := NewMockClient()
+	ctx := context.Background()
+
+	// Injected errors can be changed while calls are running
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_, _ = client.DatasetGet(ctx, "pool/dataset")
+			}
+		}()
+	}
+	for j := 0; j < 50; j++ {
+		client.SetInjectError(assert.AnError)
+		client.SetInjectError(nil)
+	}
+	wg.Wait()
+
+	client.SetInjectError(assert.AnError)
+	_, err := client.DatasetGet(ctx, "pool/dataset")
+	assert.ErrorIs(t, err, assert.AnError)
+}
+
+func TestMockClient_Latency(t *testing.T) {
+	client := NewMockClient()
+	client.SetLatency("", 20*time.Millisecond)
+	client.SetLatency("DatasetGet", time.Hour)
+
+	start := time.Now()
+	_, err := client.DatasetCreate(context.Background(), &DatasetCreateParams{Name: "pool/a"})
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	// A slow call gives up with its context without blocking other calls
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = client.DatasetGet(ctx, "pool/a")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -3,7 +3,6 @@ package truenas
 import (
 	"context"
 	"fmt"
-	"strings"
 )
 
 // NFSShare represents an NFS share from the TrueNAS API.
@@ -46,8 +45,7 @@ func (c *Client) NFSShareCreate(ctx context.Context, params *NFSShareCreateParam
 	result, err := c.Call(ctx, "sharing.nfs.create", params)
 	if err != nil {
 		// Handle "already exports" error by finding existing share
-		if strings.Contains(err.Error(), "already exports") ||
-			strings.Contains(err.Error(), "already shared") {
+		if IsAlreadyExistsError(err) {
 			existing, findErr := c.NFSShareFindByPath(ctx, params.Path)
 			if findErr == nil && existing != nil {
 				return existing, nil
@@ -63,9 +61,8 @@ func (c *Client) NFSShareCreate(ctx context.Context, params *NFSShareCreateParam
 func (c *Client) NFSShareDelete(ctx context.Context, id int) error {
 	_, err := c.Call(ctx, "sharing.nfs.delete", id)
 	if err != nil {
-		// Ignore "does not exist" errors
-		if strings.Contains(err.Error(), "does not exist") ||
-			strings.Contains(err.Error(), "not found") {
+		// Already deleted
+		if IsNotFoundError(err) {
 			return nil
 		}
 		return fmt.Errorf("failed to delete NFS share: %w", err)
@@ -85,7 +82,7 @@ func (c *Client) NFSShareGet(ctx context.Context, id int) (*NFSShare, error) {
 
 	shares, ok := result.([]interface{})
 	if !ok || len(shares) == 0 {
-		return nil, fmt.Errorf("NFS share not found: %d", id)
+		return nil, notFoundf("NFS share not found: %d", id)
 	}
 
 	return parseNFSShare(shares[0])
@@ -3,7 +3,6 @@ package truenas
 import (
 	"context"
 	"fmt"
-	"strings"
 )
 
 // NVMeoFSubsystem represents an NVMe-oF subsystem from the TrueNAS API.
@@ -48,7 +47,7 @@ func (c *Client) NVMeoFSubsystemCreate(ctx context.Context, nqn string, serial s
 
 	result, err := c.Call(ctx, "nvmet.subsys.create", params)
 	if err != nil {
-		if strings.Contains(err.Error(), "already exists") {
+		if IsAlreadyExistsError(err) {
 			return c.NVMeoFSubsystemFindByNQN(ctx, nqn)
 		}
 		return nil, fmt.Errorf("failed to create NVMe-oF subsystem: %w", err)
@@ -61,8 +60,7 @@ func (c *Client) NVMeoFSubsystemCreate(ctx context.Context, nqn string, serial s
 func (c *Client) NVMeoFSubsystemDelete(ctx context.Context, id int) error {
 	_, err := c.Call(ctx, "nvmet.subsys.delete", id)
 	if err != nil {
-		if strings.Contains(err.Error(), "does not exist") ||
-			strings.Contains(err.Error(), "not found") {
+		if IsNotFoundError(err) {
 			return nil
 		}
 		return fmt.Errorf("failed to delete NVMe-oF subsystem: %w", err)
@@ -80,7 +78,7 @@ func (c *Client) NVMeoFSubsystemGet(ctx context.Context, id int) (*NVMeoFSubsyst
 
 	subsystems, ok := result.([]interface{})
 	if !ok || len(subsystems) == 0 {
-		return nil, fmt.Errorf("NVMe-oF subsystem not found: %d", id)
+		return nil, notFoundf("NVMe-oF subsystem not found: %d", id)
 	}
 
 	return parseNVMeoFSubsystem(subsystems[0])
@@ -112,7 +110,7 @@ func (c *Client) NVMeoFNamespaceCreate(ctx context.Context, subsystemID int, dev
 
 	result, err := c.Call(ctx, "nvmet.namespace.create", params)
 	if err != nil {
-		if strings.Contains(err.Error(), "already exists") {
+		if IsAlreadyExistsError(err) {
 			return c.NVMeoFNamespaceFindByDevice(ctx, subsystemID, devicePath)
 		}
 		return nil, fmt.Errorf("failed to create NVMe-oF namespace: %w", err)
@@ -125,8 +123,7 @@ func (c *Client) NVMeoFNamespaceCreate(ctx context.Context, subsystemID int, dev
 func (c *Client) NVMeoFNamespaceDelete(ctx context.Context, id int) error {
 	_, err := c.Call(ctx, "nvmet.namespace.delete", id)
 	if err != nil {
-		if strings.Contains(err.Error(), "does not exist") ||
-			strings.Contains(err.Error(), "not found") {
+		if IsNotFoundError(err) {
 			return nil
 		}
 		return fmt.Errorf("failed to delete NVMe-oF namespace: %w", err)
@@ -144,7 +141,7 @@ func (c *Client) NVMeoFNamespaceGet(ctx context.Context, id int) (*NVMeoFNamespa
 
 	namespaces, ok := result.([]interface{})
 	if !ok || len(namespaces) == 0 {
-		return nil, fmt.Errorf("NVMe-oF namespace not found: %d", id)
+		return nil, notFoundf("NVMe-oF namespace not found: %d", id)
 	}
 
 	return parseNVMeoFNamespace(namespaces[0])
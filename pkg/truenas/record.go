@@ -0,0 +1,301 @@
+package truenas
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// redacted replaces credentials in recordings.
+const redacted = "[REDACTED]"
+
+// credentialMethods take credentials as their parameters or return them.
+var credentialMethods = map[string]bool{
+	"auth.login":              true,
+	"auth.login_ex":           true,
+	"auth.login_with_api_key": true,
+	"auth.login_with_token":   true,
+	"auth.generate_token":     true,
+}
+
+// secretKeys are object keys whose values are redacted wherever they appear, such as
+// iSCSI CHAP secrets.
+var secretKeys = map[string]bool{
+	"password":   true,
+	"secret":     true,
+	"peersecret": true,
+	"token":      true,
+	"api_key":    true,
+	"privatekey": true,
+}
+
+// Recording is one API call in a recording file, written one JSON object per line.
+type Recording struct {
+	Time     time.Time      `json:"time"`
+	Method   string         `json:"method"`
+	Params   []interface{}  `json:"params,omitempty"`
+	Result   interface{}    `json:"result,omitempty"`
+	Error    *RecordedError `json:"error,omitempty"`
+	Duration int64          `json:"duration_ms"`
+
+	// AnyParams lets the replay answer a call of the same method with other parameters,
+	// for calls that carry values changing between runs, such as timestamps. It is never
+	// set by the recorder; add it to the recordings that need it.
+	AnyParams bool `json:"any_params,omitempty"`
+}
+
+// RecordedError is a failed call. Code is zero for errors that never reached TrueNAS,
+// such as connection failures.
+type RecordedError struct {
+	Code    int         `json:"code,omitempty"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// recorder appends every call made through a connection pool to a file, with API keys,
+// passwords and secrets redacted.
+type recorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	secrets []string // Configured credentials, redacted wherever they appear
+}
+
+// newRecorder opens path for appending, so recordings survive restarts and reconfiguration.
+func newRecorder(path string, secrets ...string) (*recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open record file: %w", err)
+	}
+	r := &recorder{file: file}
+	for _, secret := range secrets {
+		if secret != "" {
+			r.secrets = append(r.secrets, secret)
+		}
+	}
+	return r, nil
+}
+
+// record appends one call. Failing to record is logged and never fails the call.
+func (r *recorder) record(method string, params []interface{}, result interface{}, err error, start time.Time) {
+	rec := Recording{
+		Time:     start.UTC(),
+		Method:   method,
+		Params:   redactParams(method, params, r.secrets),
+		Duration: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		rec.Error = recordError(err, r.secrets)
+	} else if credentialMethods[method] {
+		rec.Result = redacted
+	} else {
+		rec.Result = redact(jsonValue(result), r.secrets)
+	}
+
+	line, marshalErr := json.Marshal(rec)
+	if marshalErr != nil {
+		klog.Warningf("Failed to record %s: %v", method, marshalErr)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, writeErr := r.file.Write(append(line, '\n')); writeErr != nil {
+		klog.Warningf("Failed to record %s: %v", method, writeErr)
+	}
+}
+
+// close closes the record file.
+func (r *recorder) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// recordError converts a call error to its recorded form.
+func recordError(err error, secrets []string) *RecordedError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return &RecordedError{
+			Code:    apiErr.Code,
+			Message: redactString(apiErr.Message, secrets),
+			Data:    redact(jsonValue(apiErr.Data), secrets),
+		}
+	}
+	return &RecordedError{Message: redactString(err.Error(), secrets)}
+}
+
+// redactParams returns params as they appear on the wire, with credentials redacted.
+func redactParams(method string, params []interface{}, secrets []string) []interface{} {
+	if len(params) == 0 {
+		return nil
+	}
+	out := make([]interface{}, len(params))
+	for i, param := range params {
+		if credentialMethods[method] {
+			out[i] = redacted
+		} else {
+			out[i] = redact(jsonValue(param), secrets)
+		}
+	}
+	return out
+}
+
+// jsonValue converts v to the maps, slices and float64s it decodes to from JSON, the
+// form responses have and recordings are compared in.
+func jsonValue(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return fmt.Sprint(v)
+	}
+	return out
+}
+
+// redact replaces the values of secret keys and any occurrence of secrets in v.
+func redact(v interface{}, secrets []string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if secretKeys[strings.ToLower(key)] && value != nil && value != "" {
+				v[key] = redacted
+			} else {
+				v[key] = redact(value, secrets)
+			}
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redact(value, secrets)
+		}
+		return v
+	case string:
+		return redactString(v, secrets)
+	default:
+		return v
+	}
+}
+
+// redactString replaces any occurrence of secrets in s.
+func redactString(s string, secrets []string) string {
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return s
+}
+
+// ReadRecordings reads a recording file written by ClientConfig.RecordFile.
+func ReadRecordings(path string) ([]Recording, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	var recordings []Recording
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var rec Recording
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		recordings = append(recordings, rec)
+	}
+	return recordings, scanner.Err()
+}
+
+// NewReplayClient returns a Client that answers calls from a recording file instead of
+// a TrueNAS system, so a recording attached to a bug report can become a regression test.
+//
+// Each call is answered by the first unused recording of the same method and parameters.
+// A call repeated more often than it was recorded, such as a poll, gets the last matching
+// response again. A call whose parameters match no recording fails, so a test notices
+// when the driver asks for another dataset or ID, unless an unused recording of the same
+// method is marked AnyParams, in which case the first one answers it.
+func NewReplayClient(path string) (*Client, error) {
+	recordings, err := ReadRecordings(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recordings: %w", err)
+	}
+	return &Client{replay: &replayer{recordings: recordings, used: make([]bool, len(recordings))}}, nil
+}
+
+// replayer serves calls from recordings.
+type replayer struct {
+	mu         sync.Mutex
+	recordings []Recording
+	used       []bool
+}
+
+// call answers one call from the recordings.
+func (r *replayer) call(ctx context.Context, method string, params []interface{}) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// Compare in recorded form, so calls carrying secrets still match
+	wire := redactParams(method, params, nil)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	match, repeat, lenient, recorded := -1, -1, -1, false
+	for i, rec := range r.recordings {
+		if rec.Method != method {
+			continue
+		}
+		recorded = true
+		if reflect.DeepEqual(normalizeParams(rec.Params), normalizeParams(wire)) {
+			if !r.used[i] {
+				match = i
+				break
+			}
+			repeat = i
+		} else if rec.AnyParams && !r.used[i] && lenient < 0 {
+			lenient = i
+		}
+	}
+	if match < 0 {
+		match = repeat
+	}
+	if match < 0 {
+		match = lenient
+	}
+	if match < 0 {
+		if recorded {
+			params, _ := json.Marshal(wire)
+			return nil, fmt.Errorf("no recorded response for %s with params %s", method, params)
+		}
+		return nil, fmt.Errorf("no recorded response for %s", method)
+	}
+	r.used[match] = true
+
+	rec := r.recordings[match]
+	if rec.Error != nil {
+		if rec.Error.Code == 0 {
+			return nil, &ConnectionError{Method: method, Err: errors.New(rec.Error.Message)}
+		}
+		return nil, &APIError{Code: rec.Error.Code, Message: rec.Error.Message, Data: rec.Error.Data}
+	}
+	return rec.Result, nil
+}
+
+// normalizeParams treats missing and empty parameter lists alike.
+func normalizeParams(params []interface{}) []interface{} {
+	if len(params) == 0 {
+		return nil
+	}
+	return params
+}
@@ -0,0 +1,120 @@
+package truenas
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas/faketruenas"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "truenas.jsonl")
+	server := faketruenas.New(faketruenas.Config{APIKey: "1-secret-key"})
+	defer server.Close()
+	server.Handle("test.echo", func(params []interface{}) (interface{}, error) {
+		return params[0], nil
+	})
+	server.Handle("test.fail", func(params []interface{}) (interface{}, error) {
+		return nil, faketruenas.CallError(faketruenas.EBUSY, "dataset is busy")
+	})
+	client, err := NewClient(&ClientConfig{
+		Host:           server.Host(),
+		Port:           server.Port(),
+		Protocol:       "http",
+		APIKey:         "1-secret-key",
+		MaxConnections: 1,
+		RecordFile:     path,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Record a sequence where the same request gets different answers
+	_, missingErr := client.DatasetGet(ctx, "tank/vol")
+	assert.Error(t, missingErr)
+	created, err := client.DatasetCreate(ctx, &DatasetCreateParams{Name: "tank/vol", Type: "VOLUME", Volsize: 1 << 30, Sparse: true})
+	assert.NoError(t, err)
+	got, err := client.DatasetGet(ctx, "tank/vol")
+	assert.NoError(t, err)
+	echo := map[string]interface{}{"secret": "chap-secret-123", "comment": "key 1-secret-key"}
+	_, err = client.Call(ctx, "test.echo", echo)
+	assert.NoError(t, err)
+	_, failErr := client.Call(ctx, "test.fail")
+	assert.Error(t, failErr)
+	assert.NoError(t, client.Close())
+
+	// Credentials never reach the file
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "1-secret-key")
+	assert.NotContains(t, string(data), "chap-secret-123")
+	recordings, err := ReadRecordings(path)
+	assert.NoError(t, err)
+	assert.Len(t, recordings, 5)
+	assert.Equal(t, "pool.dataset.query", recordings[0].Method)
+	assert.Equal(t, []interface{}{}, recordings[0].Result)
+	assert.Equal(t, "pool.dataset.create", recordings[1].Method)
+	assert.Equal(t, map[string]interface{}{"secret": redacted, "comment": "key " + redacted}, recordings[3].Result)
+
+	// The replay answers the same calls in the same way, without a server
+	replay, err := NewReplayClient(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = replay.DatasetGet(ctx, "tank/vol")
+	assert.Equal(t, missingErr.Error(), err.Error())
+	replayCreated, err := replay.DatasetCreate(ctx, &DatasetCreateParams{Name: "tank/vol", Type: "VOLUME", Volsize: 1 << 30, Sparse: true})
+	assert.NoError(t, err)
+	assert.Equal(t, created, replayCreated)
+	for i := 0; i < 2; i++ {
+		replayGot, err := replay.DatasetGet(ctx, "tank/vol")
+		assert.NoError(t, err)
+		assert.Equal(t, got, replayGot)
+	}
+	_, err = replay.Call(ctx, "test.echo", map[string]interface{}{"secret": "other-secret", "comment": "key " + redacted})
+	assert.NoError(t, err)
+	_, err = replay.Call(ctx, "test.fail")
+	assert.Equal(t, failErr, err)
+
+	err = replay.DatasetDelete(ctx, "tank/vol", false, false)
+	assert.ErrorContains(t, err, "no recorded response for pool.dataset.delete")
+
+	// A recorded method called with other parameters fails, since the driver may be
+	// asking for the wrong dataset
+	_, err = replay.DatasetGet(ctx, "tank/other")
+	assert.ErrorContains(t, err, `no recorded response for pool.dataset.query with params [[["id","=","tank/other"]]`)
+	assert.True(t, replay.IsConnected())
+	assert.WithinDuration(t, time.Now(), replay.LastResponse(), time.Minute)
+	assert.Error(t, replay.Reconfigure(&ClientConfig{Host: server.Host()}))
+}
+
+func TestReplayAnyParams(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "calls.jsonl")
+	lines := `{"method":"pool.snapshot.create","params":[{"dataset":"tank/vol","name":"auto-20260101-000000"}],"result":{"id":"tank/vol@auto-20260101-000000"},"any_params":true}
+{"method":"pool.dataset.query","params":[[["id","=","tank/vol"]],{}],"result":[]}
+`
+	assert.NoError(t, os.WriteFile(path, []byte(lines), 0o600))
+	replay, err := NewReplayClient(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Test Case 1: A recording marked any_params answers a call with a new timestamp
+	result, err := replay.Call(ctx, "pool.snapshot.create", map[string]interface{}{"dataset": "tank/vol", "name": "auto-20261018-120000"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": "tank/vol@auto-20260101-000000"}, result)
+
+	// Test Case 2: Once used, it isn't reused for other parameters
+	_, err = replay.Call(ctx, "pool.snapshot.create", map[string]interface{}{"dataset": "tank/vol", "name": "auto-20261018-130000"})
+	assert.ErrorContains(t, err, "no recorded response for pool.snapshot.create with params")
+
+	// Test Case 3: Recordings without it match exactly
+	_, err = replay.Call(ctx, "pool.dataset.query", []interface{}{[]interface{}{"id", "=", "tank/other"}}, map[string]interface{}{})
+	assert.ErrorContains(t, err, "no recorded response for pool.dataset.query with params")
+}
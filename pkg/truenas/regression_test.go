@@ -0,0 +1,100 @@
+package truenas
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/truenas/faketruenas"
+	"github.com/stretchr/testify/assert"
+)
+
+// filterCalls returns the calls to the given methods, in order.
+func filterCalls(calls []string, methods ...string) []string {
+	var filtered []string
+	for _, call := range calls {
+		for _, method := range methods {
+			if call == method {
+				filtered = append(filtered, call)
+			}
+		}
+	}
+	return filtered
+}
+
+// BUG-010: TrueNAS answers duplicate iSCSI creates with "Invalid params" rather than
+// "already exists"; creation must return the existing resource.
+func TestBUG010ISCSICreateIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	server, client := newFakeClient(t, faketruenas.Config{})
+	_, err := client.DatasetCreate(ctx, &DatasetCreateParams{Name: "tank/vol", Type: "VOLUME", Volsize: 1 << 30, Sparse: true})
+	assert.NoError(t, err)
+
+	groups := []ISCSITargetGroup{{Portal: 1, Initiator: 1, AuthMethod: "NONE"}}
+	target, err := client.ISCSITargetCreate(ctx, "vol", "", "ISCSI", groups)
+	assert.NoError(t, err)
+	extent, err := client.ISCSIExtentCreate(ctx, "vol", "zvol/tank/vol", "test", 4096, "SSD")
+	assert.NoError(t, err)
+	te, err := client.ISCSITargetExtentCreate(ctx, target.ID, extent.ID, 0)
+	assert.NoError(t, err)
+
+	again, err := client.ISCSITargetCreate(ctx, "vol", "", "ISCSI", groups)
+	assert.NoError(t, err)
+	assert.Equal(t, target.ID, again.ID)
+	againExtent, err := client.ISCSIExtentCreate(ctx, "vol", "zvol/tank/vol", "test", 4096, "SSD")
+	assert.NoError(t, err)
+	assert.Equal(t, extent.ID, againExtent.ID)
+	againTE, err := client.ISCSITargetExtentCreate(ctx, target.ID, extent.ID, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, te.ID, againTE.ID)
+
+	// Each duplicate reached TrueNAS and was resolved by lookup
+	assert.Equal(t, 2, server.CallCount("iscsi.target.create"))
+	assert.Equal(t, 2, server.CallCount("iscsi.extent.create"))
+	assert.Equal(t, 2, server.CallCount("iscsi.targetextent.create"))
+}
+
+// BUG-011: "Invalid params" from snapshot delete means either a missing snapshot, which
+// is success, or dependent clones, which is an error naming them.
+func TestBUG011SnapshotDeleteInvalidParams(t *testing.T) {
+	ctx := context.Background()
+	server, client := newFakeClient(t, faketruenas.Config{})
+	_, err := client.DatasetCreate(ctx, &DatasetCreateParams{Name: "tank/vol", Type: "VOLUME", Volsize: 1 << 30, Sparse: true})
+	assert.NoError(t, err)
+
+	assert.NoError(t, client.SnapshotDelete(ctx, "tank/vol@missing", false, false))
+
+	_, err = client.SnapshotCreate(ctx, "tank/vol", "snap-1")
+	assert.NoError(t, err)
+	assert.NoError(t, client.SnapshotClone(ctx, "tank/vol@snap-1", "tank/clone"))
+	server.Handle("pool.dataset.delete", func(params []interface{}) (interface{}, error) {
+		return nil, faketruenas.CallError(faketruenas.EBUSY, "cannot destroy 'tank/clone': dataset is busy")
+	})
+
+	err = client.SnapshotDelete(ctx, "tank/vol@snap-1", false, false)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "has clones: [tank/clone]")
+	}
+	_, err = client.SnapshotGet(ctx, "tank/vol@snap-1")
+	assert.NoError(t, err)
+}
+
+// BUG-012: clones orphaned by failed volume deletes are removed so the snapshot can be.
+func TestBUG012SnapshotDeleteCleansOrphanedClones(t *testing.T) {
+	ctx := context.Background()
+	server, client := newFakeClient(t, faketruenas.Config{})
+	_, err := client.DatasetCreate(ctx, &DatasetCreateParams{Name: "tank/vol", Type: "VOLUME", Volsize: 1 << 30, Sparse: true})
+	assert.NoError(t, err)
+	_, err = client.SnapshotCreate(ctx, "tank/vol", "snap-1")
+	assert.NoError(t, err)
+	assert.NoError(t, client.SnapshotClone(ctx, "tank/vol@snap-1", "tank/orphan"))
+
+	assert.NoError(t, client.SnapshotDelete(ctx, "tank/vol@snap-1", false, false))
+	assert.Equal(t, []string{
+		"pool.snapshot.delete",
+		"pool.snapshot.get_instance",
+		"pool.dataset.delete",
+		"pool.snapshot.delete",
+	}, filterCalls(server.Calls(), "pool.snapshot.delete", "pool.snapshot.get_instance", "pool.dataset.delete"))
+	assert.Equal(t, []string{"tank", "tank/vol"}, server.Datasets())
+	assert.Empty(t, server.Snapshots())
+}
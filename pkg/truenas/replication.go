@@ -0,0 +1,140 @@
+package truenas
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ReplicationTask represents a replication task from the TrueNAS API.
+type ReplicationTask struct {
+	ID             int
+	Name           string
+	SourceDatasets []string
+	TargetDataset  string
+	Enabled        bool
+	State          ReplicationState
+}
+
+// ReplicationState is the outcome of the most recent run of a replication task.
+type ReplicationState struct {
+	// State is PENDING, RUNNING, FINISHED or ERROR
+	State        string
+	Error        string
+	Datetime     time.Time
+	LastSnapshot string
+}
+
+// ReplicationSchedule is a cron schedule for a replication task.
+type ReplicationSchedule struct {
+	Minute string `json:"minute"`
+	Hour   string `json:"hour"`
+	Dom    string `json:"dom"`
+	Month  string `json:"month"`
+	Dow    string `json:"dow"`
+}
+
+// ReplicationCreateParams holds parameters for creating a replication task.
+type ReplicationCreateParams struct {
+	Name                    string               `json:"name"`
+	Direction               string               `json:"direction"`
+	Transport               string               `json:"transport"`
+	SSHCredentials          int                  `json:"ssh_credentials"`
+	SourceDatasets          []string             `json:"source_datasets"`
+	TargetDataset           string               `json:"target_dataset"`
+	Recursive               bool                 `json:"recursive"`
+	Properties              bool                 `json:"properties"`
+	AlsoIncludeNamingSchema []string             `json:"also_include_naming_schema"`
+	Auto                    bool                 `json:"auto"`
+	Schedule                *ReplicationSchedule `json:"schedule,omitempty"`
+	RetentionPolicy         string               `json:"retention_policy"`
+	Readonly                string               `json:"readonly,omitempty"`
+	Enabled                 bool                 `json:"enabled"`
+}
+
+// ReplicationCreate creates a replication task.
+func (c *Client) ReplicationCreate(ctx context.Context, params *ReplicationCreateParams) (*ReplicationTask, error) {
+	result, err := c.Call(ctx, "replication.create", params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create replication task: %w", err)
+	}
+
+	return parseReplicationTask(result)
+}
+
+// ReplicationDelete deletes a replication task. Replicated data on the target is kept.
+func (c *Client) ReplicationDelete(ctx context.Context, id int) error {
+	_, err := c.Call(ctx, "replication.delete", id)
+	if err != nil {
+		// Handle "not found" errors as success (idempotency)
+		if IsNotFoundError(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete replication task: %w", err)
+	}
+	return nil
+}
+
+// ReplicationGet retrieves a replication task by ID.
+func (c *Client) ReplicationGet(ctx context.Context, id int) (*ReplicationTask, error) {
+	filters := [][]interface{}{{"id", "=", id}}
+	result, err := c.Call(ctx, "replication.query", filters, map[string]interface{}{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get replication task: %w", err)
+	}
+
+	tasks, ok := result.([]interface{})
+	if !ok || len(tasks) == 0 {
+		return nil, notFoundf("replication task not found: %d", id)
+	}
+
+	return parseReplicationTask(tasks[0])
+}
+
+// parseReplicationTask converts a raw API response to a ReplicationTask struct.
+func parseReplicationTask(data interface{}) (*ReplicationTask, error) {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected replication task format")
+	}
+
+	task := &ReplicationTask{}
+	if v, ok := m["id"].(float64); ok {
+		task.ID = int(v)
+	}
+	if v, ok := m["name"].(string); ok {
+		task.Name = v
+	}
+	if v, ok := m["source_datasets"].([]interface{}); ok {
+		for _, ds := range v {
+			if s, ok := ds.(string); ok {
+				task.SourceDatasets = append(task.SourceDatasets, s)
+			}
+		}
+	}
+	if v, ok := m["target_dataset"].(string); ok {
+		task.TargetDataset = v
+	}
+	if v, ok := m["enabled"].(bool); ok {
+		task.Enabled = v
+	}
+	if state, ok := m["state"].(map[string]interface{}); ok {
+		if v, ok := state["state"].(string); ok {
+			task.State.State = v
+		}
+		if v, ok := state["error"].(string); ok {
+			task.State.Error = v
+		}
+		if v, ok := state["last_snapshot"].(string); ok {
+			task.State.LastSnapshot = v
+		}
+		// Dates are encoded as {"$date": <milliseconds since the epoch>}
+		if datetime, ok := state["datetime"].(map[string]interface{}); ok {
+			if v, ok := datetime["$date"].(float64); ok {
+				task.State.Datetime = time.UnixMilli(int64(v)).UTC()
+			}
+		}
+	}
+
+	return task, nil
+}
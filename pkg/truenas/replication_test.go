@@ -0,0 +1,43 @@
+package truenas
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseReplicationTask(t *testing.T) {
+	task, err := parseReplicationTask(map[string]interface{}{
+		"id":              float64(7),
+		"name":            "truenas-csi tank/k8s/vol-01",
+		"source_datasets": []interface{}{"tank/k8s/vol-01"},
+		"target_dataset":  "backup/k8s/vol-01",
+		"enabled":         true,
+		"state": map[string]interface{}{
+			"state":         "FINISHED",
+			"datetime":      map[string]interface{}{"$date": float64(1714564800000)},
+			"last_snapshot": "tank/k8s/vol-01@hourly-20240501-120000",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, task.ID)
+	assert.Equal(t, []string{"tank/k8s/vol-01"}, task.SourceDatasets)
+	assert.Equal(t, "backup/k8s/vol-01", task.TargetDataset)
+	assert.True(t, task.Enabled)
+	assert.Equal(t, "FINISHED", task.State.State)
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), task.State.Datetime)
+	assert.Equal(t, "tank/k8s/vol-01@hourly-20240501-120000", task.State.LastSnapshot)
+
+	// Tasks that never ran have no datetime
+	task, err = parseReplicationTask(map[string]interface{}{
+		"id":    float64(8),
+		"state": map[string]interface{}{"state": "ERROR", "error": "No incremental base"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "No incremental base", task.State.Error)
+	assert.True(t, task.State.Datetime.IsZero())
+
+	_, err = parseReplicationTask("unexpected")
+	assert.Error(t, err)
+}
@@ -2,51 +2,24 @@ package truenas
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
-	"sync"
 
 	"k8s.io/klog/v2"
 )
 
-// apiMethodPrefix stores the detected API prefix for snapshot methods.
-// TrueNAS 24.x uses "zfs.snapshot.*", while 25.04+ may use "pool.snapshot.*"
-var (
-	snapshotAPIPrefix     string
-	snapshotAPIPrefixOnce sync.Once
-)
-
-// detectSnapshotAPIPrefix detects which API prefix to use for snapshot methods.
-// This provides compatibility between TrueNAS 24.x (zfs.snapshot.*) and 25.04+ (pool.snapshot.*).
-func (c *Client) detectSnapshotAPIPrefix(ctx context.Context) string {
-	snapshotAPIPrefixOnce.Do(func() {
-		// Try pool.snapshot.query first (TrueNAS 25.04+)
-		_, err := c.Call(ctx, "pool.snapshot.query", [][]interface{}{}, map[string]interface{}{"limit": 1})
-		if err == nil {
-			snapshotAPIPrefix = "pool.snapshot"
-			klog.V(2).Infof("Detected TrueNAS 25.04+ API (pool.snapshot.*)")
-			return
-		}
-
-		// Fall back to zfs.snapshot.query (TrueNAS 24.x)
-		_, err = c.Call(ctx, "zfs.snapshot.query", [][]interface{}{}, map[string]interface{}{"limit": 1})
-		if err == nil {
-			snapshotAPIPrefix = "zfs.snapshot"
-			klog.V(2).Infof("Detected TrueNAS 24.x API (zfs.snapshot.*)")
-			return
-		}
-
-		// Default to zfs.snapshot if both fail (shouldn't happen)
-		snapshotAPIPrefix = "zfs.snapshot"
-		klog.Warningf("Could not detect snapshot API prefix, defaulting to zfs.snapshot.*")
-	})
-	return snapshotAPIPrefix
-}
-
-// snapshotMethod returns the full API method name for a snapshot operation.
+// snapshotMethod returns the full API method name for a snapshot operation, which is
+// pool.snapshot.* on TrueNAS 25.04+ and zfs.snapshot.* on 24.x.
 func (c *Client) snapshotMethod(ctx context.Context, operation string) string {
-	prefix := c.detectSnapshotAPIPrefix(ctx)
-	return prefix + "." + operation
+	caps, err := c.Capabilities(ctx)
+	if err != nil {
+		// The call fails the same way; name it for the supported releases
+		klog.V(4).Infof("Snapshot API detection failed: %v", err)
+		return "pool.snapshot." + operation
+	}
+	return caps.SnapshotPrefix + "." + operation
 }
 
 // Snapshot represents a ZFS snapshot from the TrueNAS API.
@@ -77,7 +50,7 @@ func (c *Client) SnapshotCreate(ctx context.Context, dataset string, name string
 	result, err := c.Call(ctx, c.snapshotMethod(ctx, "create"), params)
 	if err != nil {
 		// Ignore "already exists" errors
-		if strings.Contains(err.Error(), "already exists") {
+		if IsAlreadyExistsError(err) {
 			return c.SnapshotGet(ctx, dataset+"@"+name)
 		}
 		return nil, fmt.Errorf("failed to create snapshot: %w", err)
@@ -97,22 +70,21 @@ func (c *Client) SnapshotDelete(ctx context.Context, snapshotID string, defer_ b
 
 	_, err := c.Call(ctx, c.snapshotMethod(ctx, "delete"), snapshotID, options)
 	if err != nil {
-		// Ignore "does not exist" errors
-		if strings.Contains(err.Error(), "does not exist") ||
-			strings.Contains(err.Error(), "not found") {
+		// Already deleted
+		if IsNotFoundError(err) {
 			return nil
 		}
-		// TrueNAS returns "Invalid params" for multiple conditions:
-		// 1. Snapshot doesn't exist
-		// 2. Snapshot has clones and can't be deleted
-		// Check if snapshot exists to distinguish between these cases
-		if strings.Contains(err.Error(), "Invalid params") {
-			// Try to get the snapshot - if it doesn't exist, treat as success
+		// A snapshot with clones fails validation or is busy, depending on the release
+		var apiErr *APIError
+		if kind := ErrorKindOf(err); kind == ErrorBusy || (errors.As(err, &apiErr) && apiErr.Code == codeInvalidParams) {
 			snap, getErr := c.SnapshotGet(ctx, snapshotID)
-			if getErr != nil {
-				// Snapshot doesn't exist, treat delete as successful
+			if IsNotFoundError(getErr) {
+				// Deleted meanwhile
 				return nil
 			}
+			if getErr != nil {
+				return fmt.Errorf("failed to delete snapshot: %w", err)
+			}
 
 			// Snapshot exists - check if it has clones we can clean up
 			clones := snap.GetClones()
@@ -149,13 +121,8 @@ func (c *Client) SnapshotDelete(ctx context.Context, snapshotID string, defer_ b
 func (c *Client) SnapshotGet(ctx context.Context, snapshotID string) (*Snapshot, error) {
 	result, err := c.Call(ctx, c.snapshotMethod(ctx, "get_instance"), snapshotID)
 	if err != nil {
-		// Check for "Invalid params" which indicates not found for get_instance
-		if apiErr, ok := err.(*APIError); ok && apiErr.Code == -32602 {
-			return nil, fmt.Errorf("snapshot not found: %s", snapshotID)
-		}
-		// Also check standard IsNotFoundError
 		if IsNotFoundError(err) {
-			return nil, fmt.Errorf("snapshot not found: %s", snapshotID)
+			return nil, notFoundf("snapshot not found: %s", snapshotID)
 		}
 		return nil, fmt.Errorf("failed to get snapshot: %w", err)
 	}
@@ -271,10 +238,10 @@ func (c *Client) SnapshotClone(ctx context.Context, snapshotID string, newDatase
 		"dataset_dst": newDatasetName,
 	}
 
-	_, err := c.Call(ctx, c.snapshotMethod(ctx, "clone"), params)
+	_, err := c.CallJob(ctx, c.snapshotMethod(ctx, "clone"), params)
 	if err != nil {
 		// Ignore "already exists" errors
-		if strings.Contains(err.Error(), "already exists") {
+		if IsAlreadyExistsError(err) {
 			return nil
 		}
 		return fmt.Errorf("failed to clone snapshot: %w", err)
@@ -378,12 +345,24 @@ func (snap *Snapshot) GetSnapshotSize() int64 {
 	return 0
 }
 
-// GetCreationTime returns the creation timestamp of a snapshot.
+// GetCreationTime returns the creation timestamp of a snapshot in seconds since the epoch.
 func (snap *Snapshot) GetCreationTime() int64 {
 	if creation, ok := snap.Properties["creation"]; ok {
 		if creationMap, ok := creation.(map[string]interface{}); ok {
-			if parsed, ok := creationMap["parsed"].(float64); ok {
+			switch parsed := creationMap["parsed"].(type) {
+			case float64:
 				return int64(parsed)
+			case map[string]interface{}:
+				// Datetimes are encoded as {"$date": <milliseconds since the epoch>}
+				if ms, ok := parsed["$date"].(float64); ok {
+					return int64(ms) / 1000
+				}
+			}
+			// The raw value is always seconds since the epoch
+			if raw, ok := creationMap["rawvalue"].(string); ok {
+				if v, err := strconv.ParseInt(raw, 10, 64); err == nil {
+					return v
+				}
 			}
 		}
 	}
@@ -0,0 +1,200 @@
+package truenas
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// spkiPinPrefix is the curl-style prefix accepted on SPKI pins.
+const spkiPinPrefix = "sha256//"
+
+// TLSConfig holds TLS settings for the TrueNAS WebSocket connection.
+// Files are re-read on every handshake, so rotated certificates are picked up on the
+// next reconnect without restarting the driver.
+type TLSConfig struct {
+	CAFile         string   // PEM CA bundle used instead of the system roots
+	CAData         []byte   // Inline PEM CA bundle, appended to CAFile if both are set
+	ServerName     string   // Overrides the hostname used for SNI and certificate verification
+	PinnedSPKI     []string // SHA-256 SPKI fingerprints (sha256//base64 or hex); any match is accepted
+	ClientCertFile string   // PEM client certificate for mutual TLS
+	ClientKeyFile  string   // PEM client key for mutual TLS
+}
+
+// tlsConfigBuilder builds the tls.Config used for each dial.
+type tlsConfigBuilder struct {
+	cfg           TLSConfig
+	allowInsecure bool
+	host          string
+	pins          [][]byte
+}
+
+// newTLSConfigBuilder validates the TLS settings and returns a builder for them.
+func newTLSConfigBuilder(cfg TLSConfig, host string, allowInsecure bool) (*tlsConfigBuilder, error) {
+	if (cfg.ClientCertFile == "") != (cfg.ClientKeyFile == "") {
+		return nil, fmt.Errorf("client certificate and key must be set together")
+	}
+
+	b := &tlsConfigBuilder{cfg: cfg, allowInsecure: allowInsecure, host: host}
+	for _, pin := range cfg.PinnedSPKI {
+		digest, err := parseSPKIPin(pin)
+		if err != nil {
+			return nil, err
+		}
+		b.pins = append(b.pins, digest)
+	}
+
+	// Fail early on unreadable files; they are read again on each handshake
+	if _, err := b.rootCAs(); err != nil {
+		return nil, err
+	}
+	if cfg.ClientCertFile != "" {
+		if _, err := b.clientCertificate(); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// build returns a tls.Config for a new connection.
+//
+// Chain verification is done in VerifyConnection rather than by crypto/tls so that the
+// CA bundle can be reloaded per handshake. It is skipped when allowInsecure is set, or
+// when pins are configured without a CA bundle (the usual setup for TrueNAS's self-signed
+// certificate). Pins, when configured, are always enforced.
+func (b *tlsConfigBuilder) build() *tls.Config {
+	serverName := b.cfg.ServerName
+	if serverName == "" {
+		serverName = b.host
+	}
+
+	config := &tls.Config{
+		ServerName: serverName,
+		// Verification happens in VerifyConnection
+		InsecureSkipVerify: true,
+		VerifyConnection:   b.verifyConnection,
+	}
+	if b.cfg.ClientCertFile != "" {
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return b.clientCertificate()
+		}
+	}
+	return config
+}
+
+// verifyChain reports whether the certificate chain must be verified against the CA roots.
+func (b *tlsConfigBuilder) verifyChain() bool {
+	if b.allowInsecure {
+		return false
+	}
+	hasCA := b.cfg.CAFile != "" || len(b.cfg.CAData) > 0
+	return hasCA || len(b.pins) == 0
+}
+
+// verifyConnection verifies the server certificate chain and SPKI pins.
+func (b *tlsConfigBuilder) verifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("server presented no certificates")
+	}
+
+	// Without a verified chain only the leaf proves the server holds its key; any other
+	// certificate could have been copied into the chain by someone else
+	candidates := cs.PeerCertificates[:1]
+	if b.verifyChain() {
+		roots, err := b.rootCAs()
+		if err != nil {
+			return err
+		}
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			DNSName:       cs.ServerName,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, cert := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		chains, err := cs.PeerCertificates[0].Verify(opts)
+		if err != nil {
+			return err
+		}
+		candidates = nil
+		for _, chain := range chains {
+			candidates = append(candidates, chain...)
+		}
+	}
+
+	if len(b.pins) > 0 {
+		return b.verifyPins(candidates)
+	}
+	return nil
+}
+
+// verifyPins succeeds if any of certs matches a configured pin. certs is the leaf
+// followed by the verified chains, or the leaf alone if the chain wasn't verified.
+func (b *tlsConfigBuilder) verifyPins(certs []*x509.Certificate) error {
+	for _, cert := range certs {
+		digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range b.pins {
+			if string(pin) == string(digest[:]) {
+				return nil
+			}
+		}
+	}
+	leaf := sha256.Sum256(certs[0].RawSubjectPublicKeyInfo)
+	return fmt.Errorf("server certificate does not match any pinned public key (server presented %s%s)",
+		spkiPinPrefix, base64.StdEncoding.EncodeToString(leaf[:]))
+}
+
+// rootCAs loads the configured CA bundle, or returns nil to use the system roots.
+func (b *tlsConfigBuilder) rootCAs() (*x509.CertPool, error) {
+	if b.cfg.CAFile == "" && len(b.cfg.CAData) == 0 {
+		return nil, nil
+	}
+
+	pool := x509.NewCertPool()
+	if b.cfg.CAFile != "" {
+		data, err := os.ReadFile(b.cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", b.cfg.CAFile)
+		}
+	}
+	if len(b.cfg.CAData) > 0 && !pool.AppendCertsFromPEM(b.cfg.CAData) {
+		return nil, fmt.Errorf("no certificates found in inline CA bundle")
+	}
+	return pool, nil
+}
+
+// clientCertificate loads the client certificate and key.
+func (b *tlsConfigBuilder) clientCertificate() (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(b.cfg.ClientCertFile, b.cfg.ClientKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	return &cert, nil
+}
+
+// parseSPKIPin decodes a SHA-256 SPKI pin given as sha256//base64 or hex (colons allowed).
+func parseSPKIPin(pin string) ([]byte, error) {
+	pin = strings.TrimSpace(pin)
+	if rest, ok := strings.CutPrefix(pin, spkiPinPrefix); ok {
+		digest, err := base64.StdEncoding.DecodeString(rest)
+		if err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("invalid SPKI pin %q: expected base64 SHA-256 digest", pin)
+		}
+		return digest, nil
+	}
+
+	digest, err := hex.DecodeString(strings.ReplaceAll(pin, ":", ""))
+	if err != nil || len(digest) != sha256.Size {
+		return nil, fmt.Errorf("invalid SPKI pin %q: expected %s<base64> or hex SHA-256 digest", pin, spkiPinPrefix)
+	}
+	return digest, nil
+}
@@ -0,0 +1,170 @@
+package truenas
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// handshake dials the test server with the builder's config.
+func handshake(t *testing.T, server *httptest.Server, b *tlsConfigBuilder) error {
+	conn, err := tls.Dial("tcp", server.Listener.Addr().String(), b.build())
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func TestTLSConfigBuilder(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	cert := server.Certificate()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	pin := spkiPinPrefix + base64.StdEncoding.EncodeToString(digest[:])
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+
+	// Test Case 1: System roots reject the self-signed certificate
+	b, err := newTLSConfigBuilder(TLSConfig{}, "127.0.0.1", false)
+	assert.NoError(t, err)
+	assert.Error(t, handshake(t, server, b))
+
+	// Test Case 2: AllowInsecure accepts it
+	b, err = newTLSConfigBuilder(TLSConfig{}, "127.0.0.1", true)
+	assert.NoError(t, err)
+	assert.NoError(t, handshake(t, server, b))
+
+	// Test Case 3: Inline CA bundle
+	b, err = newTLSConfigBuilder(TLSConfig{CAData: caPEM}, "127.0.0.1", false)
+	assert.NoError(t, err)
+	assert.NoError(t, handshake(t, server, b))
+
+	// Test Case 4: Server name must match the certificate, and can be overridden
+	b, err = newTLSConfigBuilder(TLSConfig{CAData: caPEM, ServerName: "truenas.local"}, "127.0.0.1", false)
+	assert.NoError(t, err)
+	assert.Error(t, handshake(t, server, b))
+	b, err = newTLSConfigBuilder(TLSConfig{CAData: caPEM, ServerName: "example.com"}, "127.0.0.1", false)
+	assert.NoError(t, err)
+	assert.NoError(t, handshake(t, server, b))
+
+	// Test Case 5: Pin alone is enough for a self-signed certificate
+	b, err = newTLSConfigBuilder(TLSConfig{PinnedSPKI: []string{pin}}, "127.0.0.1", false)
+	assert.NoError(t, err)
+	assert.NoError(t, handshake(t, server, b))
+
+	// Test Case 6: Pins are enforced even with AllowInsecure
+	otherPin := hex.EncodeToString(make([]byte, sha256.Size))
+	b, err = newTLSConfigBuilder(TLSConfig{PinnedSPKI: []string{otherPin}}, "127.0.0.1", true)
+	assert.NoError(t, err)
+	err = handshake(t, server, b)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), pin)
+
+	// Test Case 7: A pinned certificate copied into another key's chain is not trusted
+	assert.Error(t, handshake(t, chainServer(t, cert.Raw), mustBuilder(t, TLSConfig{PinnedSPKI: []string{pin}})))
+	assert.Error(t, handshake(t, chainServer(t, cert.Raw), mustBuilder(t, TLSConfig{CAData: caPEM, PinnedSPKI: []string{pin}})))
+
+	// Test Case 8: CA file is re-read on each handshake
+	assert.NoError(t, os.WriteFile(caFile, caPEM, 0600))
+	b, err = newTLSConfigBuilder(TLSConfig{CAFile: caFile}, "127.0.0.1", false)
+	assert.NoError(t, err)
+	assert.NoError(t, handshake(t, server, b))
+	assert.NoError(t, os.WriteFile(caFile, []byte("rotating"), 0600))
+	assert.Error(t, handshake(t, server, b))
+	assert.NoError(t, os.WriteFile(caFile, caPEM, 0600))
+	assert.NoError(t, handshake(t, server, b))
+}
+
+// mustBuilder returns a builder for cfg that verifies the test server's address.
+func mustBuilder(t *testing.T, cfg TLSConfig) *tlsConfigBuilder {
+	b, err := newTLSConfigBuilder(cfg, "127.0.0.1", false)
+	assert.NoError(t, err)
+	return b
+}
+
+// chainServer starts a TLS server with a fresh self-signed key whose chain ends with extra.
+func chainServer(t *testing.T, extra []byte) *httptest.Server {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "attacker"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	leaf, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{leaf, extra}, PrivateKey: key}}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestTLSConfigBuilderValidation(t *testing.T) {
+	_, err := newTLSConfigBuilder(TLSConfig{ClientCertFile: "tls.crt"}, "truenas", false)
+	assert.Error(t, err)
+
+	_, err = newTLSConfigBuilder(TLSConfig{CAFile: filepath.Join(t.TempDir(), "missing.crt")}, "truenas", false)
+	assert.Error(t, err)
+
+	_, err = newTLSConfigBuilder(TLSConfig{PinnedSPKI: []string{"sha256//not-a-digest"}}, "truenas", false)
+	assert.Error(t, err)
+}
+
+func TestParseSPKIPin(t *testing.T) {
+	digest := sha256.Sum256([]byte("spki"))
+	hexPin := hex.EncodeToString(digest[:])
+
+	var colonPin string
+	for i, b := range digest {
+		if i > 0 {
+			colonPin += ":"
+		}
+		colonPin += hex.EncodeToString([]byte{b})
+	}
+
+	tests := []struct {
+		name    string
+		pin     string
+		wantErr bool
+	}{
+		{"base64", spkiPinPrefix + base64.StdEncoding.EncodeToString(digest[:]), false},
+		{"hex", hexPin, false},
+		{"hex with colons", colonPin, false},
+		{"short hex", hexPin[:10], true},
+		{"bad base64", spkiPinPrefix + "###", true},
+		{"empty", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSPKIPin(tt.pin)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, digest[:], got)
+		})
+	}
+}
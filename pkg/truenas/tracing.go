@@ -0,0 +1,19 @@
+package truenas
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer records one client span per TrueNAS API call.
+var tracer = otel.Tracer("github.com/GizmoTickler/truenas-scale-csi/pkg/truenas")
+
+// endSpan records err (if any) on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
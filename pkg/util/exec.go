@@ -0,0 +1,57 @@
+// Package util provides utility functions for running node commands.
+package util
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+)
+
+// Exec runs commands on the node and locates host paths such as /sys and /dev. The node
+// service takes it as a dependency so attach flows can run against a fake.
+type Exec interface {
+	// CombinedOutput runs a command and returns its stdout and stderr.
+	CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error)
+
+	// Output runs a command and returns its stdout.
+	Output(ctx context.Context, name string, args ...string) ([]byte, error)
+
+	// HostPath returns where an absolute host path like /sys/class/nvme is visible to the driver.
+	HostPath(path string) string
+}
+
+// NewExec returns an Exec that runs commands with os/exec. Host paths are resolved under
+// root, for containers that mount the host's /sys and /dev elsewhere (empty for /).
+func NewExec(root string) Exec {
+	return &osExec{root: root}
+}
+
+// osExec is the Exec used outside of tests.
+type osExec struct {
+	root string
+}
+
+func (e *osExec) CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+func (e *osExec) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).Output()
+}
+
+func (e *osExec) HostPath(path string) string {
+	if e.root == "" {
+		return path
+	}
+	return filepath.Join(e.root, path)
+}
+
+// exitCode returns the exit status of a failed command, or -1 if err isn't an exit status.
+func exitCode(err error) int {
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
@@ -0,0 +1,513 @@
+// Package fakeexec is a fake util.Exec for node service tests. It records the commands it is
+// asked to run and simulates mount, findmnt, blkid, mkfs, iscsiadm and nvme, creating the
+// sysfs and /dev entries the attach code looks for under a temporary root.
+package fakeexec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Call is a command run through the fake.
+type Call struct {
+	Name string
+	Args []string
+}
+
+// String returns the command line.
+func (c Call) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// Handler runs a command in place of the built-in simulation. ctx is the context the command
+// was run with.
+type Handler func(ctx context.Context, args []string) ([]byte, error)
+
+// ExitError is returned by commands that exit with a non-zero status.
+type ExitError struct {
+	Code   int
+	Output string
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// ExitCode returns the exit status, like exec.ExitError.
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+// fail returns the output and error of a command that printed msg and exited with code.
+func fail(code int, format string, args ...interface{}) ([]byte, error) {
+	msg := fmt.Sprintf(format, args...)
+	return []byte(msg), &ExitError{Code: code, Output: msg}
+}
+
+// iscsiTarget is a target that can be logged into.
+type iscsiTarget struct {
+	portal string
+	lun    int
+	device string
+}
+
+// iscsiSession is a logged-in target.
+type iscsiSession struct {
+	id     int
+	portal string
+}
+
+// Exec is a fake util.Exec. Host paths resolve under the root directory passed to New.
+type Exec struct {
+	root string
+
+	mu          sync.Mutex
+	calls       []Call
+	handlers    map[string]Handler
+	mounts      map[string]string // target -> source
+	filesystems map[string]string // device -> filesystem type
+
+	iscsiTargets  map[string]iscsiTarget  // iqn -> target
+	iscsiSessions map[string]iscsiSession // iqn -> session
+	nextSession   int
+
+	nvmeSubsystems map[string]bool // known NQNs
+	nvmeConnected  map[string]int  // nqn -> controller number
+	nextController int
+}
+
+// New returns a fake whose sysfs and /dev live under root, typically t.TempDir().
+func New(root string) *Exec {
+	return &Exec{
+		root:           root,
+		handlers:       make(map[string]Handler),
+		mounts:         make(map[string]string),
+		filesystems:    make(map[string]string),
+		iscsiTargets:   make(map[string]iscsiTarget),
+		iscsiSessions:  make(map[string]iscsiSession),
+		nextSession:    1,
+		nvmeSubsystems: make(map[string]bool),
+		nvmeConnected:  make(map[string]int),
+	}
+}
+
+// CombinedOutput implements util.Exec.
+func (e *Exec) CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return e.run(ctx, name, args)
+}
+
+// Output implements util.Exec. The fake doesn't separate stdout from stderr.
+func (e *Exec) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return e.run(ctx, name, args)
+}
+
+// HostPath implements util.Exec.
+func (e *Exec) HostPath(path string) string {
+	return filepath.Join(e.root, path)
+}
+
+// Handle replaces the simulation of a command, for example to script a failure.
+func (e *Exec) Handle(name string, handler Handler) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.handlers[name] = handler
+}
+
+// Calls returns the commands run so far.
+func (e *Exec) Calls() []Call {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Call(nil), e.calls...)
+}
+
+// Commands returns the command lines run so far.
+func (e *Exec) Commands() []string {
+	var commands []string
+	for _, call := range e.Calls() {
+		commands = append(commands, call.String())
+	}
+	return commands
+}
+
+// Mounts returns the mount table as target -> source.
+func (e *Exec) Mounts() map[string]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	mounts := make(map[string]string, len(e.mounts))
+	for target, source := range e.mounts {
+		mounts[target] = source
+	}
+	return mounts
+}
+
+// Format marks a device as already holding a filesystem.
+func (e *Exec) Format(device, fsType string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.filesystems[device] = fsType
+}
+
+// AddISCSITarget makes a target loginable at portal. Logging in creates a session whose LUN
+// appears as /dev/<device>, such as "sdb".
+func (e *Exec) AddISCSITarget(portal, iqn string, lun int, device string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.iscsiTargets[iqn] = iscsiTarget{portal: portal, lun: lun, device: device}
+}
+
+// ISCSISessions returns the IQNs of the logged-in targets.
+func (e *Exec) ISCSISessions() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return sortedKeys(e.iscsiSessions)
+}
+
+// AddNVMeSubsystem makes a subsystem connectable.
+func (e *Exec) AddNVMeSubsystem(nqn string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.nvmeSubsystems[nqn] = true
+}
+
+// NVMeConnections returns the NQNs of the connected subsystems.
+func (e *Exec) NVMeConnections() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return sortedKeys(e.nvmeConnected)
+}
+
+func (e *Exec) run(ctx context.Context, name string, args []string) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.calls = append(e.calls, Call{Name: name, Args: append([]string(nil), args...)})
+
+	if handler, ok := e.handlers[name]; ok {
+		e.mu.Unlock()
+		defer e.mu.Lock()
+		return handler(ctx, args)
+	}
+
+	switch {
+	case name == "mount":
+		return e.mount(args)
+	case name == "umount":
+		return e.umount(args)
+	case name == "findmnt":
+		return e.findmnt(args)
+	case name == "blkid":
+		device := args[len(args)-1]
+		if fsType, ok := e.filesystems[device]; ok {
+			return []byte(fsType + "\n"), nil
+		}
+		return fail(2, "")
+	case strings.HasPrefix(name, "mkfs."):
+		device := args[len(args)-1]
+		if _, err := os.Stat(e.HostPath(device)); err != nil {
+			return fail(1, "The file %s does not exist and no size was specified.", device)
+		}
+		e.filesystems[device] = strings.TrimPrefix(name, "mkfs.")
+		return nil, nil
+	case name == "resize2fs", name == "xfs_growfs", name == "btrfs", name == "blockdev":
+		return nil, nil
+	case name == "iscsiadm":
+		return e.iscsiadm(args)
+	case name == "nvme":
+		return e.nvme(args)
+	}
+	return fail(127, "%s: command not found", name)
+}
+
+// mount handles "mount [-t type] [-o options] [--bind] <source> <target>".
+func (e *Exec) mount(args []string) ([]byte, error) {
+	if len(args) < 2 {
+		return fail(1, "mount: bad usage")
+	}
+	source, target := args[len(args)-2], args[len(args)-1]
+	if _, err := os.Stat(target); err != nil {
+		return fail(32, "mount: %s: mount point does not exist.", target)
+	}
+	if e.mounts[target] == source {
+		return fail(32, "mount: %s: %s already mounted on %s.", target, source, target)
+	}
+	e.mounts[target] = source
+	return nil, nil
+}
+
+// umount handles "umount [-l] <target>".
+func (e *Exec) umount(args []string) ([]byte, error) {
+	target := args[len(args)-1]
+	if _, ok := e.mounts[target]; !ok {
+		return fail(32, "umount: %s: not mounted.", target)
+	}
+	delete(e.mounts, target)
+	return nil, nil
+}
+
+// findmnt handles "findmnt --mountpoint <target> --noheadings" and "findmnt -n -o SOURCE <target>".
+func (e *Exec) findmnt(args []string) ([]byte, error) {
+	var target string
+	sourceOnly := false
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--mountpoint":
+			i++
+			target = args[i]
+		case "-o":
+			i++
+			sourceOnly = args[i] == "SOURCE"
+		default:
+			if !strings.HasPrefix(args[i], "-") {
+				target = args[i]
+			}
+		}
+	}
+	source, ok := e.mounts[target]
+	if !ok {
+		return fail(1, "")
+	}
+	if sourceOnly {
+		return []byte(source + "\n"), nil
+	}
+	return []byte(fmt.Sprintf("%s %s\n", target, source)), nil
+}
+
+// iscsiadm handles the session, discovery and node modes used by pkg/util.
+func (e *Exec) iscsiadm(args []string) ([]byte, error) {
+	var mode, iqn, op string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-m":
+			i++
+			mode = args[i]
+		case "-T":
+			i++
+			iqn = args[i]
+		case "-o":
+			i++
+			op = args[i]
+		case "--login", "--logout", "--rescan":
+			op = args[i]
+		}
+	}
+
+	switch mode {
+	case "session":
+		if len(e.iscsiSessions) == 0 {
+			return fail(21, "iscsiadm: No active sessions.")
+		}
+		var lines []string
+		for _, iqn := range sortedKeys(e.iscsiSessions) {
+			session := e.iscsiSessions[iqn]
+			lines = append(lines, fmt.Sprintf("tcp: [%d] %s,1 %s (non-flash)", session.id, session.portal, iqn))
+		}
+		return []byte(strings.Join(lines, "\n") + "\n"), nil
+	case "discovery":
+		var lines []string
+		for _, iqn := range sortedKeys(e.iscsiTargets) {
+			lines = append(lines, fmt.Sprintf("%s,1 %s", e.iscsiTargets[iqn].portal, iqn))
+		}
+		return []byte(strings.Join(lines, "\n")), nil
+	case "node":
+		switch op {
+		case "--login":
+			target, ok := e.iscsiTargets[iqn]
+			if !ok {
+				return fail(21, "iscsiadm: No records found")
+			}
+			if _, ok := e.iscsiSessions[iqn]; ok {
+				return fail(15, "iscsiadm: default: 1 session requested, but 1 already present.")
+			}
+			session := iscsiSession{id: e.nextSession, portal: target.portal}
+			e.nextSession++
+			if err := e.createISCSIDevice(iqn, session.id, target); err != nil {
+				return fail(1, "iscsiadm: %v", err)
+			}
+			e.iscsiSessions[iqn] = session
+			return []byte(fmt.Sprintf("Login to [iface: default, target: %s, portal: %s,3260] successful.", iqn, target.portal)), nil
+		case "--logout":
+			session, ok := e.iscsiSessions[iqn]
+			if !ok {
+				return fail(21, "iscsiadm: No matching sessions found")
+			}
+			e.removeISCSIDevice(session.id, e.iscsiTargets[iqn])
+			delete(e.iscsiSessions, iqn)
+			return []byte(fmt.Sprintf("Logout of [sid: %d, target: %s] successful.", session.id, iqn)), nil
+		}
+		return nil, nil
+	}
+	return fail(7, "iscsiadm: invalid mode %q", mode)
+}
+
+// createISCSIDevice creates the sysfs entries for a session's LUN, using the session number as
+// the SCSI host number.
+func (e *Exec) createISCSIDevice(iqn string, session int, target iscsiTarget) error {
+	host := session
+	sessionName := fmt.Sprintf("session%d", session)
+	hctl := fmt.Sprintf("%d:0:0:%d", host, target.lun)
+	deviceDir := e.HostPath(fmt.Sprintf("/sys/devices/platform/host%d/%s/target%d:0:0/%s", host, sessionName, host, hctl))
+
+	for _, dir := range []string{
+		e.HostPath("/sys/class/iscsi_session/" + sessionName),
+		e.HostPath(fmt.Sprintf("/sys/class/iscsi_host/host%d/device/%s", host, sessionName)),
+		e.HostPath(fmt.Sprintf("/sys/class/scsi_device/%s/device/block/%s", hctl, target.device)),
+		e.HostPath("/sys/block/" + target.device),
+		e.HostPath("/dev"),
+		deviceDir,
+	} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(e.HostPath("/sys/class/iscsi_session/"+sessionName+"/targetname"), []byte(iqn+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := os.Symlink(deviceDir, e.HostPath("/sys/block/"+target.device+"/device")); err != nil {
+		return err
+	}
+	return os.WriteFile(e.HostPath("/dev/"+target.device), nil, 0644)
+}
+
+// removeISCSIDevice removes what createISCSIDevice created.
+func (e *Exec) removeISCSIDevice(session int, target iscsiTarget) {
+	for _, path := range []string{
+		fmt.Sprintf("/sys/class/iscsi_session/session%d", session),
+		fmt.Sprintf("/sys/class/iscsi_host/host%d", session),
+		fmt.Sprintf("/sys/class/scsi_device/%d:0:0:%d", session, target.lun),
+		fmt.Sprintf("/sys/devices/platform/host%d", session),
+		"/sys/block/" + target.device,
+		"/dev/" + target.device,
+	} {
+		_ = os.RemoveAll(e.HostPath(path))
+	}
+}
+
+// nvme handles the nvme-cli subcommands used by pkg/util.
+func (e *Exec) nvme(args []string) ([]byte, error) {
+	if len(args) == 0 {
+		return fail(1, "usage: nvme <command> [<device>] [<args>]")
+	}
+	var nqn string
+	for i := 1; i < len(args)-1; i++ {
+		if args[i] == "-n" {
+			nqn = args[i+1]
+		}
+	}
+
+	switch args[0] {
+	case "list-subsys":
+		type path struct {
+			Name      string `json:"Name"`
+			Transport string `json:"Transport"`
+			Address   string `json:"Address"`
+			State     string `json:"State"`
+		}
+		type subsystem struct {
+			NQN   string `json:"NQN"`
+			Name  string `json:"Name"`
+			Paths []path `json:"Paths"`
+		}
+		result := struct {
+			Subsystems []subsystem `json:"Subsystems"`
+		}{Subsystems: []subsystem{}}
+		for _, nqn := range sortedKeys(e.nvmeConnected) {
+			ctrl := e.nvmeConnected[nqn]
+			result.Subsystems = append(result.Subsystems, subsystem{
+				NQN:   nqn,
+				Name:  fmt.Sprintf("nvme-subsys%d", ctrl),
+				Paths: []path{{Name: fmt.Sprintf("nvme%d", ctrl), Transport: "tcp", State: "live"}},
+			})
+		}
+		return json.Marshal(result)
+	case "list":
+		type device struct {
+			DevicePath   string `json:"DevicePath"`
+			SubsystemNQN string `json:"SubsystemNQN"`
+		}
+		result := struct {
+			Devices []device `json:"Devices"`
+		}{Devices: []device{}}
+		for _, nqn := range sortedKeys(e.nvmeConnected) {
+			result.Devices = append(result.Devices, device{
+				DevicePath:   fmt.Sprintf("/dev/nvme%dn1", e.nvmeConnected[nqn]),
+				SubsystemNQN: nqn,
+			})
+		}
+		return json.Marshal(result)
+	case "connect":
+		if !e.nvmeSubsystems[nqn] {
+			return fail(1, "Failed to write to /dev/nvme-fabrics: Input/output error")
+		}
+		if _, ok := e.nvmeConnected[nqn]; ok {
+			return fail(114, "Failed to write to /dev/nvme-fabrics: Operation already in progress\nalready connected")
+		}
+		ctrl := e.nextController
+		e.nextController++
+		if err := e.createNVMeDevice(nqn, ctrl); err != nil {
+			return fail(1, "%v", err)
+		}
+		e.nvmeConnected[nqn] = ctrl
+		return []byte(fmt.Sprintf("connecting to device: nvme%d\n", ctrl)), nil
+	case "disconnect":
+		ctrl, ok := e.nvmeConnected[nqn]
+		if !ok {
+			return []byte(fmt.Sprintf("NQN:%s disconnected 0 controller(s)\n", nqn)), nil
+		}
+		e.removeNVMeDevice(ctrl)
+		delete(e.nvmeConnected, nqn)
+		return []byte(fmt.Sprintf("NQN:%s disconnected 1 controller(s)\n", nqn)), nil
+	}
+	return nil, nil
+}
+
+// createNVMeDevice creates the sysfs entries for a connected subsystem with one namespace.
+func (e *Exec) createNVMeDevice(nqn string, ctrl int) error {
+	subsys := e.HostPath(fmt.Sprintf("/sys/class/nvme-subsystem/nvme-subsys%d", ctrl))
+	controller := e.HostPath(fmt.Sprintf("/sys/class/nvme/nvme%d", ctrl))
+	for _, dir := range []string{
+		filepath.Join(subsys, fmt.Sprintf("nvme%d/nvme%dn1", ctrl, ctrl)),
+		controller,
+		e.HostPath("/dev"),
+	} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	for path, content := range map[string]string{
+		filepath.Join(subsys, "subsysnqn"):             nqn,
+		filepath.Join(controller, "subsysnqn"):         nqn,
+		filepath.Join(controller, "transport"):         "tcp",
+		e.HostPath(fmt.Sprintf("/dev/nvme%dn1", ctrl)): "",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeNVMeDevice removes what createNVMeDevice created.
+func (e *Exec) removeNVMeDevice(ctrl int) {
+	for _, path := range []string{
+		fmt.Sprintf("/sys/class/nvme-subsystem/nvme-subsys%d", ctrl),
+		fmt.Sprintf("/sys/class/nvme/nvme%d", ctrl),
+		fmt.Sprintf("/dev/nvme%dn1", ctrl),
+	} {
+		_ = os.RemoveAll(e.HostPath(path))
+	}
+}
+
+// sortedKeys returns the keys of a map in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -5,7 +5,6 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -13,6 +12,7 @@ import (
 	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	"k8s.io/klog/v2"
 )
 
@@ -96,12 +96,24 @@ type ISCSIConnectOptions struct {
 }
 
 // ISCSIConnect connects to an iSCSI target and returns the device path.
-func ISCSIConnect(portal, iqn string, lun int) (string, error) {
-	return ISCSIConnectWithOptions(context.Background(), portal, iqn, lun, nil)
+func ISCSIConnect(exec Exec, portal, iqn string, lun int) (string, error) {
+	return ISCSIConnectWithOptions(context.Background(), exec, portal, iqn, lun, nil)
 }
 
 // ISCSIConnectWithOptions connects to an iSCSI target with configurable options.
-func ISCSIConnectWithOptions(ctx context.Context, portal, iqn string, lun int, opts *ISCSIConnectOptions) (string, error) {
+func ISCSIConnectWithOptions(ctx context.Context, exec Exec, portal, iqn string, lun int, opts *ISCSIConnectOptions) (string, error) {
+	ctx, span := startSpan(ctx, "iscsi.Connect",
+		attribute.String("iscsi.portal", portal),
+		attribute.String("iscsi.iqn", iqn),
+		attribute.Int("iscsi.lun", lun),
+	)
+	devicePath, err := iscsiConnect(ctx, exec, portal, iqn, lun, opts)
+	endSpan(span, err)
+	return devicePath, err
+}
+
+// iscsiConnect performs discovery, login and device wait for ISCSIConnectWithOptions.
+func iscsiConnect(ctx context.Context, exec Exec, portal, iqn string, lun int, opts *ISCSIConnectOptions) (string, error) {
 	start := time.Now()
 	klog.Infof("ISCSIConnect: portal=%s, iqn=%s, lun=%d", portal, iqn, lun)
 
@@ -119,7 +131,7 @@ func ISCSIConnectWithOptions(ctx context.Context, portal, iqn string, lun int, o
 	}
 
 	// Check if already logged in - skip discovery if session exists
-	sessions, err := getISCSISessions()
+	sessions, err := getISCSISessions(exec)
 	if err != nil {
 		klog.V(4).Infof("Failed to get sessions: %v, will proceed with discovery", err)
 	} else {
@@ -127,7 +139,7 @@ func ISCSIConnectWithOptions(ctx context.Context, portal, iqn string, lun int, o
 			if session.IQN == iqn {
 				klog.Infof("Session already exists for %s, skipping discovery (elapsed: %v)", iqn, time.Since(start))
 				// Session exists, just wait for device
-				devicePath, err := waitForISCSIDeviceWithContext(ctx, portal, iqn, lun, timeout)
+				devicePath, err := waitForISCSIDeviceWithContext(ctx, exec, portal, iqn, lun, timeout)
 				if err != nil {
 					return "", fmt.Errorf("device not found after %v: %w", timeout, err)
 				}
@@ -140,7 +152,7 @@ func ISCSIConnectWithOptions(ctx context.Context, portal, iqn string, lun int, o
 	// Serialized discovery with caching to prevent TrueNAS overload
 	// when multiple volumes mount simultaneously
 	discoveryStart := time.Now()
-	if err := iscsiDiscoverySerialized(ctx, portal); err != nil {
+	if err := iscsiDiscoverySerialized(ctx, exec, portal); err != nil {
 		return "", fmt.Errorf("discovery failed: %w", err)
 	}
 	klog.Infof("iSCSI discovery completed in %v", time.Since(discoveryStart))
@@ -149,7 +161,7 @@ func ISCSIConnectWithOptions(ctx context.Context, portal, iqn string, lun int, o
 	// If login fails due to target not found, retry with exponential backoff.
 	// TrueNAS may take time to propagate newly created targets to the iSCSI daemon.
 	loginStart := time.Now()
-	loginErr := iscsiLoginSerialized(ctx, portal, iqn)
+	loginErr := iscsiLoginSerialized(ctx, exec, portal, iqn)
 	if loginErr != nil && isTargetNotFoundError(loginErr) {
 		klog.Warningf("iSCSI login failed for %s (target not found in discovery), will retry with fresh discovery: %v", iqn, loginErr)
 
@@ -175,7 +187,7 @@ func ISCSIConnectWithOptions(ctx context.Context, portal, iqn string, lun int, o
 
 			// Invalidate cache and perform fresh discovery
 			invalidateDiscoveryCache(portal)
-			if discoverErr := iscsiDiscoverySerialized(ctx, portal); discoverErr != nil {
+			if discoverErr := iscsiDiscoverySerialized(ctx, exec, portal); discoverErr != nil {
 				klog.Warningf("iSCSI retry %d/%d: discovery failed for portal %s: %v", attempt, maxDiscoveryRetries, portal, discoverErr)
 				// Continue to next retry
 			} else {
@@ -183,7 +195,7 @@ func ISCSIConnectWithOptions(ctx context.Context, portal, iqn string, lun int, o
 					attempt, maxDiscoveryRetries, portal, iqn)
 
 				// Retry login
-				loginErr = iscsiLoginSerialized(ctx, portal, iqn)
+				loginErr = iscsiLoginSerialized(ctx, exec, portal, iqn)
 				if loginErr == nil {
 					klog.Infof("iSCSI login succeeded for %s after %d discovery retries (total elapsed: %v)",
 						iqn, attempt, time.Since(start))
@@ -216,7 +228,7 @@ func ISCSIConnectWithOptions(ctx context.Context, portal, iqn string, lun int, o
 
 	// Wait for device to appear
 	deviceStart := time.Now()
-	devicePath, err := waitForISCSIDeviceWithContext(ctx, portal, iqn, lun, timeout)
+	devicePath, err := waitForISCSIDeviceWithContext(ctx, exec, portal, iqn, lun, timeout)
 	if err != nil {
 		return "", fmt.Errorf("device not found after %v: %w", timeout, err)
 	}
@@ -227,12 +239,12 @@ func ISCSIConnectWithOptions(ctx context.Context, portal, iqn string, lun int, o
 }
 
 // ISCSIDisconnect disconnects from an iSCSI target.
-func ISCSIDisconnect(portal, iqn string) error {
+func ISCSIDisconnect(exec Exec, portal, iqn string) error {
 	klog.V(4).Infof("ISCSIDisconnect: portal=%s, iqn=%s", portal, iqn)
 
 	// Logout from target
-	cmd := exec.Command("iscsiadm", "-m", "node", "-T", iqn, "-p", portal, "--logout")
-	output, err := cmd.CombinedOutput()
+	ctx := context.Background()
+	output, err := exec.CombinedOutput(ctx, "iscsiadm", "-m", "node", "-T", iqn, "-p", portal, "--logout")
 	if err != nil {
 		// Check if already logged out
 		if strings.Contains(string(output), "No matching sessions") ||
@@ -244,8 +256,7 @@ func ISCSIDisconnect(portal, iqn string) error {
 	}
 
 	// Delete the node record
-	cmd = exec.Command("iscsiadm", "-m", "node", "-T", iqn, "-p", portal, "-o", "delete")
-	output, err = cmd.CombinedOutput()
+	output, err = exec.CombinedOutput(ctx, "iscsiadm", "-m", "node", "-T", iqn, "-p", portal, "-o", "delete")
 	if err != nil {
 		// Not critical if delete fails
 		klog.Warningf("Failed to delete node record: %v, output: %s", err, string(output))
@@ -257,7 +268,7 @@ func ISCSIDisconnect(portal, iqn string) error {
 // iscsiDiscoverySerialized performs iSCSI discovery with serialization and caching.
 // This prevents TrueNAS from being overwhelmed when multiple volumes try to
 // discover targets simultaneously. Discovery results are cached for 30 seconds.
-func iscsiDiscoverySerialized(ctx context.Context, portal string) error {
+func iscsiDiscoverySerialized(ctx context.Context, exec Exec, portal string) error {
 	// Check cache first (outside of mutex for fast path)
 	if lastDiscovery, ok := discoveryCache.Load(portal); ok {
 		if time.Since(lastDiscovery.(time.Time)) < discoveryValidDuration {
@@ -281,7 +292,7 @@ func iscsiDiscoverySerialized(ctx context.Context, portal string) error {
 
 	// Perform actual discovery
 	klog.Infof("Performing iSCSI discovery for portal %s (serialized)", portal)
-	if err := iscsiDiscovery(ctx, portal); err != nil {
+	if err := iscsiDiscovery(ctx, exec, portal); err != nil {
 		return err
 	}
 
@@ -291,7 +302,10 @@ func iscsiDiscoverySerialized(ctx context.Context, portal string) error {
 }
 
 // iscsiDiscovery performs iSCSI discovery on the target portal.
-func iscsiDiscovery(ctx context.Context, portal string) error {
+func iscsiDiscovery(ctx context.Context, exec Exec, portal string) (err error) {
+	ctx, span := startSpan(ctx, "iscsiadm discovery", attribute.String("iscsi.portal", portal))
+	defer func() { endSpan(span, err) }()
+
 	// Add timeout to prevent hangs on unreachable portals
 	ctx, cancel := context.WithTimeout(ctx, iscsiCommandTimeout)
 	defer cancel()
@@ -301,8 +315,7 @@ func iscsiDiscovery(ctx context.Context, portal string) error {
 	// which outputs warnings like "This command will remove the record... but a session
 	// is using it" for EACH stale session. On systems with hundreds of stale sessions,
 	// this can cause discovery to take >10 seconds and hit the timeout.
-	cmd := exec.CommandContext(ctx, "iscsiadm", "-m", "discovery", "-t", "sendtargets", "-p", portal, "-o", "new")
-	output, err := cmd.CombinedOutput()
+	output, err := exec.CombinedOutput(ctx, "iscsiadm", "-m", "discovery", "-t", "sendtargets", "-p", portal, "-o", "new")
 	if err != nil {
 		return fmt.Errorf("discovery command failed: %v, output: %s", err, string(output))
 	}
@@ -319,19 +332,25 @@ func getLoginSemaphore(portal string) chan struct{} {
 // iscsiLoginSerialized performs iSCSI login with limited concurrency.
 // Allows up to maxConcurrentLogins (2) concurrent logins per portal to prevent
 // overwhelming TrueNAS while still allowing some parallelism.
-func iscsiLoginSerialized(ctx context.Context, portal, iqn string) error {
+func iscsiLoginSerialized(ctx context.Context, exec Exec, portal, iqn string) error {
 	// Acquire semaphore slot (blocks if maxConcurrentLogins already in progress)
 	sem := getLoginSemaphore(portal)
 	sem <- struct{}{}
 	defer func() { <-sem }()
 
-	return iscsiLogin(ctx, portal, iqn)
+	return iscsiLogin(ctx, exec, portal, iqn)
 }
 
 // iscsiLogin logs into an iSCSI target.
-func iscsiLogin(ctx context.Context, portal, iqn string) error {
+func iscsiLogin(ctx context.Context, exec Exec, portal, iqn string) (err error) {
+	ctx, span := startSpan(ctx, "iscsiadm login",
+		attribute.String("iscsi.portal", portal),
+		attribute.String("iscsi.iqn", iqn),
+	)
+	defer func() { endSpan(span, err) }()
+
 	// Check if already logged in
-	sessions, err := getISCSISessions()
+	sessions, err := getISCSISessions(exec)
 	if err != nil {
 		klog.Warningf("Failed to get iSCSI sessions: %v", err)
 	} else {
@@ -348,8 +367,7 @@ func iscsiLogin(ctx context.Context, portal, iqn string) error {
 	ctx, cancel := context.WithTimeout(ctx, iscsiCommandTimeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "iscsiadm", "-m", "node", "-T", iqn, "-p", portal, "--login")
-	output, err := cmd.CombinedOutput()
+	output, err := exec.CombinedOutput(ctx, "iscsiadm", "-m", "node", "-T", iqn, "-p", portal, "--login")
 	if err != nil {
 		// Check if already logged in
 		if strings.Contains(string(output), "already present") {
@@ -363,12 +381,11 @@ func iscsiLogin(ctx context.Context, portal, iqn string) error {
 }
 
 // getISCSISessions returns the list of active iSCSI sessions.
-func getISCSISessions() ([]ISCSISession, error) {
-	cmd := exec.Command("iscsiadm", "-m", "session")
-	output, err := cmd.Output()
+func getISCSISessions(exec Exec) ([]ISCSISession, error) {
+	output, err := exec.Output(context.Background(), "iscsiadm", "-m", "session")
 	if err != nil {
-		// No sessions is not an error
-		if strings.Contains(string(output), "No active sessions") {
+		// No sessions is not an error; iscsiadm exits 21 (no objects found)
+		if exitCode(err) == 21 || strings.Contains(string(output), "No active sessions") {
 			return nil, nil
 		}
 		return nil, err
@@ -376,8 +393,8 @@ func getISCSISessions() ([]ISCSISession, error) {
 
 	var sessions []ISCSISession
 	lines := strings.Split(string(output), "\n")
-	// Format: tcp: [session_id] portal:port,target_portal_group_tag iqn
-	re := regexp.MustCompile(`^tcp:\s+\[(\d+)\]\s+([^,]+),\d+\s+(.+)$`)
+	// Format: tcp: [session_id] portal:port,target_portal_group_tag iqn (non-flash)
+	re := regexp.MustCompile(`^tcp:\s+\[(\d+)\]\s+([^,]+),\d+\s+(\S+)`)
 
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
@@ -399,7 +416,10 @@ func getISCSISessions() ([]ISCSISession, error) {
 
 // waitForISCSIDeviceWithContext waits for the iSCSI device with context support.
 // Uses exponential backoff starting at 50ms, maxing at 500ms for faster detection.
-func waitForISCSIDeviceWithContext(ctx context.Context, portal, iqn string, lun int, timeout time.Duration) (string, error) {
+func waitForISCSIDeviceWithContext(ctx context.Context, exec Exec, portal, iqn string, lun int, timeout time.Duration) (string, error) {
+	ctx, span := startSpan(ctx, "iscsi.WaitForDevice", attribute.String("iscsi.iqn", iqn), attribute.Int("iscsi.lun", lun))
+	defer span.End()
+
 	start := time.Now()
 	pollInterval := 50 * time.Millisecond
 	maxPollInterval := 500 * time.Millisecond
@@ -412,7 +432,7 @@ func waitForISCSIDeviceWithContext(ctx context.Context, portal, iqn string, lun
 		default:
 		}
 
-		devicePath, err := findISCSIDevice(iqn, lun)
+		devicePath, err := findISCSIDevice(exec, iqn, lun)
 		if err == nil && devicePath != "" {
 			return devicePath, nil
 		}
@@ -437,9 +457,9 @@ func waitForISCSIDeviceWithContext(ctx context.Context, portal, iqn string, lun
 }
 
 // findISCSIDevice finds the device path for an iSCSI LUN.
-func findISCSIDevice(iqn string, lun int) (string, error) {
+func findISCSIDevice(exec Exec, iqn string, lun int) (string, error) {
 	// Look in /sys/class/iscsi_session for the session
-	sessionDirs, err := filepath.Glob("/sys/class/iscsi_session/session*")
+	sessionDirs, err := filepath.Glob(exec.HostPath("/sys/class/iscsi_session/session*"))
 	if err != nil {
 		return "", err
 	}
@@ -460,7 +480,7 @@ func findISCSIDevice(iqn string, lun int) (string, error) {
 		// Found the session, now find the device
 		// Session directory contains device subdirectory
 		sessionName := filepath.Base(sessionDir)
-		devicePath, err := findDeviceForSession(sessionName, lun)
+		devicePath, err := findDeviceForSession(exec, sessionName, lun)
 		if err == nil && devicePath != "" {
 			return devicePath, nil
 		}
@@ -470,7 +490,7 @@ func findISCSIDevice(iqn string, lun int) (string, error) {
 }
 
 // findDeviceForSession finds the block device for a specific session and LUN.
-func findDeviceForSession(sessionName string, lun int) (string, error) {
+func findDeviceForSession(exec Exec, sessionName string, lun int) (string, error) {
 	// Extract session number
 	var sessionNum int
 	if _, err := fmt.Sscanf(sessionName, "session%d", &sessionNum); err != nil {
@@ -479,14 +499,14 @@ func findDeviceForSession(sessionName string, lun int) (string, error) {
 
 	// Look for the device in /sys/class/scsi_device
 	// Format: host:bus:target:lun
-	pattern := fmt.Sprintf("/sys/class/scsi_device/*:0:0:%d/device/block/*", lun)
+	pattern := exec.HostPath(fmt.Sprintf("/sys/class/scsi_device/*:0:0:%d/device/block/*", lun))
 	devices, err := filepath.Glob(pattern)
 	if err != nil {
 		return "", err
 	}
 
 	// Also check specific host pattern based on session
-	hostPattern := fmt.Sprintf("/sys/class/iscsi_host/host*/device/session%d", sessionNum)
+	hostPattern := exec.HostPath(fmt.Sprintf("/sys/class/iscsi_host/host*/device/session%d", sessionNum))
 	hostDirs, _ := filepath.Glob(hostPattern)
 	if len(hostDirs) > 0 {
 		// Extract host number
@@ -498,7 +518,7 @@ func findDeviceForSession(sessionName string, lun int) (string, error) {
 		}
 
 		// Look for device with this host
-		pattern = fmt.Sprintf("/sys/class/scsi_device/%d:0:0:%d/device/block/*", hostNum, lun)
+		pattern = exec.HostPath(fmt.Sprintf("/sys/class/scsi_device/%d:0:0:%d/device/block/*", hostNum, lun))
 		devices, err = filepath.Glob(pattern)
 		if err == nil && len(devices) > 0 {
 			deviceName := filepath.Base(devices[0])
@@ -510,7 +530,7 @@ func findDeviceForSession(sessionName string, lun int) (string, error) {
 	for _, device := range devices {
 		deviceName := filepath.Base(device)
 		devicePath := "/dev/" + deviceName
-		if _, err := os.Stat(devicePath); err == nil {
+		if _, err := os.Stat(exec.HostPath(devicePath)); err == nil {
 			return devicePath, nil
 		}
 	}
@@ -519,14 +539,13 @@ func findDeviceForSession(sessionName string, lun int) (string, error) {
 }
 
 // GetISCSIDevicePath returns the device path for an iSCSI target/LUN combination.
-func GetISCSIDevicePath(iqn string, lun int) (string, error) {
-	return findISCSIDevice(iqn, lun)
+func GetISCSIDevicePath(exec Exec, iqn string, lun int) (string, error) {
+	return findISCSIDevice(exec, iqn, lun)
 }
 
 // ISCSIRescanSession rescans an iSCSI session to detect new LUNs.
-func ISCSIRescanSession(portal, iqn string) error {
-	cmd := exec.Command("iscsiadm", "-m", "node", "-T", iqn, "-p", portal, "--rescan")
-	output, err := cmd.CombinedOutput()
+func ISCSIRescanSession(exec Exec, portal, iqn string) error {
+	output, err := exec.CombinedOutput(context.Background(), "iscsiadm", "-m", "node", "-T", iqn, "-p", portal, "--rescan")
 	if err != nil {
 		return fmt.Errorf("rescan failed: %v, output: %s", err, string(output))
 	}
@@ -534,9 +553,8 @@ func ISCSIRescanSession(portal, iqn string) error {
 }
 
 // ISCSIGetSessionStats returns session statistics for an iSCSI target.
-func ISCSIGetSessionStats(iqn string) (map[string]string, error) {
-	cmd := exec.Command("iscsiadm", "-m", "session", "-s")
-	output, err := cmd.Output()
+func ISCSIGetSessionStats(exec Exec, iqn string) (map[string]string, error) {
+	output, err := exec.Output(context.Background(), "iscsiadm", "-m", "session", "-s")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get session stats: %v", err)
 	}
@@ -570,10 +588,9 @@ func ISCSIGetSessionStats(iqn string) (map[string]string, error) {
 }
 
 // SetISCSINodeParam sets a parameter on an iSCSI node.
-func SetISCSINodeParam(portal, iqn, name, value string) error {
-	cmd := exec.Command("iscsiadm", "-m", "node", "-T", iqn, "-p", portal,
+func SetISCSINodeParam(exec Exec, portal, iqn, name, value string) error {
+	output, err := exec.CombinedOutput(context.Background(), "iscsiadm", "-m", "node", "-T", iqn, "-p", portal,
 		"-o", "update", "-n", name, "-v", value)
-	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("failed to set node param: %v, output: %s", err, string(output))
 	}
@@ -581,19 +598,19 @@ func SetISCSINodeParam(portal, iqn, name, value string) error {
 }
 
 // ConfigureISCSICHAP configures CHAP authentication for an iSCSI target.
-func ConfigureISCSICHAP(portal, iqn, username, password string) error {
+func ConfigureISCSICHAP(exec Exec, portal, iqn, username, password string) error {
 	// Set auth method to CHAP
-	if err := SetISCSINodeParam(portal, iqn, "node.session.auth.authmethod", "CHAP"); err != nil {
+	if err := SetISCSINodeParam(exec, portal, iqn, "node.session.auth.authmethod", "CHAP"); err != nil {
 		return err
 	}
 
 	// Set username
-	if err := SetISCSINodeParam(portal, iqn, "node.session.auth.username", username); err != nil {
+	if err := SetISCSINodeParam(exec, portal, iqn, "node.session.auth.username", username); err != nil {
 		return err
 	}
 
 	// Set password
-	if err := SetISCSINodeParam(portal, iqn, "node.session.auth.password", password); err != nil {
+	if err := SetISCSINodeParam(exec, portal, iqn, "node.session.auth.password", password); err != nil {
 		return err
 	}
 
@@ -601,16 +618,16 @@ func ConfigureISCSICHAP(portal, iqn, username, password string) error {
 }
 
 // GetDeviceWWN returns the WWN (World Wide Name) for a device.
-func GetDeviceWWN(devicePath string) (string, error) {
+func GetDeviceWWN(exec Exec, devicePath string) (string, error) {
 	// Get the device name without /dev/
 	deviceName := filepath.Base(devicePath)
 
 	// Read the WWN from sysfs
-	wwnPath := fmt.Sprintf("/sys/block/%s/device/wwid", deviceName)
+	wwnPath := exec.HostPath(fmt.Sprintf("/sys/block/%s/device/wwid", deviceName))
 	wwn, err := os.ReadFile(wwnPath)
 	if err != nil {
 		// Try alternative path
-		wwnPath = fmt.Sprintf("/sys/block/%s/device/vpd_pg83", deviceName)
+		wwnPath = exec.HostPath(fmt.Sprintf("/sys/block/%s/device/vpd_pg83", deviceName))
 		wwn, err = os.ReadFile(wwnPath)
 		if err != nil {
 			return "", fmt.Errorf("failed to read WWN: %v", err)
@@ -621,9 +638,9 @@ func GetDeviceWWN(devicePath string) (string, error) {
 }
 
 // GetDeviceSize returns the size of a block device in bytes.
-func GetDeviceSize(devicePath string) (int64, error) {
+func GetDeviceSize(exec Exec, devicePath string) (int64, error) {
 	deviceName := filepath.Base(devicePath)
-	sizePath := fmt.Sprintf("/sys/block/%s/size", deviceName)
+	sizePath := exec.HostPath(fmt.Sprintf("/sys/block/%s/size", deviceName))
 
 	sizeBytes, err := os.ReadFile(sizePath)
 	if err != nil {
@@ -640,9 +657,8 @@ func GetDeviceSize(devicePath string) (int64, error) {
 }
 
 // FlushDeviceBuffers flushes buffers for a block device.
-func FlushDeviceBuffers(devicePath string) error {
-	cmd := exec.Command("blockdev", "--flushbufs", devicePath)
-	output, err := cmd.CombinedOutput()
+func FlushDeviceBuffers(exec Exec, devicePath string) error {
+	output, err := exec.CombinedOutput(context.Background(), "blockdev", "--flushbufs", devicePath)
 	if err != nil {
 		return fmt.Errorf("failed to flush buffers: %v, output: %s", err, string(output))
 	}
@@ -654,8 +670,8 @@ func FlushDeviceBuffers(devicePath string) error {
 // If portal is non-empty, only sessions matching that portal are cleaned up.
 // This should be called periodically or after volume cleanup to prevent
 // session accumulation that can slow down discovery operations.
-func CleanupStaleISCSISessions(portal string) error {
-	sessions, err := getISCSISessions()
+func CleanupStaleISCSISessions(exec Exec, portal string) error {
+	sessions, err := getISCSISessions(exec)
 	if err != nil {
 		return fmt.Errorf("failed to get sessions: %w", err)
 	}
@@ -668,7 +684,7 @@ func CleanupStaleISCSISessions(portal string) error {
 		}
 
 		// Check if this session has any active devices
-		sessionDirs, err := filepath.Glob("/sys/class/iscsi_session/session*")
+		sessionDirs, err := filepath.Glob(exec.HostPath("/sys/class/iscsi_session/session*"))
 		if err != nil {
 			continue
 		}
@@ -688,14 +704,14 @@ func CleanupStaleISCSISessions(portal string) error {
 				if _, err := fmt.Sscanf(sessionName, "session%d", &sessionNum); err != nil {
 					continue
 				}
-				hostPattern := fmt.Sprintf("/sys/class/iscsi_host/host*/device/session%d", sessionNum)
+				hostPattern := exec.HostPath(fmt.Sprintf("/sys/class/iscsi_host/host*/device/session%d", sessionNum))
 				hostDirs, _ := filepath.Glob(hostPattern)
 				if len(hostDirs) > 0 {
 					hostDir := filepath.Dir(filepath.Dir(hostDirs[0]))
 					hostName := filepath.Base(hostDir)
 					var hostNum int
 					if _, err := fmt.Sscanf(hostName, "host%d", &hostNum); err == nil {
-						blockPattern := fmt.Sprintf("/sys/class/scsi_device/%d:0:0:*/device/block/*", hostNum)
+						blockPattern := exec.HostPath(fmt.Sprintf("/sys/class/scsi_device/%d:0:0:*/device/block/*", hostNum))
 						blocks, _ := filepath.Glob(blockPattern)
 						if len(blocks) > 0 {
 							hasDevice = true
@@ -709,7 +725,7 @@ func CleanupStaleISCSISessions(portal string) error {
 		if !hasDevice {
 			klog.V(4).Infof("Cleaning up stale iSCSI session for %s (no devices found)", session.IQN)
 			// Logout and delete node record
-			if err := ISCSIDisconnect(session.TargetPortal, session.IQN); err != nil {
+			if err := ISCSIDisconnect(exec, session.TargetPortal, session.IQN); err != nil {
 				klog.Warningf("Failed to disconnect stale session %s: %v", session.IQN, err)
 			} else {
 				cleanedCount++
@@ -725,10 +741,9 @@ func CleanupStaleISCSISessions(portal string) error {
 
 // CleanupOrphanedNodeRecords removes iSCSI node records that don't have active sessions.
 // This helps keep the iscsiadm database clean and speeds up discovery.
-func CleanupOrphanedNodeRecords(portal string) error {
+func CleanupOrphanedNodeRecords(exec Exec, portal string) error {
 	// List all node records for the portal
-	cmd := exec.Command("iscsiadm", "-m", "node", "-P", "1")
-	output, err := cmd.Output()
+	output, err := exec.Output(context.Background(), "iscsiadm", "-m", "node", "-P", "1")
 	if err != nil {
 		// No records is not an error
 		if strings.Contains(string(output), "No records found") {
@@ -738,7 +753,7 @@ func CleanupOrphanedNodeRecords(portal string) error {
 	}
 
 	// Get active sessions
-	sessions, err := getISCSISessions()
+	sessions, err := getISCSISessions(exec)
 	if err != nil {
 		return fmt.Errorf("failed to get sessions: %w", err)
 	}
@@ -767,8 +782,7 @@ func CleanupOrphanedNodeRecords(portal string) error {
 		if currentTarget != "" && currentPortal != "" && strings.Contains(currentPortal, portal) {
 			if !activeIQNs[currentTarget] {
 				klog.V(4).Infof("Deleting orphaned node record for %s at %s", currentTarget, currentPortal)
-				deleteCmd := exec.Command("iscsiadm", "-m", "node", "-T", currentTarget, "-p", currentPortal, "-o", "delete")
-				if output, err := deleteCmd.CombinedOutput(); err != nil {
+				if output, err := exec.CombinedOutput(context.Background(), "iscsiadm", "-m", "node", "-T", currentTarget, "-p", currentPortal, "-o", "delete"); err != nil {
 					klog.V(4).Infof("Failed to delete orphaned node record: %v, output: %s", err, string(output))
 				} else {
 					orphanedCount++
@@ -786,12 +800,12 @@ func CleanupOrphanedNodeRecords(portal string) error {
 }
 
 // GetISCSIInfoFromDevice returns the portal and IQN for a given device path.
-func GetISCSIInfoFromDevice(devicePath string) (string, string, error) {
+func GetISCSIInfoFromDevice(exec Exec, devicePath string) (string, string, error) {
 	deviceName := filepath.Base(devicePath)
 
 	// Find session directory in sysfs
 	// /sys/block/sdX/device points to the scsi device
-	sysPath := filepath.Join("/sys/block", deviceName, "device")
+	sysPath := exec.HostPath(filepath.Join("/sys/block", deviceName, "device"))
 	targetPath, err := filepath.EvalSymlinks(sysPath)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to resolve sysfs path: %v", err)
@@ -820,7 +834,7 @@ func GetISCSIInfoFromDevice(devicePath string) (string, string, error) {
 	iqn := ""
 	// Optimization (PERF-005): Construct path directly using session name instead of walking
 	sessionName := filepath.Base(sessionDir)
-	targetNamePath := filepath.Join("/sys/class/iscsi_session", sessionName, "targetname")
+	targetNamePath := exec.HostPath(filepath.Join("/sys/class/iscsi_session", sessionName, "targetname"))
 	content, err := os.ReadFile(targetNamePath)
 	if err == nil {
 		iqn = strings.TrimSpace(string(content))
@@ -842,7 +856,7 @@ func GetISCSIInfoFromDevice(devicePath string) (string, string, error) {
 	}
 
 	// Get Portal using iscsiadm
-	sessions, err := getISCSISessions()
+	sessions, err := getISCSISessions(exec)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to get sessions: %v", err)
 	}
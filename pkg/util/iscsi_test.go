@@ -0,0 +1,57 @@
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/util/fakeexec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetISCSISessions(t *testing.T) {
+	fake := fakeexec.New(t.TempDir())
+
+	// iscsiadm exits 21 when there are no sessions
+	sessions, err := getISCSISessions(fake)
+	assert.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	// Session lines end with the iface flash type, which isn't part of the IQN
+	fake.AddISCSITarget("10.0.0.1:3260", "iqn.2005-10.org.freenas.ctl:vol-1", 0, "sdb")
+	_, err = fake.CombinedOutput(context.Background(), "iscsiadm", "-m", "node", "-T", "iqn.2005-10.org.freenas.ctl:vol-1", "-p", "10.0.0.1:3260", "--login")
+	assert.NoError(t, err)
+	sessions, err = getISCSISessions(fake)
+	assert.NoError(t, err)
+	if assert.Len(t, sessions, 1) {
+		assert.Equal(t, "iqn.2005-10.org.freenas.ctl:vol-1", sessions[0].IQN)
+		assert.Equal(t, "10.0.0.1:3260", sessions[0].TargetPortal)
+	}
+
+	portal, iqn, err := GetISCSIInfoFromDevice(fake, "/dev/sdb")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1:3260", portal)
+	assert.Equal(t, "iqn.2005-10.org.freenas.ctl:vol-1", iqn)
+}
+
+// BUG-013: iscsiadm discovery and login against an unreachable portal must time out
+// instead of hanging the node plugin.
+func TestISCSIConnectUnreachablePortal(t *testing.T) {
+	fake := fakeexec.New(t.TempDir())
+	var bounded []string
+	fake.Handle("iscsiadm", func(ctx context.Context, args []string) ([]byte, error) {
+		if args[1] == "session" {
+			return []byte("iscsiadm: No active sessions."), &fakeexec.ExitError{Code: 21}
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= iscsiCommandTimeout {
+			bounded = append(bounded, args[1])
+		}
+		return []byte("iscsiadm: cannot make connection to 10.0.0.99: No route to host"), &fakeexec.ExitError{Code: 4}
+	})
+
+	_, err := ISCSIConnectWithOptions(context.Background(), fake, "10.0.0.99:3260", "iqn.2005-10.org.freenas.ctl:vol-1", 0,
+		&ISCSIConnectOptions{DeviceTimeout: time.Second})
+	assert.Error(t, err)
+	assert.Contains(t, bounded, "discovery")
+	assert.NotContains(t, fake.Commands(), "iscsiadm -m node -T iqn.2005-10.org.freenas.ctl:vol-1 -p 10.0.0.99:3260 --login")
+}
@@ -2,9 +2,9 @@
 package util
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"strings"
 	"syscall"
 
@@ -21,19 +21,54 @@ type FilesystemStats struct {
 	UsedInodes      int64
 }
 
-// IsMounted checks if a path is currently mounted.
-func IsMounted(path string) (bool, error) {
+// Mounter mounts, formats and resizes volumes on the node.
+type Mounter interface {
+	// IsMounted checks if a path is currently mounted.
+	IsMounted(path string) (bool, error)
+
+	// Mount mounts a source to a target with the given filesystem type and options.
+	Mount(source, target, fsType string, options []string) error
+
+	// MountNFS mounts an NFS share.
+	MountNFS(source, target string, options []string) error
+
+	// BindMount creates a bind mount.
+	BindMount(source, target string, options []string) error
+
+	// Unmount unmounts a target path. It is not an error if the path isn't mounted.
+	Unmount(target string) error
+
+	// FormatAndMount formats a device if it has no filesystem and mounts it.
+	FormatAndMount(devicePath, target, fsType string, options []string) error
+
+	// ResizeFilesystem resizes the filesystem on a mounted path to fill its device.
+	ResizeFilesystem(mountPath string) error
+
+	// GetDeviceFromMountPoint returns the device path for a mount point.
+	GetDeviceFromMountPoint(mountPath string) (string, error)
+}
+
+// NewMounter returns a Mounter that runs mount, findmnt, mkfs and friends through exec.
+func NewMounter(exec Exec) Mounter {
+	return &mounter{exec: exec}
+}
+
+// mounter implements Mounter with the util-linux and filesystem tools.
+type mounter struct {
+	exec Exec
+}
+
+func (m *mounter) IsMounted(path string) (bool, error) {
 	// Check if path exists
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return false, nil
 	}
 
 	// Use findmnt to check mount status
-	cmd := exec.Command("findmnt", "--mountpoint", path, "--noheadings")
-	output, err := cmd.Output()
+	output, err := m.exec.Output(context.Background(), "findmnt", "--mountpoint", path, "--noheadings")
 	if err != nil {
 		// Exit code 1 means not mounted
-		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		if exitCode(err) == 1 {
 			return false, nil
 		}
 		return false, err
@@ -42,8 +77,7 @@ func IsMounted(path string) (bool, error) {
 	return len(strings.TrimSpace(string(output))) > 0, nil
 }
 
-// Mount mounts a source to a target with the given filesystem type and options.
-func Mount(source, target, fsType string, options []string) error {
+func (m *mounter) Mount(source, target, fsType string, options []string) error {
 	klog.V(4).Infof("Mounting %s to %s (fsType=%s, options=%v)", source, target, fsType, options)
 
 	args := []string{}
@@ -55,8 +89,7 @@ func Mount(source, target, fsType string, options []string) error {
 	}
 	args = append(args, source, target)
 
-	cmd := exec.Command("mount", args...)
-	output, err := cmd.CombinedOutput()
+	output, err := m.exec.CombinedOutput(context.Background(), "mount", args...)
 	if err != nil {
 		return fmt.Errorf("mount failed: %v, output: %s", err, string(output))
 	}
@@ -64,17 +97,15 @@ func Mount(source, target, fsType string, options []string) error {
 	return nil
 }
 
-// MountNFS mounts an NFS share.
-func MountNFS(source, target string, options []string) error {
+func (m *mounter) MountNFS(source, target string, options []string) error {
 	// Add NFS-specific default options
 	nfsOptions := []string{"nfsvers=4"}
 	nfsOptions = append(nfsOptions, options...)
 
-	return Mount(source, target, "nfs", nfsOptions)
+	return m.Mount(source, target, "nfs", nfsOptions)
 }
 
-// BindMount creates a bind mount.
-func BindMount(source, target string, options []string) error {
+func (m *mounter) BindMount(source, target string, options []string) error {
 	klog.V(4).Infof("Bind mounting %s to %s", source, target)
 
 	args := []string{"--bind"}
@@ -83,8 +114,7 @@ func BindMount(source, target string, options []string) error {
 	}
 	args = append(args, source, target)
 
-	cmd := exec.Command("mount", args...)
-	output, err := cmd.CombinedOutput()
+	output, err := m.exec.CombinedOutput(context.Background(), "mount", args...)
 	if err != nil {
 		return fmt.Errorf("bind mount failed: %v, output: %s", err, string(output))
 	}
@@ -92,12 +122,11 @@ func BindMount(source, target string, options []string) error {
 	return nil
 }
 
-// Unmount unmounts a target path.
-func Unmount(target string) error {
+func (m *mounter) Unmount(target string) error {
 	klog.V(4).Infof("Unmounting %s", target)
 
 	// Check if mounted
-	mounted, err := IsMounted(target)
+	mounted, err := m.IsMounted(target)
 	if err != nil {
 		return err
 	}
@@ -105,11 +134,10 @@ func Unmount(target string) error {
 		return nil
 	}
 
-	cmd := exec.Command("umount", target)
-	if _, err := cmd.CombinedOutput(); err != nil {
+	ctx := context.Background()
+	if _, err := m.exec.CombinedOutput(ctx, "umount", target); err != nil {
 		// Try lazy unmount
-		cmd = exec.Command("umount", "-l", target)
-		if output, err := cmd.CombinedOutput(); err != nil {
+		if output, err := m.exec.CombinedOutput(ctx, "umount", "-l", target); err != nil {
 			return fmt.Errorf("unmount failed: %v, output: %s", err, string(output))
 		}
 	}
@@ -117,19 +145,18 @@ func Unmount(target string) error {
 	return nil
 }
 
-// FormatAndMount formats a device and mounts it.
-func FormatAndMount(devicePath, target, fsType string, options []string) error {
+func (m *mounter) FormatAndMount(devicePath, target, fsType string, options []string) error {
 	klog.V(4).Infof("FormatAndMount: device=%s, target=%s, fsType=%s", devicePath, target, fsType)
 
 	// Check if already formatted
-	existingFS, err := GetFilesystemType(devicePath)
+	existingFS, err := m.getFilesystemType(devicePath)
 	if err != nil {
 		klog.Warningf("Failed to get filesystem type: %v", err)
 	}
 
 	if existingFS == "" {
 		// Format the device
-		if err := FormatDevice(devicePath, fsType); err != nil {
+		if err := m.formatDevice(devicePath, fsType); err != nil {
 			return err
 		}
 	} else if existingFS != fsType {
@@ -137,28 +164,24 @@ func FormatAndMount(devicePath, target, fsType string, options []string) error {
 	}
 
 	// Mount the device
-	return Mount(devicePath, target, fsType, options)
+	return m.Mount(devicePath, target, fsType, options)
 }
 
-// FormatDevice formats a block device with the given filesystem type.
-func FormatDevice(devicePath, fsType string) error {
+// formatDevice formats a block device with the given filesystem type.
+func (m *mounter) formatDevice(devicePath, fsType string) error {
 	klog.Infof("Formatting device %s with %s", devicePath, fsType)
 
-	var cmd *exec.Cmd
+	var args []string
 	switch fsType {
-	case "ext4":
-		cmd = exec.Command("mkfs.ext4", "-F", devicePath)
-	case "ext3":
-		cmd = exec.Command("mkfs.ext3", "-F", devicePath)
-	case "xfs":
-		cmd = exec.Command("mkfs.xfs", "-f", devicePath)
-	case "btrfs":
-		cmd = exec.Command("mkfs.btrfs", "-f", devicePath)
+	case "ext4", "ext3":
+		args = []string{"mkfs." + fsType, "-F", devicePath}
+	case "xfs", "btrfs":
+		args = []string{"mkfs." + fsType, "-f", devicePath}
 	default:
 		return fmt.Errorf("unsupported filesystem type: %s", fsType)
 	}
 
-	output, err := cmd.CombinedOutput()
+	output, err := m.exec.CombinedOutput(context.Background(), args[0], args[1:]...)
 	if err != nil {
 		return fmt.Errorf("format failed: %v, output: %s", err, string(output))
 	}
@@ -166,10 +189,9 @@ func FormatDevice(devicePath, fsType string) error {
 	return nil
 }
 
-// GetFilesystemType returns the filesystem type of a device.
-func GetFilesystemType(devicePath string) (string, error) {
-	cmd := exec.Command("blkid", "-o", "value", "-s", "TYPE", devicePath)
-	output, err := cmd.Output()
+// getFilesystemType returns the filesystem type of a device.
+func (m *mounter) getFilesystemType(devicePath string) (string, error) {
+	output, err := m.exec.Output(context.Background(), "blkid", "-o", "value", "-s", "TYPE", devicePath)
 	if err != nil {
 		// Device may not be formatted yet
 		return "", nil
@@ -196,36 +218,35 @@ func GetFilesystemStats(path string) (*FilesystemStats, error) {
 	}, nil
 }
 
-// ResizeFilesystem resizes the filesystem on a mounted path.
-func ResizeFilesystem(mountPath string) error {
+func (m *mounter) ResizeFilesystem(mountPath string) error {
 	klog.Infof("Resizing filesystem at %s", mountPath)
 
 	// Get the device path
-	devicePath, err := GetDeviceFromMountPoint(mountPath)
+	devicePath, err := m.GetDeviceFromMountPoint(mountPath)
 	if err != nil {
 		return fmt.Errorf("failed to get device from mount point: %v", err)
 	}
 
 	// Get filesystem type
-	fsType, err := GetFilesystemType(devicePath)
+	fsType, err := m.getFilesystemType(devicePath)
 	if err != nil {
 		return err
 	}
 
 	// Resize based on filesystem type
-	var cmd *exec.Cmd
+	var args []string
 	switch fsType {
 	case "ext4", "ext3", "ext2":
-		cmd = exec.Command("resize2fs", devicePath)
+		args = []string{"resize2fs", devicePath}
 	case "xfs":
-		cmd = exec.Command("xfs_growfs", mountPath)
+		args = []string{"xfs_growfs", mountPath}
 	case "btrfs":
-		cmd = exec.Command("btrfs", "filesystem", "resize", "max", mountPath)
+		args = []string{"btrfs", "filesystem", "resize", "max", mountPath}
 	default:
 		return fmt.Errorf("resize not supported for filesystem type: %s", fsType)
 	}
 
-	output, err := cmd.CombinedOutput()
+	output, err := m.exec.CombinedOutput(context.Background(), args[0], args[1:]...)
 	if err != nil {
 		return fmt.Errorf("resize failed: %v, output: %s", err, string(output))
 	}
@@ -233,10 +254,8 @@ func ResizeFilesystem(mountPath string) error {
 	return nil
 }
 
-// GetDeviceFromMountPoint returns the device path for a mount point.
-func GetDeviceFromMountPoint(mountPath string) (string, error) {
-	cmd := exec.Command("findmnt", "-n", "-o", "SOURCE", mountPath)
-	output, err := cmd.Output()
+func (m *mounter) GetDeviceFromMountPoint(mountPath string) (string, error) {
+	output, err := m.exec.Output(context.Background(), "findmnt", "-n", "-o", "SOURCE", mountPath)
 	if err != nil {
 		return "", fmt.Errorf("failed to find device: %v", err)
 	}
@@ -2,15 +2,17 @@
 package util
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	"k8s.io/klog/v2"
 )
 
@@ -50,13 +52,24 @@ type NVMeoFConnectOptions struct {
 }
 
 // NVMeoFConnect connects to an NVMe-oF target and returns the device path.
-func NVMeoFConnect(nqn, transportURI string) (string, error) {
-	return NVMeoFConnectWithOptions(nqn, transportURI, nil)
+func NVMeoFConnect(exec Exec, nqn, transportURI string) (string, error) {
+	return NVMeoFConnectWithOptions(context.Background(), exec, nqn, transportURI, nil)
 }
 
 // NVMeoFConnectWithOptions connects to an NVMe-oF target with configurable options.
 // (OTHER-001 fix: make NVMe-oF timeout configurable like iSCSI)
-func NVMeoFConnectWithOptions(nqn, transportURI string, opts *NVMeoFConnectOptions) (string, error) {
+func NVMeoFConnectWithOptions(ctx context.Context, exec Exec, nqn, transportURI string, opts *NVMeoFConnectOptions) (string, error) {
+	ctx, span := startSpan(ctx, "nvme.Connect",
+		attribute.String("nvme.nqn", nqn),
+		attribute.String("nvme.transport_uri", transportURI),
+	)
+	devicePath, err := nvmeoFConnect(ctx, exec, nqn, transportURI, opts)
+	endSpan(span, err)
+	return devicePath, err
+}
+
+// nvmeoFConnect performs the connect and device wait for NVMeoFConnectWithOptions.
+func nvmeoFConnect(ctx context.Context, exec Exec, nqn, transportURI string, opts *NVMeoFConnectOptions) (string, error) {
 	klog.V(4).Infof("NVMeoFConnect: nqn=%s, transportURI=%s", nqn, transportURI)
 
 	// Apply defaults (OTHER-001 fix)
@@ -73,12 +86,14 @@ func NVMeoFConnectWithOptions(nqn, transportURI string, opts *NVMeoFConnectOptio
 	}
 
 	// Connect to the subsystem
-	if err := nvmeConnect(transport, host, port, nqn); err != nil {
+	if err := nvmeConnect(ctx, exec, transport, host, port, nqn); err != nil {
 		return "", fmt.Errorf("connect failed: %w", err)
 	}
 
 	// Wait for device to appear with configurable timeout (OTHER-001 fix)
-	devicePath, err := waitForNVMeDevice(nqn, timeout)
+	_, waitSpan := startSpan(ctx, "nvme.WaitForDevice", attribute.String("nvme.nqn", nqn))
+	devicePath, err := waitForNVMeDevice(exec, nqn, timeout)
+	endSpan(waitSpan, err)
 	if err != nil {
 		return "", fmt.Errorf("device not found: %w", err)
 	}
@@ -87,11 +102,10 @@ func NVMeoFConnectWithOptions(nqn, transportURI string, opts *NVMeoFConnectOptio
 }
 
 // NVMeoFDisconnect disconnects from an NVMe-oF target.
-func NVMeoFDisconnect(nqn string) error {
+func NVMeoFDisconnect(exec Exec, nqn string) error {
 	klog.V(4).Infof("NVMeoFDisconnect: nqn=%s", nqn)
 
-	cmd := exec.Command("nvme", "disconnect", "-n", nqn)
-	output, err := cmd.CombinedOutput()
+	output, err := exec.CombinedOutput(context.Background(), "nvme", "disconnect", "-n", nqn)
 	if err != nil {
 		// Check if already disconnected
 		if strings.Contains(string(output), "not found") ||
@@ -131,9 +145,12 @@ func parseTransportURI(transportURI string) (transport, host, port string, err e
 }
 
 // nvmeConnect connects to an NVMe-oF subsystem.
-func nvmeConnect(transport, host, port, nqn string) error {
+func nvmeConnect(ctx context.Context, exec Exec, transport, host, port, nqn string) (err error) {
+	ctx, span := startSpan(ctx, "nvme connect", attribute.String("nvme.nqn", nqn), attribute.String("nvme.transport", transport))
+	defer func() { endSpan(span, err) }()
+
 	// Check if already connected
-	subsystems, err := listNVMeSubsystems()
+	subsystems, err := listNVMeSubsystems(exec)
 	if err != nil {
 		klog.Warningf("Failed to list NVMe subsystems: %v", err)
 	} else {
@@ -154,8 +171,7 @@ func nvmeConnect(transport, host, port, nqn string) error {
 		"-s", port,
 	}
 
-	cmd := exec.Command("nvme", args...)
-	output, err := cmd.CombinedOutput()
+	output, err := exec.CombinedOutput(ctx, "nvme", args...)
 	if err != nil {
 		// Check if already connected
 		if strings.Contains(string(output), "already connected") {
@@ -170,9 +186,8 @@ func nvmeConnect(transport, host, port, nqn string) error {
 }
 
 // listNVMeSubsystems returns the list of connected NVMe subsystems.
-func listNVMeSubsystems() ([]NVMeSubsystem, error) {
-	cmd := exec.Command("nvme", "list-subsys", "-o", "json")
-	output, err := cmd.Output()
+func listNVMeSubsystems(exec Exec) ([]NVMeSubsystem, error) {
+	output, err := exec.Output(context.Background(), "nvme", "list-subsys", "-o", "json")
 	if err != nil {
 		return nil, fmt.Errorf("list-subsys failed: %v", err)
 	}
@@ -196,7 +211,7 @@ func listNVMeSubsystems() ([]NVMeSubsystem, error) {
 // waitForNVMeDevice waits for the NVMe device to appear.
 // Uses exponential backoff starting at 50ms, maxing at 500ms for faster detection.
 // (OTHER-003 fix: check timeout before waiting, use exponential backoff like iSCSI)
-func waitForNVMeDevice(nqn string, timeout time.Duration) (string, error) {
+func waitForNVMeDevice(exec Exec, nqn string, timeout time.Duration) (string, error) {
 	start := time.Now()
 	pollInterval := 50 * time.Millisecond
 	maxPollInterval := 500 * time.Millisecond
@@ -207,7 +222,7 @@ func waitForNVMeDevice(nqn string, timeout time.Duration) (string, error) {
 			return "", fmt.Errorf("timeout waiting for device (nqn=%s)", nqn)
 		}
 
-		devicePath, err := findNVMeDevice(nqn)
+		devicePath, err := findNVMeDevice(exec, nqn)
 		if err == nil && devicePath != "" {
 			return devicePath, nil
 		}
@@ -223,9 +238,9 @@ func waitForNVMeDevice(nqn string, timeout time.Duration) (string, error) {
 
 // findNVMeDevice finds the device path for an NVMe subsystem.
 // Variable for testability.
-var findNVMeDevice = func(nqn string) (string, error) {
+var findNVMeDevice = func(exec Exec, nqn string) (string, error) {
 	// Look in /sys/class/nvme-subsystem
-	subsysDirs, err := filepath.Glob("/sys/class/nvme-subsystem/nvme-subsys*")
+	subsysDirs, err := filepath.Glob(exec.HostPath("/sys/class/nvme-subsystem/nvme-subsys*"))
 	if err != nil {
 		return "", err
 	}
@@ -254,20 +269,19 @@ var findNVMeDevice = func(nqn string) (string, error) {
 			// Get the device name
 			deviceName := filepath.Base(nvmeDevices[0])
 			devicePath := "/dev/" + deviceName
-			if _, err := os.Stat(devicePath); err == nil {
+			if _, err := os.Stat(exec.HostPath(devicePath)); err == nil {
 				return devicePath, nil
 			}
 		}
 	}
 
 	// Alternative: use nvme list
-	return findNVMeDeviceFromList(nqn)
+	return findNVMeDeviceFromList(exec, nqn)
 }
 
 // findNVMeDeviceFromList finds NVMe device using nvme list command.
-func findNVMeDeviceFromList(nqn string) (string, error) {
-	cmd := exec.Command("nvme", "list", "-o", "json")
-	output, err := cmd.Output()
+func findNVMeDeviceFromList(exec Exec, nqn string) (string, error) {
+	output, err := exec.Output(context.Background(), "nvme", "list", "-o", "json")
 	if err != nil {
 		return "", fmt.Errorf("nvme list failed: %v", err)
 	}
@@ -292,14 +306,13 @@ func findNVMeDeviceFromList(nqn string) (string, error) {
 }
 
 // GetNVMeDevicePath returns the device path for an NVMe subsystem NQN.
-func GetNVMeDevicePath(nqn string) (string, error) {
-	return findNVMeDevice(nqn)
+func GetNVMeDevicePath(exec Exec, nqn string) (string, error) {
+	return findNVMeDevice(exec, nqn)
 }
 
 // NVMeRescan rescans for new NVMe namespaces.
-func NVMeRescan() error {
-	cmd := exec.Command("nvme", "ns-rescan", "/dev/nvme0")
-	output, err := cmd.CombinedOutput()
+func NVMeRescan(exec Exec) error {
+	output, err := exec.CombinedOutput(context.Background(), "nvme", "ns-rescan", "/dev/nvme0")
 	if err != nil {
 		klog.Warningf("NVMe rescan failed: %v, output: %s", err, string(output))
 		// Not critical, continue
@@ -308,9 +321,8 @@ func NVMeRescan() error {
 }
 
 // NVMeGetNamespaceInfo returns information about an NVMe namespace.
-func NVMeGetNamespaceInfo(devicePath string) (*NVMeNamespace, error) {
-	cmd := exec.Command("nvme", "id-ns", devicePath, "-o", "json")
-	output, err := cmd.Output()
+func NVMeGetNamespaceInfo(exec Exec, devicePath string) (*NVMeNamespace, error) {
+	output, err := exec.Output(context.Background(), "nvme", "id-ns", devicePath, "-o", "json")
 	if err != nil {
 		return nil, fmt.Errorf("id-ns failed: %v", err)
 	}
@@ -324,8 +336,8 @@ func NVMeGetNamespaceInfo(devicePath string) (*NVMeNamespace, error) {
 }
 
 // NVMeGetSubsystemInfo returns information about an NVMe subsystem.
-func NVMeGetSubsystemInfo(nqn string) (*NVMeSubsystem, error) {
-	subsystems, err := listNVMeSubsystems()
+func NVMeGetSubsystemInfo(exec Exec, nqn string) (*NVMeSubsystem, error) {
+	subsystems, err := listNVMeSubsystems(exec)
 	if err != nil {
 		return nil, err
 	}
@@ -340,19 +352,14 @@ func NVMeGetSubsystemInfo(nqn string) (*NVMeSubsystem, error) {
 }
 
 // NVMeListNamespaces lists all namespaces for a device.
-func NVMeListNamespaces(devicePath string) ([]int, error) {
+func NVMeListNamespaces(exec Exec, devicePath string) ([]int, error) {
 	// Remove namespace suffix if present (e.g., /dev/nvme0n1 -> /dev/nvme0)
 	ctrlPath := devicePath
-	if strings.Contains(filepath.Base(devicePath), "n") {
-		// Extract controller path
-		parts := strings.Split(filepath.Base(devicePath), "n")
-		if len(parts) >= 2 {
-			ctrlPath = filepath.Join(filepath.Dir(devicePath), parts[0])
-		}
+	if ctrlName, ok := nvmeControllerName(filepath.Base(devicePath)); ok {
+		ctrlPath = filepath.Join(filepath.Dir(devicePath), ctrlName)
 	}
 
-	cmd := exec.Command("nvme", "list-ns", ctrlPath, "-o", "json")
-	output, err := cmd.Output()
+	output, err := exec.Output(context.Background(), "nvme", "list-ns", ctrlPath, "-o", "json")
 	if err != nil {
 		return nil, fmt.Errorf("list-ns failed: %v", err)
 	}
@@ -373,9 +380,8 @@ func NVMeListNamespaces(devicePath string) ([]int, error) {
 }
 
 // NVMeFlush flushes data to the NVMe device.
-func NVMeFlush(devicePath string, nsid int) error {
-	cmd := exec.Command("nvme", "flush", devicePath, "-n", fmt.Sprintf("%d", nsid))
-	output, err := cmd.CombinedOutput()
+func NVMeFlush(exec Exec, devicePath string, nsid int) error {
+	output, err := exec.CombinedOutput(context.Background(), "nvme", "flush", devicePath, "-n", fmt.Sprintf("%d", nsid))
 	if err != nil {
 		return fmt.Errorf("flush failed: %v, output: %s", err, string(output))
 	}
@@ -383,19 +389,17 @@ func NVMeFlush(devicePath string, nsid int) error {
 }
 
 // IsNVMeFabric checks if a device is an NVMe-oF (fabric) device.
-func IsNVMeFabric(devicePath string) (bool, error) {
+func IsNVMeFabric(exec Exec, devicePath string) (bool, error) {
 	// Get the device name without /dev/
 	deviceName := filepath.Base(devicePath)
 
 	// Check if transport is fabrics
-	transportPath := fmt.Sprintf("/sys/block/%s/device/transport", deviceName)
+	transportPath := exec.HostPath(fmt.Sprintf("/sys/block/%s/device/transport", deviceName))
 	transport, err := os.ReadFile(transportPath)
 	if err != nil {
 		// Try alternative path
-		parts := strings.Split(deviceName, "n")
-		if len(parts) >= 2 {
-			ctrlName := parts[0]
-			transportPath = fmt.Sprintf("/sys/class/nvme/%s/transport", ctrlName)
+		if ctrlName, ok := nvmeControllerName(deviceName); ok {
+			transportPath = exec.HostPath(fmt.Sprintf("/sys/class/nvme/%s/transport", ctrlName))
 			transport, err = os.ReadFile(transportPath)
 			if err != nil {
 				return false, fmt.Errorf("failed to read transport: %v", err)
@@ -411,7 +415,7 @@ func IsNVMeFabric(devicePath string) (bool, error) {
 }
 
 // NVMeDiscovery performs NVMe-oF discovery.
-func NVMeDiscovery(transport, host, port string) ([]string, error) {
+func NVMeDiscovery(exec Exec, transport, host, port string) ([]string, error) {
 	args := []string{
 		"discover",
 		"-t", transport,
@@ -420,8 +424,7 @@ func NVMeDiscovery(transport, host, port string) ([]string, error) {
 		"-o", "json",
 	}
 
-	cmd := exec.Command("nvme", args...)
-	output, err := cmd.Output()
+	output, err := exec.Output(context.Background(), "nvme", args...)
 	if err != nil {
 		return nil, fmt.Errorf("discovery failed: %v", err)
 	}
@@ -444,7 +447,7 @@ func NVMeDiscovery(transport, host, port string) ([]string, error) {
 }
 
 // GetNVMeInfoFromDevice returns the NQN for a given device path.
-func GetNVMeInfoFromDevice(devicePath string) (string, error) {
+func GetNVMeInfoFromDevice(exec Exec, devicePath string) (string, error) {
 	deviceName := filepath.Base(devicePath)
 
 	// Check if it's an NVMe device
@@ -454,15 +457,14 @@ func GetNVMeInfoFromDevice(devicePath string) (string, error) {
 
 	// Find subsystem NQN
 	// nvme0n1 -> nvme0
-	parts := strings.Split(deviceName, "n")
-	if len(parts) < 2 {
+	ctrlName, ok := nvmeControllerName(deviceName)
+	if !ok {
 		return "", fmt.Errorf("invalid NVMe device name: %s", deviceName)
 	}
-	ctrlName := parts[0]
 
 	// Read subsysnqn from controller
 	// /sys/class/nvme/nvme0/subsysnqn
-	nqnPath := filepath.Join("/sys/class/nvme", ctrlName, "subsysnqn")
+	nqnPath := exec.HostPath(filepath.Join("/sys/class/nvme", ctrlName, "subsysnqn"))
 	content, err := os.ReadFile(nqnPath)
 	if err == nil {
 		return strings.TrimSpace(string(content)), nil
@@ -470,7 +472,7 @@ func GetNVMeInfoFromDevice(devicePath string) (string, error) {
 
 	// Try via subsystem link
 	// /sys/class/nvme/nvme0/subsystem/subsysnqn
-	nqnPath = filepath.Join("/sys/class/nvme", ctrlName, "subsystem", "subsysnqn")
+	nqnPath = exec.HostPath(filepath.Join("/sys/class/nvme", ctrlName, "subsystem", "subsysnqn"))
 	content, err = os.ReadFile(nqnPath)
 	if err == nil {
 		return strings.TrimSpace(string(content)), nil
@@ -478,3 +480,16 @@ func GetNVMeInfoFromDevice(devicePath string) (string, error) {
 
 	return "", fmt.Errorf("could not find NQN for device %s", devicePath)
 }
+
+// nvmeNamespacePattern matches namespace block devices such as nvme0n1 or, with native
+// multipath, the per-path nvme0c1n1.
+var nvmeNamespacePattern = regexp.MustCompile(`^(nvme\d+)(?:c\d+)?n\d+$`)
+
+// nvmeControllerName returns the controller of a namespace device, nvme0 for nvme0n1.
+func nvmeControllerName(deviceName string) (string, bool) {
+	match := nvmeNamespacePattern.FindStringSubmatch(deviceName)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
@@ -1,10 +1,12 @@
 package util
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
 
+	"github.com/GizmoTickler/truenas-scale-csi/pkg/util/fakeexec"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -14,17 +16,17 @@ func TestWaitForNVMeDevice(t *testing.T) {
 	defer func() { findNVMeDevice = originalFind }()
 
 	t.Run("Success immediately", func(t *testing.T) {
-		findNVMeDevice = func(nqn string) (string, error) {
+		findNVMeDevice = func(exec Exec, nqn string) (string, error) {
 			return "/dev/nvme0n1", nil
 		}
-		path, err := waitForNVMeDevice("nqn.test", 1*time.Second)
+		path, err := waitForNVMeDevice(nil, "nqn.test", 1*time.Second)
 		assert.NoError(t, err)
 		assert.Equal(t, "/dev/nvme0n1", path)
 	})
 
 	t.Run("Success after retry", func(t *testing.T) {
 		attempts := 0
-		findNVMeDevice = func(nqn string) (string, error) {
+		findNVMeDevice = func(exec Exec, nqn string) (string, error) {
 			attempts++
 			if attempts < 3 {
 				return "", fmt.Errorf("not found")
@@ -32,19 +34,19 @@ func TestWaitForNVMeDevice(t *testing.T) {
 			return "/dev/nvme0n1", nil
 		}
 		// Should succeed after ~150ms (50ms + 100ms)
-		path, err := waitForNVMeDevice("nqn.test", 1*time.Second)
+		path, err := waitForNVMeDevice(nil, "nqn.test", 1*time.Second)
 		assert.NoError(t, err)
 		assert.Equal(t, "/dev/nvme0n1", path)
 		assert.Equal(t, 3, attempts)
 	})
 
 	t.Run("Timeout", func(t *testing.T) {
-		findNVMeDevice = func(nqn string) (string, error) {
+		findNVMeDevice = func(exec Exec, nqn string) (string, error) {
 			return "", fmt.Errorf("not found")
 		}
 		// Short timeout for test
 		start := time.Now()
-		_, err := waitForNVMeDevice("nqn.test", 200*time.Millisecond)
+		_, err := waitForNVMeDevice(nil, "nqn.test", 200*time.Millisecond)
 		duration := time.Since(start)
 
 		assert.Error(t, err)
@@ -60,7 +62,7 @@ func TestNVMeoFConnectWithOptions(t *testing.T) {
 	defer func() { findNVMeDevice = originalFind }()
 
 	// Mock findNVMeDevice to always succeed immediately
-	findNVMeDevice = func(nqn string) (string, error) {
+	findNVMeDevice = func(exec Exec, nqn string) (string, error) {
 		return "/dev/nvme0n1", nil
 	}
 
@@ -76,7 +78,7 @@ func TestNVMeoFConnectWithOptions(t *testing.T) {
 		// For now, let's just test the timeout logic by mocking findNVMeDevice to fail
 		// and seeing if it respects the timeout.
 
-		findNVMeDevice = func(nqn string) (string, error) {
+		findNVMeDevice = func(exec Exec, nqn string) (string, error) {
 			return "", fmt.Errorf("not found")
 		}
 
@@ -87,3 +89,25 @@ func TestNVMeoFConnectWithOptions(t *testing.T) {
 		// The NVMeoFConnectWithOptions mainly passes the timeout.
 	})
 }
+
+func TestGetNVMeInfoFromDevice(t *testing.T) {
+	fake := fakeexec.New(t.TempDir())
+	fake.AddNVMeSubsystem("nqn.2011-06.com.truenas:vol-1")
+	_, err := fake.CombinedOutput(context.Background(), "nvme", "connect", "-t", "tcp", "-n", "nqn.2011-06.com.truenas:vol-1", "-a", "10.0.0.1", "-s", "4420")
+	assert.NoError(t, err)
+
+	nqn, err := GetNVMeInfoFromDevice(fake, "/dev/nvme0n1")
+	assert.NoError(t, err)
+	assert.Equal(t, "nqn.2011-06.com.truenas:vol-1", nqn)
+
+	_, err = GetNVMeInfoFromDevice(fake, "/dev/nvme0")
+	assert.Error(t, err)
+}
+
+func TestNVMeControllerName(t *testing.T) {
+	for device, want := range map[string]string{"nvme0n1": "nvme0", "nvme12n3": "nvme12", "nvme0c1n1": "nvme0", "nvme0": "", "sdb": ""} {
+		name, ok := nvmeControllerName(device)
+		assert.Equal(t, want != "", ok, device)
+		assert.Equal(t, want, name, device)
+	}
+}